@@ -7,11 +7,13 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 
@@ -125,13 +127,34 @@ func (h *Handler) Health(c *fiber.Ctx) error {
 
 // ListModels — проксирование к LM Studio (список моделей)
 func (h *Handler) ListModels(c *fiber.Ctx) error {
-	models, err := h.llm.ListModels()
+	models, err := h.llm.ListModels(c.UserContext())
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 	return c.JSON(models)
 }
 
+// ListProviders — типы бэкендов LLM, которые можно указать в providerType / embedProviderType, и их возможности
+func (h *Handler) ListProviders(c *fiber.Ctx) error {
+	return c.JSON(h.llm.Providers())
+}
+
+// ListModelRegistry — встроенный реестр моделей: контекстное окно и токенизатор для бюджета токенов
+func (h *Handler) ListModelRegistry(c *fiber.Ctx) error {
+	return c.JSON(service.ModelRegistry())
+}
+
+// CacheStats — размер и доля попаданий кэша эмбеддингов запросов и семантического кэша ответов
+func (h *Handler) CacheStats(c *fiber.Ctx) error {
+	return c.JSON(h.rag.CacheStats())
+}
+
+// ClearCaches — сброс кэшей (например, после смены модели эмбеддингов)
+func (h *Handler) ClearCaches(c *fiber.Ctx) error {
+	h.rag.ClearCaches()
+	return c.JSON(h.rag.CacheStats())
+}
+
 // IngestPDF — загрузка PDF, извлечение текста, разбиение, embeddings, сохранение в pgvector
 func (h *Handler) IngestPDF(c *fiber.Ctx) error {
 	// получаем файл
@@ -157,27 +180,16 @@ func (h *Handler) IngestPDF(c *fiber.Ctx) error {
 	}
 
 	// extract text
-	txt, err := pdf.ExtractText(savePath)
+	pages, err := pdf.ExtractPages(savePath)
 	if err != nil {
 		log.Printf("extract error: %v", err)
 		return c.Status(500).JSON(fiber.Map{"error": "failed to extract text from pdf"})
 	}
-	txt = pdf.Sanitize(txt)
-	if len(txt) == 0 {
+	if len(pages) == 0 {
 		return c.Status(400).JSON(fiber.Map{"error": "no text extracted from PDF"})
 	}
 
-	// chunk
-	// берём параметры по умолчанию (можно вынести в config/env)
-	const chunkSize = 220
-	const chunkOverlap = 40
-	parts := pdf.ChunkBySentences(txt, chunkSize, chunkOverlap)
-	if len(parts) == 0 {
-		return c.Status(400).JSON(fiber.Map{"error": "no chunks created"})
-	}
-
 	docName := filepath.Base(savePath)
-	saved := 0
 	// Попробуем получить chat_id из формы (опционально) и загрузить per-chat настройки
 	chatIDStr := c.FormValue("chat_id")
 	var chatID uuid.UUID
@@ -186,56 +198,33 @@ func (h *Handler) IngestPDF(c *fiber.Ctx) error {
 			chatID = cid
 		}
 	}
-
-	for i, p := range parts {
-		chunk_name := fmt.Sprintf("%s_chunk_%d", docName, i)
-		ch := models.Chunk{Text: p, Filepath: savePath, DocName: chunk_name, ChunkName: chunk_name}
-
-		var emb []float32
-		var err error
-		if chatID != uuid.Nil && h.chatSettings != nil {
-			if cs, serr := h.chatSettings.GetByChatID(context.Background(), chatID); serr == nil && cs != nil && cs.Settings != nil {
-				raw, _ := json.Marshal(cs.Settings)
-				var dbSettings models.AskSettings
-				if jerr := json.Unmarshal(raw, &dbSettings); jerr == nil {
-					// Попробуем дешифровать ключи, если они были сохранены зашифрованными
-					if dbSettings.ExternalAPIKey != "" {
-						if dec, derr := utils.DecryptString(dbSettings.ExternalAPIKey); derr == nil {
-							dbSettings.ExternalAPIKey = dec
-						}
-					}
-					if dbSettings.EmbedExternalAPIKey != "" {
-						if dec2, derr2 := utils.DecryptString(dbSettings.EmbedExternalAPIKey); derr2 == nil {
-							dbSettings.EmbedExternalAPIKey = dec2
-						}
-					}
-					emb, err = h.llm.EmbeddingWithSettings(p, &dbSettings)
-				} else {
-					emb, err = h.llm.Embedding(p)
-				}
-			} else {
-				emb, err = h.llm.Embedding(p)
-			}
-		} else {
-			emb, err = h.llm.Embedding(p)
-		}
-
-		if err != nil {
-			log.Printf("embedding error (%s): %v", chunk_name, err)
-			continue
+	var chatSettings *models.AskSettings
+	if chatID != uuid.Nil && h.chatSettings != nil {
+		chatSettings = h.chatSettings.AskSettings(context.Background(), chatID)
+	}
+	ctx := c.UserContext()
+	embed := func(text string) ([]float32, error) {
+		if chatSettings != nil {
+			return h.llm.EmbeddingWithSettings(ctx, text, chatSettings)
 		}
+		return h.llm.Embedding(ctx, text)
+	}
 
-		if err := h.chunkService.SaveChunk(ch, emb); err != nil {
-			log.Printf("db insert error (%s): %v", chunk_name, err)
-			continue
-		}
-		saved++
+	// chunk + embeddings: та же разбивка, что и у /documents/upload, включая режим parent
+	target := service.IngestTarget{ChatID: chatID, DocName: docName, Filepath: savePath}
+	total, saved, err := h.chunkService.IngestPages(target, pages, chatSettings, embed)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	// Кэш ответов проверяет версию корпуса и сам, но старые ответы чата уже не понадобятся.
+	if saved > 0 && chatID != uuid.Nil {
+		h.rag.InvalidateAnswerCache(chatID)
 	}
 
 	return c.JSON(fiber.Map{
 		"status":       "ok",
 		"doc":          docName,
-		"chunks_total": len(parts),
+		"chunks_total": total,
 		"chunks_saved": saved,
 	})
 }
@@ -283,125 +272,28 @@ func (h *Handler) AskQuestion(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "chat_id is required"})
 	}
 
-	// Попробуем получить claims из контекста; для публичного /ask также читаем Bearer-токен из заголовка.
-	accessLevel := 0
-	var claims jwt.MapClaims
-	if v := c.Locals("user"); v != nil {
-		if localClaims, ok := v.(jwt.MapClaims); ok {
-			claims = localClaims
-		}
-	}
-	if claims == nil {
-		headerClaims, claimsErr := parseOptionalJWTClaims(c)
-		if claimsErr != nil {
-			log.Printf("ask token parse error: %v", claimsErr)
-			return c.Status(401).JSON(fiber.Map{"error": "invalid token"})
-		}
-		claims = headerClaims
-	}
-
-	if claims != nil {
-		accessLevel = claimAccessLevel(claims)
-		if role, ok := claims["role"].(string); ok {
-			if role == "superuser" {
-				accessLevel = 100
-			}
-			if role == "chat_user" {
-				if chatStr, ok := claims["chat_id"].(string); ok && chatStr != "" {
-					if claimChat, err := uuid.Parse(chatStr); err == nil {
-						if claimChat != req.ChatID {
-							return c.Status(403).JSON(fiber.Map{"error": "chat mismatch"})
-						}
-					}
-				}
-			}
-		}
+	accessLevel, accessStatus, accessErr := h.resolveRequestAccessLevel(c, req.ChatID)
+	if accessErr != nil {
+		return c.Status(accessStatus).JSON(fiber.Map{"error": accessErr.Error()})
 	}
-	log.Printf("ask access resolved: chat_id=%s access_level=%d has_claims=%t", req.ChatID.String(), accessLevel, claims != nil)
 
 	// Собираем настройки LLM
-	settings := req.Settings
-	if settings == nil {
-		settings = &models.AskSettings{}
-	}
-	// если модель в теле запроса не указана, используем параметр model из query
-	if settings.Model == "" && req.Model != "" {
-		settings.Model = req.Model
-	}
-	// Попробуем получить настройки чата из БД и слить их (db -> override by request)
-	if h.chatSettings != nil {
-		if cs, err := h.chatSettings.GetByChatID(context.Background(), req.ChatID); err == nil && cs != nil && cs.Settings != nil {
-			// marshal JSONB -> bytes
-			raw, _ := json.Marshal(cs.Settings)
-			var dbSettings models.AskSettings
-			if err := json.Unmarshal(raw, &dbSettings); err == nil {
-				// Попробуем дешифровать ключи, если они были сохранены зашифрованными
-				if dbSettings.ExternalAPIKey != "" {
-					if dec, derr := utils.DecryptString(dbSettings.ExternalAPIKey); derr == nil {
-						dbSettings.ExternalAPIKey = dec
-					}
-				}
-				if dbSettings.EmbedExternalAPIKey != "" {
-					if dec2, derr2 := utils.DecryptString(dbSettings.EmbedExternalAPIKey); derr2 == nil {
-						dbSettings.EmbedExternalAPIKey = dec2
-					}
-				}
-				// Применяем только те поля из dbSettings, которые не заданы в request (request имеет приоритет)
-				if settings.Provider == "" {
-					settings.Provider = dbSettings.Provider
-				}
-				if settings.ExternalAPIKey == "" {
-					settings.ExternalAPIKey = dbSettings.ExternalAPIKey
-				}
-				if settings.ExternalBaseURL == "" {
-					settings.ExternalBaseURL = dbSettings.ExternalBaseURL
-				}
-				if settings.EmbedProvider == "" {
-					settings.EmbedProvider = dbSettings.EmbedProvider
-				}
-				if settings.EmbedExternalAPIKey == "" {
-					settings.EmbedExternalAPIKey = dbSettings.EmbedExternalAPIKey
-				}
-				if settings.EmbedExternalBaseURL == "" {
-					settings.EmbedExternalBaseURL = dbSettings.EmbedExternalBaseURL
-				}
-				if settings.Model == "" {
-					settings.Model = dbSettings.Model
-				}
-				if settings.EmbedModel == "" {
-					settings.EmbedModel = dbSettings.EmbedModel
-				}
-				if settings.SystemPrompt == "" {
-					settings.SystemPrompt = dbSettings.SystemPrompt
-				}
-				if settings.MaxTokens == 0 {
-					settings.MaxTokens = dbSettings.MaxTokens
-				}
-				if settings.Temperature == 0 {
-					settings.Temperature = dbSettings.Temperature
-				}
-				if settings.RetrievalMode == "" {
-					settings.RetrievalMode = dbSettings.RetrievalMode
-				}
-				if settings.MaxCosineDistance == 0 {
-					settings.MaxCosineDistance = dbSettings.MaxCosineDistance
-				}
-				if settings.MaxDistanceGap == 0 {
-					settings.MaxDistanceGap = dbSettings.MaxDistanceGap
-				}
-				if settings.MinChunkChars == 0 {
-					settings.MinChunkChars = dbSettings.MinChunkChars
-				}
-			}
+	settings := h.resolveRequestSettings(req.ChatID, req.Settings, req.Model, withAsOf(req.Filters, req.AsOf))
+	settings.Explain = req.Explain
+	if len(req.ResponseSchema) > 0 && string(req.ResponseSchema) != "null" {
+		if err := service.ValidateResponseSchema(req.ResponseSchema); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 		}
+		settings.ResponseSchema = req.ResponseSchema
 	}
 
 	if modelName == "" {
 		modelName = settings.Model
 	}
 
+	// История нужна и генератору (EnableHistory), и шагу переписывания поискового запроса.
 	historyMessages := make([]models.ChatContextMessage, 0)
-	if settings.EnableHistory && req.ChatHistoryID != nil && h.messageRepo != nil {
+	if (settings.EnableHistory || settings.EnableQueryRewrite) && req.ChatHistoryID != nil && h.messageRepo != nil {
 		loadedHistory, historyErr := h.buildLLMHistoryMessages(*req.ChatHistoryID, req.Query)
 		if historyErr != nil {
 			log.Printf("history load error chat_history_id=%s: %v", req.ChatHistoryID.String(), historyErr)
@@ -425,15 +317,42 @@ func (h *Handler) AskQuestion(c *fiber.Ctx) error {
 		)
 	}
 
-	// если модель указана, используем её; иначе — дефолт внутри LLMClient/Service
-	ans, ctxChunks, diagnostics, err := h.rag.AskWithDiagnostics(effectiveQuery, k, req.ChatID, settings, accessLevel, historyMessages)
-	if err != nil {
+	// если модель указана, используем её; иначе — дефолт внутри LLMClient/Service.
+	// fasthttp не сообщает об отключении клиента, пока обработчик не вернул ответ, и не отменяет UserContext:
+	// без стриминга генерацию и подбор следующих вопросов ограничивает только answerTimeoutSeconds.
+	// Остановка генерации при отключении клиента есть только в SSE (ошибка записи в поток).
+	ans, ctxChunks, diagnostics, err := h.rag.AskWithDiagnostics(c.UserContext(), effectiveQuery, k, req.ChatID, settings, accessLevel, historyMessages)
+	timedOut := errors.Is(err, service.ErrAnswerTimeout)
+	if timedOut && strings.TrimSpace(ans) == "" {
+		return c.Status(fiber.StatusGatewayTimeout).JSON(fiber.Map{"error": err.Error(), "timed_out": true, "retrieval_diagnostics": diagnostics})
+	}
+	if errors.Is(err, service.ErrProvidersUnavailable) {
+		log.Printf("rag ask error: %v", err)
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error(), "provider": diagnostics.Provider})
+	}
+	if err != nil && !timedOut {
 		log.Printf("rag ask error: %v", err)
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 
+	var followUps []service.FollowUpQuestion
+	if !timedOut {
+		followUps, err = h.rag.SuggestFollowUps(c.UserContext(), effectiveQuery, ans, ctxChunks, diagnostics, req.ChatID, settings, accessLevel)
+		if err != nil {
+			log.Printf("follow-up suggestions failed: %v", err)
+		}
+	}
+
 	return c.JSON(fiber.Map{
 		"answer":                ans,
+		"timed_out":             timedOut,
+		"intent":                diagnostics.Intent,
+		"provider":              diagnostics.Provider,
+		"citations":             diagnostics.Citations,
+		"grounding":             diagnostics.Grounding,
+		"data":                  structuredData(diagnostics),
+		"structured":            diagnostics.Structured,
+		"follow_ups":            followUps,
 		"context":               ctxChunks,
 		"model":                 modelName,
 		"retrieval_diagnostics": diagnostics,
@@ -449,6 +368,417 @@ func (h *Handler) AskQuestion(c *fiber.Ctx) error {
 	})
 }
 
+// Search godoc
+// @Summary Search documents without generation
+// @Description Поиск по документам чата с теми же настройками, уровнем доступа и фильтрами, что и /ask, но без вызова LLM
+// @Tags RAG
+// @Accept json
+// @Produce json
+// @Param request body models.SearchRequest true "Search payload"
+// @Success 200 {object} map[string]interface{} "Ranked chunks and retrieval diagnostics"
+// @Failure 400 {object} map[string]string "Invalid request"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /search [post]
+func (h *Handler) Search(c *fiber.Ctx) error {
+	var req models.SearchRequest
+	if err := c.BodyParser(&req); err != nil || strings.TrimSpace(req.Query) == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid request, expected JSON: {\"query\":\"...\", \"chat_id\":\"...\"}"})
+	}
+	if req.ChatID == uuid.Nil {
+		return c.Status(400).JSON(fiber.Map{"error": "chat_id is required"})
+	}
+
+	k := req.TopK
+	if k <= 0 {
+		k = 5
+	}
+
+	accessLevel, accessStatus, accessErr := h.resolveRequestAccessLevel(c, req.ChatID)
+	if accessErr != nil {
+		return c.Status(accessStatus).JSON(fiber.Map{"error": accessErr.Error()})
+	}
+
+	settings := h.resolveRequestSettings(req.ChatID, req.Settings, "", withAsOf(req.Filters, req.AsOf))
+	settings.Explain = req.Explain
+
+	chunks, diagnostics, err := h.rag.Retrieve(c.UserContext(), strings.TrimSpace(req.Query), k, req.ChatID, settings, accessLevel)
+	if err != nil {
+		log.Printf("rag search error: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	results := make([]dto.SearchResultItem, 0, len(chunks))
+	for i, ch := range chunks {
+		item := dto.SearchResultItem{
+			Rank:            i + 1,
+			ChunkID:         ch.ID,
+			DocID:           ch.DocID,
+			DocName:         ch.DocName,
+			ChunkName:       ch.ChunkName,
+			ChunkIndex:      ch.ChunkIndex,
+			Text:            ch.Text,
+			MatchedText:     ch.MatchedText,
+			Language:        ch.Language,
+			KeywordScore:    ch.KeywordScore,
+			HybridScore:     ch.HybridScore,
+			RetrievalSource: ch.RetrievalSource,
+		}
+		if ch.Score > 0 {
+			distance := ch.Score
+			similarity := 1 - distance
+			item.VectorDistance = &distance
+			item.VectorSimilarity = &similarity
+		}
+		results = append(results, item)
+	}
+
+	return c.JSON(fiber.Map{
+		"query":                 req.Query,
+		"results":               results,
+		"total":                 len(results),
+		"retrieval_diagnostics": diagnostics,
+	})
+}
+
+// PreviewPrompt godoc
+// @Summary Preview rendered prompt
+// @Description Рендерит шаблон промпта чата для вопроса и возвращает итоговые сообщения модели без генерации ответа.
+// @Description Шаблон выбирается так же, как в /ask (settings.promptTemplate / promptTemplateVersion или настройки чата);
+// @Description контекст собирается поиском, если не передан явно в поле context
+// @Tags prompt-templates
+// @Accept json
+// @Produce json
+// @Param chat_id path string true "Chat ID"
+// @Param request body dto.PromptPreviewRequest true "Preview payload"
+// @Success 200 {object} map[string]interface{} "Rendered prompt, context chunks and retrieval diagnostics"
+// @Failure 400 {object} map[string]string "Invalid request"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /chats/{chat_id}/prompt-templates/preview [post]
+// @Security BearerAuth
+func (h *Handler) PreviewPrompt(c *fiber.Ctx) error {
+	chatID, err := uuid.Parse(c.Params("chat_id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid chat_id"})
+	}
+	var req dto.PromptPreviewRequest
+	if err := c.BodyParser(&req); err != nil || strings.TrimSpace(req.Query) == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid request, expected JSON: {\"query\":\"...\"}"})
+	}
+
+	accessLevel, accessStatus, accessErr := h.resolveRequestAccessLevel(c, chatID)
+	if accessErr != nil {
+		return c.Status(accessStatus).JSON(fiber.Map{"error": accessErr.Error()})
+	}
+	settings := h.resolveRequestSettings(chatID, req.Settings, "", req.Filters)
+
+	prompt, chunks, diagnostics, err := h.rag.PreviewPrompt(c.UserContext(), strings.TrimSpace(req.Query), req.TopK, chatID, settings, accessLevel, nil, req.Context)
+	if err != nil {
+		log.Printf("prompt preview error: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"prompt":                prompt,
+		"context":               chunks,
+		"retrieval_diagnostics": diagnostics,
+	})
+}
+
+// resolveRequestAccessLevel определяет уровень доступа к документам по JWT (из контекста или,
+// для публичных эндпоинтов, из заголовка Authorization). chat_user может обращаться только к своему чату.
+// При ошибке возвращает HTTP-статус для ответа.
+func (h *Handler) resolveRequestAccessLevel(c *fiber.Ctx, chatID uuid.UUID) (int, int, error) {
+	accessLevel := 0
+	var claims jwt.MapClaims
+	if v := c.Locals("user"); v != nil {
+		if localClaims, ok := v.(jwt.MapClaims); ok {
+			claims = localClaims
+		}
+	}
+	if claims == nil {
+		headerClaims, claimsErr := parseOptionalJWTClaims(c)
+		if claimsErr != nil {
+			log.Printf("ask token parse error: %v", claimsErr)
+			return 0, 401, errors.New("invalid token")
+		}
+		claims = headerClaims
+	}
+
+	if claims != nil {
+		accessLevel = claimAccessLevel(claims)
+		if role, ok := claims["role"].(string); ok {
+			if role == "superuser" {
+				accessLevel = 100
+			}
+			if role == "chat_user" {
+				if chatStr, ok := claims["chat_id"].(string); ok && chatStr != "" {
+					if claimChat, err := uuid.Parse(chatStr); err == nil {
+						if claimChat != chatID {
+							return 0, 403, errors.New("chat mismatch")
+						}
+					}
+				}
+			}
+		}
+	}
+	log.Printf("ask access resolved: chat_id=%s access_level=%d has_claims=%t", chatID.String(), accessLevel, claims != nil)
+	return accessLevel, 0, nil
+}
+
+// resolveRequestSettings собирает настройки запроса: значения из тела запроса имеют приоритет,
+// недостающие берутся из настроек чата; фильтры по метаданным относятся только к этому запросу.
+func (h *Handler) resolveRequestSettings(chatID uuid.UUID, requested *models.AskSettings, model string, filters *models.RetrievalFilters) *models.AskSettings {
+	settings := requested
+	if settings == nil {
+		settings = &models.AskSettings{}
+	}
+	// если модель в теле запроса не указана, используем параметр model из query
+	if settings.Model == "" && model != "" {
+		settings.Model = model
+	}
+	// Попробуем получить настройки чата из БД и слить их (db -> override by request)
+	h.applyChatAskSettings(chatID, settings)
+	settings.Filters = filters.Normalize()
+	return settings
+}
+
+// structuredData — объект ответа по responseSchema; nil, если схема не задана или ответ её не прошёл.
+func structuredData(diagnostics service.RetrievalDiagnostics) interface{} {
+	if diagnostics.Structured == nil {
+		return nil
+	}
+	return diagnostics.Structured.Data
+}
+
+// withAsOf переносит дату "as of" из запроса в фильтры поиска.
+func withAsOf(filters *models.RetrievalFilters, asOf *models.FilterDate) *models.RetrievalFilters {
+	if asOf == nil {
+		return filters
+	}
+	merged := models.RetrievalFilters{}
+	if filters != nil {
+		merged = *filters
+	}
+	merged.AsOf = asOf
+	return &merged
+}
+
+// applyChatAskSettings подгружает настройки чата из БД и дополняет ими settings.
+// Поля, заданные в запросе, имеют приоритет над сохранёнными.
+func (h *Handler) applyChatAskSettings(chatID uuid.UUID, settings *models.AskSettings) {
+	if h.chatSettings == nil || settings == nil {
+		return
+	}
+	cs, err := h.chatSettings.GetByChatID(context.Background(), chatID)
+	if err != nil || cs == nil || cs.Settings == nil {
+		return
+	}
+
+	// marshal JSONB -> bytes
+	raw, _ := json.Marshal(cs.Settings)
+	var dbSettings models.AskSettings
+	if err := json.Unmarshal(raw, &dbSettings); err != nil {
+		return
+	}
+
+	// Попробуем дешифровать ключи, если они были сохранены зашифрованными
+	if dbSettings.ExternalAPIKey != "" {
+		if dec, derr := utils.DecryptString(dbSettings.ExternalAPIKey); derr == nil {
+			dbSettings.ExternalAPIKey = dec
+		}
+	}
+	if dbSettings.EmbedExternalAPIKey != "" {
+		if dec2, derr2 := utils.DecryptString(dbSettings.EmbedExternalAPIKey); derr2 == nil {
+			dbSettings.EmbedExternalAPIKey = dec2
+		}
+	}
+
+	// Применяем только те поля из dbSettings, которые не заданы в request (request имеет приоритет)
+	if settings.Provider == "" {
+		settings.Provider = dbSettings.Provider
+	}
+	if settings.ExternalAPIKey == "" {
+		settings.ExternalAPIKey = dbSettings.ExternalAPIKey
+	}
+	if settings.ExternalBaseURL == "" {
+		settings.ExternalBaseURL = dbSettings.ExternalBaseURL
+	}
+	if settings.EmbedProvider == "" {
+		settings.EmbedProvider = dbSettings.EmbedProvider
+	}
+	if settings.EmbedExternalAPIKey == "" {
+		settings.EmbedExternalAPIKey = dbSettings.EmbedExternalAPIKey
+	}
+	if settings.EmbedExternalBaseURL == "" {
+		settings.EmbedExternalBaseURL = dbSettings.EmbedExternalBaseURL
+	}
+	if settings.ProviderType == "" {
+		settings.ProviderType = dbSettings.ProviderType
+	}
+	if settings.EmbedProviderType == "" {
+		settings.EmbedProviderType = dbSettings.EmbedProviderType
+	}
+	if len(settings.FallbackProviders) == 0 {
+		settings.FallbackProviders = dbSettings.FallbackProviders
+	}
+	if settings.ProviderRetries == 0 {
+		settings.ProviderRetries = dbSettings.ProviderRetries
+	}
+	// Версия из настроек чата относится к шаблону чата, а не к шаблону, указанному в запросе.
+	if settings.PromptTemplate == "" {
+		settings.PromptTemplate = dbSettings.PromptTemplate
+		if settings.PromptTemplateVersion == 0 {
+			settings.PromptTemplateVersion = dbSettings.PromptTemplateVersion
+		}
+	}
+	if settings.ContextWindow == 0 {
+		settings.ContextWindow = dbSettings.ContextWindow
+	}
+	if settings.TokenEstimator == "" && settings.Tokenizer == "" {
+		settings.TokenEstimator = dbSettings.TokenEstimator
+		settings.Tokenizer = dbSettings.Tokenizer
+	}
+	if settings.AnswerTimeoutSeconds == 0 {
+		settings.AnswerTimeoutSeconds = dbSettings.AnswerTimeoutSeconds
+	}
+	if settings.CitationMode == "" {
+		settings.CitationMode = dbSettings.CitationMode
+	}
+	if settings.GroundingCheck == "" {
+		settings.GroundingCheck = dbSettings.GroundingCheck
+	}
+	if settings.GroundingPolicy == "" {
+		settings.GroundingPolicy = dbSettings.GroundingPolicy
+	}
+	if settings.GroundingMinScore == 0 {
+		settings.GroundingMinScore = dbSettings.GroundingMinScore
+	}
+	if settings.GroundingSimilarity == 0 {
+		settings.GroundingSimilarity = dbSettings.GroundingSimilarity
+	}
+	if settings.StructuredRetries == 0 {
+		settings.StructuredRetries = dbSettings.StructuredRetries
+	}
+	if !settings.AgentMode {
+		settings.AgentMode = dbSettings.AgentMode
+	}
+	if settings.AgentMaxSteps == 0 {
+		settings.AgentMaxSteps = dbSettings.AgentMaxSteps
+	}
+	if !settings.SuggestFollowUps {
+		settings.SuggestFollowUps = dbSettings.SuggestFollowUps
+	}
+	if settings.FollowUpCount == 0 {
+		settings.FollowUpCount = dbSettings.FollowUpCount
+	}
+	if settings.IntentClassifier == "" {
+		settings.IntentClassifier = dbSettings.IntentClassifier
+	}
+	if settings.IntentSimilarity == 0 {
+		settings.IntentSimilarity = dbSettings.IntentSimilarity
+	}
+	if len(settings.IntentReplies) == 0 {
+		settings.IntentReplies = dbSettings.IntentReplies
+	}
+	if settings.Model == "" {
+		settings.Model = dbSettings.Model
+	}
+	if settings.EmbedModel == "" {
+		settings.EmbedModel = dbSettings.EmbedModel
+	}
+	if settings.SystemPrompt == "" {
+		settings.SystemPrompt = dbSettings.SystemPrompt
+	}
+	if settings.MaxTokens == 0 {
+		settings.MaxTokens = dbSettings.MaxTokens
+	}
+	if settings.Temperature == 0 {
+		settings.Temperature = dbSettings.Temperature
+	}
+	if settings.RetrievalMode == "" {
+		settings.RetrievalMode = dbSettings.RetrievalMode
+	}
+	if settings.MaxCosineDistance == 0 {
+		settings.MaxCosineDistance = dbSettings.MaxCosineDistance
+	}
+	if settings.MaxDistanceGap == 0 {
+		settings.MaxDistanceGap = dbSettings.MaxDistanceGap
+	}
+	if settings.MinChunkChars == 0 {
+		settings.MinChunkChars = dbSettings.MinChunkChars
+	}
+	if settings.VectorWeight == nil {
+		settings.VectorWeight = dbSettings.VectorWeight
+	}
+	if settings.KeywordWeight == nil {
+		settings.KeywordWeight = dbSettings.KeywordWeight
+	}
+	if settings.RRFWeight == nil {
+		settings.RRFWeight = dbSettings.RRFWeight
+	}
+	if settings.RRFDenominator == 0 {
+		settings.RRFDenominator = dbSettings.RRFDenominator
+	}
+	if !settings.EnableMMR {
+		settings.EnableMMR = dbSettings.EnableMMR
+	}
+	if settings.MMRLambda == 0 {
+		settings.MMRLambda = dbSettings.MMRLambda
+	}
+	if settings.DuplicateSimilarity == 0 {
+		settings.DuplicateSimilarity = dbSettings.DuplicateSimilarity
+	}
+	if settings.NeighborWindow == 0 {
+		settings.NeighborWindow = dbSettings.NeighborWindow
+	}
+	if !settings.EnableQueryRewrite {
+		settings.EnableQueryRewrite = dbSettings.EnableQueryRewrite
+	}
+	if settings.RewriteHistoryTurns == 0 {
+		settings.RewriteHistoryTurns = dbSettings.RewriteHistoryTurns
+	}
+	if settings.QueryStrategy == "" {
+		settings.QueryStrategy = dbSettings.QueryStrategy
+	}
+	if settings.MultiQueryCount == 0 {
+		settings.MultiQueryCount = dbSettings.MultiQueryCount
+	}
+	if len(settings.CorpusLanguages) == 0 {
+		settings.CorpusLanguages = dbSettings.CorpusLanguages
+	}
+	if settings.CrossLingualMode == "" {
+		settings.CrossLingualMode = dbSettings.CrossLingualMode
+	}
+	if settings.HNSWEfSearch == 0 {
+		settings.HNSWEfSearch = dbSettings.HNSWEfSearch
+	}
+	if settings.IVFProbes == 0 {
+		settings.IVFProbes = dbSettings.IVFProbes
+	}
+	if !settings.ExpandEmbeddingWithSynonyms {
+		settings.ExpandEmbeddingWithSynonyms = dbSettings.ExpandEmbeddingWithSynonyms
+	}
+	if settings.RecencyWeight == 0 {
+		settings.RecencyWeight = dbSettings.RecencyWeight
+	}
+	if settings.RecencyHalfLifeDays == 0 {
+		settings.RecencyHalfLifeDays = dbSettings.RecencyHalfLifeDays
+	}
+	if !settings.EnableAnswerCache {
+		settings.EnableAnswerCache = dbSettings.EnableAnswerCache
+	}
+	if settings.AnswerCacheSimilarity == 0 {
+		settings.AnswerCacheSimilarity = dbSettings.AnswerCacheSimilarity
+	}
+	if settings.AnswerCacheTTLMinutes == 0 {
+		settings.AnswerCacheTTLMinutes = dbSettings.AnswerCacheTTLMinutes
+	}
+}
+
+// sseHeartbeatInterval — период SSE-комментариев, по которым обнаруживается отключение клиента,
+// пока модель ещё не прислала ни одного фрагмента.
+const sseHeartbeatInterval = 10 * time.Second
+
 func (h *Handler) streamAskQuestion(
 	c *fiber.Ctx,
 	effectiveQuery string,
@@ -469,20 +799,54 @@ func (h *Handler) streamAskQuestion(
 	historyUsed := settings.EnableHistory && len(historyMessages) > 0
 
 	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
-		sendEvent := func(event string, payload interface{}) error {
-			raw, err := json.Marshal(payload)
-			if err != nil {
+		// Отключение клиента (ошибка записи) отменяет поиск и генерацию через ctx.
+		ctx, cancel := context.WithCancel(context.Background())
+		// Writer нельзя трогать после возврата из колбэка: дожидаемся остановки heartbeat-горутины.
+		var heartbeat sync.WaitGroup
+		defer func() {
+			cancel()
+			heartbeat.Wait()
+		}()
+
+		var writeMu sync.Mutex
+		write := func(format string, args ...interface{}) error {
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			if _, err := fmt.Fprintf(w, format, args...); err != nil {
+				cancel()
 				return err
 			}
-			if _, err := fmt.Fprintf(w, "event: %s\n", event); err != nil {
+			if err := w.Flush(); err != nil {
+				cancel()
 				return err
 			}
-			if _, err := fmt.Fprintf(w, "data: %s\n\n", raw); err != nil {
+			return nil
+		}
+		sendEvent := func(event string, payload interface{}) error {
+			raw, err := json.Marshal(payload)
+			if err != nil {
 				return err
 			}
-			return w.Flush()
+			return write("event: %s\ndata: %s\n\n", event, raw)
 		}
 
+		heartbeat.Add(1)
+		go func() {
+			defer heartbeat.Done()
+			ticker := time.NewTicker(sseHeartbeatInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if write(": keep-alive\n\n") != nil {
+						return
+					}
+				}
+			}
+		}()
+
 		if err := sendEvent("start", fiber.Map{
 			"model":                 modelName,
 			"history_used":          historyUsed,
@@ -498,20 +862,45 @@ func (h *Handler) streamAskQuestion(
 			return
 		}
 
-		ans, ctxChunks, diagnostics, err := h.rag.AskWithDiagnosticsStream(effectiveQuery, topK, chatID, settings, accessLevel, historyMessages, func(delta string) error {
+		ans, ctxChunks, diagnostics, err := h.rag.AskWithDiagnosticsStream(ctx, effectiveQuery, topK, chatID, settings, accessLevel, historyMessages, func(delta string) error {
 			if delta == "" {
 				return nil
 			}
 			return sendEvent("delta", fiber.Map{"delta": delta})
+		}, func(step service.AgentStep) error {
+			// Режим агента: каждый вызов инструмента отправляем по мере выполнения.
+			return sendEvent("tool", step)
 		})
-		if err != nil {
+		if ctx.Err() != nil {
+			log.Printf("rag ask stream: client disconnected, generation cancelled")
+			return
+		}
+		timedOut := errors.Is(err, service.ErrAnswerTimeout)
+		if timedOut {
+			// Срок ответа истёк: сообщаем об этом и завершаем поток частичным ответом.
+			if sendEvent("timeout", fiber.Map{
+				"error":           err.Error(),
+				"timeout_seconds": settings.AnswerTimeoutSeconds,
+				"partial":         strings.TrimSpace(ans) != "",
+			}) != nil {
+				return
+			}
+		} else if err != nil {
 			log.Printf("rag ask stream error: %v", err)
-			_ = sendEvent("error", fiber.Map{"error": err.Error()})
+			_ = sendEvent("error", fiber.Map{"error": err.Error(), "provider": diagnostics.Provider})
 			return
 		}
 
+		// В done — итоговый ответ: при citationMode "strip" и проверке groundingCheck он может отличаться от склеенных delta.
 		if err := sendEvent("done", fiber.Map{
 			"answer":                ans,
+			"timed_out":             timedOut,
+			"intent":                diagnostics.Intent,
+			"provider":              diagnostics.Provider,
+			"citations":             diagnostics.Citations,
+			"grounding":             diagnostics.Grounding,
+			"data":                  structuredData(diagnostics),
+			"structured":            diagnostics.Structured,
 			"context":               ctxChunks,
 			"model":                 modelName,
 			"retrieval_diagnostics": diagnostics,
@@ -527,6 +916,18 @@ func (h *Handler) streamAskQuestion(
 		}); err != nil {
 			return
 		}
+
+		// Следующие вопросы требуют вызова LLM и поиска, поэтому приходят после done и не задерживают ответ.
+		if settings.SuggestFollowUps && !timedOut {
+			followUps, err := h.rag.SuggestFollowUps(ctx, effectiveQuery, ans, ctxChunks, diagnostics, chatID, settings, accessLevel)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Printf("follow-up suggestions failed: %v", err)
+			}
+			_ = sendEvent("follow_ups", fiber.Map{"follow_ups": followUps})
+		}
 	})
 
 	return nil
@@ -833,74 +1234,13 @@ func (h *Handler) StartEvaluationRun(c *fiber.Ctx) error {
 		settings.Model = req.Model
 	}
 
-	if h.chatSettings != nil {
-		if cs, err := h.chatSettings.GetByChatID(context.Background(), req.ChatID); err == nil && cs != nil && cs.Settings != nil {
-			raw, _ := json.Marshal(cs.Settings)
-			var dbSettings models.AskSettings
-			if err := json.Unmarshal(raw, &dbSettings); err == nil {
-				if dbSettings.ExternalAPIKey != "" {
-					if dec, derr := utils.DecryptString(dbSettings.ExternalAPIKey); derr == nil {
-						dbSettings.ExternalAPIKey = dec
-					}
-				}
-				if dbSettings.EmbedExternalAPIKey != "" {
-					if dec2, derr2 := utils.DecryptString(dbSettings.EmbedExternalAPIKey); derr2 == nil {
-						dbSettings.EmbedExternalAPIKey = dec2
-					}
-				}
-
-				if settings.Provider == "" {
-					settings.Provider = dbSettings.Provider
-				}
-				if settings.ExternalAPIKey == "" {
-					settings.ExternalAPIKey = dbSettings.ExternalAPIKey
-				}
-				if settings.ExternalBaseURL == "" {
-					settings.ExternalBaseURL = dbSettings.ExternalBaseURL
-				}
-				if settings.EmbedProvider == "" {
-					settings.EmbedProvider = dbSettings.EmbedProvider
-				}
-				if settings.EmbedExternalAPIKey == "" {
-					settings.EmbedExternalAPIKey = dbSettings.EmbedExternalAPIKey
-				}
-				if settings.EmbedExternalBaseURL == "" {
-					settings.EmbedExternalBaseURL = dbSettings.EmbedExternalBaseURL
-				}
-				if settings.Model == "" {
-					settings.Model = dbSettings.Model
-				}
-				if settings.EmbedModel == "" {
-					settings.EmbedModel = dbSettings.EmbedModel
-				}
-				if settings.SystemPrompt == "" {
-					settings.SystemPrompt = dbSettings.SystemPrompt
-				}
-				if settings.MaxTokens == 0 {
-					settings.MaxTokens = dbSettings.MaxTokens
-				}
-				if settings.Temperature == 0 {
-					settings.Temperature = dbSettings.Temperature
-				}
-				if settings.RetrievalMode == "" {
-					settings.RetrievalMode = dbSettings.RetrievalMode
-				}
-				if settings.MaxCosineDistance == 0 {
-					settings.MaxCosineDistance = dbSettings.MaxCosineDistance
-				}
-				if settings.MaxDistanceGap == 0 {
-					settings.MaxDistanceGap = dbSettings.MaxDistanceGap
-				}
-				if settings.MinChunkChars == 0 {
-					settings.MinChunkChars = dbSettings.MinChunkChars
-				}
-			}
-		}
-	}
+	h.applyChatAskSettings(req.ChatID, settings)
 
 	if run.Model == "" && settings.Model != "" {
 		run.Model = settings.Model
 	}
+	run.RetrievalMode = service.ResolveRetrievalMode(settings)
+	run.QueryStrategy = service.ResolveQueryStrategy(settings)
 
 	accessLevel := 100
 	if v := c.Locals("user"); v != nil {
@@ -916,7 +1256,7 @@ func (h *Handler) StartEvaluationRun(c *fiber.Ctx) error {
 	results := make([]models.EvaluationResult, 0, len(questions))
 	for _, question := range questions {
 		start := time.Now()
-		answer, chunks, askErr := h.rag.Ask(question.Text, topK, req.ChatID, settings, accessLevel, nil)
+		answer, chunks, askErr := h.rag.Ask(c.UserContext(), question.Text, topK, req.ChatID, settings, accessLevel, nil)
 		duration := time.Since(start).Milliseconds()
 
 		retrieved := ""
@@ -1009,6 +1349,8 @@ func (h *Handler) ListEvaluationRuns(c *fiber.Ctx) error {
 			Status:         run.Status,
 			Model:          run.Model,
 			TopK:           run.TopK,
+			RetrievalMode:  run.RetrievalMode,
+			QueryStrategy:  run.QueryStrategy,
 			TotalQuestions: run.TotalQuestions,
 			EvaluatedCount: run.EvaluatedCount,
 			CorrectCount:   run.CorrectCount,
@@ -1112,7 +1454,7 @@ func (h *Handler) CompareRunWithBaseline(c *fiber.Ctx) error {
 		}
 
 		start := time.Now()
-		chunks, err := h.chunkService.SearchByKeyword(result.Question.Text, searchLimit, run.ChatID, accessLevel)
+		chunks, err := h.chunkService.SearchByKeyword(c.UserContext(), result.Question.Text, searchLimit, run.ChatID, accessLevel)
 		elapsed := time.Since(start).Milliseconds()
 		if elapsed < 0 {
 			elapsed = 0
@@ -1144,6 +1486,127 @@ func (h *Handler) CompareRunWithBaseline(c *fiber.Ctx) error {
 	return c.JSON(resp)
 }
 
+// CompareRunRetrievalStrategies прогоняет контрольные вопросы run только через поиск (без генерации)
+// для нескольких стратегий построения запроса и сравнивает попадание в контекст и MRR.
+func (h *Handler) CompareRunRetrievalStrategies(c *fiber.Ctx) error {
+	runID, err := uuid.Parse(c.Params("run_id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid run_id"})
+	}
+
+	run, err := h.evaluation.GetRunByID(context.Background(), runID)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "run not found"})
+	}
+
+	topK := c.QueryInt("top_k", run.TopK)
+	if topK <= 0 {
+		topK = 5
+	}
+
+	strategies := make([]string, 0, 3)
+	for _, raw := range strings.Split(c.Query("strategies", "single,multi_query,hyde"), ",") {
+		if strategy := strings.TrimSpace(raw); strategy != "" {
+			strategies = append(strategies, strategy)
+		}
+	}
+	if len(strategies) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "strategies is empty"})
+	}
+
+	accessLevel := 100
+	if v := c.Locals("user"); v != nil {
+		if claims, ok := v.(jwt.MapClaims); ok {
+			if role, ok := claims["role"].(string); ok && role == "chat_user" {
+				if al, ok := claims["access_level"].(float64); ok {
+					accessLevel = int(al)
+				}
+			}
+		}
+	}
+
+	baseSettings := &models.AskSettings{}
+	h.applyChatAskSettings(run.ChatID, baseSettings)
+	mode := strings.TrimSpace(c.Query("mode", "hybrid"))
+
+	resp := dto.RetrievalStrategyCompareResponse{
+		RunID:          run.ID,
+		TopK:           topK,
+		QuestionsTotal: len(run.Results),
+		Strategies:     make([]dto.RetrievalStrategyMetrics, 0, len(strategies)),
+	}
+
+	for _, strategy := range strategies {
+		settings := *baseSettings
+		settings.RetrievalMode = mode
+		settings.QueryStrategy = strategy
+
+		metrics := dto.RetrievalStrategyMetrics{
+			RetrievalMode: service.ResolveRetrievalMode(&settings),
+			QueryStrategy: service.ResolveQueryStrategy(&settings),
+		}
+		hits, refusals, noAnswerTotal := 0, 0, 0
+		reciprocalRankSum := 0.0
+		searchTimes := make([]int64, 0, len(run.Results))
+
+		for _, result := range run.Results {
+			questionText := strings.TrimSpace(result.Question.Text)
+			if questionText == "" {
+				continue
+			}
+
+			start := time.Now()
+			chunks, diagnostics, searchErr := h.rag.Retrieve(c.UserContext(), questionText, topK, run.ChatID, &settings, accessLevel)
+			searchTimes = append(searchTimes, time.Since(start).Milliseconds())
+			if searchErr != nil {
+				log.Printf("strategy compare error run=%s strategy=%s question=%s: %v", run.ID, strategy, result.Question.ID, searchErr)
+				metrics.ErrorCount++
+				continue
+			}
+
+			if result.Question.ExpectedNoAnswer {
+				noAnswerTotal++
+				// Отбор чанков при пустом результате берёт первые фрагменты без учёта порога,
+				// поэтому отказ определяем по порогу: ни один кандидат не прошёл max_cosine_distance.
+				if !service.PassesDistanceThreshold(chunks, diagnostics.MaxCosineDistance) {
+					refusals++
+				}
+				continue
+			}
+
+			reference := strings.TrimSpace(result.Question.SourceHint + " " + result.Question.ExpectedAnswer)
+			if reference == "" {
+				continue
+			}
+			metrics.RankedSamples++
+			for i, ch := range chunks {
+				if service.ReferenceOverlapScore(reference, ch) >= minReferenceOverlap {
+					hits++
+					reciprocalRankSum += 1.0 / float64(i+1)
+					break
+				}
+			}
+		}
+
+		metrics.ContextHitRate = safeRate(float64(hits), float64(metrics.RankedSamples))
+		metrics.MRR = safeRate(reciprocalRankSum, float64(metrics.RankedSamples))
+		metrics.CorrectRefusal = safeRate(float64(refusals), float64(noAnswerTotal))
+		if len(searchTimes) > 0 {
+			sort.Slice(searchTimes, func(i, j int) bool { return searchTimes[i] < searchTimes[j] })
+			var sum int64
+			for _, t := range searchTimes {
+				sum += t
+			}
+			metrics.AvgSearchMs = float64(sum) / float64(len(searchTimes))
+			metrics.P95SearchMs = percentile95(searchTimes)
+		}
+
+		resp.Strategies = append(resp.Strategies, metrics)
+	}
+
+	return c.JSON(resp)
+}
+
 func (h *Handler) CalibrateRunRetrieval(c *fiber.Ctx) error {
 	runID, err := uuid.Parse(c.Params("run_id"))
 	if err != nil {
@@ -1194,6 +1657,10 @@ func (h *Handler) ApplyRunRetrievalCalibration(c *fiber.Ctx) error {
 
 	chatSettings.Settings["maxCosineDistance"] = calibration.RecommendedMaxCosineDistance
 	chatSettings.Settings["maxDistanceGap"] = calibration.RecommendedMaxDistanceGap
+	chatSettings.Settings["vectorWeight"] = calibration.RecommendedVectorWeight
+	chatSettings.Settings["keywordWeight"] = calibration.RecommendedKeywordWeight
+	chatSettings.Settings["rrfWeight"] = calibration.RecommendedRRFWeight
+	chatSettings.Settings["rrfDenominator"] = calibration.RecommendedRRFDenominator
 
 	mode, _ := chatSettings.Settings["retrievalMode"].(string)
 	if strings.TrimSpace(mode) == "" {
@@ -1210,6 +1677,7 @@ func (h *Handler) ApplyRunRetrievalCalibration(c *fiber.Ctx) error {
 	if err := h.chatSettings.CreateOrUpdate(ctx, chatSettings); err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
+	h.rag.InvalidateAnswerCache(run.ChatID)
 
 	return c.JSON(dto.RetrievalCalibrationApplyResponse{
 		RunID:             calibration.RunID,
@@ -1219,6 +1687,10 @@ func (h *Handler) ApplyRunRetrievalCalibration(c *fiber.Ctx) error {
 		RetrievalMode:     mode,
 		MaxCosineDistance: calibration.RecommendedMaxCosineDistance,
 		MaxDistanceGap:    calibration.RecommendedMaxDistanceGap,
+		VectorWeight:      calibration.RecommendedVectorWeight,
+		KeywordWeight:     calibration.RecommendedKeywordWeight,
+		RRFWeight:         calibration.RecommendedRRFWeight,
+		RRFDenominator:    calibration.RecommendedRRFDenominator,
 		Calibration:       calibration,
 	})
 }
@@ -1257,21 +1729,30 @@ func (h *Handler) buildRunRetrievalCalibration(c *fiber.Ctx, runID uuid.UUID) (*
 			continue
 		}
 
-		emb, embErr := h.llm.EmbeddingWithSettings(questionText, nil)
+		emb, embErr := h.llm.EmbeddingWithSettings(c.UserContext(), questionText, nil)
 		if embErr != nil {
 			log.Printf("calibration embedding error run=%s question=%s: %v", run.ID, result.Question.ID, embErr)
 			continue
 		}
 
-		chunks, searchErr := h.chunkService.SearchSimilar(emb, candidateLimit, run.ChatID, accessLevel)
+		chunks, searchErr := h.chunkService.SearchSimilar(c.UserContext(), emb, candidateLimit, run.ChatID, accessLevel)
 		if searchErr != nil {
 			log.Printf("calibration search error run=%s question=%s: %v", run.ID, result.Question.ID, searchErr)
 			continue
 		}
 
+		keywordChunks, keywordErr := h.chunkService.SearchByKeyword(c.UserContext(), questionText, candidateLimit, run.ChatID, accessLevel)
+		if keywordErr != nil {
+			log.Printf("calibration keyword search error run=%s question=%s: %v", run.ID, result.Question.ID, keywordErr)
+			keywordChunks = nil
+		}
+
 		samples = append(samples, retrievalCalibrationSample{
-			ExpectedNoAnswer: result.Question.ExpectedNoAnswer,
-			Candidates:       chunks,
+			Query:             questionText,
+			ExpectedNoAnswer:  result.Question.ExpectedNoAnswer,
+			Reference:         strings.TrimSpace(result.Question.SourceHint + " " + result.Question.ExpectedAnswer),
+			Candidates:        chunks,
+			KeywordCandidates: keywordChunks,
 		})
 	}
 
@@ -1292,6 +1773,34 @@ func (h *Handler) buildRunRetrievalCalibration(c *fiber.Ctx, runID uuid.UUID) (*
 		}
 	}
 
+	// Веса гибридного ранжирования подбираем при уже найденных порогах:
+	// стартуем с текущих значений по умолчанию и меняем их только при строгом улучшении.
+	// Нулевой вес выключает сигнал, поэтому сетка включает и чисто векторное ранжирование.
+	vectorGrid := []float32{0.40, 0.50, 0.62, 0.70, 0.80, 0.90, 1.00}
+	rrfGrid := []float32{0, 0.05, 0.10, 0.20}
+	denominatorGrid := []float32{20, 40, 60, 100}
+
+	bestWeights := evaluateHybridWeightsCalibration(h.rag, samples, topK, best, service.ResolveHybridWeights(nil))
+	for _, vectorWeight := range vectorGrid {
+		for _, rrfWeight := range rrfGrid {
+			keywordWeight := float32(math.Round(float64(1-vectorWeight-rrfWeight)*100) / 100)
+			if keywordWeight < 0 {
+				continue
+			}
+			for _, denominator := range denominatorGrid {
+				current := evaluateHybridWeightsCalibration(h.rag, samples, topK, best, service.HybridWeights{
+					Vector:         vectorWeight,
+					Keyword:        keywordWeight,
+					RRF:            rrfWeight,
+					RRFDenominator: denominator,
+				})
+				if current.MRR > bestWeights.MRR || (current.MRR == bestWeights.MRR && current.ContextHitRate > bestWeights.ContextHitRate) {
+					bestWeights = current
+				}
+			}
+		}
+	}
+
 	resp := dto.RetrievalCalibrationResponse{
 		RunID:                        run.ID,
 		TopK:                         topK,
@@ -1307,6 +1816,13 @@ func (h *Handler) buildRunRetrievalCalibration(c *fiber.Ctx, runID uuid.UUID) (*
 		TrueNegative:                 best.TrueNegative,
 		FalsePositive:                best.FalsePositive,
 		FalseNegative:                best.FalseNegative,
+		RecommendedVectorWeight:      bestWeights.Weights.Vector,
+		RecommendedKeywordWeight:     bestWeights.Weights.Keyword,
+		RecommendedRRFWeight:         bestWeights.Weights.RRF,
+		RecommendedRRFDenominator:    bestWeights.Weights.RRFDenominator,
+		RankingSamples:               bestWeights.RankedSamples,
+		ContextHitRate:               bestWeights.ContextHitRate,
+		MRR:                          bestWeights.MRR,
 	}
 
 	return run, resp, nil
@@ -1357,6 +1873,8 @@ func mapEvaluationRunToDTO(run models.EvaluationRun) dto.EvaluationRunResponse {
 		Status:         run.Status,
 		Model:          run.Model,
 		TopK:           run.TopK,
+		RetrievalMode:  run.RetrievalMode,
+		QueryStrategy:  run.QueryStrategy,
 		TotalQuestions: run.TotalQuestions,
 		EvaluatedCount: run.EvaluatedCount,
 		CorrectCount:   run.CorrectCount,
@@ -1455,10 +1973,23 @@ func percentile95(values []int64) int64 {
 }
 
 type retrievalCalibrationSample struct {
-	ExpectedNoAnswer bool
-	Candidates       []models.Chunk
+	Query             string
+	ExpectedNoAnswer  bool
+	Reference         string
+	Candidates        []models.Chunk
+	KeywordCandidates []models.Chunk
+}
+
+type retrievalWeightsMetrics struct {
+	Weights        service.HybridWeights
+	RankedSamples  int
+	ContextHitRate float64
+	MRR            float64
 }
 
+// minReferenceOverlap — доля терминов эталонной подсказки, при которой чанк считается релевантным.
+const minReferenceOverlap = 0.5
+
 type retrievalCalibrationMetrics struct {
 	MaxCosineDistance float32
 	MaxDistanceGap    float32
@@ -1513,6 +2044,43 @@ func evaluateRetrievalCalibration(rag *service.RAGService, samples []retrievalCa
 	return metrics
 }
 
+// evaluateHybridWeightsCalibration оценивает качество ранжирования для набора весов:
+// по вопросам с ожидаемым ответом считаем долю попаданий релевантного чанка в выборку и MRR.
+func evaluateHybridWeightsCalibration(rag *service.RAGService, samples []retrievalCalibrationSample, topK int, thresholds retrievalCalibrationMetrics, weights service.HybridWeights) retrievalWeightsMetrics {
+	tuning := &models.AskSettings{
+		MaxCosineDistance: thresholds.MaxCosineDistance,
+		MaxDistanceGap:    thresholds.MaxDistanceGap,
+		VectorWeight:      &weights.Vector,
+		KeywordWeight:     &weights.Keyword,
+		RRFWeight:         &weights.RRF,
+		RRFDenominator:    weights.RRFDenominator,
+	}
+
+	metrics := retrievalWeightsMetrics{Weights: weights}
+	hits := 0
+	reciprocalRankSum := 0.0
+
+	for _, sample := range samples {
+		if sample.ExpectedNoAnswer || sample.Reference == "" {
+			continue
+		}
+		metrics.RankedSamples++
+
+		selected := rag.ApplyHybridRanking(sample.Query, sample.Candidates, sample.KeywordCandidates, topK, tuning)
+		for i, ch := range selected {
+			if service.ReferenceOverlapScore(sample.Reference, ch) >= minReferenceOverlap {
+				hits++
+				reciprocalRankSum += 1.0 / float64(i+1)
+				break
+			}
+		}
+	}
+
+	metrics.ContextHitRate = safeRate(float64(hits), float64(metrics.RankedSamples))
+	metrics.MRR = safeRate(reciprocalRankSum, float64(metrics.RankedSamples))
+	return metrics
+}
+
 func normalizeChatContextRole(role string) (string, bool) {
 	if isUserRole(role) {
 		return "user", true
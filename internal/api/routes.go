@@ -13,7 +13,7 @@ import (
 	"github.com/katakuxiko/Diplom/internal/service"
 )
 
-func RegisterRoutes(app *fiber.App, cfg *config.Config, rag *service.RAGService, llm *service.LLMClient, chunkService *service.ChunkService, adminService *service.AdminService, chatService *service.ChatService, documentService *service.DocumentService, chatuserService *service.ChatUserService, chatSettingsService *service.ChatSettingsService, chatHistoryRepo *repository.ChatHistoryRepository, messageRepo *repository.MessageRepository, evaluationService *service.EvaluationService) {
+func RegisterRoutes(app *fiber.App, cfg *config.Config, rag *service.RAGService, llm *service.LLMClient, chunkService *service.ChunkService, adminService *service.AdminService, chatService *service.ChatService, documentService *service.DocumentService, chatuserService *service.ChatUserService, chatSettingsService *service.ChatSettingsService, chatHistoryRepo *repository.ChatHistoryRepository, messageRepo *repository.MessageRepository, evaluationService *service.EvaluationService, vectorIndexService *service.VectorIndexService, synonymService *service.SynonymService, promptTemplateService *service.PromptTemplateService) {
 
 	h := NewHandler(rag, llm, chunkService, chatSettingsService, chatHistoryRepo, messageRepo, evaluationService)
 	docH := handlers.NewDocumentHandler(documentService, chunkService, llm, cfg, chatSettingsService)
@@ -21,11 +21,14 @@ func RegisterRoutes(app *fiber.App, cfg *config.Config, rag *service.RAGService,
 	handlers.RegisterAuthRoutes(app, adminService, chatuserService, cfg)
 
 	handlers.RegisterAdminRoutes(app, adminService)
-	handlers.RegisterDocumentRoutes(app, documentService, cfg)
+	handlers.RegisterDocumentRoutes(app, documentService, cfg, rag)
 	routes.RegisterChatRoutes(app, chatService)
 	handlers.RegisterChatUserRoutes(app, chatuserService)
-	chatSettingsHandler := &handlers.ChatSettingsHandler{Service: chatSettingsService}
+	chatSettingsHandler := &handlers.ChatSettingsHandler{Service: chatSettingsService, RAG: rag}
 	routes.RegisterChatSettingsRoutes(app, chatSettingsHandler)
+	handlers.RegisterVectorIndexRoutes(app, vectorIndexService)
+	handlers.RegisterSynonymRoutes(app, synonymService, rag)
+	handlers.RegisterPromptTemplateRoutes(app, promptTemplateService)
 
 	askLimiter := limiter.New(limiter.Config{
 		Max:        60,
@@ -37,8 +40,9 @@ func RegisterRoutes(app *fiber.App, cfg *config.Config, rag *service.RAGService,
 		Expiration: time.Minute,
 	})
 
-	// Публичные эндпоинты (анонимный доступ) — создание истории, отправка сообщений и запрос к RAG
+	// Публичные эндпоинты (анонимный доступ) — создание истории, отправка сообщений, запрос к RAG и поиск
 	app.Post("/ask", askLimiter, h.AskQuestion)
+	app.Post("/search", askLimiter, h.Search)
 	app.Post("/chat_histories", h.CreateChatHistory)
 	app.Post("/messages", h.CreateMessage)
 
@@ -51,7 +55,12 @@ func RegisterRoutes(app *fiber.App, cfg *config.Config, rag *service.RAGService,
 	newApp.Post("/documents/upload", docH.UploadAndIngestPDF)
 	newApp.Get("/health", h.Health)
 	newApp.Get("/models", h.ListModels)
+	newApp.Get("/models/registry", h.ListModelRegistry)
+	newApp.Get("/providers", h.ListProviders)
+	newApp.Get("/admin/cache", middleware.SuperadminProtected(), h.CacheStats)
+	newApp.Delete("/admin/cache", middleware.SuperadminProtected(), h.ClearCaches)
 	newApp.Post("/ingest", h.IngestPDF)
+	newApp.Post("/chats/:chat_id/prompt-templates/preview", h.PreviewPrompt)
 	newApp.Post("/chats/:chat_id/test-questions", h.CreateTestQuestion)
 	newApp.Post("/chats/:chat_id/test-questions/batch", h.CreateTestQuestionsBatch)
 	newApp.Get("/chats/:chat_id/test-questions", h.ListTestQuestions)
@@ -61,6 +70,7 @@ func RegisterRoutes(app *fiber.App, cfg *config.Config, rag *service.RAGService,
 	newApp.Get("/evaluations/runs/:run_id", h.GetEvaluationRun)
 	newApp.Get("/evaluations/runs/:run_id/metrics", h.GetEvaluationRunMetrics)
 	newApp.Get("/evaluations/runs/:run_id/baseline", h.CompareRunWithBaseline)
+	newApp.Get("/evaluations/runs/:run_id/retrieval-strategies", h.CompareRunRetrievalStrategies)
 	newApp.Get("/evaluations/runs/:run_id/retrieval-calibration", h.CalibrateRunRetrieval)
 	newApp.Post("/evaluations/runs/:run_id/retrieval-calibration/apply", h.ApplyRunRetrievalCalibration)
 	newApp.Put("/evaluations/results/:result_id/score", h.ScoreEvaluationResult)
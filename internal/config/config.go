@@ -3,6 +3,7 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 	"github.com/katakuxiko/Diplom/internal/storage"
@@ -14,6 +15,12 @@ type Config struct {
 	EmbedModel string
 	ChatModel  string
 	LMBaseURL  string
+	// Тип локального сервера моделей: "openai" (LM Studio и другие OpenAI-совместимые), "ollama", "llamacpp"
+	LMProviderType string
+	// Circuit breaker провайдеров: после ProviderBreakerFailures подряд неудачных запросов к адресу
+	// он пропускается ProviderBreakerCooldownSeconds секунд
+	ProviderBreakerFailures        int
+	ProviderBreakerCooldownSeconds int
 
 	// MinIO
 	MinioEndpoint string
@@ -24,6 +31,12 @@ type Config struct {
 	MinioStorage  *storage.MinioStorage
 
 	JWTSecret []byte
+
+	// Векторный индекс pgvector: "ivfflat" или "hnsw" (0 — значения по умолчанию)
+	VectorIndexType          string
+	VectorIVFLists           int
+	VectorHNSWM              int
+	VectorHNSWEfConstruction int
 }
 
 func Load() *Config {
@@ -32,11 +45,15 @@ func Load() *Config {
 	}
 
 	return &Config{
-		PgConn:     getenv("PG_CONN", "host=localhost port=5432 user=postgres password=111 dbname=DiplomaDB sslmode=disable"),
-		ServerAddr: getenv("SERVER_ADDR", ":8080"),
-		EmbedModel: getenv("EMBED_MODEL", "text-embedding-nomic-embed-text-v1.5"),
-		ChatModel:  getenv("LLM_MODEL", "liquid/lfm2-1.2b"),
-		LMBaseURL:  getenv("LMSTUDIO_BASE_URL", "http://localhost:1234/v1"),
+		PgConn:         getenv("PG_CONN", "host=localhost port=5432 user=postgres password=111 dbname=DiplomaDB sslmode=disable"),
+		ServerAddr:     getenv("SERVER_ADDR", ":8080"),
+		EmbedModel:     getenv("EMBED_MODEL", "text-embedding-nomic-embed-text-v1.5"),
+		ChatModel:      getenv("LLM_MODEL", "liquid/lfm2-1.2b"),
+		LMBaseURL:      getenv("LMSTUDIO_BASE_URL", "http://localhost:1234/v1"),
+		LMProviderType: getenv("LM_PROVIDER_TYPE", "openai"),
+
+		ProviderBreakerFailures:        getenvInt("PROVIDER_BREAKER_FAILURES", 3),
+		ProviderBreakerCooldownSeconds: getenvInt("PROVIDER_BREAKER_COOLDOWN_SECONDS", 30),
 
 		MinioEndpoint: getenv("MINIO_ENDPOINT", "localhost:9000"),
 		MinioAccess:   getenv("MINIO_ACCESS_KEY", "admin"),
@@ -44,6 +61,11 @@ func Load() *Config {
 		MinioBucket:   getenv("MINIO_BUCKET", "documents"),
 		MinioUseSSL:   getenvBool("MINIO_USE_SSL", false),
 		JWTSecret:     []byte(getenv("JWT_SECRET", "sadadasdasd")),
+
+		VectorIndexType:          getenv("VECTOR_INDEX_TYPE", "ivfflat"),
+		VectorIVFLists:           getenvInt("VECTOR_IVF_LISTS", 0),
+		VectorHNSWM:              getenvInt("VECTOR_HNSW_M", 16),
+		VectorHNSWEfConstruction: getenvInt("VECTOR_HNSW_EF_CONSTRUCTION", 64),
 	}
 }
 
@@ -54,6 +76,16 @@ func getenv(k, def string) string {
 	return def
 }
 
+func getenvInt(k string, def int) int {
+	if v := os.Getenv(k); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+		log.Printf("  некорректное значение %s=%q, используем %d", k, v, def)
+	}
+	return def
+}
+
 func getenvBool(k string, def bool) bool {
 	if v := os.Getenv(k); v != "" {
 		if v == "true" || v == "1" {
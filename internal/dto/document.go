@@ -20,6 +20,15 @@ type DocumentResponseDTO struct {
 	Protected   bool      `json:"protected"`
 	AccessLevel int       `json:"access_level"`
 	CreatedDate time.Time `json:"created_date"`
+	// Период действия документа (для поиска "as of" и учёта свежести)
+	EffectiveDate *time.Time `json:"effective_date,omitempty"`
+	ValidUntil    *time.Time `json:"valid_until,omitempty"`
+}
+
+// DocumentValidityRequest — период действия документа; null очищает значение.
+type DocumentValidityRequest struct {
+	EffectiveDate *models.FilterDate `json:"effective_date" swaggertype:"string" example:"2025-09-01"`
+	ValidUntil    *models.FilterDate `json:"valid_until" swaggertype:"string" example:"2026-09-01"`
 }
 
 type PaginatedDocuments struct {
@@ -71,6 +71,8 @@ type EvaluationRunResponse struct {
 	Status         string                     `json:"status"`
 	Model          string                     `json:"model"`
 	TopK           int                        `json:"top_k"`
+	RetrievalMode  string                     `json:"retrieval_mode"`
+	QueryStrategy  string                     `json:"query_strategy"`
 	TotalQuestions int                        `json:"total_questions"`
 	EvaluatedCount int                        `json:"evaluated_count"`
 	CorrectCount   int                        `json:"correct_count"`
@@ -86,6 +88,8 @@ type EvaluationRunListItem struct {
 	Status         string     `json:"status"`
 	Model          string     `json:"model"`
 	TopK           int        `json:"top_k"`
+	RetrievalMode  string     `json:"retrieval_mode"`
+	QueryStrategy  string     `json:"query_strategy"`
 	TotalQuestions int        `json:"total_questions"`
 	EvaluatedCount int        `json:"evaluated_count"`
 	CorrectCount   int        `json:"correct_count"`
@@ -123,6 +127,26 @@ type EvaluationBaselineCompareResponse struct {
 	BaselineP95SearchMs    int64     `json:"baseline_p95_search_ms"`
 }
 
+// RetrievalStrategyMetrics — качество поиска (без генерации) для одной стратегии на контрольных вопросах.
+type RetrievalStrategyMetrics struct {
+	RetrievalMode  string  `json:"retrieval_mode"`
+	QueryStrategy  string  `json:"query_strategy"`
+	RankedSamples  int     `json:"ranking_samples"`
+	ContextHitRate float64 `json:"context_hit_rate"`
+	MRR            float64 `json:"mrr"`
+	CorrectRefusal float64 `json:"correct_refusal_rate"` // доля вопросов без ответа, где ни один кандидат не прошёл max_cosine_distance
+	ErrorCount     int     `json:"error_count"`
+	AvgSearchMs    float64 `json:"avg_search_ms"`
+	P95SearchMs    int64   `json:"p95_search_ms"`
+}
+
+type RetrievalStrategyCompareResponse struct {
+	RunID          uuid.UUID                  `json:"run_id"`
+	TopK           int                        `json:"top_k"`
+	QuestionsTotal int                        `json:"questions_total"`
+	Strategies     []RetrievalStrategyMetrics `json:"strategies"`
+}
+
 type RetrievalCalibrationResponse struct {
 	RunID                        uuid.UUID `json:"run_id"`
 	TopK                         int       `json:"top_k"`
@@ -138,6 +162,13 @@ type RetrievalCalibrationResponse struct {
 	TrueNegative                 int       `json:"true_negative"`
 	FalsePositive                int       `json:"false_positive"`
 	FalseNegative                int       `json:"false_negative"`
+	RecommendedVectorWeight      float32   `json:"recommended_vector_weight"`
+	RecommendedKeywordWeight     float32   `json:"recommended_keyword_weight"`
+	RecommendedRRFWeight         float32   `json:"recommended_rrf_weight"`
+	RecommendedRRFDenominator    float32   `json:"recommended_rrf_denominator"`
+	RankingSamples               int       `json:"ranking_samples"`
+	ContextHitRate               float64   `json:"context_hit_rate"`
+	MRR                          float64   `json:"mrr"`
 }
 
 type RetrievalCalibrationApplyResponse struct {
@@ -148,5 +179,9 @@ type RetrievalCalibrationApplyResponse struct {
 	RetrievalMode     string                       `json:"retrieval_mode"`
 	MaxCosineDistance float32                      `json:"max_cosine_distance"`
 	MaxDistanceGap    float32                      `json:"max_distance_gap"`
+	VectorWeight      float32                      `json:"vector_weight"`
+	KeywordWeight     float32                      `json:"keyword_weight"`
+	RRFWeight         float32                      `json:"rrf_weight"`
+	RRFDenominator    float32                      `json:"rrf_denominator"`
 	Calibration       RetrievalCalibrationResponse `json:"calibration"`
 }
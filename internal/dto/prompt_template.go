@@ -0,0 +1,23 @@
+package dto
+
+import "github.com/katakuxiko/Diplom/internal/models"
+
+// PromptTemplateRequest — новая версия шаблона промпта; пустые части берутся из шаблона по умолчанию
+type PromptTemplateRequest struct {
+	Name        string `json:"name" example:"formal"`
+	Description string `json:"description,omitempty"`
+	System      string `json:"system,omitempty" example:"Ты — помощник чата «{{.ChatName}}». Сегодня {{.Date}}.\n\n{{.LanguagePolicy}}"`
+	User        string `json:"user,omitempty" example:"CONTEXT:\n{{.Context}}\n\nQUESTION:\n{{.Question}}"`
+	Refusal     string `json:"refusal,omitempty"`
+	Greeting    string `json:"greeting,omitempty"`
+}
+
+// PromptPreviewRequest — вопрос, для которого нужно показать итоговые сообщения модели.
+// Если Context не задан, контекст собирается поиском по документам чата, как в /ask.
+type PromptPreviewRequest struct {
+	Query    string                   `json:"query"`
+	Context  *string                  `json:"context,omitempty"`
+	TopK     int                      `json:"topK,omitempty"`
+	Settings *models.AskSettings      `json:"settings,omitempty"`
+	Filters  *models.RetrievalFilters `json:"filters,omitempty"`
+}
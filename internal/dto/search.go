@@ -0,0 +1,23 @@
+package dto
+
+import (
+	"github.com/google/uuid"
+)
+
+// SearchResultItem — найденный чанк с оценками всех этапов ранжирования.
+type SearchResultItem struct {
+	Rank             int       `json:"rank"`
+	ChunkID          uuid.UUID `json:"chunk_id"`
+	DocID            uuid.UUID `json:"doc_id"`
+	DocName          string    `json:"doc_name"`
+	ChunkName        string    `json:"chunk_name"`
+	ChunkIndex       int       `json:"chunk_index"`
+	Text             string    `json:"text"`
+	MatchedText      string    `json:"matched_text,omitempty"`
+	Language         string    `json:"language,omitempty"`
+	VectorDistance   *float32  `json:"vector_distance,omitempty"`
+	VectorSimilarity *float32  `json:"vector_similarity,omitempty"`
+	KeywordScore     float32   `json:"keyword_score"`
+	HybridScore      float32   `json:"hybrid_score"`
+	RetrievalSource  string    `json:"retrieval_source"`
+}
@@ -0,0 +1,14 @@
+package dto
+
+type SynonymRequest struct {
+	Term     string   `json:"term" example:"кгу"`
+	Synonyms []string `json:"synonyms" example:"карагандинский государственный университет"`
+	Kind     string   `json:"kind,omitempty" example:"abbreviation"` // "synonym" (по умолчанию) или "abbreviation"
+}
+
+// SynonymImportResponse — итог импорта словаря из CSV
+type SynonymImportResponse struct {
+	Imported int      `json:"imported"`
+	Skipped  int      `json:"skipped"`
+	Errors   []string `json:"errors,omitempty"`
+}
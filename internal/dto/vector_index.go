@@ -0,0 +1,44 @@
+package dto
+
+import "github.com/katakuxiko/Diplom/internal/repository"
+
+// VectorIndexHealthResponse — состояние векторного индекса по chunks.embedding
+type VectorIndexHealthResponse struct {
+	ConfiguredType      string                       `json:"configured_type"`
+	Indexes             []repository.VectorIndexInfo `json:"indexes"`
+	Table               repository.VectorTableStats  `json:"table"`
+	RecommendedIVFLists int                          `json:"recommended_ivf_lists"`
+	SearchSettings      map[string]string            `json:"search_settings"`
+	Warnings            []string                     `json:"warnings,omitempty"`
+}
+
+// VectorIndexRebuildRequest — параметры перестроения; пустые поля берутся из конфигурации сервера
+type VectorIndexRebuildRequest struct {
+	Type           string `json:"type" example:"hnsw"`
+	Lists          int    `json:"lists,omitempty"`
+	M              int    `json:"m,omitempty"`
+	EfConstruction int    `json:"ef_construction,omitempty"`
+}
+
+// VectorIndexBenchmarkRequest — замер recall@k приблизительного поиска относительно точного
+type VectorIndexBenchmarkRequest struct {
+	ChatID   string `json:"chat_id,omitempty"`
+	Samples  int    `json:"samples,omitempty" example:"50"`
+	K        int    `json:"k,omitempty" example:"10"`
+	EfSearch int    `json:"ef_search,omitempty"`
+	Probes   int    `json:"probes,omitempty"`
+}
+
+type VectorIndexBenchmarkResponse struct {
+	Samples          int                          `json:"samples"`
+	K                int                          `json:"k"`
+	EfSearch         int                          `json:"ef_search,omitempty"`
+	Probes           int                          `json:"probes,omitempty"`
+	Indexes          []repository.VectorIndexInfo `json:"indexes"`
+	RecallAvg        float64                      `json:"recall_avg"`
+	RecallMin        float64                      `json:"recall_min"`
+	ApproxLatencyAvg float64                      `json:"approx_latency_avg_ms"`
+	ApproxLatencyP95 float64                      `json:"approx_latency_p95_ms"`
+	ExactLatencyAvg  float64                      `json:"exact_latency_avg_ms"`
+	ExactLatencyP95  float64                      `json:"exact_latency_p95_ms"`
+}
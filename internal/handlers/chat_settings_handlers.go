@@ -17,6 +17,14 @@ import (
 
 type ChatSettingsHandler struct {
 	Service *service.ChatSettingsService // Сервис для работы с настройками чата
+	RAG     *service.RAGService          // Сброс кэша ответов чата после изменения настроек
+}
+
+// invalidateAnswers сбрасывает кэш ответов чата после изменения его настроек.
+func (h *ChatSettingsHandler) invalidateAnswers(chatID uuid.UUID) {
+	if h.RAG != nil {
+		h.RAG.InvalidateAnswerCache(chatID)
+	}
 }
 
 // sanitizeSettings возвращает копию settings без полей с секретами,
@@ -191,6 +199,7 @@ func (h *ChatSettingsHandler) CreateOrUpdateChatSettings(c *fiber.Ctx) error {
 	if err := h.Service.CreateOrUpdate(context.Background(), settings); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
+	h.invalidateAnswers(settings.ChatID)
 
 	// Формируем ответ
 	response := &dto.ChatSettingResponse{
@@ -352,6 +361,7 @@ func (h *ChatSettingsHandler) UpdateChatSettings(c *fiber.Ctx) error {
 	if err := h.Service.Update(context.Background(), settings); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
+	h.invalidateAnswers(settings.ChatID)
 
 	response := &dto.ChatSettingResponse{
 		ID:          settings.ID,
@@ -382,9 +392,14 @@ func (h *ChatSettingsHandler) DeleteChatSettings(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id format"})
 	}
+	existing, err := h.Service.GetByID(context.Background(), id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
 	if err := h.Service.Delete(context.Background(), id); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
+	h.invalidateAnswers(existing.ChatID)
 	return c.SendStatus(fiber.StatusNoContent)
 }
 
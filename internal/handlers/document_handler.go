@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
@@ -19,11 +21,13 @@ import (
 
 var documentService *service.DocumentService
 var cfg *config.Config
+var ragService *service.RAGService
 
 // RegisterDocumentRoutes регистрирует CRUD эндпоинты для документов
-func RegisterDocumentRoutes(app *fiber.App, svc *service.DocumentService, cfgo *config.Config) {
+func RegisterDocumentRoutes(app *fiber.App, svc *service.DocumentService, cfgo *config.Config, rag *service.RAGService) {
 	documentService = svc
 	cfg = cfgo
+	ragService = rag
 	r := app.Group("/documents", middleware.JWTProtected())
 
 	r.Post("/", CreateDocument)
@@ -34,6 +38,7 @@ func RegisterDocumentRoutes(app *fiber.App, svc *service.DocumentService, cfgo *
 	r.Delete("/:id", DeleteDocument)
 	r.Put("/:id/access", UpdateDocumentAccess)
 	r.Put("/:id/tags", UpdateDocumentTags)
+	r.Put("/:id/validity", UpdateDocumentValidity)
 
 	// Публичный эндпоинт для скачивания документов с access_level=0 (без JWT)
 	app.Get("/public/documents/:id/download", DownloadPublicDocument)
@@ -79,10 +84,18 @@ func CreateDocument(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
+	invalidateAnswerCache(chatID)
 
 	return c.Status(201).JSON(doc)
 }
 
+// invalidateAnswerCache сбрасывает кэш ответов чата после изменения его документов.
+func invalidateAnswerCache(chatID uuid.UUID) {
+	if ragService != nil {
+		ragService.InvalidateAnswerCache(chatID)
+	}
+}
+
 // GetDocuments godoc
 // @Summary      Получить документы чата с пагинацией
 // @Description  Возвращает список документов для конкретного чата с пагинацией
@@ -300,9 +313,14 @@ func DeleteDocument(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
 	}
 
+	doc, err := documentService.GetDocument(id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
 	if err := documentService.DeleteDocument(id); err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
+	invalidateAnswerCache(doc.ChatID)
 
 	return c.SendStatus(204)
 }
@@ -334,6 +352,7 @@ func UpdateDocumentAccess(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
+	invalidateAnswerCache(doc.ChatID)
 
 	return c.JSON(doc)
 }
@@ -366,6 +385,49 @@ func UpdateDocumentTags(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
+	invalidateAnswerCache(doc.ChatID)
+
+	return c.JSON(doc)
+}
+
+// UpdateDocumentValidity godoc
+// @Summary      Обновить период действия документа
+// @Description  Задаёт дату вступления в силу и дату утраты силы (используются в поиске asOf и при учёте свежести)
+// @Tags         documents
+// @Param        id    path   string true "Document ID"
+// @Param        body  body   dto.DocumentValidityRequest true "{\"effective_date\":\"2025-09-01\",\"valid_until\":null}"
+// @Success      200   {object} dto.DocumentResponseDTO
+// @Failure      400   {object} map[string]string
+// @Failure      500   {object} map[string]string
+// @Router       /documents/{id}/validity [put]
+// @Security     BearerAuth
+func UpdateDocumentValidity(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+	}
+
+	var payload dto.DocumentValidityRequest
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid body"})
+	}
+
+	var effectiveDate, validUntil *time.Time
+	if payload.EffectiveDate != nil {
+		effectiveDate = &payload.EffectiveDate.Time
+	}
+	if payload.ValidUntil != nil {
+		validUntil = &payload.ValidUntil.Time
+	}
+
+	doc, err := documentService.UpdateValidity(id, effectiveDate, validUntil)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidValidityPeriod) {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	invalidateAnswerCache(doc.ChatID)
 
 	return c.JSON(doc)
 }
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/katakuxiko/Diplom/internal/dto"
+	"github.com/katakuxiko/Diplom/internal/middleware"
+	"github.com/katakuxiko/Diplom/internal/service"
+)
+
+// PromptTemplateHandler — версионированные шаблоны промптов чата.
+type PromptTemplateHandler struct {
+	Service *service.PromptTemplateService
+}
+
+// RegisterPromptTemplateRoutes регистрирует шаблоны промптов чата (предпросмотр — в api.RegisterRoutes)
+func RegisterPromptTemplateRoutes(app *fiber.App, svc *service.PromptTemplateService) {
+	h := &PromptTemplateHandler{Service: svc}
+	r := app.Group("/chats/:chat_id/prompt-templates", middleware.JWTProtected())
+
+	r.Get("/", h.ListPromptTemplates)
+	r.Post("/", h.SavePromptTemplate)
+	r.Get("/:name", h.ListPromptTemplateVersions)
+	r.Delete("/:name", h.DeletePromptTemplate)
+}
+
+// ListPromptTemplates godoc
+// @Summary      Шаблоны промптов чата
+// @Description  Все версии всех шаблонов промптов чата (новые версии первыми)
+// @Tags         prompt-templates
+// @Produce      json
+// @Param        chat_id path string true "Chat ID"
+// @Success      200 {array} models.ChatPromptTemplate
+// @Failure      400 {object} map[string]string
+// @Failure      500 {object} map[string]string
+// @Router       /chats/{chat_id}/prompt-templates [get]
+// @Security     BearerAuth
+func (h *PromptTemplateHandler) ListPromptTemplates(c *fiber.Ctx) error {
+	chatID, err := uuid.Parse(c.Params("chat_id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid chat_id"})
+	}
+	templates, err := h.Service.List(context.Background(), chatID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(templates)
+}
+
+// SavePromptTemplate godoc
+// @Summary      Сохранить шаблон промпта
+// @Description  Сохраняет новую версию шаблона с указанным именем. Части system/user/refusal/greeting — text/template
+// @Description  с переменными {{.Context}}, {{.Question}}, {{.LanguagePolicy}}, {{.Language}}, {{.ChatName}}, {{.Date}};
+// @Description  пустые части берутся из шаблона по умолчанию. Шаблон выбирается настройками promptTemplate / promptTemplateVersion
+// @Tags         prompt-templates
+// @Accept       json
+// @Produce      json
+// @Param        chat_id path string true "Chat ID"
+// @Param        body body dto.PromptTemplateRequest true "Шаблон"
+// @Success      201 {object} models.ChatPromptTemplate
+// @Failure      400 {object} map[string]string
+// @Failure      500 {object} map[string]string
+// @Router       /chats/{chat_id}/prompt-templates [post]
+// @Security     BearerAuth
+func (h *PromptTemplateHandler) SavePromptTemplate(c *fiber.Ctx) error {
+	chatID, err := uuid.Parse(c.Params("chat_id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid chat_id"})
+	}
+	var req dto.PromptTemplateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid body"})
+	}
+
+	tpl, err := h.Service.Save(context.Background(), chatID, req)
+	if err != nil {
+		return promptTemplateError(c, err)
+	}
+	return c.Status(201).JSON(tpl)
+}
+
+// ListPromptTemplateVersions godoc
+// @Summary      Версии шаблона промпта
+// @Tags         prompt-templates
+// @Produce      json
+// @Param        chat_id path string true "Chat ID"
+// @Param        name path string true "Template name"
+// @Success      200 {array} models.ChatPromptTemplate
+// @Failure      400 {object} map[string]string
+// @Failure      404 {object} map[string]string
+// @Failure      500 {object} map[string]string
+// @Router       /chats/{chat_id}/prompt-templates/{name} [get]
+// @Security     BearerAuth
+func (h *PromptTemplateHandler) ListPromptTemplateVersions(c *fiber.Ctx) error {
+	chatID, err := uuid.Parse(c.Params("chat_id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid chat_id"})
+	}
+	versions, err := h.Service.Versions(context.Background(), chatID, c.Params("name"))
+	if err != nil {
+		return promptTemplateError(c, err)
+	}
+	return c.JSON(versions)
+}
+
+// DeletePromptTemplate godoc
+// @Summary      Удалить шаблон промпта
+// @Description  Удаляет все версии шаблона
+// @Tags         prompt-templates
+// @Param        chat_id path string true "Chat ID"
+// @Param        name path string true "Template name"
+// @Success      204
+// @Failure      400 {object} map[string]string
+// @Failure      404 {object} map[string]string
+// @Failure      500 {object} map[string]string
+// @Router       /chats/{chat_id}/prompt-templates/{name} [delete]
+// @Security     BearerAuth
+func (h *PromptTemplateHandler) DeletePromptTemplate(c *fiber.Ctx) error {
+	chatID, err := uuid.Parse(c.Params("chat_id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid chat_id"})
+	}
+	if err := h.Service.Delete(context.Background(), chatID, c.Params("name")); err != nil {
+		return promptTemplateError(c, err)
+	}
+	return c.SendStatus(204)
+}
+
+func promptTemplateError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, service.ErrPromptTemplateNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidPromptTemplate), errors.Is(err, service.ErrInvalidPromptTemplateID):
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	default:
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+}
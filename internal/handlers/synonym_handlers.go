@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/katakuxiko/Diplom/internal/dto"
+	"github.com/katakuxiko/Diplom/internal/middleware"
+	"github.com/katakuxiko/Diplom/internal/service"
+)
+
+// SynonymHandler — словарь синонимов и сокращений чата для расширения поисковых запросов.
+type SynonymHandler struct {
+	Service *service.SynonymService
+	RAG     *service.RAGService // сброс кэша ответов чата после изменения словаря
+}
+
+// RegisterSynonymRoutes регистрирует CRUD словаря синонимов чата и импорт из CSV
+func RegisterSynonymRoutes(app *fiber.App, svc *service.SynonymService, rag *service.RAGService) {
+	h := &SynonymHandler{Service: svc, RAG: rag}
+	r := app.Group("/chats/:chat_id/synonyms", middleware.JWTProtected())
+
+	r.Get("/", h.ListSynonyms)
+	r.Post("/", h.CreateSynonym)
+	r.Post("/import", h.ImportSynonyms)
+	r.Put("/:id", h.UpdateSynonym)
+	r.Delete("/:id", h.DeleteSynonym)
+}
+
+// ListSynonyms godoc
+// @Summary      Словарь синонимов чата
+// @Description  Возвращает все термины словаря синонимов и сокращений чата
+// @Tags         synonyms
+// @Produce      json
+// @Param        chat_id path string true "Chat ID"
+// @Success      200 {array} models.ChatSynonym
+// @Failure      400 {object} map[string]string
+// @Failure      500 {object} map[string]string
+// @Router       /chats/{chat_id}/synonyms [get]
+// @Security     BearerAuth
+func (h *SynonymHandler) ListSynonyms(c *fiber.Ctx) error {
+	chatID, err := uuid.Parse(c.Params("chat_id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid chat_id"})
+	}
+	entries, err := h.Service.List(context.Background(), chatID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(entries)
+}
+
+// CreateSynonym godoc
+// @Summary      Добавить термин в словарь
+// @Description  Добавляет термин с синонимами; существующий термин перезаписывается
+// @Tags         synonyms
+// @Accept       json
+// @Produce      json
+// @Param        chat_id path string true "Chat ID"
+// @Param        body body dto.SynonymRequest true "Термин и синонимы"
+// @Success      201 {object} models.ChatSynonym
+// @Failure      400 {object} map[string]string
+// @Failure      500 {object} map[string]string
+// @Router       /chats/{chat_id}/synonyms [post]
+// @Security     BearerAuth
+func (h *SynonymHandler) CreateSynonym(c *fiber.Ctx) error {
+	chatID, err := uuid.Parse(c.Params("chat_id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid chat_id"})
+	}
+	var req dto.SynonymRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid body"})
+	}
+
+	entry, err := h.Service.Create(context.Background(), chatID, req)
+	if err != nil {
+		return synonymError(c, err)
+	}
+	h.invalidateAnswers(chatID)
+	return c.Status(201).JSON(entry)
+}
+
+// UpdateSynonym godoc
+// @Summary      Изменить термин словаря
+// @Tags         synonyms
+// @Accept       json
+// @Produce      json
+// @Param        chat_id path string true "Chat ID"
+// @Param        id path string true "Synonym ID"
+// @Param        body body dto.SynonymRequest true "Термин и синонимы"
+// @Success      200 {object} models.ChatSynonym
+// @Failure      400 {object} map[string]string
+// @Failure      404 {object} map[string]string
+// @Failure      409 {object} map[string]string
+// @Failure      500 {object} map[string]string
+// @Router       /chats/{chat_id}/synonyms/{id} [put]
+// @Security     BearerAuth
+func (h *SynonymHandler) UpdateSynonym(c *fiber.Ctx) error {
+	chatID, err := uuid.Parse(c.Params("chat_id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid chat_id"})
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+	}
+	var req dto.SynonymRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid body"})
+	}
+
+	entry, err := h.Service.Update(context.Background(), chatID, id, req)
+	if err != nil {
+		return synonymError(c, err)
+	}
+	h.invalidateAnswers(chatID)
+	return c.JSON(entry)
+}
+
+// DeleteSynonym godoc
+// @Summary      Удалить термин словаря
+// @Tags         synonyms
+// @Param        chat_id path string true "Chat ID"
+// @Param        id path string true "Synonym ID"
+// @Success      204
+// @Failure      400 {object} map[string]string
+// @Failure      404 {object} map[string]string
+// @Failure      500 {object} map[string]string
+// @Router       /chats/{chat_id}/synonyms/{id} [delete]
+// @Security     BearerAuth
+func (h *SynonymHandler) DeleteSynonym(c *fiber.Ctx) error {
+	chatID, err := uuid.Parse(c.Params("chat_id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid chat_id"})
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+	}
+
+	if err := h.Service.Delete(context.Background(), chatID, id); err != nil {
+		return synonymError(c, err)
+	}
+	h.invalidateAnswers(chatID)
+	return c.SendStatus(204)
+}
+
+// ImportSynonyms godoc
+// @Summary      Импорт словаря из CSV
+// @Description  CSV: term,synonyms[,kind]; синонимы разделяются ";" или "|", kind — synonym или abbreviation. Файл в поле file или тело text/csv
+// @Tags         synonyms
+// @Accept       multipart/form-data
+// @Produce      json
+// @Param        chat_id path string true "Chat ID"
+// @Param        file formData file false "CSV file"
+// @Success      200 {object} dto.SynonymImportResponse
+// @Failure      400 {object} map[string]string
+// @Failure      500 {object} map[string]string
+// @Router       /chats/{chat_id}/synonyms/import [post]
+// @Security     BearerAuth
+func (h *SynonymHandler) ImportSynonyms(c *fiber.Ctx) error {
+	chatID, err := uuid.Parse(c.Params("chat_id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid chat_id"})
+	}
+
+	var reader io.Reader
+	if fileHeader, ferr := c.FormFile("file"); ferr == nil {
+		file, err := fileHeader.Open()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to open file"})
+		}
+		defer file.Close()
+		reader = file
+	} else if len(c.Body()) > 0 {
+		reader = bytes.NewReader(c.Body())
+	} else {
+		return c.Status(400).JSON(fiber.Map{"error": "csv file (form field: file) or text/csv body is required"})
+	}
+
+	result, err := h.Service.ImportCSV(context.Background(), chatID, reader)
+	// Часть строк могла записаться и при ошибке.
+	h.invalidateAnswers(chatID)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error(), "result": result})
+	}
+	return c.JSON(result)
+}
+
+// invalidateAnswers сбрасывает кэш ответов чата: синонимы меняют поисковые запросы.
+func (h *SynonymHandler) invalidateAnswers(chatID uuid.UUID) {
+	if h.RAG != nil {
+		h.RAG.InvalidateAnswerCache(chatID)
+	}
+}
+
+func synonymError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, service.ErrSynonymNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidSynonym), errors.Is(err, service.ErrInvalidSynonymKind):
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrSynonymTermExists):
+		return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+	default:
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+}
@@ -2,8 +2,6 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
 	"log"
 	"os"
 	"path/filepath"
@@ -97,82 +95,45 @@ func (h *DocumentHandler) UploadAndIngestPDF(c *fiber.Ctx) error {
 	defer os.Remove(tmpFile)
 
 	// --- 4. Извлекаем текст из PDF
-	txt, err := pdf.ExtractText(tmpFile)
+	pages, err := pdf.ExtractPages(tmpFile)
 	if err != nil {
 		log.Printf("extract error: %v", err)
 		return c.Status(500).JSON(fiber.Map{"error": "failed to extract text from pdf"})
 	}
-	txt = pdf.Sanitize(txt)
-	if len(txt) == 0 {
+	if len(pages) == 0 {
 		return c.Status(400).JSON(fiber.Map{"error": "no text extracted from PDF"})
 	}
 
-	// --- 5. Дробим на chunks
-	const chunkSize = 220
-	const chunkOverlap = 40
-	parts := pdf.ChunkBySentences(txt, chunkSize, chunkOverlap)
-	if len(parts) == 0 {
-		return c.Status(400).JSON(fiber.Map{"error": "no chunks created"})
-	}
-
-	// --- 6. Сохраняем chunks
-	saved := 0
-	for i, p := range parts {
-		chunkName := fmt.Sprintf("%s_chunk_%d", doc.Name, i)
-		ch := models.Chunk{
-			Text:      p,
-			Filepath:  doc.Path, // ссылка на MinIO
-			DocName:   doc.Name,
-			ChunkName: chunkName,
-			DocID:     doc.ID,
-			ChatID:    chatID,
-		}
-
-		// Попробуем получить настройки чата и использовать их для эмбеддингов
-		var emb []float32
-		if h.chatSettings != nil {
-			if cs, err := h.chatSettings.GetByChatID(context.Background(), chatID); err == nil && cs != nil && cs.Settings != nil {
-				// marshal JSONB -> bytes -> AskSettings
-				raw, _ := json.Marshal(cs.Settings)
-				var dbSettings models.AskSettings
-				if err := json.Unmarshal(raw, &dbSettings); err == nil {
-					// Попробуем дешифровать ключи, если они были сохранены зашифрованными
-					if dbSettings.ExternalAPIKey != "" {
-						if dec, derr := utils.DecryptString(dbSettings.ExternalAPIKey); derr == nil {
-							dbSettings.ExternalAPIKey = dec
-						}
-					}
-					if dbSettings.EmbedExternalAPIKey != "" {
-						if dec2, derr2 := utils.DecryptString(dbSettings.EmbedExternalAPIKey); derr2 == nil {
-							dbSettings.EmbedExternalAPIKey = dec2
-						}
-					}
-					emb, err = h.llm.EmbeddingWithSettings(p, &dbSettings)
-				} else {
-					emb, err = h.llm.Embedding(p)
-				}
-			} else {
-				emb, err = h.llm.Embedding(p)
-			}
-		} else {
-			emb, err = h.llm.Embedding(p)
-		}
-		if err != nil {
-			log.Printf("embedding error (%s): %v", chunkName, err)
-			continue
+	// Настройки чата загружаем один раз: они определяют модель эмбеддингов и режим поиска
+	chatSettings := h.loadChatSettings(chatID)
+	ctx := c.UserContext()
+	embed := func(text string) ([]float32, error) {
+		if chatSettings != nil {
+			return h.llm.EmbeddingWithSettings(ctx, text, chatSettings)
 		}
+		return h.llm.Embedding(ctx, text)
+	}
 
-		if err := h.chunkService.SaveChunk(ch, emb); err != nil {
-			log.Printf("db insert error (%s): %v", chunkName, err)
-			continue
-		}
-		saved++
+	// --- 5. Дробим на chunks (в режиме parent — разделы + дочерние чанки) и сохраняем
+	target := service.IngestTarget{DocID: doc.ID, ChatID: chatID, DocName: doc.Name, Filepath: doc.Path}
+	total, saved, err := h.chunkService.IngestPages(target, pages, chatSettings, embed)
+	invalidateAnswerCache(chatID)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 	}
 
 	return c.JSON(dto.DocumentIngestResponse{
 		Status:      "ok",
 		Document:    doc,
-		ChunksTotal: len(parts),
+		ChunksTotal: total,
 		ChunksSaved: saved,
 	})
 }
+
+// loadChatSettings читает настройки чата с расшифрованными ключами; nil — настроек нет.
+func (h *DocumentHandler) loadChatSettings(chatID uuid.UUID) *models.AskSettings {
+	if h.chatSettings == nil {
+		return nil
+	}
+	return h.chatSettings.AskSettings(context.Background(), chatID)
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/katakuxiko/Diplom/internal/dto"
+	"github.com/katakuxiko/Diplom/internal/middleware"
+	"github.com/katakuxiko/Diplom/internal/service"
+)
+
+// VectorIndexHandler — обслуживание векторного индекса pgvector (только для суперадмина).
+type VectorIndexHandler struct {
+	Service *service.VectorIndexService
+}
+
+// RegisterVectorIndexRoutes регистрирует эндпоинты обслуживания векторного индекса
+func RegisterVectorIndexRoutes(app *fiber.App, svc *service.VectorIndexService) {
+	h := &VectorIndexHandler{Service: svc}
+	r := app.Group("/admin/vector-index", middleware.JWTProtected(), middleware.SuperadminProtected())
+
+	r.Get("/", h.Health)
+	r.Post("/rebuild", h.Rebuild)
+	r.Post("/reindex", h.Reindex)
+	r.Post("/benchmark", h.Benchmark)
+}
+
+// Health godoc
+// @Summary      Состояние векторного индекса
+// @Description  Возвращает векторные индексы chunks, число строк, статистику ANALYZE и текущие ef_search/probes
+// @Tags         vector-index
+// @Produce      json
+// @Success      200 {object} dto.VectorIndexHealthResponse
+// @Failure      500 {object} map[string]string
+// @Router       /admin/vector-index [get]
+// @Security     BearerAuth
+func (h *VectorIndexHandler) Health(c *fiber.Ctx) error {
+	health, err := h.Service.Health()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(health)
+}
+
+// Rebuild godoc
+// @Summary      Перестроить векторный индекс
+// @Description  Удаляет векторные индексы chunks и создаёт новый (ivfflat или hnsw) с заданными параметрами
+// @Tags         vector-index
+// @Accept       json
+// @Produce      json
+// @Param        body body dto.VectorIndexRebuildRequest false "Параметры индекса"
+// @Success      200 {object} map[string]interface{}
+// @Failure      400 {object} map[string]string
+// @Failure      500 {object} map[string]string
+// @Router       /admin/vector-index/rebuild [post]
+// @Security     BearerAuth
+func (h *VectorIndexHandler) Rebuild(c *fiber.Ctx) error {
+	var req dto.VectorIndexRebuildRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
+		}
+	}
+	if req.Type != "" && req.Type != "ivfflat" && req.Type != "hnsw" {
+		return c.Status(400).JSON(fiber.Map{"error": "type must be ivfflat or hnsw"})
+	}
+
+	applied, err := h.Service.Rebuild(req)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	health, err := h.Service.Health()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"applied": applied, "health": health})
+}
+
+// Reindex godoc
+// @Summary      REINDEX векторного индекса
+// @Description  Перестраивает существующие векторные индексы с прежними параметрами и выполняет ANALYZE
+// @Tags         vector-index
+// @Produce      json
+// @Success      200 {object} dto.VectorIndexHealthResponse
+// @Failure      500 {object} map[string]string
+// @Router       /admin/vector-index/reindex [post]
+// @Security     BearerAuth
+func (h *VectorIndexHandler) Reindex(c *fiber.Ctx) error {
+	if err := h.Service.Reindex(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	health, err := h.Service.Health()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(health)
+}
+
+// Benchmark godoc
+// @Summary      Recall@k векторного индекса
+// @Description  Сравнивает приблизительный поиск по индексу с точным перебором на эмбеддингах случайных чанков
+// @Tags         vector-index
+// @Accept       json
+// @Produce      json
+// @Param        body body dto.VectorIndexBenchmarkRequest false "Параметры замера"
+// @Success      200 {object} dto.VectorIndexBenchmarkResponse
+// @Failure      400 {object} map[string]string
+// @Failure      500 {object} map[string]string
+// @Router       /admin/vector-index/benchmark [post]
+// @Security     BearerAuth
+func (h *VectorIndexHandler) Benchmark(c *fiber.Ctx) error {
+	var req dto.VectorIndexBenchmarkRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
+		}
+	}
+
+	result, err := h.Service.Benchmark(req)
+	if err != nil {
+		if errors.Is(err, service.ErrNoEmbeddings) {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(result)
+}
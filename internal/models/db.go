@@ -55,8 +55,12 @@ type Document struct {
 	Protected   bool           `gorm:"default:false" json:"protected"`
 	AccessLevel int            `gorm:"default:0" json:"access_level"`
 	CreatedDate time.Time      `gorm:"default:now()" json:"created_date"`
-	Chat        Chat           `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:ChatID" json:"-"`
-	Chunks      []Chunk        `gorm:"foreignKey:DocID;constraint:OnDelete:CASCADE;" swaggerignore:"true" json:"-"`
+	// Период действия документа (редакции положения): дата вступления в силу (пусто — created_date)
+	// и дата, с которой документ утратил силу (пусто — действует)
+	EffectiveDate *time.Time `gorm:"index" json:"effective_date,omitempty"`
+	ValidUntil    *time.Time `json:"valid_until,omitempty"`
+	Chat          Chat       `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:ChatID" json:"-"`
+	Chunks        []Chunk    `gorm:"foreignKey:DocID;constraint:OnDelete:CASCADE;" swaggerignore:"true" json:"-"`
 }
 
 // Чанки
@@ -69,12 +73,34 @@ type Chunk struct {
 	Embedding       pgvector.Vector `gorm:"type:vector(768)" swaggerignore:"true" json:"-"`
 	Filepath        string
 	ChunkName       string
-	Score           float32  `gorm:"-" json:"score,omitempty"`
-	KeywordScore    float32  `gorm:"-" json:"keyword_score,omitempty"`
-	HybridScore     float32  `gorm:"-" json:"hybrid_score,omitempty"`
-	RetrievalSource string   `gorm:"-" json:"retrieval_source,omitempty"`
-	Document        Document `gorm:"foreignKey:DocID;references:ID" swaggerignore:"true" json:"-"`
-	Chat            Chat     `gorm:"foreignKey:ChatID;references:ID" swaggerignore:"true" json:"-"`
+	ChunkIndex      int        `gorm:"default:0" json:"chunk_index"`
+	Page            int        `gorm:"default:0" json:"page,omitempty"` // страница PDF, с которой начинается чанк (с 1; 0 — неизвестна)
+	ParentID        *uuid.UUID `gorm:"type:uuid;index" json:"parent_id,omitempty"`
+	Language        string     `gorm:"size:8;index" json:"language,omitempty"`
+	MatchedText     string     `gorm:"-" json:"matched_text,omitempty"`
+	Score           float32    `gorm:"-" json:"score,omitempty"`
+	KeywordScore    float32    `gorm:"-" json:"keyword_score,omitempty"`
+	HybridScore     float32    `gorm:"-" json:"hybrid_score,omitempty"`
+	RetrievalSource string     `gorm:"-" json:"retrieval_source,omitempty"`
+	DocumentDate    *time.Time `gorm:"->;-:migration" json:"document_date,omitempty"` // дата действия документа, читается в поиске
+	RecencyScore    float32    `gorm:"-" json:"-"`
+	VectorRank      int        `gorm:"-" json:"-"` // позиция в векторной выдаче (с 1; 0 — не найден)
+	KeywordRank     int        `gorm:"-" json:"-"` // позиция в лексической выдаче (с 1; 0 — не найден)
+	RRFScore        float32    `gorm:"-" json:"-"`
+	Document        Document   `gorm:"foreignKey:DocID;references:ID" swaggerignore:"true" json:"-"`
+	Chat            Chat       `gorm:"foreignKey:ChatID;references:ID" swaggerignore:"true" json:"-"`
+}
+
+// Крупные разделы документа для parent-document retrieval:
+// поиск идёт по мелким дочерним чанкам, а в контекст LLM попадает весь раздел.
+type DocumentSection struct {
+	ID           uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey" json:"id"`
+	DocID        uuid.UUID `gorm:"type:uuid;not null;index" json:"doc_id"`
+	ChatID       uuid.UUID `gorm:"type:uuid;index" json:"chat_id"`
+	DocName      string    `json:"doc_name"`
+	SectionIndex int       `gorm:"default:0" json:"section_index"`
+	Text         string    `gorm:"type:text" json:"text"`
+	Document     Document  `gorm:"foreignKey:DocID;references:ID;constraint:OnDelete:CASCADE" swaggerignore:"true" json:"-"`
 }
 
 // Настройки чата
@@ -28,6 +28,8 @@ type EvaluationRun struct {
 	Status         string             `gorm:"size:30;not null;default:in_progress" json:"status"`
 	Model          string             `gorm:"size:200" json:"model"`
 	TopK           int                `gorm:"default:5" json:"top_k"`
+	RetrievalMode  string             `gorm:"size:30" json:"retrieval_mode"`
+	QueryStrategy  string             `gorm:"size:30" json:"query_strategy"`
 	TotalQuestions int                `gorm:"default:0" json:"total_questions"`
 	EvaluatedCount int                `gorm:"default:0" json:"evaluated_count"`
 	CorrectCount   int                `gorm:"default:0" json:"correct_count"`
@@ -0,0 +1,133 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RetrievalFilters ограничивает поиск подмножеством документов чата.
+// Фильтры применяются прямо в SQL векторного и лексического поиска.
+type RetrievalFilters struct {
+	TagsAny            []string    `json:"tagsAny,omitempty"`            // документ содержит хотя бы один тег
+	TagsAll            []string    `json:"tagsAll,omitempty"`            // документ содержит все теги
+	DocumentIDs        []uuid.UUID `json:"documentIds,omitempty"`        // искать только в этих документах
+	ExcludeDocumentIDs []uuid.UUID `json:"excludeDocumentIds,omitempty"` // не искать в этих документах
+	CreatedFrom        *FilterDate `json:"createdFrom,omitempty"`        // created_date >= createdFrom
+	CreatedTo          *FilterDate `json:"createdTo,omitempty"`          // created_date <= createdTo (дата без времени — включая весь день)
+	AsOf               *FilterDate `json:"asOf,omitempty"`               // только документы, действовавшие на эту дату
+}
+
+// FilterDate принимает как "2006-01-02", так и RFC3339.
+type FilterDate struct {
+	time.Time
+	DateOnly bool `json:"-"`
+}
+
+func (d *FilterDate) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	raw = strings.TrimSpace(raw)
+	if t, err := time.Parse("2006-01-02", raw); err == nil {
+		d.Time, d.DateOnly = t, true
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return fmt.Errorf("invalid date %q: expected YYYY-MM-DD or RFC3339", raw)
+	}
+	d.Time, d.DateOnly = t, false
+	return nil
+}
+
+func (d FilterDate) MarshalJSON() ([]byte, error) {
+	if d.DateOnly {
+		return json.Marshal(d.Time.Format("2006-01-02"))
+	}
+	return json.Marshal(d.Time.Format(time.RFC3339))
+}
+
+// Normalize приводит теги к виду, в котором они хранятся у документов (нижний регистр, без пробелов),
+// и убирает пустые значения. Возвращает nil, если фильтров нет.
+func (f *RetrievalFilters) Normalize() *RetrievalFilters {
+	if f == nil {
+		return nil
+	}
+	out := &RetrievalFilters{
+		TagsAny:            normalizeFilterTags(f.TagsAny),
+		TagsAll:            normalizeFilterTags(f.TagsAll),
+		DocumentIDs:        nonNilUUIDs(f.DocumentIDs),
+		ExcludeDocumentIDs: nonNilUUIDs(f.ExcludeDocumentIDs),
+		CreatedFrom:        f.CreatedFrom,
+		CreatedTo:          f.CreatedTo,
+		AsOf:               f.AsOf,
+	}
+	if out.IsEmpty() {
+		return nil
+	}
+	return out
+}
+
+func (f *RetrievalFilters) IsEmpty() bool {
+	return f == nil || (len(f.TagsAny) == 0 && len(f.TagsAll) == 0 &&
+		len(f.DocumentIDs) == 0 && len(f.ExcludeDocumentIDs) == 0 &&
+		f.CreatedFrom == nil && f.CreatedTo == nil && f.AsOf == nil)
+}
+
+// CreatedBefore возвращает исключающую верхнюю границу по дате создания документа.
+func (f *RetrievalFilters) CreatedBefore() *time.Time {
+	if f == nil || f.CreatedTo == nil {
+		return nil
+	}
+	return exclusiveUpperBound(*f.CreatedTo)
+}
+
+// AsOfBefore возвращает исключающую границу для даты "as of": документ действует на эту дату,
+// если вступил в силу раньше границы и не утратил силу до неё.
+func (f *RetrievalFilters) AsOfBefore() *time.Time {
+	if f == nil || f.AsOf == nil {
+		return nil
+	}
+	return exclusiveUpperBound(*f.AsOf)
+}
+
+func exclusiveUpperBound(d FilterDate) *time.Time {
+	to := d.Time
+	if d.DateOnly {
+		to = to.AddDate(0, 0, 1)
+	} else {
+		to = to.Add(time.Microsecond)
+	}
+	return &to
+}
+
+func normalizeFilterTags(tags []string) []string {
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" {
+			out = append(out, tag)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+func nonNilUUIDs(ids []uuid.UUID) []uuid.UUID {
+	out := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if id != uuid.Nil {
+			out = append(out, id)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PromptParts — части шаблона промпта. Каждая часть — text/template с переменными
+// {{.Context}}, {{.Question}}, {{.LanguagePolicy}}, {{.Language}}, {{.ChatName}}, {{.Date}}.
+// Пустая часть берётся из шаблона по умолчанию.
+type PromptParts struct {
+	System   string `gorm:"type:text" json:"system,omitempty"`
+	User     string `gorm:"type:text" json:"user,omitempty"`
+	Refusal  string `gorm:"type:text" json:"refusal,omitempty"`  // ответ, когда в документах ничего не найдено
+	Greeting string `gorm:"type:text" json:"greeting,omitempty"` // ответ на приветствие
+}
+
+// ChatPromptTemplate — именованный шаблон промпта чата. Каждое сохранение создаёт новую версию,
+// старые версии остаются доступны для отката через AskSettings.PromptTemplateVersion.
+type ChatPromptTemplate struct {
+	ID          uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey" json:"id"`
+	ChatID      uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_chat_prompt_template_version" json:"chat_id"`
+	Chat        Chat      `gorm:"foreignKey:ChatID;references:ID;constraint:OnDelete:CASCADE" swaggerignore:"true" json:"-"`
+	Name        string    `gorm:"size:100;not null;uniqueIndex:idx_chat_prompt_template_version" json:"name"`
+	Version     int       `gorm:"not null;uniqueIndex:idx_chat_prompt_template_version" json:"version"`
+	Description string    `gorm:"size:500" json:"description,omitempty"`
+	PromptParts `gorm:"embedded"`
+	CreatedDate time.Time `gorm:"default:now()" json:"created_date"`
+}
+
+// ResolvedPrompt — шаблон, выбранный для конкретного запроса (AskSettings.Prompt).
+// Name пустой — используется шаблон по умолчанию.
+type ResolvedPrompt struct {
+	Name     string
+	Version  int
+	Parts    PromptParts
+	ChatName string
+}
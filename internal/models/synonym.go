@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Виды записей словаря синонимов чата.
+const (
+	SynonymKindSynonym      = "synonym"      // равнозначные формулировки: любая раскрывается во все остальные
+	SynonymKindAbbreviation = "abbreviation" // сокращение: раскрывается только term -> synonyms
+)
+
+// ChatSynonym — запись словаря синонимов и сокращений чата, используемого при расширении поисковых запросов.
+type ChatSynonym struct {
+	ID          uuid.UUID      `gorm:"type:uuid;default:gen_random_uuid();primaryKey" json:"id"`
+	ChatID      uuid.UUID      `gorm:"type:uuid;not null;uniqueIndex:idx_chat_synonym_term" json:"chat_id"`
+	Chat        Chat           `gorm:"foreignKey:ChatID;references:ID;constraint:OnDelete:CASCADE" swaggerignore:"true" json:"-"`
+	Term        string         `gorm:"size:200;not null;uniqueIndex:idx_chat_synonym_term" json:"term"` // хранится в нижнем регистре
+	Synonyms    pq.StringArray `gorm:"type:text[];not null;default:'{}'" json:"synonyms" swaggertype:"array,string"`
+	Kind        string         `gorm:"size:20;not null;default:synonym" json:"kind"`
+	CreatedDate time.Time      `gorm:"default:now()" json:"created_date"`
+}
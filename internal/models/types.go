@@ -1,20 +1,104 @@
 package models
 
-import "github.com/google/uuid"
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
 
 type AskSettings struct {
 	EnableHistory     bool    `json:"enableHistory"`
 	MaxTokens         int     `json:"maxTokens"`
 	Model             string  `json:"model"`
 	EmbedModel        string  `json:"embedModel,omitempty"`
-	RetrievalMode     string  `json:"retrievalMode,omitempty"` // "vector", "keyword", "hybrid"
+	RetrievalMode     string  `json:"retrievalMode,omitempty"` // "vector", "keyword", "hybrid", "parent"
 	MaxCosineDistance float32 `json:"maxCosineDistance,omitempty"`
 	MaxDistanceGap    float32 `json:"maxDistanceGap,omitempty"`
 	MinChunkChars     int     `json:"minChunkChars,omitempty"`
+	// Hybrid ranking weights: не задан (null) — значение по умолчанию, 0 — сигнал выключен.
+	// RRFDenominator: 0 — значение по умолчанию
+	VectorWeight   *float32 `json:"vectorWeight,omitempty"`
+	KeywordWeight  *float32 `json:"keywordWeight,omitempty"`
+	RRFWeight      *float32 `json:"rrfWeight,omitempty"`
+	RRFDenominator float32  `json:"rrfDenominator,omitempty"`
+	// Maximal marginal relevance: диверсификация выбранных чанков
+	EnableMMR           bool    `json:"enableMmr,omitempty"`
+	MMRLambda           float32 `json:"mmrLambda,omitempty"`           // 1 — только релевантность, 0 — только разнообразие
+	DuplicateSimilarity float32 `json:"duplicateSimilarity,omitempty"` // cosine similarity, выше которой чанк считается дублем
+	// Сколько соседних чанков (до и после) добавлять к найденному в контекст; 0 — не расширять
+	NeighborWindow int `json:"neighborWindow,omitempty"`
+	// Переписывание уточняющего вопроса в самостоятельный поисковый запрос по истории диалога
+	EnableQueryRewrite  bool `json:"enableQueryRewrite,omitempty"`
+	RewriteHistoryTurns int  `json:"rewriteHistoryTurns,omitempty"`
+	// Стратегия построения поисковых запросов: "single", "multi_query", "hyde"
+	QueryStrategy   string `json:"queryStrategy,omitempty"`
+	MultiQueryCount int    `json:"multiQueryCount,omitempty"`
+	// Кросс-языковой поиск: языки корпуса ("ru", "kk", "en"; пусто — определяются по чанкам чата)
+	// и режим "fallback" (только если по исходному запросу ничего не найдено), "always" или "off"
+	CorpusLanguages  []string `json:"corpusLanguages,omitempty"`
+	CrossLingualMode string   `json:"crossLingualMode,omitempty"`
+	// Точность приблизительного векторного поиска на запрос: hnsw.ef_search и ivfflat.probes (0 — значение сервера)
+	HNSWEfSearch int `json:"hnswEfSearch,omitempty"`
+	IVFProbes    int `json:"ivfProbes,omitempty"`
+	// Добавлять раскрытия из словаря синонимов чата к тексту запроса для эмбеддинга
+	ExpandEmbeddingWithSynonyms bool `json:"expandEmbeddingWithSynonyms,omitempty"`
+	// Учёт свежести документа в итоговом score (0 — выключено, максимум 0.5) и период полураспада в днях
+	RecencyWeight       float32 `json:"recencyWeight,omitempty"`
+	RecencyHalfLifeDays int     `json:"recencyHalfLifeDays,omitempty"`
+	// Семантический кэш ответов чата: порог cosine similarity запросов и время жизни записи
+	EnableAnswerCache     bool    `json:"enableAnswerCache,omitempty"`
+	AnswerCacheSimilarity float32 `json:"answerCacheSimilarity,omitempty"`
+	AnswerCacheTTLMinutes int     `json:"answerCacheTtlMinutes,omitempty"`
+	// Шаблон промпта чата по имени и версия (0 — последняя); пусто — шаблон по умолчанию
+	PromptTemplate        string `json:"promptTemplate,omitempty"`
+	PromptTemplateVersion int    `json:"promptTemplateVersion,omitempty"`
+	// Контекстное окно модели в токенах и оценщик числа токенов ("cl100k-ratio", "o200k-ratio", "llama3-ratio",
+	// "sentencepiece-ratio", "approx"); 0 и пусто — по реестру моделей. Tokenizer — прежнее название поля
+	// (значения "cl100k" и т.д.), учитывается, если TokenEstimator не задан.
+	ContextWindow  int    `json:"contextWindow,omitempty"`
+	TokenEstimator string `json:"tokenEstimator,omitempty"`
+	Tokenizer      string `json:"tokenizer,omitempty"`
+	// Общий срок ответа в секундах (поиск и генерация); по истечении отдаётся частичный ответ. 0 — без ограничения
+	AnswerTimeoutSeconds int `json:"answerTimeoutSeconds,omitempty"`
+	// Ссылки на фрагменты контекста [n] в ответе: "strip" — несуществующие удаляются, "flag" — остаются
+	// и помечаются в citations; пусто или "off" — выключено
+	CitationMode string `json:"citationMode,omitempty"`
+	// Проверка ответа по найденным фрагментам: "embedding", "llm" или "both"; пусто или "off" — выключена.
+	// Если доля подтверждённых предложений ниже groundingMinScore (0 — 0.7), применяется groundingPolicy:
+	// "annotate" (по умолчанию), "regenerate" или "refuse". groundingSimilarity — порог для эмбеддингов (0 — 0.6)
+	GroundingCheck      string  `json:"groundingCheck,omitempty"`
+	GroundingPolicy     string  `json:"groundingPolicy,omitempty"`
+	GroundingMinScore   float32 `json:"groundingMinScore,omitempty"`
+	GroundingSimilarity float32 `json:"groundingSimilarity,omitempty"`
+	// Сколько раз отправлять на исправление ответ, не прошедший проверку по responseSchema (0 — 2, <0 — не исправлять)
+	StructuredRetries int `json:"structuredRetries,omitempty"`
+	// Режим агента: модель сама вызывает инструменты (поиск, соседние фрагменты, список документов,
+	// калькулятор, даты), не больше agentMaxSteps вызовов (0 — 5, максимум 10). Нужен провайдер с поддержкой tools
+	AgentMode     bool `json:"agentMode,omitempty"`
+	AgentMaxSteps int  `json:"agentMaxSteps,omitempty"`
+	// Предлагать после ответа followUpCount следующих вопросов (0 — 3, от 2 до 4), подтверждённых поиском
+	SuggestFollowUps bool `json:"suggestFollowUps,omitempty"`
+	FollowUpCount    int  `json:"followUpCount,omitempty"`
+	// Определение намерения до поиска: "rules" (по умолчанию), "llm" или "embedding" — правила плюс классификатор
+	// для сообщений, не распознанных правилами; "off" — все сообщения считаются вопросами по документам.
+	// intentSimilarity — порог близости для "embedding" (0 — 0.82)
+	IntentClassifier string  `json:"intentClassifier,omitempty"`
+	IntentSimilarity float32 `json:"intentSimilarity,omitempty"`
+	// Ответы на намерения greeting, thanks, smalltalk, help, out_of_scope (text/template с переменными промпта)
+	IntentReplies map[string]string `json:"intentReplies,omitempty"`
+	// Шаблон, подобранный для запроса; заполняется сервисом, в настройках чата не хранится
+	Prompt *ResolvedPrompt `json:"-"`
+	// Фильтры конкретного запроса (из AskRequest.Filters); в настройках чата не хранятся
+	Filters *RetrievalFilters `json:"-"`
+	// Режим explain конкретного запроса: вернуть всех кандидатов с решениями фильтра
+	Explain bool `json:"-"`
+	// JSON Schema ответа конкретного запроса (из AskRequest.ResponseSchema); в настройках чата не хранится
+	ResponseSchema json.RawMessage `json:"-"`
 	// Embedding provider specific settings
 	EmbedProvider        string  `json:"embedProvider,omitempty"`
 	EmbedExternalAPIKey  string  `json:"embedExternalApiKey,omitempty"`
 	EmbedExternalBaseURL string  `json:"embedExternalBaseUrl,omitempty"`
+	EmbedProviderType    string  `json:"embedProviderType,omitempty"` // бэкенд эмбеддингов, как ProviderType
 	RequestsLimit        int     `json:"requestsLimit"`
 	RequestsWindow       int     `json:"requestsWindow"`
 	SystemPrompt         string  `json:"systemPrompt"`
@@ -23,6 +107,20 @@ type AskSettings struct {
 	Provider        string `json:"provider,omitempty"`        // "local" or "external"
 	ExternalAPIKey  string `json:"externalApiKey,omitempty"`  // api key for external provider
 	ExternalBaseURL string `json:"externalBaseUrl,omitempty"` // base url for external OpenAI-compatible API
+	ProviderType    string `json:"providerType,omitempty"`    // "openai", "huggingface", "ollama", "llamacpp"
+	// Резервные провайдеры генерации по порядку: используются, если основной недоступен
+	FallbackProviders []ProviderFallback `json:"fallbackProviders,omitempty"`
+	// Повторы запроса к провайдеру при временных ошибках (0 — по умолчанию 2, отрицательное — без повторов)
+	ProviderRetries int `json:"providerRetries,omitempty"`
+}
+
+// ProviderFallback — резервный провайдер генерации. Для "external" ключ берётся из externalApiKey чата,
+// адрес — из BaseURL или externalBaseUrl; пустая модель — та же, что у основного провайдера.
+type ProviderFallback struct {
+	Provider     string `json:"provider"`               // "local" или "external"
+	ProviderType string `json:"providerType,omitempty"` // "openai", "huggingface", "ollama", "llamacpp"
+	BaseURL      string `json:"baseUrl,omitempty"`
+	Model        string `json:"model,omitempty"`
 }
 
 type AskRequest struct {
@@ -33,6 +131,25 @@ type AskRequest struct {
 	ChatID        uuid.UUID    `json:"chat_id"`
 	ChatHistoryID *uuid.UUID   `json:"chat_history_id,omitempty"`
 	Settings      *AskSettings `json:"settings,omitempty"`
+	// Фильтры по метаданным документов (теги, документы, даты)
+	Filters *RetrievalFilters `json:"filters,omitempty"`
+	// Explain — вернуть в диагностике всех кандидатов поиска и причины отбора/отсева
+	Explain bool `json:"explain,omitempty"`
+	// AsOf — искать только в документах, действовавших на эту дату (YYYY-MM-DD или RFC3339)
+	AsOf *FilterDate `json:"asOf,omitempty"`
+	// ResponseSchema — JSON Schema ответа: вместо текста вернуть объект по схеме (поле data)
+	ResponseSchema json.RawMessage `json:"responseSchema,omitempty"`
+}
+
+// SearchRequest — поиск по документам чата без генерации ответа.
+type SearchRequest struct {
+	Query    string            `json:"query"`
+	TopK     int               `json:"topK,omitempty"`
+	ChatID   uuid.UUID         `json:"chat_id"`
+	Settings *AskSettings      `json:"settings,omitempty"`
+	Filters  *RetrievalFilters `json:"filters,omitempty"`
+	Explain  bool              `json:"explain,omitempty"`
+	AsOf     *FilterDate       `json:"asOf,omitempty"`
 }
 
 // ChatContextMessage хранит краткую историю диалога для генерации ответа в LLM.
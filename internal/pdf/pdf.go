@@ -1,7 +1,9 @@
 package pdf
 
 import (
+	"os/exec"
 	"regexp"
+	"sort"
 	"strings"
 	"unicode"
 
@@ -17,6 +19,101 @@ func ExtractText(path string) (string, error) {
 	return res.Body, nil
 }
 
+// Page — текст страницы PDF. Number начинается с 1; 0 — номер страницы неизвестен.
+type Page struct {
+	Number int
+	Text   string
+}
+
+// ExtractPages извлекает текст постранично: pdftotext без -nopgbrk разделяет страницы символом \f.
+// Если pdftotext не справился (не PDF или утилиты нет), весь текст ExtractText возвращается одной страницей без номера.
+// Текст страниц очищается Sanitize, пустые страницы пропускаются с сохранением нумерации.
+func ExtractPages(path string) ([]Page, error) {
+	var pages []Page
+	body, err := exec.Command("pdftotext", "-q", "-enc", "UTF-8", "-eol", "unix", path, "-").Output()
+	if err != nil {
+		txt, err := ExtractText(path)
+		if err != nil {
+			return nil, err
+		}
+		pages = []Page{{Text: txt}}
+	} else {
+		for i, text := range strings.Split(string(body), "\f") {
+			pages = append(pages, Page{Number: i + 1, Text: text})
+		}
+	}
+
+	out := pages[:0]
+	for _, page := range pages {
+		page.Text = strings.TrimSpace(Sanitize(page.Text))
+		if page.Text != "" {
+			out = append(out, page)
+		}
+	}
+	return out, nil
+}
+
+// JoinPages склеивает страницы в текст документа.
+func JoinPages(pages []Page) string {
+	texts := make([]string, len(pages))
+	for i, page := range pages {
+		texts[i] = page.Text
+	}
+	return strings.Join(texts, "\n")
+}
+
+// pageProbeRunes — сколько первых символов чанка ищется в тексте страниц.
+const pageProbeRunes = 64
+
+// PageLocator определяет страницу, с которой начинается чанк. Разбивка на чанки меняет только пробелы,
+// поэтому начало чанка ищется в тексте без пробельных символов. Чанки передаются в порядке документа:
+// поиск продолжается с места предыдущего совпадения, и повторяющиеся колонтитулы не сбивают нумерацию.
+type PageLocator struct {
+	text   string
+	starts []int // смещение начала каждой страницы в text
+	pages  []int
+	from   int
+}
+
+func NewPageLocator(pages []Page) *PageLocator {
+	l := &PageLocator{}
+	var b strings.Builder
+	for _, page := range pages {
+		l.starts = append(l.starts, b.Len())
+		l.pages = append(l.pages, page.Number)
+		b.WriteString(stripSpaces(page.Text))
+	}
+	l.text = b.String()
+	return l
+}
+
+// Locate возвращает номер страницы начала чанка; 0 — страница не найдена или неизвестна.
+func (l *PageLocator) Locate(chunk string) int {
+	probe := []rune(stripSpaces(chunk))
+	if len(probe) == 0 || len(l.pages) == 0 {
+		return 0
+	}
+	if len(probe) > pageProbeRunes {
+		probe = probe[:pageProbeRunes]
+	}
+	idx := strings.Index(l.text[l.from:], string(probe))
+	if idx < 0 {
+		return 0
+	}
+	l.from += idx
+	page := sort.Search(len(l.starts), func(i int) bool { return l.starts[i] > l.from }) - 1
+	return l.pages[page]
+}
+
+func stripSpaces(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
 func Sanitize(s string) string {
 	s = strings.ReplaceAll(s, "\r", "\n")
 	s = strings.ReplaceAll(s, "\t", " ")
@@ -112,6 +209,36 @@ func ChunkBySentences(text string, maxWords, overlap int) []string {
 	return cleanupChunks(chunks, max(20, maxWords/5))
 }
 
+// Section — крупный раздел документа и мелкие дочерние чанки внутри него.
+type Section struct {
+	Text     string
+	Children []string
+}
+
+// ChunkSections режет текст на крупные разделы без перекрытия (sectionWords слов),
+// а каждый раздел — на мелкие дочерние чанки для поиска (childWords слов с перекрытием childOverlap).
+func ChunkSections(text string, sectionWords, childWords, childOverlap int) []Section {
+	if sectionWords <= 0 {
+		sectionWords = 900
+	}
+	if childWords <= 0 {
+		childWords = 120
+	}
+	if childWords > sectionWords {
+		childWords = sectionWords
+	}
+
+	var sections []Section
+	for _, sectionText := range ChunkBySentences(text, sectionWords, 0) {
+		children := ChunkBySentences(sectionText, childWords, childOverlap)
+		if len(children) == 0 {
+			children = []string{sectionText}
+		}
+		sections = append(sections, Section{Text: sectionText, Children: children})
+	}
+	return sections
+}
+
 // ChunkByWords оставляем для обратной совместимости
 func ChunkByWords(text string, size, overlap int) []string {
 	words := strings.Fields(text)
@@ -1,10 +1,13 @@
 package repository
 
 import (
+	"context"
+	"fmt"
 	"strings"
 
 	"github.com/google/uuid"
 	"github.com/katakuxiko/Diplom/internal/models"
+	"github.com/lib/pq"
 	"github.com/pgvector/pgvector-go"
 	"gorm.io/gorm"
 )
@@ -21,31 +24,133 @@ func (r *ChunkRepository) Add(chunk models.Chunk) error {
 	return r.db.Create(&chunk).Error
 }
 
+func (r *ChunkRepository) AddSection(section *models.DocumentSection) error {
+	return r.db.Create(section).Error
+}
+
+// FindSectionsByIDs возвращает родительские разделы по идентификаторам с учётом уровня доступа.
+func (r *ChunkRepository) FindSectionsByIDs(ctx context.Context, ids []uuid.UUID, accessLevel int) ([]models.DocumentSection, error) {
+	var sections []models.DocumentSection
+	if len(ids) == 0 {
+		return sections, nil
+	}
+	err := r.db.WithContext(ctx).Raw(`
+		SELECT s.* FROM document_sections s
+		JOIN documents d ON d.id = s.doc_id
+		WHERE s.id IN ? AND d.access_level <= ?
+	`, ids, accessLevel).Scan(&sections).Error
+	return sections, err
+}
+
 func (r *ChunkRepository) FindByDocID(docID string) ([]models.Chunk, error) {
 	var chunks []models.Chunk
 	err := r.db.Where("doc_id = ?", docID).Find(&chunks).Error
 	return chunks, err
 }
 
-func (r *ChunkRepository) SearchByVector(vec pgvector.Vector, limit int, chatID uuid.UUID, accessLevel int) ([]models.Chunk, error) {
+// FindByDocIndexRange возвращает чанки документа с порядковыми номерами в [fromIndex, toIndex]
+// в порядке следования в документе с учётом уровня доступа.
+func (r *ChunkRepository) FindByDocIndexRange(ctx context.Context, docID uuid.UUID, fromIndex, toIndex int, accessLevel int) ([]models.Chunk, error) {
+	var chunks []models.Chunk
+	err := r.db.WithContext(ctx).Raw(`
+		SELECT c.* FROM chunks c
+		JOIN documents d ON d.id = c.doc_id
+		WHERE c.doc_id = ? AND d.access_level <= ? AND c.chunk_index BETWEEN ? AND ?
+		ORDER BY c.chunk_index ASC
+	`, docID, accessLevel, fromIndex, toIndex).Scan(&chunks).Error
+	return chunks, err
+}
+
+// FindChatDocIndexRange — как FindByDocIndexRange, но только для документа указанного чата, попадающего
+// под фильтры запроса: идентификатор документа приходит извне (от модели в режиме агента) и не должен
+// выводить ни за пределы чата, ни за пределы фильтров (теги, документы, даты, asOf).
+func (r *ChunkRepository) FindChatDocIndexRange(ctx context.Context, chatID, docID uuid.UUID, fromIndex, toIndex int, accessLevel int, filters *models.RetrievalFilters) ([]models.Chunk, error) {
+	filterSQL, args := documentFilterSQL(filters, []interface{}{docID, chatID, accessLevel, fromIndex, toIndex})
 	var chunks []models.Chunk
-	err := r.db.Raw(`
-		SELECT c.*, (c.embedding <=> ?) AS score FROM chunks c
+	err := r.db.WithContext(ctx).Raw(`
+		SELECT c.* FROM chunks c
+		JOIN documents d ON d.id = c.doc_id
+		WHERE c.doc_id = ? AND d.chat_id = ? AND d.access_level <= ? AND c.chunk_index BETWEEN ? AND ?`+filterSQL+`
+		ORDER BY c.chunk_index ASC
+	`, args...).Scan(&chunks).Error
+	return chunks, err
+}
+
+// ListLanguages возвращает языки, определённые для чанков чата при загрузке документов.
+func (r *ChunkRepository) ListLanguages(ctx context.Context, chatID uuid.UUID) ([]string, error) {
+	var languages []string
+	err := r.db.WithContext(ctx).Raw(`
+		SELECT DISTINCT language FROM chunks
+		WHERE chat_id = ? AND language NOT IN ('', 'und')
+		ORDER BY language
+	`, chatID).Scan(&languages).Error
+	return languages, err
+}
+
+// CorpusVersion возвращает отпечаток корпуса чата: хэш списка документов с их уровнями доступа
+// (по id, поэтому обмен уровнями между документами тоже меняет отпечаток), периодами действия
+// и признаком «действует сегодня», плюс число чанков. Признак меняет отпечаток, когда документ
+// вступает в силу или утрачивает её без правок. Используется для инвалидации кэша ответов.
+func (r *ChunkRepository) CorpusVersion(ctx context.Context, chatID uuid.UUID) (string, error) {
+	var row struct {
+		Documents string
+		Chunks    int64
+	}
+	err := r.db.WithContext(ctx).Raw(`
+		SELECT
+			(SELECT coalesce(md5(string_agg(
+					id::text || ':' || access_level
+						|| ':' || coalesce(effective_date::text, '') || ':' || coalesce(valid_until::text, '')
+						|| ':' || (COALESCE(effective_date, created_date) <= now() AND (valid_until IS NULL OR valid_until > now()))::text,
+					',' ORDER BY id)), '')
+				FROM documents WHERE chat_id = ?) AS documents,
+			(SELECT count(*) FROM chunks WHERE chat_id = ?) AS chunks
+	`, chatID, chatID).Scan(&row).Error
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d", row.Documents, row.Chunks), nil
+}
+
+// SearchByVector ищет ближайшие чанки. Ненулевые params (hnsw.ef_search / ivfflat.probes)
+// применяются только к этому запросу через SET LOCAL внутри транзакции.
+func (r *ChunkRepository) SearchByVector(ctx context.Context, vec pgvector.Vector, limit int, chatID uuid.UUID, accessLevel int, filters *models.RetrievalFilters, params VectorSearchParams) ([]models.Chunk, error) {
+	args := []interface{}{vec, chatID, accessLevel}
+	filterSQL, args := documentFilterSQL(filters, args)
+	args = append(args, vec, limit)
+
+	querySQL := `
+		SELECT c.*, (c.embedding <=> ?) AS score, COALESCE(d.effective_date, d.created_date) AS document_date FROM chunks c
 		JOIN documents d ON d.id = c.doc_id
-		WHERE c.chat_id = ? AND d.access_level <= ?
+		WHERE c.chat_id = ? AND d.access_level <= ?` + filterSQL + `
 		ORDER BY c.embedding <=> ?
 		LIMIT ?
-	`, vec, chatID, accessLevel, vec, limit).Scan(&chunks).Error
+	`
+
+	var chunks []models.Chunk
+	if params.isZero() {
+		err := r.db.WithContext(ctx).Raw(querySQL, args...).Scan(&chunks).Error
+		return chunks, err
+	}
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := applySearchParams(tx, params); err != nil {
+			return err
+		}
+		return tx.Raw(querySQL, args...).Scan(&chunks).Error
+	})
 	return chunks, err
 }
 
-func (r *ChunkRepository) SearchByKeyword(query string, limit int, chatID uuid.UUID, accessLevel int) ([]models.Chunk, error) {
+// SearchByKeyword ищет чанки, содержащие термины запроса или одну из фраз expansions
+// (раскрытия синонимов и сокращений из словаря чата).
+func (r *ChunkRepository) SearchByKeyword(ctx context.Context, query string, limit int, chatID uuid.UUID, accessLevel int, filters *models.RetrievalFilters, expansions []string) ([]models.Chunk, error) {
 	if limit <= 0 {
 		limit = 5
 	}
 
 	terms := strings.Fields(strings.TrimSpace(query))
-	if len(terms) == 0 {
+	if len(terms) == 0 && len(expansions) == 0 {
 		return []models.Chunk{}, nil
 	}
 	if len(terms) > 6 {
@@ -64,21 +169,76 @@ func (r *ChunkRepository) SearchByKeyword(query string, limit int, chatID uuid.U
 		conditions = append(conditions, "LOWER(c.text) LIKE LOWER(?)")
 		args = append(args, "%"+clean+"%")
 	}
+	for _, phrase := range expansions {
+		if phrase = strings.TrimSpace(phrase); len(phrase) < 3 {
+			continue
+		}
+		conditions = append(conditions, "LOWER(c.text) LIKE LOWER(?)")
+		args = append(args, "%"+phrase+"%")
+	}
 
 	if len(conditions) == 0 {
 		return []models.Chunk{}, nil
 	}
 
+	filterSQL, args := documentFilterSQL(filters, args)
 	args = append(args, limit)
 	querySQL := `
-		SELECT c.* FROM chunks c
+		SELECT c.*, COALESCE(d.effective_date, d.created_date) AS document_date FROM chunks c
 		JOIN documents d ON d.id = c.doc_id
-		WHERE c.chat_id = ? AND d.access_level <= ? AND (` + strings.Join(conditions, " OR ") + `)
-		ORDER BY c.doc_name ASC, c.chunk_name ASC
+		WHERE c.chat_id = ? AND d.access_level <= ? AND (` + strings.Join(conditions, " OR ") + `)` + filterSQL + `
+		ORDER BY c.doc_name ASC, c.chunk_index ASC
 		LIMIT ?
 	`
 
 	var chunks []models.Chunk
-	err := r.db.Raw(querySQL, args...).Scan(&chunks).Error
+	err := r.db.WithContext(ctx).Raw(querySQL, args...).Scan(&chunks).Error
 	return chunks, err
 }
+
+// documentFilterSQL строит дополнительные условия по документу (алиас d) для фильтров запроса.
+// Возвращает фрагмент SQL, начинающийся с " AND ...", и дополненный список аргументов.
+func documentFilterSQL(filters *models.RetrievalFilters, args []interface{}) (string, []interface{}) {
+	if filters.IsEmpty() {
+		return "", args
+	}
+
+	var b strings.Builder
+	if len(filters.TagsAny) > 0 {
+		b.WriteString(" AND d.tags && ?")
+		args = append(args, pq.Array(filters.TagsAny))
+	}
+	if len(filters.TagsAll) > 0 {
+		b.WriteString(" AND d.tags @> ?")
+		args = append(args, pq.Array(filters.TagsAll))
+	}
+	if len(filters.DocumentIDs) > 0 {
+		b.WriteString(" AND d.id IN ?")
+		args = append(args, filters.DocumentIDs)
+	}
+	if len(filters.ExcludeDocumentIDs) > 0 {
+		b.WriteString(" AND d.id NOT IN ?")
+		args = append(args, filters.ExcludeDocumentIDs)
+	}
+	if filters.CreatedFrom != nil {
+		b.WriteString(" AND d.created_date >= ?")
+		args = append(args, filters.CreatedFrom.Time)
+	}
+	if before := filters.CreatedBefore(); before != nil {
+		b.WriteString(" AND d.created_date < ?")
+		args = append(args, *before)
+	}
+	// Документ действует на дату asOf: вступил в силу до конца этой даты и не утратил силу к её началу.
+	// valid_until сравнивается с самой датой asOf, а не с исключающей границей: для asOf=2025-06-30
+	// документ с valid_until=2025-06-30 ещё действует. Для момента времени условие то же, что в
+	// отпечатке корпуса (valid_until > now()).
+	if asOf := filters.AsOfBefore(); asOf != nil {
+		validUntil := " AND (d.valid_until IS NULL OR d.valid_until > ?)"
+		if filters.AsOf.DateOnly {
+			validUntil = " AND (d.valid_until IS NULL OR d.valid_until >= ?)"
+		}
+		b.WriteString(" AND COALESCE(d.effective_date, d.created_date) < ?" + validUntil)
+		args = append(args, *asOf, filters.AsOf.Time)
+	}
+	return b.String(), args
+}
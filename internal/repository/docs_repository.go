@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"context"
+
 	"github.com/google/uuid"
 	"github.com/katakuxiko/Diplom/internal/models"
 	"github.com/lib/pq"
@@ -53,6 +55,22 @@ func (r *DocumentRepository) GetAllPaginated(limit, offset int, chatID uuid.UUID
 	return docs, total, err
 }
 
+// GetFiltered возвращает до limit документов чата, попадающих под фильтры запроса
+// (теги, документы, даты загрузки, действие на дату asOf), и их общее число.
+func (r *DocumentRepository) GetFiltered(ctx context.Context, limit int, chatID uuid.UUID, maxAccessLevel int, filters *models.RetrievalFilters) ([]models.Document, int64, error) {
+	filterSQL, args := documentFilterSQL(filters, []interface{}{chatID, maxAccessLevel})
+	where := ` FROM documents d WHERE d.chat_id = ? AND d.access_level <= ?` + filterSQL
+
+	db := r.db.WithContext(ctx)
+	var total int64
+	if err := db.Raw(`SELECT count(*)`+where, args...).Scan(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var docs []models.Document
+	err := db.Raw(`SELECT d.*`+where+` ORDER BY d.name LIMIT ?`, append(args, limit)...).Scan(&docs).Error
+	return docs, total, err
+}
+
 func (r *DocumentRepository) GetDistinctTags(chatID uuid.UUID, maxAccessLevel int) ([]string, error) {
 	var tags []string
 	query := `
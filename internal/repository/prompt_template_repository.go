@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/katakuxiko/Diplom/internal/models"
+	"gorm.io/gorm"
+)
+
+type PromptTemplateRepository struct {
+	db *gorm.DB
+}
+
+func NewPromptTemplateRepository(db *gorm.DB) *PromptTemplateRepository {
+	return &PromptTemplateRepository{db: db}
+}
+
+// ListByChat возвращает все версии всех шаблонов чата (по имени, новые версии первыми).
+func (r *PromptTemplateRepository) ListByChat(ctx context.Context, chatID uuid.UUID) ([]models.ChatPromptTemplate, error) {
+	var templates []models.ChatPromptTemplate
+	err := r.db.WithContext(ctx).
+		Where("chat_id = ?", chatID).
+		Order("name asc, version desc").
+		Find(&templates).Error
+	return templates, err
+}
+
+func (r *PromptTemplateRepository) ListVersions(ctx context.Context, chatID uuid.UUID, name string) ([]models.ChatPromptTemplate, error) {
+	var templates []models.ChatPromptTemplate
+	err := r.db.WithContext(ctx).
+		Where("chat_id = ? AND name = ?", chatID, name).
+		Order("version desc").
+		Find(&templates).Error
+	return templates, err
+}
+
+// Get возвращает версию шаблона; version <= 0 — последнюю.
+func (r *PromptTemplateRepository) Get(ctx context.Context, chatID uuid.UUID, name string, version int) (*models.ChatPromptTemplate, error) {
+	q := r.db.WithContext(ctx).Where("chat_id = ? AND name = ?", chatID, name)
+	if version > 0 {
+		q = q.Where("version = ?", version)
+	} else {
+		q = q.Order("version desc")
+	}
+	var tpl models.ChatPromptTemplate
+	if err := q.First(&tpl).Error; err != nil {
+		return nil, err
+	}
+	return &tpl, nil
+}
+
+// CreateVersion сохраняет шаблон следующей версией после последней существующей с тем же именем.
+func (r *PromptTemplateRepository) CreateVersion(ctx context.Context, tpl *models.ChatPromptTemplate) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var latest int
+		if err := tx.Model(&models.ChatPromptTemplate{}).
+			Where("chat_id = ? AND name = ?", tpl.ChatID, tpl.Name).
+			Select("COALESCE(MAX(version), 0)").
+			Scan(&latest).Error; err != nil {
+			return err
+		}
+		tpl.Version = latest + 1
+		return tx.Create(tpl).Error
+	})
+}
+
+func (r *PromptTemplateRepository) Delete(ctx context.Context, chatID uuid.UUID, name string) (int64, error) {
+	res := r.db.WithContext(ctx).Where("chat_id = ? AND name = ?", chatID, name).Delete(&models.ChatPromptTemplate{})
+	return res.RowsAffected, res.Error
+}
+
+func (r *PromptTemplateRepository) ChatName(ctx context.Context, chatID uuid.UUID) (string, error) {
+	var name string
+	err := r.db.WithContext(ctx).Model(&models.Chat{}).Where("id = ?", chatID).Select("name").Scan(&name).Error
+	return name, err
+}
@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/katakuxiko/Diplom/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type SynonymRepository struct {
+	db *gorm.DB
+}
+
+func NewSynonymRepository(db *gorm.DB) *SynonymRepository {
+	return &SynonymRepository{db: db}
+}
+
+func (r *SynonymRepository) ListByChat(ctx context.Context, chatID uuid.UUID) ([]models.ChatSynonym, error) {
+	var entries []models.ChatSynonym
+	err := r.db.WithContext(ctx).
+		Where("chat_id = ?", chatID).
+		Order("term asc").
+		Find(&entries).Error
+	return entries, err
+}
+
+func (r *SynonymRepository) GetByID(ctx context.Context, chatID, id uuid.UUID) (*models.ChatSynonym, error) {
+	var entry models.ChatSynonym
+	if err := r.db.WithContext(ctx).Where("chat_id = ? AND id = ?", chatID, id).First(&entry).Error; err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (r *SynonymRepository) Create(ctx context.Context, entry *models.ChatSynonym) error {
+	return r.db.WithContext(ctx).Create(entry).Error
+}
+
+// TermExists сообщает, есть ли в словаре чата другая запись (id не exceptID) с тем же термином.
+func (r *SynonymRepository) TermExists(ctx context.Context, chatID uuid.UUID, term string, exceptID uuid.UUID) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.ChatSynonym{}).
+		Where("chat_id = ? AND term = ? AND id <> ?", chatID, term, exceptID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (r *SynonymRepository) Update(ctx context.Context, entry *models.ChatSynonym) error {
+	return r.db.WithContext(ctx).Save(entry).Error
+}
+
+func (r *SynonymRepository) Delete(ctx context.Context, chatID, id uuid.UUID) (bool, error) {
+	res := r.db.WithContext(ctx).Where("chat_id = ? AND id = ?", chatID, id).Delete(&models.ChatSynonym{})
+	return res.RowsAffected > 0, res.Error
+}
+
+// Upsert сохраняет запись словаря; при совпадении (chat_id, term) заменяет синонимы и вид.
+func (r *SynonymRepository) Upsert(ctx context.Context, entry *models.ChatSynonym) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "chat_id"}, {Name: "term"}},
+		DoUpdates: clause.AssignmentColumns([]string{"synonyms", "kind"}),
+	}).Create(entry).Error
+}
@@ -0,0 +1,337 @@
+package repository
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pgvector/pgvector-go"
+	"gorm.io/gorm"
+)
+
+const (
+	ivfflatIndexName = "chunks_embedding_ivfflat_idx"
+	hnswIndexName    = "chunks_embedding_hnsw_idx"
+	rebuildSuffix    = "_new"
+
+	// MinIVFFlatRows — меньше строк ivfflat не строится автоматически: центроиды списков
+	// считаются по имеющимся данным, и на почти пустой таблице индекс даёт плохой recall.
+	MinIVFFlatRows = 1000
+)
+
+// VectorIndexConfig — параметры построения векторного индекса по chunks.embedding.
+type VectorIndexConfig struct {
+	Type           string `json:"type"`            // "ivfflat" или "hnsw"
+	Lists          int    `json:"lists"`           // ivfflat; 0 — подобрать по числу строк
+	M              int    `json:"m"`               // hnsw
+	EfConstruction int    `json:"ef_construction"` // hnsw
+}
+
+// VectorSearchParams — параметры точности приблизительного поиска, задаваемые на один запрос.
+type VectorSearchParams struct {
+	EfSearch int // hnsw.ef_search
+	Probes   int // ivfflat.probes
+}
+
+func (p VectorSearchParams) isZero() bool {
+	return p.EfSearch <= 0 && p.Probes <= 0
+}
+
+type VectorIndexInfo struct {
+	Name       string `json:"name"`
+	Method     string `json:"method"`
+	Definition string `json:"definition"`
+	Valid      bool   `json:"valid"`
+	SizeBytes  int64  `json:"size_bytes"`
+}
+
+type VectorTableStats struct {
+	Rows              int64      `json:"rows"`
+	RowsWithEmbedding int64      `json:"rows_with_embedding"`
+	DeadRows          int64      `json:"dead_rows"`
+	LastAnalyze       *time.Time `json:"last_analyze"`
+	LastAutoAnalyze   *time.Time `json:"last_autoanalyze"`
+}
+
+// EmbeddingSample — эмбеддинг существующего чанка, используемый как запрос в бенчмарке.
+type EmbeddingSample struct {
+	ID        uuid.UUID
+	ChatID    uuid.UUID
+	Embedding pgvector.Vector
+}
+
+type VectorIndexRepository struct {
+	db *gorm.DB
+}
+
+func NewVectorIndexRepository(db *gorm.DB) *VectorIndexRepository {
+	return &VectorIndexRepository{db: db}
+}
+
+// NormalizeVectorIndexConfig подставляет значения по умолчанию.
+func NormalizeVectorIndexConfig(cfg VectorIndexConfig) VectorIndexConfig {
+	cfg.Type = strings.ToLower(strings.TrimSpace(cfg.Type))
+	if cfg.Type != "hnsw" {
+		cfg.Type = "ivfflat"
+	}
+	if cfg.M <= 0 {
+		cfg.M = 16
+	}
+	if cfg.EfConstruction <= 0 {
+		cfg.EfConstruction = 64
+	}
+	if cfg.EfConstruction < 2*cfg.M {
+		cfg.EfConstruction = 2 * cfg.M
+	}
+	return cfg
+}
+
+// RecommendedIVFLists — рекомендация pgvector: rows/1000 до миллиона строк, sqrt(rows) — выше.
+func RecommendedIVFLists(rows int64) int {
+	lists := int(rows / 1000)
+	if rows > 1_000_000 {
+		lists = int(math.Sqrt(float64(rows)))
+	}
+	if lists < 10 {
+		lists = 10
+	}
+	return lists
+}
+
+// ListIndexes возвращает векторные индексы таблицы chunks.
+func (r *VectorIndexRepository) ListIndexes() ([]VectorIndexInfo, error) {
+	var indexes []VectorIndexInfo
+	err := r.db.Raw(`
+		SELECT i.relname AS name, am.amname AS method, pg_get_indexdef(i.oid) AS definition,
+			x.indisvalid AS valid, pg_relation_size(i.oid) AS size_bytes
+		FROM pg_index x
+		JOIN pg_class i ON i.oid = x.indexrelid
+		JOIN pg_class t ON t.oid = x.indrelid
+		JOIN pg_am am ON am.oid = i.relam
+		WHERE t.relname = 'chunks' AND am.amname IN ('ivfflat', 'hnsw')
+		ORDER BY i.relname
+	`).Scan(&indexes).Error
+	return indexes, err
+}
+
+func (r *VectorIndexRepository) TableStats() (VectorTableStats, error) {
+	var stats VectorTableStats
+	if err := r.db.Raw(`
+		SELECT count(*) AS rows, count(embedding) AS rows_with_embedding FROM chunks
+	`).Scan(&stats).Error; err != nil {
+		return stats, err
+	}
+
+	var pgStats struct {
+		DeadRows        int64
+		LastAnalyze     *time.Time
+		LastAutoAnalyze *time.Time
+	}
+	if err := r.db.Raw(`
+		SELECT n_dead_tup AS dead_rows, last_analyze, last_autoanalyze
+		FROM pg_stat_user_tables WHERE relname = 'chunks'
+	`).Scan(&pgStats).Error; err != nil {
+		return stats, err
+	}
+	stats.DeadRows = pgStats.DeadRows
+	stats.LastAnalyze = pgStats.LastAnalyze
+	stats.LastAutoAnalyze = pgStats.LastAutoAnalyze
+	return stats, nil
+}
+
+// EnsureIndex создаёт векторный индекс, если его ещё нет. Существующий индекс другого типа
+// не трогается — для смены типа используется Rebuild. ivfflat не создаётся, пока в таблице
+// меньше MinIVFFlatRows эмбеддингов: до этого поиск работает точным перебором.
+// Возвращает true, если индекс создан.
+func (r *VectorIndexRepository) EnsureIndex(cfg VectorIndexConfig) (bool, error) {
+	indexes, err := r.ListIndexes()
+	if err != nil {
+		return false, err
+	}
+	if len(indexes) > 0 {
+		return false, nil
+	}
+	cfg = NormalizeVectorIndexConfig(cfg)
+	if cfg.Type == "ivfflat" {
+		stats, err := r.TableStats()
+		if err != nil {
+			return false, err
+		}
+		if stats.RowsWithEmbedding < MinIVFFlatRows {
+			return false, nil
+		}
+		if cfg.Lists <= 0 {
+			cfg.Lists = RecommendedIVFLists(stats.RowsWithEmbedding)
+		}
+	}
+	if err := r.db.Exec(r.indexStatement(cfg, indexName(cfg), false)).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Rebuild строит новый индекс с заданными параметрами под временным именем (CREATE INDEX CONCURRENTLY,
+// без блокировки записи), затем в одной транзакции удаляет старые векторные индексы и переименовывает новый.
+// Всё время построения поиск продолжает пользоваться старым индексом.
+func (r *VectorIndexRepository) Rebuild(cfg VectorIndexConfig) (VectorIndexConfig, error) {
+	cfg = NormalizeVectorIndexConfig(cfg)
+	name := indexName(cfg)
+	tmpName := name + rebuildSuffix
+
+	// Остаток прерванного перестроения (в том числе невалидный индекс после ошибки CONCURRENTLY).
+	if err := r.db.Exec(fmt.Sprintf(`DROP INDEX CONCURRENTLY IF EXISTS %q`, tmpName)).Error; err != nil {
+		return cfg, err
+	}
+	stmt, err := r.buildStatement(cfg, tmpName)
+	if err != nil {
+		return cfg, err
+	}
+	// CONCURRENTLY нельзя выполнять внутри транзакции, поэтому запрос идёт отдельно.
+	if err := r.db.Exec(stmt).Error; err != nil {
+		r.db.Exec(fmt.Sprintf(`DROP INDEX CONCURRENTLY IF EXISTS %q`, tmpName))
+		return cfg, err
+	}
+
+	indexes, err := r.ListIndexes()
+	if err != nil {
+		return cfg, err
+	}
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		for _, idx := range indexes {
+			if idx.Name == tmpName {
+				continue
+			}
+			if err := tx.Exec(fmt.Sprintf(`DROP INDEX IF EXISTS %q`, idx.Name)).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Exec(fmt.Sprintf(`ALTER INDEX %q RENAME TO %q`, tmpName, name)).Error
+	})
+	if err != nil {
+		return cfg, err
+	}
+	return cfg, r.Analyze()
+}
+
+// Reindex перестраивает существующие векторные индексы с прежними параметрами и обновляет статистику.
+func (r *VectorIndexRepository) Reindex() error {
+	indexes, err := r.ListIndexes()
+	if err != nil {
+		return err
+	}
+	for _, idx := range indexes {
+		if err := r.db.Exec(fmt.Sprintf(`REINDEX INDEX %q`, idx.Name)).Error; err != nil {
+			return err
+		}
+	}
+	return r.Analyze()
+}
+
+func (r *VectorIndexRepository) Analyze() error {
+	return r.db.Exec(`ANALYZE chunks`).Error
+}
+
+func indexName(cfg VectorIndexConfig) string {
+	if cfg.Type == "hnsw" {
+		return hnswIndexName
+	}
+	return ivfflatIndexName
+}
+
+// buildStatement — CREATE INDEX CONCURRENTLY для перестроения; число списков ivfflat подбирается по строкам.
+func (r *VectorIndexRepository) buildStatement(cfg VectorIndexConfig, name string) (string, error) {
+	if cfg.Type != "hnsw" && cfg.Lists <= 0 {
+		stats, err := r.TableStats()
+		if err != nil {
+			return "", err
+		}
+		cfg.Lists = RecommendedIVFLists(stats.RowsWithEmbedding)
+	}
+	return r.indexStatement(cfg, name, true), nil
+}
+
+// indexStatement формирует CREATE INDEX; cfg.Lists для ivfflat должен быть уже подобран.
+func (r *VectorIndexRepository) indexStatement(cfg VectorIndexConfig, name string, concurrently bool) string {
+	create := `CREATE INDEX IF NOT EXISTS`
+	if concurrently {
+		create = `CREATE INDEX CONCURRENTLY IF NOT EXISTS`
+	}
+	if cfg.Type == "hnsw" {
+		return fmt.Sprintf(
+			`%s %s ON chunks USING hnsw (embedding vector_cosine_ops) WITH (m = %d, ef_construction = %d)`,
+			create, name, cfg.M, cfg.EfConstruction,
+		)
+	}
+	return fmt.Sprintf(
+		`%s %s ON chunks USING ivfflat (embedding vector_cosine_ops) WITH (lists = %d)`,
+		create, name, cfg.Lists,
+	)
+}
+
+// CurrentSearchSettings возвращает текущие значения hnsw.ef_search и ivfflat.probes.
+func (r *VectorIndexRepository) CurrentSearchSettings() (map[string]string, error) {
+	var row struct {
+		EfSearch string
+		Probes   string
+	}
+	err := r.db.Raw(`
+		SELECT current_setting('hnsw.ef_search', true) AS ef_search,
+			current_setting('ivfflat.probes', true) AS probes
+	`).Scan(&row).Error
+	return map[string]string{"hnsw.ef_search": row.EfSearch, "ivfflat.probes": row.Probes}, err
+}
+
+// SampleEmbeddings выбирает случайные эмбеддинги чанков (опционально одного чата) как запросы бенчмарка.
+func (r *VectorIndexRepository) SampleEmbeddings(chatID *uuid.UUID, n int) ([]EmbeddingSample, error) {
+	var samples []EmbeddingSample
+	query := r.db.Table("chunks").Select("id, chat_id, embedding").Where("embedding IS NOT NULL")
+	if chatID != nil {
+		query = query.Where("chat_id = ?", *chatID)
+	}
+	err := query.Order("random()").Limit(n).Scan(&samples).Error
+	return samples, err
+}
+
+// NearestIDs возвращает k ближайших чанков. exact=true отключает индексы и даёт точный перебор.
+func (r *VectorIndexRepository) NearestIDs(vec pgvector.Vector, chatID *uuid.UUID, k int, params VectorSearchParams, exact bool) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if exact {
+			for _, stmt := range []string{`SET LOCAL enable_indexscan = off`, `SET LOCAL enable_bitmapscan = off`, `SET LOCAL enable_indexonlyscan = off`} {
+				if err := tx.Exec(stmt).Error; err != nil {
+					return err
+				}
+			}
+		} else if err := applySearchParams(tx, params); err != nil {
+			return err
+		}
+
+		where := `embedding IS NOT NULL`
+		args := []interface{}{}
+		if chatID != nil {
+			where += ` AND chat_id = ?`
+			args = append(args, *chatID)
+		}
+		args = append(args, vec, k)
+		return tx.Raw(`SELECT id FROM chunks WHERE `+where+` ORDER BY embedding <=> ? LIMIT ?`, args...).Scan(&ids).Error
+	})
+	return ids, err
+}
+
+// applySearchParams выставляет параметры точности поиска на время текущей транзакции.
+// SET LOCAL не принимает плейсхолдеры, поэтому значения подставляются как целые числа.
+func applySearchParams(tx *gorm.DB, params VectorSearchParams) error {
+	if params.EfSearch > 0 {
+		if err := tx.Exec(fmt.Sprintf(`SET LOCAL hnsw.ef_search = %d`, params.EfSearch)).Error; err != nil {
+			return err
+		}
+	}
+	if params.Probes > 0 {
+		if err := tx.Exec(fmt.Sprintf(`SET LOCAL ivfflat.probes = %d`, params.Probes)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/katakuxiko/Diplom/internal/models"
+	"github.com/katakuxiko/Diplom/internal/utils"
+)
+
+const (
+	defaultAgentMaxSteps = 5
+	maxAgentMaxSteps     = 10
+	agentTraceMaxRunes   = 300
+)
+
+const agentInstruction = `Для ответа используй инструменты: search_chunks — поиск по документам чата, fetch_section — соседние фрагменты документа, list_documents — список документов, calculator — вычисления, date_math — операции с датами.
+Отвечай только по найденным фрагментам; числа и даты не считай в уме — вызывай calculator и date_math.
+Можно сделать не больше %d вызовов инструментов. Когда данных достаточно, дай итоговый ответ без вызова инструментов.`
+
+const agentStepLimitNote = "Лимит вызовов инструментов исчерпан. Дай итоговый ответ по уже полученным данным; если их недостаточно, так и скажи."
+
+// AgentStep — один вызов инструмента моделью в режиме агента.
+type AgentStep struct {
+	Step       int             `json:"step"`
+	Tool       string          `json:"tool"`
+	Arguments  json.RawMessage `json:"arguments,omitempty"`
+	Result     string          `json:"result,omitempty"` // начало результата, полный текст получает только модель
+	Error      string          `json:"error,omitempty"`
+	Fragments  int             `json:"fragments,omitempty"` // новых фрагментов для ответа и ссылок [n]
+	DurationMs int64           `json:"duration_ms"`
+}
+
+// AgentDiagnostics — ход работы агента: вызовы инструментов и причина перехода к обычному ответу.
+type AgentDiagnostics struct {
+	MaxSteps         int         `json:"max_steps"`
+	Steps            int         `json:"steps"`
+	Turns            int         `json:"turns"` // обращений к модели
+	StepLimitReached bool        `json:"step_limit_reached,omitempty"`
+	BudgetExhausted  bool        `json:"budget_exhausted,omitempty"` // результаты инструментов заняли весь бюджет контекста
+	Trace            []AgentStep `json:"trace,omitempty"`
+	Fallback         string      `json:"fallback,omitempty"` // почему ответ построен без инструментов
+}
+
+// agentRun — состояние одного запроса в режиме агента. Фрагменты нумеруются сквозь все вызовы,
+// поэтому ссылки [n] в ответе проверяются так же, как в обычном режиме.
+type agentRun struct {
+	s           *RAGService
+	chatID      uuid.UUID
+	accessLevel int
+	topK        int
+	settings    *models.AskSettings
+	fragments   []models.Chunk
+	seen        map[uuid.UUID]int
+}
+
+func agentModeEnabled(settings *models.AskSettings) bool {
+	return settings != nil && settings.AgentMode
+}
+
+func resolveAgentMaxSteps(settings *models.AskSettings) int {
+	if settings == nil || settings.AgentMaxSteps <= 0 {
+		return defaultAgentMaxSteps
+	}
+	if settings.AgentMaxSteps > maxAgentMaxSteps {
+		return maxAgentMaxSteps
+	}
+	return settings.AgentMaxSteps
+}
+
+// runAgent отвечает на вопрос в цикле: модель вызывает инструменты, получает их результаты
+// и в конце даёт ответ. handled=false — провайдер чата не умеет вызывать инструменты,
+// и ответ нужно построить обычным поиском (причина — в diagnostics.Agent.Fallback).
+func (s *RAGService) runAgent(ctx context.Context, query string, topK int, chatID uuid.UUID, settings *models.AskSettings, accessLevel int, history []models.ChatContextMessage, onToolStep func(AgentStep) error, diagnostics *RetrievalDiagnostics) (answer string, answerCtx answerContext, usage *ProviderUsage, handled bool, err error) {
+	maxSteps := resolveAgentMaxSteps(settings)
+	report := &AgentDiagnostics{MaxSteps: maxSteps}
+	diagnostics.Agent = report
+
+	if s.llm == nil {
+		report.Fallback = "llm client is nil"
+		return "", answerContext{}, nil, false, nil
+	}
+	providerType, _ := s.llm.chatProviderTarget(settings)
+	if caps, ok := s.llm.registry.Capabilities(providerType); !ok || !caps.Tools {
+		report.Fallback = fmt.Sprintf("provider %q does not support tool calling", providerType)
+		return "", answerContext{}, nil, false, nil
+	}
+
+	// Результаты инструментов занимают место контекста документов обычного ответа.
+	plan := planTokens(s.llm.chatName, query, settings, history)
+	tok := plan.estimator
+	budgetLeft := plan.budget.Context
+	diagnostics.RetrievalMode = "agent"
+	diagnostics.ContextBudget = plan.budget.Context
+
+	temperature := defaultAnswerTemperature
+	if settings.Temperature > 0 {
+		temperature = settings.Temperature
+	}
+	system := strings.TrimRight(renderSystemPrompt(query, settings, newPromptVars(query, "", settings)), "\n") + "\n\n" + fmt.Sprintf(agentInstruction, maxSteps)
+	messages := buildAnswerMessages(system, query, plan.history)
+
+	run := &agentRun{s: s, chatID: chatID, accessLevel: accessLevel, topK: topK, settings: settings, seen: make(map[uuid.UUID]int)}
+	for {
+		tools := agentTools
+		if report.StepLimitReached || report.BudgetExhausted {
+			tools = nil
+			messages = append(messages, ChatMessage{Role: "user", Content: agentStepLimitNote})
+		}
+
+		var resp ChatResponse
+		report.Turns++
+		_, usage, err = s.llm.chatWithFallback(ctx, settings, plan.budget.Model, func(ctx context.Context, provider ChatProvider, model string) (string, error) {
+			if tools != nil && !provider.Capabilities().Tools {
+				return "", fmt.Errorf("provider %s does not support tool calling", provider.Type())
+			}
+			r, err := provider.Chat(ctx, withReasoningEffort(ChatRequest{
+				Model:           model,
+				Messages:        messages,
+				Temperature:     temperature,
+				TopP:            0.9,
+				MaxTokens:       plan.budget.Answer,
+				PresencePenalty: 0.1,
+				Tools:           tools,
+			}, provider))
+			if err != nil {
+				return "", err
+			}
+			resp = r
+			return r.Content, nil
+		}, nil)
+		if err != nil {
+			if deadlineExceeded(ctx, err) {
+				err = fmt.Errorf("%w: %v", ErrAnswerTimeout, err)
+			}
+			return "", run.answerContext(tok, plan, budgetLeft, diagnostics), usage, true, err
+		}
+
+		if tools == nil || len(resp.ToolCalls) == 0 {
+			answer = strings.TrimSpace(resp.Content)
+			break
+		}
+
+		messages = append(messages, ChatMessage{Role: "assistant", Content: resp.Content, ToolCalls: resp.ToolCalls})
+		// На каждый вызов модель ждёт ответ с тем же tool_call_id, даже если инструмент уже не выполняется.
+		for _, call := range resp.ToolCalls {
+			if report.Steps >= maxSteps {
+				report.StepLimitReached = true
+				messages = append(messages, ChatMessage{Role: "tool", ToolCallID: call.ID, Content: "Ошибка: лимит вызовов инструментов исчерпан."})
+				continue
+			}
+			if budgetLeft <= 0 {
+				report.BudgetExhausted = true
+				messages = append(messages, ChatMessage{Role: "tool", ToolCallID: call.ID, Content: "Ошибка: бюджет контекста исчерпан."})
+				continue
+			}
+
+			report.Steps++
+			step, result := run.execute(ctx, call, report.Steps)
+			if tokens := tok.Count(result); tokens > budgetLeft {
+				result = truncateToTokens(tok, result, budgetLeft)
+			}
+			budgetLeft -= tok.Count(result)
+			report.Trace = append(report.Trace, step)
+			messages = append(messages, ChatMessage{Role: "tool", ToolCallID: call.ID, Content: result})
+			log.Printf("Agent step %d: tool=%s fragments=%d error=%q", step.Step, step.Tool, step.Fragments, step.Error)
+
+			if ctx.Err() != nil {
+				return "", run.answerContext(tok, plan, budgetLeft, diagnostics), usage, true, fmt.Errorf("%w: %v", ErrAnswerTimeout, ctx.Err())
+			}
+			if onToolStep != nil {
+				if cbErr := onToolStep(step); cbErr != nil {
+					return "", run.answerContext(tok, plan, budgetLeft, diagnostics), usage, true, cbErr
+				}
+			}
+		}
+		if report.Steps >= maxSteps {
+			report.StepLimitReached = true
+		}
+	}
+
+	return answer, run.answerContext(tok, plan, budgetLeft, diagnostics), usage, true, nil
+}
+
+// execute выполняет вызов инструмента; ошибка не прерывает цикл, а возвращается модели текстом.
+func (r *agentRun) execute(ctx context.Context, call ToolCall, number int) (AgentStep, string) {
+	start := time.Now()
+	step := AgentStep{Step: number, Tool: call.Name}
+	if args := strings.TrimSpace(call.Arguments); args != "" {
+		if json.Valid([]byte(args)) {
+			step.Arguments = json.RawMessage(args)
+		} else {
+			step.Arguments, _ = json.Marshal(args)
+		}
+	}
+
+	result, added, err := r.callTool(ctx, call)
+	step.DurationMs = time.Since(start).Milliseconds()
+	step.Fragments = added
+	if err != nil {
+		step.Error = err.Error()
+		result = "Ошибка: " + err.Error()
+	}
+	step.Result = truncateByRunes(result, agentTraceMaxRunes)
+	return step, result
+}
+
+// answerContext собирает найденные агентом фрагменты в контекст ответа для ссылок и проверки по источникам.
+func (r *agentRun) answerContext(tok TokenEstimator, plan tokenPlan, budgetLeft int, diagnostics *RetrievalDiagnostics) answerContext {
+	var b strings.Builder
+	for i, ch := range r.fragments {
+		label := strings.TrimSpace(ch.DocName)
+		if label == "" {
+			label = strings.TrimSpace(ch.ChunkName)
+		}
+		if label != "" {
+			fmt.Fprintf(&b, "Фрагмент %d [%s]: %s\n", i+1, label, utils.NormalizeText(ch.Text))
+		} else {
+			fmt.Fprintf(&b, "Фрагмент %d: %s\n", i+1, utils.NormalizeText(ch.Text))
+		}
+	}
+	text := b.String()
+	if tokens := tok.Count(text); tokens > plan.budget.Context {
+		text = truncateToTokens(tok, text, plan.budget.Context)
+	}
+
+	diagnostics.SelectedChunks = len(r.fragments)
+	diagnostics.ContextCharsUsed = len([]rune(text))
+	budget := plan.budget
+	budget.ContextUsed = plan.budget.Context - budgetLeft
+	budget.updateTotal()
+	diagnostics.TokenBudget = &budget
+	return answerContext{chunks: r.fragments, text: text, fragments: r.fragments}
+}
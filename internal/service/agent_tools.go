@@ -0,0 +1,577 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/katakuxiko/Diplom/internal/models"
+	"github.com/katakuxiko/Diplom/internal/repository"
+	"github.com/katakuxiko/Diplom/internal/utils"
+)
+
+const (
+	maxAgentSearchTopK     = 8
+	maxAgentSectionWindow  = 3
+	defaultAgentDocsLimit  = 20
+	maxAgentDocsLimit      = 50
+	agentFragmentMaxRunes  = 1200
+	maxCalculatorExprRunes = 200
+	maxCalculatorDepth     = 32
+)
+
+// agentTools — инструменты режима агента в формате OpenAI function calling.
+var agentTools = []ToolDefinition{
+	{
+		Name:        "search_chunks",
+		Description: "Поиск фрагментов документов чата по запросу. Можно сузить поиск тегами документов или идентификаторами документов.",
+		Parameters: json.RawMessage(`{"type":"object","properties":{
+			"query":{"type":"string","description":"Поисковый запрос"},
+			"top_k":{"type":"integer","minimum":1,"maximum":8,"description":"Сколько фрагментов вернуть"},
+			"tags_any":{"type":"array","items":{"type":"string"},"description":"Только документы хотя бы с одним из тегов"},
+			"document_ids":{"type":"array","items":{"type":"string"},"description":"Только эти документы"}},
+			"required":["query"]}`),
+	},
+	{
+		Name:        "fetch_section",
+		Description: "Текст документа вокруг фрагмента: чанк с номером chunk_index и window соседних чанков с каждой стороны.",
+		Parameters: json.RawMessage(`{"type":"object","properties":{
+			"document_id":{"type":"string"},
+			"chunk_index":{"type":"integer","minimum":0},
+			"window":{"type":"integer","minimum":0,"maximum":3}},
+			"required":["document_id","chunk_index"]}`),
+	},
+	{
+		Name:        "list_documents",
+		Description: "Список документов чата (идентификатор, название, теги, дата действия), при необходимости — только с тегом.",
+		Parameters: json.RawMessage(`{"type":"object","properties":{
+			"tag":{"type":"string"},
+			"limit":{"type":"integer","minimum":1,"maximum":50}}}`),
+	},
+	{
+		Name:        "calculator",
+		Description: "Вычисляет арифметическое выражение: + - * / % ^, скобки, sqrt(), abs(), round().",
+		Parameters: json.RawMessage(`{"type":"object","properties":{
+			"expression":{"type":"string","description":"Например: (12500 - 9800) / 9800 * 100"}},
+			"required":["expression"]}`),
+	},
+	{
+		Name:        "date_math",
+		Description: "Операции с датами (YYYY-MM-DD или today): add — прибавить days/months/years, diff — дней от date до end_date, weekday — день недели.",
+		Parameters: json.RawMessage(`{"type":"object","properties":{
+			"operation":{"type":"string","enum":["add","diff","weekday"]},
+			"date":{"type":"string"},
+			"end_date":{"type":"string"},
+			"days":{"type":"integer"},
+			"months":{"type":"integer"},
+			"years":{"type":"integer"}},
+			"required":["operation","date"]}`),
+	},
+}
+
+// SetDocumentRepository подключает список документов чата к инструментам агента и к подбору следующих вопросов.
+func (s *RAGService) SetDocumentRepository(repo *repository.DocumentRepository) {
+	s.documents = repo
+}
+
+// callTool выполняет вызов инструмента. Чат и уровень доступа берутся из запроса, а не из аргументов модели.
+// Ошибка в аргументах возвращается модели текстом, чтобы она могла исправить вызов.
+func (r *agentRun) callTool(ctx context.Context, call ToolCall) (string, int, error) {
+	args := strings.TrimSpace(call.Arguments)
+	if args == "" {
+		args = "{}"
+	}
+	switch call.Name {
+	case "search_chunks":
+		var in struct {
+			Query       string   `json:"query"`
+			TopK        int      `json:"top_k"`
+			TagsAny     []string `json:"tags_any"`
+			DocumentIDs []string `json:"document_ids"`
+		}
+		if err := json.Unmarshal([]byte(args), &in); err != nil {
+			return "", 0, fmt.Errorf("invalid arguments: %w", err)
+		}
+		return r.searchChunks(ctx, in.Query, in.TopK, in.TagsAny, in.DocumentIDs)
+	case "fetch_section":
+		var in struct {
+			DocumentID string `json:"document_id"`
+			ChunkIndex int    `json:"chunk_index"`
+			Window     int    `json:"window"`
+		}
+		if err := json.Unmarshal([]byte(args), &in); err != nil {
+			return "", 0, fmt.Errorf("invalid arguments: %w", err)
+		}
+		return r.fetchSection(ctx, in.DocumentID, in.ChunkIndex, in.Window)
+	case "list_documents":
+		var in struct {
+			Tag   string `json:"tag"`
+			Limit int    `json:"limit"`
+		}
+		if err := json.Unmarshal([]byte(args), &in); err != nil {
+			return "", 0, fmt.Errorf("invalid arguments: %w", err)
+		}
+		result, err := r.listDocuments(ctx, in.Tag, in.Limit)
+		return result, 0, err
+	case "calculator":
+		var in struct {
+			Expression string `json:"expression"`
+		}
+		if err := json.Unmarshal([]byte(args), &in); err != nil {
+			return "", 0, fmt.Errorf("invalid arguments: %w", err)
+		}
+		value, err := evalExpression(in.Expression)
+		if err != nil {
+			return "", 0, err
+		}
+		return strconv.FormatFloat(value, 'f', -1, 64), 0, nil
+	case "date_math":
+		var in dateMathArgs
+		if err := json.Unmarshal([]byte(args), &in); err != nil {
+			return "", 0, fmt.Errorf("invalid arguments: %w", err)
+		}
+		result, err := dateMath(in, time.Now())
+		return result, 0, err
+	default:
+		return "", 0, fmt.Errorf("unknown tool %q", call.Name)
+	}
+}
+
+// searchChunks ищет так же, как обычный ответ, но фильтры модели могут только сузить фильтры запроса.
+func (r *agentRun) searchChunks(ctx context.Context, query string, topK int, tagsAny, documentIDs []string) (string, int, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return "", 0, errors.New("query is required")
+	}
+	if topK <= 0 || topK > maxAgentSearchTopK {
+		topK = r.topK
+	}
+	if topK > maxAgentSearchTopK {
+		topK = maxAgentSearchTopK
+	}
+
+	settings := *r.settings
+	filters, ok, err := narrowFilters(r.settings.Filters, tagsAny, documentIDs)
+	if err != nil {
+		return "", 0, err
+	}
+	if !ok {
+		return "Ничего не найдено: фильтры инструмента не пересекаются с фильтрами запроса.", 0, nil
+	}
+	settings.Filters = filters
+	settings.Explain = false
+
+	var diagnostics RetrievalDiagnostics
+	chunks, err := r.s.retrieveChunksForQuery(ctx, query, topK, r.chatID, &settings, r.accessLevel, &diagnostics)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(chunks) == 0 {
+		return "Ничего не найдено.", 0, nil
+	}
+	return r.addFragments(chunks)
+}
+
+// narrowFilters накладывает фильтры модели на фильтры запроса. ok=false — пересечение пустое;
+// nil-фильтры при ok=true означают поиск без фильтров.
+func narrowFilters(base *models.RetrievalFilters, tagsAny, documentIDs []string) (*models.RetrievalFilters, bool, error) {
+	out := &models.RetrievalFilters{}
+	if base != nil {
+		copied := *base
+		out = &copied
+	}
+	// Теги модели приводим к виду тегов документов, как и теги запроса.
+	if tags := (&models.RetrievalFilters{TagsAny: tagsAny}).Normalize(); tags != nil {
+		if len(out.TagsAny) == 0 {
+			out.TagsAny = tags.TagsAny
+		} else if out.TagsAny = intersectStrings(out.TagsAny, tags.TagsAny); len(out.TagsAny) == 0 {
+			return nil, false, nil
+		}
+	}
+	if len(documentIDs) > 0 {
+		ids := make([]uuid.UUID, 0, len(documentIDs))
+		for _, raw := range documentIDs {
+			id, err := uuid.Parse(strings.TrimSpace(raw))
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid document id %q", raw)
+			}
+			ids = append(ids, id)
+		}
+		if len(out.DocumentIDs) == 0 {
+			out.DocumentIDs = ids
+		} else if out.DocumentIDs = intersectIDs(out.DocumentIDs, ids); len(out.DocumentIDs) == 0 {
+			return nil, false, nil
+		}
+	}
+	return out.Normalize(), true, nil
+}
+
+func (r *agentRun) fetchSection(ctx context.Context, documentID string, chunkIndex, window int) (string, int, error) {
+	docID, err := uuid.Parse(strings.TrimSpace(documentID))
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid document id %q", documentID)
+	}
+	if window < 0 {
+		window = 0
+	}
+	if window > maxAgentSectionWindow {
+		window = maxAgentSectionWindow
+	}
+	if r.s.ChunkRepository == nil {
+		return "", 0, errors.New("chunk repository is not configured")
+	}
+	chunks, err := r.s.ChunkRepository.FindChatDocIndexRange(ctx, r.chatID, docID, chunkIndex-window, chunkIndex+window, r.accessLevel, retrievalFilters(r.settings))
+	if err != nil {
+		return "", 0, err
+	}
+	if len(chunks) == 0 {
+		return "Фрагмент не найден или недоступен.", 0, nil
+	}
+	return r.addFragments(chunks)
+}
+
+// listDocuments перечисляет документы чата в пределах фильтров запроса, включая действие на дату asOf.
+func (r *agentRun) listDocuments(ctx context.Context, tag string, limit int) (string, error) {
+	if r.s.documents == nil {
+		return "", errors.New("document repository is not configured")
+	}
+	if limit <= 0 {
+		limit = defaultAgentDocsLimit
+	}
+	if limit > maxAgentDocsLimit {
+		limit = maxAgentDocsLimit
+	}
+	// Как и в search_chunks, тег модели только сужает фильтры запроса.
+	var tags []string
+	if tag = strings.TrimSpace(tag); tag != "" {
+		tags = []string{tag}
+	}
+	filters, ok, err := narrowFilters(retrievalFilters(r.settings), tags, nil)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "Документов нет: тег не пересекается с фильтрами запроса.", nil
+	}
+	docs, total, err := r.s.documents.GetFiltered(ctx, limit, r.chatID, r.accessLevel, filters)
+	if err != nil {
+		return "", err
+	}
+	if len(docs) == 0 {
+		return "Документов нет.", nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Документов: %d (показано %d)\n", total, len(docs))
+	for _, doc := range docs {
+		date := doc.CreatedDate
+		if doc.EffectiveDate != nil {
+			date = *doc.EffectiveDate
+		}
+		fmt.Fprintf(&b, "- %s | %s | теги: %s | действует с %s", doc.ID, doc.Name, strings.Join(doc.Tags, ", "), date.Format("2006-01-02"))
+		if doc.ValidUntil != nil {
+			fmt.Fprintf(&b, " по %s", doc.ValidUntil.Format("2006-01-02"))
+		}
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
+
+// addFragments нумерует новые чанки сквозной нумерацией «Фрагмент n» (по ней модель ставит ссылки [n])
+// и возвращает их текст; уже показанные модели чанки повторно не нумеруются.
+func (r *agentRun) addFragments(chunks []models.Chunk) (string, int, error) {
+	var b strings.Builder
+	added := 0
+	for _, ch := range chunks {
+		n, ok := r.seen[ch.ID]
+		if !ok {
+			r.fragments = append(r.fragments, ch)
+			n = len(r.fragments)
+			r.seen[ch.ID] = n
+			added++
+		}
+		label := strings.TrimSpace(ch.DocName)
+		if label == "" {
+			label = strings.TrimSpace(ch.ChunkName)
+		}
+		fmt.Fprintf(&b, "Фрагмент %d [%s] (document_id=%s, chunk_index=%d): %s\n", n, label, ch.DocID, ch.ChunkIndex,
+			utils.TruncateByChars(utils.NormalizeText(ch.Text), agentFragmentMaxRunes))
+	}
+	return b.String(), added, nil
+}
+
+func intersectStrings(a, b []string) []string {
+	set := make(map[string]bool, len(b))
+	for _, v := range b {
+		set[strings.ToLower(v)] = true
+	}
+	var out []string
+	for _, v := range a {
+		if set[strings.ToLower(v)] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func intersectIDs(a, b []uuid.UUID) []uuid.UUID {
+	set := make(map[uuid.UUID]bool, len(b))
+	for _, id := range b {
+		set[id] = true
+	}
+	var out []uuid.UUID
+	for _, id := range a {
+		if set[id] {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// evalExpression вычисляет арифметическое выражение рекурсивным спуском. Пробелы игнорируются
+// ("1 000" — это 1000), запятая считается десятичным разделителем.
+func evalExpression(expr string) (float64, error) {
+	if len([]rune(expr)) > maxCalculatorExprRunes {
+		return 0, fmt.Errorf("expression is longer than %d characters", maxCalculatorExprRunes)
+	}
+	cleaned := strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsSpace(r):
+			return -1
+		case r == ',':
+			return '.'
+		case r == '×':
+			return '*'
+		case r == '÷':
+			return '/'
+		case r == '−':
+			return '-'
+		}
+		return r
+	}, expr)
+	if cleaned == "" {
+		return 0, errors.New("empty expression")
+	}
+	p := &calcParser{src: []rune(cleaned)}
+	value, err := p.expr()
+	if err != nil {
+		return 0, err
+	}
+	if p.pos < len(p.src) {
+		return 0, fmt.Errorf("unexpected %q at position %d", string(p.src[p.pos]), p.pos+1)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, errors.New("result is not a finite number")
+	}
+	return value, nil
+}
+
+type calcParser struct {
+	src   []rune
+	pos   int
+	depth int
+}
+
+func (p *calcParser) peek() rune {
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+// expr := term { ("+" | "-") term }
+func (p *calcParser) expr() (float64, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxCalculatorDepth {
+		return 0, errors.New("expression is nested too deeply")
+	}
+	left, err := p.term()
+	if err != nil {
+		return 0, err
+	}
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+		right, err := p.term()
+		if err != nil {
+			return 0, err
+		}
+		if op == '+' {
+			left += right
+		} else {
+			left -= right
+		}
+	}
+	return left, nil
+}
+
+// term := power { ("*" | "/" | "%") power }
+func (p *calcParser) term() (float64, error) {
+	left, err := p.power()
+	if err != nil {
+		return 0, err
+	}
+	for op := p.peek(); op == '*' || op == '/' || op == '%'; op = p.peek() {
+		p.pos++
+		right, err := p.power()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case '*':
+			left *= right
+		case '/':
+			if right == 0 {
+				return 0, errors.New("division by zero")
+			}
+			left /= right
+		case '%':
+			if right == 0 {
+				return 0, errors.New("division by zero")
+			}
+			left = math.Mod(left, right)
+		}
+	}
+	return left, nil
+}
+
+// power := unary [ "^" power ]
+func (p *calcParser) power() (float64, error) {
+	base, err := p.unary()
+	if err != nil {
+		return 0, err
+	}
+	if p.peek() == '^' {
+		p.pos++
+		exp, err := p.power()
+		if err != nil {
+			return 0, err
+		}
+		return math.Pow(base, exp), nil
+	}
+	return base, nil
+}
+
+// unary := ("-" | "+") unary | primary
+func (p *calcParser) unary() (float64, error) {
+	switch p.peek() {
+	case '-':
+		p.pos++
+		v, err := p.unary()
+		return -v, err
+	case '+':
+		p.pos++
+		return p.unary()
+	}
+	return p.primary()
+}
+
+// primary := number | "(" expr ")" | function "(" expr ")"
+func (p *calcParser) primary() (float64, error) {
+	r := p.peek()
+	switch {
+	case r == '(':
+		p.pos++
+		v, err := p.expr()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, errors.New("missing closing parenthesis")
+		}
+		p.pos++
+		return v, nil
+	case unicode.IsDigit(r) || r == '.':
+		start := p.pos
+		for p.pos < len(p.src) && (unicode.IsDigit(p.src[p.pos]) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		return strconv.ParseFloat(string(p.src[start:p.pos]), 64)
+	case unicode.IsLetter(r):
+		start := p.pos
+		for p.pos < len(p.src) && unicode.IsLetter(p.src[p.pos]) {
+			p.pos++
+		}
+		name := strings.ToLower(string(p.src[start:p.pos]))
+		if p.peek() != '(' {
+			return 0, fmt.Errorf("unknown identifier %q", name)
+		}
+		arg, err := p.primary()
+		if err != nil {
+			return 0, err
+		}
+		switch name {
+		case "sqrt":
+			if arg < 0 {
+				return 0, errors.New("square root of a negative number")
+			}
+			return math.Sqrt(arg), nil
+		case "abs":
+			return math.Abs(arg), nil
+		case "round":
+			return math.Round(arg), nil
+		default:
+			return 0, fmt.Errorf("unknown function %q", name)
+		}
+	case r == 0:
+		return 0, errors.New("unexpected end of expression")
+	default:
+		return 0, fmt.Errorf("unexpected %q at position %d", string(r), p.pos+1)
+	}
+}
+
+type dateMathArgs struct {
+	Operation string `json:"operation"`
+	Date      string `json:"date"`
+	EndDate   string `json:"end_date"`
+	Days      int    `json:"days"`
+	Months    int    `json:"months"`
+	Years     int    `json:"years"`
+}
+
+var weekdaysRu = [...]string{"воскресенье", "понедельник", "вторник", "среда", "четверг", "пятница", "суббота"}
+
+// dateMath выполняет операции с датами; "today" — текущая дата сервера.
+func dateMath(in dateMathArgs, now time.Time) (string, error) {
+	parse := func(raw string) (time.Time, error) {
+		raw = strings.TrimSpace(raw)
+		if raw == "" || strings.EqualFold(raw, "today") {
+			y, m, d := now.Date()
+			return time.Date(y, m, d, 0, 0, 0, 0, time.UTC), nil
+		}
+		t, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", raw)
+		}
+		return t, nil
+	}
+
+	date, err := parse(in.Date)
+	if err != nil {
+		return "", err
+	}
+	switch strings.ToLower(strings.TrimSpace(in.Operation)) {
+	case "add":
+		result := date.AddDate(in.Years, in.Months, in.Days)
+		return fmt.Sprintf("%s (%s)", result.Format("2006-01-02"), weekdaysRu[result.Weekday()]), nil
+	case "diff":
+		if strings.TrimSpace(in.EndDate) == "" {
+			return "", errors.New("end_date is required for diff")
+		}
+		end, err := parse(in.EndDate)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d дней", int(math.Round(end.Sub(date).Hours()/24))), nil
+	case "weekday":
+		return weekdaysRu[date.Weekday()], nil
+	default:
+		return "", fmt.Errorf("unknown operation %q, expected add, diff or weekday", in.Operation)
+	}
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/katakuxiko/Diplom/internal/models"
+)
+
+// ErrAnswerTimeout — истёк общий срок ответа чата (answerTimeoutSeconds).
+// Вместе с ошибкой возвращается частичный ответ, если модель успела его сгенерировать.
+var ErrAnswerTimeout = errors.New("answer deadline exceeded")
+
+// withAnswerDeadline ограничивает весь запрос (поиск и генерацию) сроком из настроек чата.
+// Без настройки срок не задаётся: запрос ограничен только отменой ctx (например, отключением клиента).
+func withAnswerDeadline(ctx context.Context, settings *models.AskSettings) (context.Context, context.CancelFunc) {
+	if settings == nil || settings.AnswerTimeoutSeconds <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(settings.AnswerTimeoutSeconds)*time.Second)
+}
+
+// deadlineExceeded сообщает, что ошибка вызвана истечением срока ответа, а не отменой или сбоем.
+func deadlineExceeded(ctx context.Context, err error) bool {
+	if errors.Is(err, ErrAnswerTimeout) {
+		return true
+	}
+	return errors.Is(ctx.Err(), context.DeadlineExceeded) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// partialAnswer собирает уже сгенерированные части ответа, когда генерация прервана по сроку.
+// Для остальных ошибок возвращает исходную ошибку без текста.
+func partialAnswer(ctx context.Context, parts []string, current string, err error) (string, error) {
+	if !deadlineExceeded(ctx, err) {
+		return "", err
+	}
+	if current = strings.TrimSpace(current); current != "" {
+		parts = append(parts, current)
+	}
+	return strings.TrimSpace(strings.Join(parts, "\n")), fmt.Errorf("%w: %v", ErrAnswerTimeout, err)
+}
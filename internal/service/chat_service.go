@@ -155,6 +155,18 @@ func (s *ChatService) Delete(id string) error {
 		if err := s.db.Where("chat_id = ?", id).Delete(&models.Chunk{}).Error; err != nil {
 			return err
 		}
+		// Удаляем родительские разделы (parent-document retrieval)
+		if err := s.db.Where("chat_id = ?", id).Delete(&models.DocumentSection{}).Error; err != nil {
+			return err
+		}
+		// Удаляем словарь синонимов чата
+		if err := s.db.Where("chat_id = ?", id).Delete(&models.ChatSynonym{}).Error; err != nil {
+			return err
+		}
+		// Удаляем шаблоны промптов чата
+		if err := s.db.Where("chat_id = ?", id).Delete(&models.ChatPromptTemplate{}).Error; err != nil {
+			return err
+		}
 		// Удаляем все документы этого чата
 		if err := s.db.Where("chat_id = ?", id).Delete(&models.Document{}).Error; err != nil {
 			return err
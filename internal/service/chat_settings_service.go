@@ -2,10 +2,12 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 
 	"github.com/google/uuid"
 	"github.com/katakuxiko/Diplom/internal/models"
 	"github.com/katakuxiko/Diplom/internal/repository"
+	"github.com/katakuxiko/Diplom/internal/utils"
 )
 
 type ChatSettingsService struct {
@@ -32,6 +34,33 @@ func (s *ChatSettingsService) GetByChatID(ctx context.Context, chatID uuid.UUID)
 	return s.Repo.GetChatSettingsByChatID(ctx, chatID)
 }
 
+// AskSettings читает настройки чата и расшифровывает сохранённые ключи.
+// Возвращает nil, если настроек нет — тогда используются значения по умолчанию.
+func (s *ChatSettingsService) AskSettings(ctx context.Context, chatID uuid.UUID) *models.AskSettings {
+	cs, err := s.GetByChatID(ctx, chatID)
+	if err != nil || cs == nil || cs.Settings == nil {
+		return nil
+	}
+	// marshal JSONB -> bytes -> AskSettings
+	raw, _ := json.Marshal(cs.Settings)
+	var dbSettings models.AskSettings
+	if err := json.Unmarshal(raw, &dbSettings); err != nil {
+		return nil
+	}
+	// Попробуем дешифровать ключи, если они были сохранены зашифрованными
+	if dbSettings.ExternalAPIKey != "" {
+		if dec, derr := utils.DecryptString(dbSettings.ExternalAPIKey); derr == nil {
+			dbSettings.ExternalAPIKey = dec
+		}
+	}
+	if dbSettings.EmbedExternalAPIKey != "" {
+		if dec, derr := utils.DecryptString(dbSettings.EmbedExternalAPIKey); derr == nil {
+			dbSettings.EmbedExternalAPIKey = dec
+		}
+	}
+	return &dbSettings
+}
+
 // Update обновляет настройки чата
 func (s *ChatSettingsService) Update(ctx context.Context, settings *models.ChatSetting) error {
 	return s.Repo.UpdateChatSettings(ctx, settings)
@@ -1,6 +1,8 @@
 package service
 
 import (
+	"context"
+
 	"github.com/google/uuid"
 	"github.com/katakuxiko/Diplom/internal/models"
 	"github.com/katakuxiko/Diplom/internal/repository"
@@ -22,10 +24,15 @@ func (s *ChunkService) SaveChunk(c models.Chunk, embedding []float32) error {
 	return s.repo.Add(c)
 }
 
-func (s *ChunkService) SearchSimilar(vec []float32, limit int, chatID uuid.UUID, accessLevel int) ([]models.Chunk, error) {
-	return s.repo.SearchByVector(pgvector.NewVector(vec), limit, chatID, accessLevel)
+// SaveSection сохраняет родительский раздел документа; ID заполняется после вставки.
+func (s *ChunkService) SaveSection(section *models.DocumentSection) error {
+	return s.repo.AddSection(section)
+}
+
+func (s *ChunkService) SearchSimilar(ctx context.Context, vec []float32, limit int, chatID uuid.UUID, accessLevel int) ([]models.Chunk, error) {
+	return s.repo.SearchByVector(ctx, pgvector.NewVector(vec), limit, chatID, accessLevel, nil, repository.VectorSearchParams{})
 }
 
-func (s *ChunkService) SearchByKeyword(query string, limit int, chatID uuid.UUID, accessLevel int) ([]models.Chunk, error) {
-	return s.repo.SearchByKeyword(query, limit, chatID, accessLevel)
+func (s *ChunkService) SearchByKeyword(ctx context.Context, query string, limit int, chatID uuid.UUID, accessLevel int) ([]models.Chunk, error) {
+	return s.repo.SearchByKeyword(ctx, query, limit, chatID, accessLevel, nil, nil)
 }
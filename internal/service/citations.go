@@ -0,0 +1,162 @@
+package service
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/katakuxiko/Diplom/internal/models"
+	"github.com/katakuxiko/Diplom/internal/utils"
+)
+
+const (
+	CitationModeOff   = "off"
+	CitationModeStrip = "strip" // несуществующие ссылки удаляются из ответа
+	CitationModeFlag  = "flag"  // несуществующие ссылки остаются, в citations помечены valid=false
+
+	maxCitationQuoteRunes = 300
+	maxCitationClaimRunes = 300
+
+	citationInstruction = `ССЫЛКИ НА ИСТОЧНИКИ:
+- После каждого утверждения указывай в квадратных скобках номер фрагмента КОНТЕКСТА, на котором оно основано: [1], [2] или [1, 3]
+- Используй только номера фрагментов из КОНТЕКСТА и не придумывай новые`
+)
+
+// citationMarkerPattern — ссылка [n] или [n, m] с необязательным пробелом перед ней.
+var citationMarkerPattern = regexp.MustCompile(`\s?\[(\d{1,3}(?:\s*[,;]\s*\d{1,3})*)\]`)
+
+// Citation — ссылка [n] из ответа на фрагмент контекста. Место в документе задаётся номером чанка
+// и страницей PDF, с которой он начинается.
+type Citation struct {
+	Marker     int        `json:"marker"` // номер фрагмента, как в ответе
+	Valid      bool       `json:"valid"`
+	ChunkID    *uuid.UUID `json:"chunk_id,omitempty"`
+	DocID      *uuid.UUID `json:"doc_id,omitempty"`
+	DocName    string     `json:"doc_name,omitempty"`
+	ChunkIndex int        `json:"chunk_index"`
+	Page       int        `json:"page,omitempty"`  // страница PDF начала фрагмента; нет, если номер неизвестен
+	Quote      string     `json:"quote,omitempty"` // предложение фрагмента, ближайшее к утверждению
+	Claim      string     `json:"claim,omitempty"` // утверждение ответа перед ссылкой
+	Offset     int        `json:"offset"`          // позиция ссылки в ответе, в символах
+}
+
+// resolveCitationMode возвращает режим ссылок чата; по умолчанию ссылки выключены.
+// С responseSchema ссылки тоже выключены: маркеры [n] внутри JSON ломают его разбор и проверку по схеме,
+// поэтому ссылки на фрагменты в таком ответе задаются полем схемы.
+func resolveCitationMode(settings *models.AskSettings) string {
+	if settings == nil || responseSchema(settings) != nil {
+		return CitationModeOff
+	}
+	switch mode := strings.ToLower(strings.TrimSpace(settings.CitationMode)); mode {
+	case CitationModeStrip, CitationModeFlag:
+		return mode
+	default:
+		return CitationModeOff
+	}
+}
+
+// parseCitationNumbers разбирает содержимое скобок "1, 3" в номера.
+func parseCitationNumbers(group string) []int {
+	fields := strings.FieldsFunc(group, func(r rune) bool { return r == ',' || r == ';' || r == ' ' })
+	numbers := make([]int, 0, len(fields))
+	for _, f := range fields {
+		if n, err := strconv.Atoi(f); err == nil {
+			numbers = append(numbers, n)
+		}
+	}
+	return numbers
+}
+
+// citeAnswer применяет режим ссылок чата к ответу и записывает ссылки в диагностику.
+func citeAnswer(answer string, fragments []models.Chunk, settings *models.AskSettings, diagnostics *RetrievalDiagnostics) string {
+	mode := resolveCitationMode(settings)
+	if mode == CitationModeOff || answer == "" {
+		return answer
+	}
+	diagnostics.CitationMode = mode
+	answer, diagnostics.Citations, diagnostics.CitationsInvalid = applyCitations(answer, fragments, mode)
+	return answer
+}
+
+// applyCitations проверяет ссылки [n] ответа по фрагментам контекста (нумерация с 1).
+// В режиме strip несуществующие номера удаляются из ответа. Возвращает итоговый ответ,
+// ссылки в порядке появления и число несуществующих номеров в исходном ответе.
+func applyCitations(answer string, fragments []models.Chunk, mode string) (string, []Citation, int) {
+	valid := func(n int) bool { return n >= 1 && n <= len(fragments) }
+
+	invalid := 0
+	for _, m := range citationMarkerPattern.FindAllStringSubmatch(answer, -1) {
+		for _, n := range parseCitationNumbers(m[1]) {
+			if !valid(n) {
+				invalid++
+			}
+		}
+	}
+
+	if mode == CitationModeStrip && invalid > 0 {
+		answer = citationMarkerPattern.ReplaceAllStringFunc(answer, func(marker string) string {
+			sub := citationMarkerPattern.FindStringSubmatch(marker)
+			kept := make([]string, 0, 2)
+			for _, n := range parseCitationNumbers(sub[1]) {
+				if valid(n) {
+					kept = append(kept, strconv.Itoa(n))
+				}
+			}
+			if len(kept) == 0 {
+				return ""
+			}
+			prefix := marker[:strings.Index(marker, "[")]
+			return prefix + "[" + strings.Join(kept, ", ") + "]"
+		})
+	}
+
+	var citations []Citation
+	for _, loc := range citationMarkerPattern.FindAllStringSubmatchIndex(answer, -1) {
+		claim := citationClaim(answer, loc[0])
+		offset := utf8.RuneCountInString(answer[:loc[0]])
+		for _, n := range parseCitationNumbers(answer[loc[2]:loc[3]]) {
+			citation := Citation{Marker: n, Valid: valid(n), Claim: claim, Offset: offset}
+			if citation.Valid {
+				fragment := fragments[n-1]
+				if fragment.ID != uuid.Nil {
+					id := fragment.ID
+					citation.ChunkID = &id
+				}
+				if fragment.DocID != uuid.Nil {
+					docID := fragment.DocID
+					citation.DocID = &docID
+				}
+				citation.DocName = fragment.DocName
+				citation.ChunkIndex = fragment.ChunkIndex
+				citation.Page = fragment.Page
+				citation.Quote = citationQuote(claim, fragment.Text)
+			}
+			citations = append(citations, citation)
+		}
+	}
+	return answer, citations, invalid
+}
+
+// citationClaim — текст ответа от начала предложения до ссылки, без других ссылок.
+func citationClaim(answer string, markerStart int) string {
+	before := strings.TrimRight(citationMarkerPattern.ReplaceAllString(answer[:markerStart], ""), " ")
+	before = strings.TrimRight(before, ".!?")
+	if i := strings.LastIndexAny(before, ".!?\n"); i >= 0 {
+		before = before[i+1:]
+	}
+	return utils.TruncateByChars(strings.TrimSpace(before), maxCitationClaimRunes)
+}
+
+// citationQuote выбирает предложение фрагмента с наибольшим лексическим совпадением с утверждением.
+func citationQuote(claim, text string) string {
+	var best string
+	bestScore := float32(-1)
+	for _, sentence := range splitSentences(utils.NormalizeText(text)) {
+		if score := lexicalOverlapScore(claim, sentence.text); score > bestScore {
+			best, bestScore = sentence.text, score
+		}
+	}
+	return utils.TruncateByChars(best, maxCitationQuoteRunes)
+}
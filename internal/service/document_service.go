@@ -1,6 +1,7 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"mime/multipart"
 	"sort"
@@ -128,6 +129,31 @@ func (s *DocumentService) UpdateTags(id uuid.UUID, tags []string) (*models.Docum
 	return doc, nil
 }
 
+var ErrInvalidValidityPeriod = errors.New("valid_until must be after effective_date")
+
+// UpdateValidity задаёт период действия документа; nil очищает соответствующую дату.
+func (s *DocumentService) UpdateValidity(id uuid.UUID, effectiveDate, validUntil *time.Time) (*models.Document, error) {
+	doc, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	start := doc.CreatedDate
+	if effectiveDate != nil {
+		start = *effectiveDate
+	}
+	if validUntil != nil && !validUntil.After(start) {
+		return nil, ErrInvalidValidityPeriod
+	}
+
+	doc.EffectiveDate = effectiveDate
+	doc.ValidUntil = validUntil
+	if err := s.repo.Update(doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
 func (s *DocumentService) GetDocumentTags(chatID uuid.UUID, maxAccessLevel int) ([]string, error) {
 	tags, err := s.repo.GetDistinctTags(chatID, maxAccessLevel)
 	if err != nil {
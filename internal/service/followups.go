@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/katakuxiko/Diplom/internal/models"
+	"github.com/katakuxiko/Diplom/internal/utils"
+)
+
+const (
+	defaultFollowUpCount     = 3
+	minFollowUpCount         = 2
+	maxFollowUpCount         = 4
+	followUpCandidatesFactor = 2 // кандидатов просим больше: часть отсеется проверкой поиском
+	followUpSupportTopK      = 3
+	minFollowUpSupport       = float32(0.5)  // доля терминов вопроса, найденных в лучшем чанке поиска
+	usedChunkOverlap         = float32(0.35) // доля терминов чанка, попавших в ответ, — чанк считается использованным
+	maxFollowUpSourceChunks  = 5
+	followUpChunkMaxRunes    = 600
+	followUpAnswerMaxRunes   = 1500
+	followUpTopicDocs        = 20
+	maxFollowUpTokens        = 64
+	followUpPrompt           = "You suggest follow-up questions a user may ask next about their documents. Base every question on the given document fragments and topics: it must be answerable from them. Do not repeat the original question or anything the answer already covers. Keep the language of the user's question. Return exactly the requested number of short questions, one per line, without numbering or explanations."
+)
+
+// FollowUpQuestion — предлагаемый следующий вопрос; Support — доля его терминов в лучшем найденном чанке.
+type FollowUpQuestion struct {
+	Question  string   `json:"question"`
+	Support   float32  `json:"support"`
+	Documents []string `json:"documents,omitempty"`
+}
+
+func resolveFollowUpCount(settings *models.AskSettings) int {
+	if settings == nil || settings.FollowUpCount == 0 {
+		return defaultFollowUpCount
+	}
+	if settings.FollowUpCount < minFollowUpCount {
+		return minFollowUpCount
+	}
+	if settings.FollowUpCount > maxFollowUpCount {
+		return maxFollowUpCount
+	}
+	return settings.FollowUpCount
+}
+
+// SuggestFollowUps предлагает 2–4 следующих вопроса по найденным, но не использованным в ответе чанкам
+// и темам документов чата. Остаются только вопросы, по которым поиск находит подходящий чанк.
+// Без suggestFollowUps или без источников ответа возвращает nil.
+func (s *RAGService) SuggestFollowUps(ctx context.Context, query, answer string, chunks []models.Chunk, diagnostics RetrievalDiagnostics, chatID uuid.UUID, settings *models.AskSettings, accessLevel int) ([]FollowUpQuestion, error) {
+	if settings == nil || !settings.SuggestFollowUps || len(chunks) == 0 || strings.TrimSpace(answer) == "" {
+		return nil, nil
+	}
+	if s.llm == nil {
+		return nil, fmt.Errorf("llm client is nil")
+	}
+	// Без стриминга отключение клиента не отменяет ctx, поэтому подбор ограничен тем же сроком, что и ответ.
+	ctx, cancel := withAnswerDeadline(ctx, settings)
+	defer cancel()
+	count := resolveFollowUpCount(settings)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Number of questions: %d\nUser question: %s\nAnswer: %s\n", count*followUpCandidatesFactor,
+		strings.TrimSpace(query), utils.TruncateByChars(strings.TrimSpace(answer), followUpAnswerMaxRunes))
+	if topics := s.followUpTopics(ctx, chatID, accessLevel, settings); topics != "" {
+		b.WriteString("Document topics: " + topics + "\n")
+	}
+	b.WriteString("Fragments:\n")
+	for _, ch := range followUpSourceChunks(answer, chunks, diagnostics.Citations) {
+		fmt.Fprintf(&b, "- [%s] %s\n", strings.TrimSpace(ch.DocName), utils.TruncateByChars(utils.NormalizeText(ch.Text), followUpChunkMaxRunes))
+	}
+
+	raw, err := s.llm.utilityCompletion(ctx, settings, followUpPrompt, b.String(), maxFollowUpTokens*count*followUpCandidatesFactor, 0.5)
+	if err != nil {
+		return nil, err
+	}
+
+	// Проверка поиском — одиночный запрос без переводов и explain, чтобы не тратить вызовы LLM.
+	supportSettings := *settings
+	supportSettings.QueryStrategy = "single"
+	supportSettings.CrossLingualMode = "off"
+	supportSettings.Explain = false
+
+	suggestions := make([]FollowUpQuestion, 0, count)
+	seen := map[string]bool{strings.ToLower(strings.TrimSpace(query)): true}
+	for _, line := range strings.Split(raw, "\n") {
+		question := strings.Trim(strings.TrimSpace(listMarkerPattern.ReplaceAllString(line, "")), "\"'«»")
+		key := strings.ToLower(question)
+		if len(queryTerms(question)) < 2 || seen[key] || sameNormalizedQuery(query, question) {
+			continue
+		}
+		seen[key] = true
+
+		var scratch RetrievalDiagnostics
+		found, err := s.retrieveChunksForQuery(ctx, question, followUpSupportTopK, chatID, &supportSettings, accessLevel, &scratch)
+		if err != nil {
+			if ctx.Err() != nil {
+				return suggestions, ctx.Err()
+			}
+			continue
+		}
+		suggestion := FollowUpQuestion{Question: question}
+		for _, ch := range found {
+			score := lexicalOverlapScore(question, ch.DocName+" "+ch.Text)
+			if score > suggestion.Support {
+				suggestion.Support = score
+			}
+			if score >= minFollowUpSupport && !containsString(suggestion.Documents, ch.DocName) {
+				suggestion.Documents = append(suggestion.Documents, ch.DocName)
+			}
+		}
+		if suggestion.Support < minFollowUpSupport {
+			continue
+		}
+		suggestions = append(suggestions, suggestion)
+		if len(suggestions) == count {
+			break
+		}
+	}
+	return suggestions, nil
+}
+
+// followUpSourceChunks — чанки для новых вопросов: сначала не процитированные и мало использованные в ответе,
+// затем остальные, если неиспользованных не хватает.
+func followUpSourceChunks(answer string, chunks []models.Chunk, citations []Citation) []models.Chunk {
+	cited := make(map[uuid.UUID]bool, len(citations))
+	for _, c := range citations {
+		if c.Valid && c.ChunkID != nil {
+			cited[*c.ChunkID] = true
+		}
+	}
+	unused := make([]models.Chunk, 0, len(chunks))
+	var used []models.Chunk
+	for _, ch := range chunks {
+		if cited[ch.ID] || lexicalOverlapScore(ch.Text, answer) >= usedChunkOverlap {
+			used = append(used, ch)
+			continue
+		}
+		unused = append(unused, ch)
+	}
+	sources := append(unused, used...)
+	if len(sources) > maxFollowUpSourceChunks {
+		sources = sources[:maxFollowUpSourceChunks]
+	}
+	return sources
+}
+
+// followUpTopics — названия и теги документов чата, доступных пользователю и попадающих под фильтры
+// запроса: темы документов вне фильтров породили бы вопросы, на которые поиск не ответит.
+func (s *RAGService) followUpTopics(ctx context.Context, chatID uuid.UUID, accessLevel int, settings *models.AskSettings) string {
+	if s.documents == nil {
+		return ""
+	}
+	docs, _, err := s.documents.GetFiltered(ctx, followUpTopicDocs, chatID, accessLevel, retrievalFilters(settings))
+	if err != nil {
+		return ""
+	}
+	topics := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		topic := strings.TrimSpace(doc.Name)
+		if len(doc.Tags) > 0 {
+			topic += " (" + strings.Join(doc.Tags, ", ") + ")"
+		}
+		if topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+	return strings.Join(topics, "; ")
+}
@@ -0,0 +1,319 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/katakuxiko/Diplom/internal/models"
+	"github.com/katakuxiko/Diplom/internal/utils"
+)
+
+const (
+	GroundingCheckOff       = "off"
+	GroundingCheckEmbedding = "embedding"
+	GroundingCheckLLM       = "llm"
+	GroundingCheckBoth      = "both" // LLM перепроверяет предложения, которые не подтвердились по эмбеддингам
+
+	GroundingPolicyAnnotate   = "annotate"   // ответ не меняется, неподтверждённые предложения — в grounding
+	GroundingPolicyRegenerate = "regenerate" // одна повторная генерация с перечнем неподтверждённых утверждений
+	GroundingPolicyRefuse     = "refuse"     // ответ заменяется стандартным отказом
+
+	defaultGroundingMinScore   = 0.7
+	defaultGroundingSimilarity = 0.6
+	minGroundingSentenceTerms  = 3  // короче — связки вроде "Да." или "Подробнее ниже", их не проверяем
+	maxGroundingSentences      = 40 // больше в один запрос проверки не отправляем
+	groundingFragmentMaxRunes  = 4000
+	groundingVerdictTokens     = 8
+
+	groundingEntailmentPrompt = `Ты проверяешь, подтверждается ли ответ контекстом документов.
+Для каждого пронумерованного утверждения определи, следует ли оно из КОНТЕКСТА.
+Ответь только списком строк вида "1: да" или "1: нет", по одной на утверждение, без пояснений.`
+
+	groundingRegenerateNote = `ВНИМАНИЕ: в предыдущем варианте ответа были утверждения, которых нет в контексте:
+%s
+Ответь заново строго по фрагментам контекста. Если сведений в контексте нет, так и скажи.`
+)
+
+var groundingVerdictPattern = regexp.MustCompile(`(?im)^[^\p{L}\d]*(\d+)[^\p{L}\d]*(да|нет|yes|no)`)
+
+// SentenceSupport — результат проверки одного предложения ответа.
+type SentenceSupport struct {
+	Sentence   string  `json:"sentence"`
+	Offset     int     `json:"offset"`               // позиция предложения в ответе, в символах
+	Similarity float32 `json:"similarity,omitempty"` // лучшая cosine similarity с фрагментом контекста
+	Fragment   int     `json:"fragment,omitempty"`   // номер ближайшего фрагмента (с 1)
+	Entailed   *bool   `json:"entailed,omitempty"`   // вердикт LLM, если предложение проверялось моделью
+}
+
+// GroundingReport — насколько ответ подтверждается найденными фрагментами и что с ним сделано.
+type GroundingReport struct {
+	Method      string            `json:"method"`
+	Score       float32           `json:"score"` // доля подтверждённых предложений (1 — подтверждено всё)
+	MinScore    float32           `json:"min_score"`
+	Checked     int               `json:"checked"` // сколько предложений проверено
+	Unsupported []SentenceSupport `json:"unsupported,omitempty"`
+	Policy      string            `json:"policy"`
+	Action      string            `json:"action"`                // "none", "annotated", "regenerated" или "refused"
+	FirstScore  *float32          `json:"first_score,omitempty"` // оценка исходного ответа, если он перегенерирован
+	Error       string            `json:"error,omitempty"`       // проверка не выполнена, ответ оставлен как есть
+}
+
+// textSentence — предложение и его позиция в тексте в символах.
+type textSentence struct {
+	text   string
+	offset int
+}
+
+// splitSentences делит текст на предложения: граница — ".", "!" или "?" перед пробелом либо перенос строки.
+// Точка внутри числа ("3.5") или сокращения без пробела границей не считается.
+func splitSentences(text string) []textSentence {
+	var out []textSentence
+	runes := []rune(text)
+	start := 0
+	flush := func(end int) {
+		raw := string(runes[start:end])
+		trimmed := strings.TrimSpace(raw)
+		if trimmed != "" {
+			lead := utf8.RuneCountInString(raw) - utf8.RuneCountInString(strings.TrimLeftFunc(raw, unicode.IsSpace))
+			out = append(out, textSentence{text: trimmed, offset: start + lead})
+		}
+		start = end
+	}
+	for i, r := range runes {
+		switch {
+		case r == '\n':
+			flush(i + 1)
+		case (r == '.' || r == '!' || r == '?') && (i+1 == len(runes) || unicode.IsSpace(runes[i+1])):
+			flush(i + 1)
+		}
+	}
+	if start < len(runes) {
+		flush(len(runes))
+	}
+	return out
+}
+
+// resolveGroundingCheck возвращает способ проверки ответа; по умолчанию проверка выключена.
+func resolveGroundingCheck(settings *models.AskSettings) string {
+	if settings == nil {
+		return GroundingCheckOff
+	}
+	switch check := strings.ToLower(strings.TrimSpace(settings.GroundingCheck)); check {
+	case GroundingCheckEmbedding, GroundingCheckLLM, GroundingCheckBoth:
+		return check
+	default:
+		return GroundingCheckOff
+	}
+}
+
+// groundingWithholdsStream — фрагменты ответа нельзя отдавать по мере генерации: проверка включена,
+// а политика может заменить ответ. Ответ по схеме проверяется схемой, а не по источникам.
+func groundingWithholdsStream(settings *models.AskSettings) bool {
+	if resolveGroundingCheck(settings) == GroundingCheckOff || responseSchema(settings) != nil {
+		return false
+	}
+	policy, _, _ := resolveGroundingSettings(settings)
+	return policy != GroundingPolicyAnnotate
+}
+
+// resolveGroundingSettings — политика, минимальная доля подтверждённых предложений и порог similarity.
+func resolveGroundingSettings(settings *models.AskSettings) (string, float32, float32) {
+	policy := GroundingPolicyAnnotate
+	minScore := float32(defaultGroundingMinScore)
+	similarity := float32(defaultGroundingSimilarity)
+	if settings == nil {
+		return policy, minScore, similarity
+	}
+	switch p := strings.ToLower(strings.TrimSpace(settings.GroundingPolicy)); p {
+	case GroundingPolicyRegenerate, GroundingPolicyRefuse:
+		policy = p
+	}
+	if settings.GroundingMinScore > 0 && settings.GroundingMinScore <= 1 {
+		minScore = settings.GroundingMinScore
+	}
+	if settings.GroundingSimilarity > 0 && settings.GroundingSimilarity <= 1 {
+		similarity = settings.GroundingSimilarity
+	}
+	return policy, minScore, similarity
+}
+
+// enforceGrounding проверяет ответ по фрагментам контекста и применяет политику чата, если доля
+// подтверждённых предложений ниже groundingMinScore. Ошибка проверки не мешает ответу: он отдаётся как есть.
+func (s *RAGService) enforceGrounding(ctx context.Context, query, answer string, answerCtx answerContext, settings *models.AskSettings, history []models.ChatContextMessage, diagnostics *RetrievalDiagnostics) string {
+	policy, minScore, _ := resolveGroundingSettings(settings)
+	report, err := s.verifyGrounding(ctx, answer, answerCtx, settings)
+	report.Policy = policy
+	report.MinScore = minScore
+	report.Action = "none"
+	diagnostics.Grounding = report
+	if err != nil {
+		log.Printf("grounding check failed: %v", err)
+		report.Error = err.Error()
+		return answer
+	}
+	if report.Score >= minScore {
+		return answer
+	}
+
+	switch policy {
+	case GroundingPolicyRefuse:
+		report.Action = "refused"
+		diagnostics.Citations = nil
+		return s.refusalReply(query, settings)
+	case GroundingPolicyRegenerate:
+		if s.llm == nil {
+			break
+		}
+		claims := make([]string, 0, len(report.Unsupported))
+		for _, u := range report.Unsupported {
+			claims = append(claims, "- "+u.Sentence)
+		}
+		retryContext := answerCtx.text + "\n" + fmt.Sprintf(groundingRegenerateNote, strings.Join(claims, "\n"))
+		// Повторный ответ не стримится: клиент получает его в done.
+		regenerated, usage, genErr := s.llm.AskWithSettings(ctx, query, retryContext, settings, history)
+		if usage != nil {
+			diagnostics.Provider = usage
+		}
+		if genErr != nil || strings.TrimSpace(regenerated) == "" {
+			log.Printf("grounding regeneration failed: %v", genErr)
+			break
+		}
+		regenerated = citeAnswer(regenerated, answerCtx.fragments, settings, diagnostics)
+		retry, verifyErr := s.verifyGrounding(ctx, regenerated, answerCtx, settings)
+		firstScore := report.Score
+		retry.Policy, retry.MinScore, retry.Action, retry.FirstScore = policy, minScore, "regenerated", &firstScore
+		if verifyErr != nil {
+			retry.Error = verifyErr.Error()
+		}
+		diagnostics.Grounding = retry
+		return regenerated
+	}
+	report.Action = "annotated"
+	return answer
+}
+
+// verifyGrounding проверяет каждое содержательное предложение ответа: по эмбеддингам (ближайший фрагмент
+// не ниже groundingSimilarity) и/или вердиктом LLM, следует ли предложение из контекста.
+func (s *RAGService) verifyGrounding(ctx context.Context, answer string, answerCtx answerContext, settings *models.AskSettings) (*GroundingReport, error) {
+	method := resolveGroundingCheck(settings)
+	report := &GroundingReport{Method: method, Score: 1}
+
+	var sentences []SentenceSupport
+	for _, sent := range splitSentences(answer) {
+		text := strings.TrimSpace(citationMarkerPattern.ReplaceAllString(sent.text, ""))
+		if len(queryTerms(text)) < minGroundingSentenceTerms {
+			continue
+		}
+		sentences = append(sentences, SentenceSupport{Sentence: text, Offset: sent.offset})
+		if len(sentences) == maxGroundingSentences {
+			break
+		}
+	}
+	report.Checked = len(sentences)
+	if len(sentences) == 0 || len(answerCtx.fragments) == 0 {
+		return report, nil
+	}
+	if s.llm == nil {
+		return report, fmt.Errorf("llm client is nil")
+	}
+
+	supported := make([]bool, len(sentences))
+	if method == GroundingCheckEmbedding || method == GroundingCheckBoth {
+		if err := s.groundByEmbedding(ctx, sentences, supported, answerCtx.fragments, settings); err != nil {
+			return report, err
+		}
+	}
+	if method == GroundingCheckLLM || method == GroundingCheckBoth {
+		if err := s.groundByEntailment(ctx, sentences, supported, answerCtx.text, settings); err != nil {
+			return report, err
+		}
+	}
+
+	ok := 0
+	for i, sent := range sentences {
+		if supported[i] {
+			ok++
+		} else {
+			report.Unsupported = append(report.Unsupported, sent)
+		}
+	}
+	report.Score = float32(ok) / float32(len(sentences))
+	return report, nil
+}
+
+// groundByEmbedding отмечает предложения, близкие к одному из фрагментов. Предложения и фрагменты
+// эмбеддятся одним запросом той же моделью: эмбеддинги чанков в БД могут быть посчитаны другой.
+func (s *RAGService) groundByEmbedding(ctx context.Context, sentences []SentenceSupport, supported []bool, fragments []models.Chunk, settings *models.AskSettings) error {
+	_, _, threshold := resolveGroundingSettings(settings)
+	texts := make([]string, 0, len(sentences)+len(fragments))
+	for _, sent := range sentences {
+		texts = append(texts, sent.Sentence)
+	}
+	for _, fragment := range fragments {
+		texts = append(texts, utils.TruncateByChars(utils.NormalizeText(fragment.Text), groundingFragmentMaxRunes))
+	}
+	vectors, err := s.llm.EmbeddingsWithSettings(ctx, texts, settings)
+	if err != nil {
+		return fmt.Errorf("grounding embeddings: %w", err)
+	}
+
+	fragmentVectors := vectors[len(sentences):]
+	for i := range sentences {
+		for j, fv := range fragmentVectors {
+			if len(fv) != len(vectors[i]) {
+				continue
+			}
+			if sim := cosineSimilarity(vectors[i], fv); sim > sentences[i].Similarity {
+				sentences[i].Similarity = sim
+				sentences[i].Fragment = j + 1
+			}
+		}
+		supported[i] = sentences[i].Similarity >= threshold
+	}
+	return nil
+}
+
+// groundByEntailment спрашивает модель, следуют ли из контекста предложения, ещё не подтверждённые.
+// Предложение без разборчивого вердикта считается подтверждённым, чтобы сбой формата не приводил к отказу.
+func (s *RAGService) groundByEntailment(ctx context.Context, sentences []SentenceSupport, supported []bool, contextText string, settings *models.AskSettings) error {
+	var pending []int
+	var claims strings.Builder
+	for i, sent := range sentences {
+		if supported[i] {
+			continue
+		}
+		pending = append(pending, i)
+		fmt.Fprintf(&claims, "%d. %s\n", len(pending), sent.Sentence)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	content := "КОНТЕКСТ:\n" + contextText + "\n\nУТВЕРЖДЕНИЯ:\n" + claims.String()
+	reply, err := s.llm.utilityCompletion(ctx, settings, groundingEntailmentPrompt, content, groundingVerdictTokens*len(pending)+16, 0)
+	if err != nil {
+		return fmt.Errorf("grounding entailment: %w", err)
+	}
+
+	verdicts := make(map[int]bool, len(pending))
+	for _, m := range groundingVerdictPattern.FindAllStringSubmatch(reply, -1) {
+		n, _ := strconv.Atoi(m[1])
+		verdict := strings.ToLower(m[2])
+		verdicts[n] = verdict == "да" || verdict == "yes"
+	}
+	for n, i := range pending {
+		entailed, ok := verdicts[n+1]
+		if !ok {
+			entailed = true
+		}
+		sentences[i].Entailed = &entailed
+		supported[i] = entailed
+	}
+	return nil
+}
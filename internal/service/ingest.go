@@ -0,0 +1,107 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/katakuxiko/Diplom/internal/models"
+	"github.com/katakuxiko/Diplom/internal/pdf"
+	"github.com/katakuxiko/Diplom/internal/utils"
+)
+
+const (
+	// Размеры чанков для обычного режима поиска.
+	DefaultChunkWords   = 220
+	DefaultChunkOverlap = 40
+)
+
+// ErrNoChunks — из текста документа не получилось ни одного чанка.
+var ErrNoChunks = errors.New("no chunks created")
+
+// IngestTarget — документ, чанки которого сохраняются.
+type IngestTarget struct {
+	DocID    uuid.UUID
+	ChatID   uuid.UUID
+	DocName  string
+	Filepath string
+}
+
+// IngestPages дробит текст документа и сохраняет чанки с эмбеддингами. В режиме parent-document retrieval
+// сохраняются крупные разделы и мелкие дочерние чанки, иначе — чанки по предложениям. Разбивка идёт по
+// всему тексту, а у каждого чанка запоминается страница, с которой он начинается.
+// Ошибки отдельных чанков пишутся в лог и не прерывают загрузку; total — сколько чанков получилось, saved — сколько сохранено.
+func (s *ChunkService) IngestPages(target IngestTarget, pages []pdf.Page, settings *models.AskSettings, embed func(string) ([]float32, error)) (total, saved int, err error) {
+	txt := pdf.JoinPages(pages)
+	locator := pdf.NewPageLocator(pages)
+	saveChunk := func(ch models.Chunk) {
+		emb, err := embed(ch.Text)
+		if err != nil {
+			log.Printf("embedding error (%s): %v", ch.ChunkName, err)
+			return
+		}
+		if err := s.SaveChunk(ch, emb); err != nil {
+			log.Printf("db insert error (%s): %v", ch.ChunkName, err)
+			return
+		}
+		saved++
+	}
+	newChunk := func(text string, index int) models.Chunk {
+		lang := utils.DetectLanguage(text)
+		if lang == "" {
+			lang = utils.LangUndetermined
+		}
+		return models.Chunk{
+			Text:       text,
+			Filepath:   target.Filepath,
+			DocName:    target.DocName,
+			ChunkName:  fmt.Sprintf("%s_chunk_%d", target.DocName, index),
+			ChunkIndex: index,
+			Page:       locator.Locate(text),
+			Language:   lang,
+			DocID:      target.DocID,
+			ChatID:     target.ChatID,
+		}
+	}
+
+	if IsParentRetrieval(settings) {
+		sections := pdf.ChunkSections(txt, ParentSectionWords, ChildChunkWords, ChildChunkOverlap)
+		if len(sections) == 0 {
+			return 0, 0, ErrNoChunks
+		}
+		chunkIndex := 0
+		for si, section := range sections {
+			total += len(section.Children)
+			sec := models.DocumentSection{
+				DocID:        target.DocID,
+				ChatID:       target.ChatID,
+				DocName:      target.DocName,
+				SectionIndex: si,
+				Text:         section.Text,
+			}
+			if err := s.SaveSection(&sec); err != nil {
+				log.Printf("db insert error (%s_section_%d): %v", target.DocName, si, err)
+				chunkIndex += len(section.Children)
+				continue
+			}
+			parentID := sec.ID
+			for _, p := range section.Children {
+				ch := newChunk(p, chunkIndex)
+				ch.ParentID = &parentID
+				chunkIndex++
+				saveChunk(ch)
+			}
+		}
+		return total, saved, nil
+	}
+
+	parts := pdf.ChunkBySentences(txt, DefaultChunkWords, DefaultChunkOverlap)
+	if len(parts) == 0 {
+		return 0, 0, ErrNoChunks
+	}
+	for i, p := range parts {
+		saveChunk(newChunk(p, i))
+	}
+	return len(parts), saved, nil
+}
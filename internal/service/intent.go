@@ -0,0 +1,331 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/katakuxiko/Diplom/internal/models"
+)
+
+// Намерения сообщения пользователя. На document_question отвечает поиск по документам,
+// на остальные — заготовленный ответ чата без поиска и генерации.
+const (
+	IntentGreeting         = "greeting"
+	IntentThanks           = "thanks"
+	IntentSmalltalk        = "smalltalk"
+	IntentHelp             = "help"
+	IntentOutOfScope       = "out_of_scope"
+	IntentDocumentQuestion = "document_question"
+)
+
+// Классификатор намерений (AskSettings.IntentClassifier). Правила работают всегда, кроме "off";
+// llm и embedding уточняют только сообщения, которые правила отнесли к вопросам по документам.
+const (
+	IntentClassifierRules     = "rules"
+	IntentClassifierLLM       = "llm"
+	IntentClassifierEmbedding = "embedding"
+	IntentClassifierOff       = "off"
+)
+
+const (
+	defaultIntentSimilarity = float32(0.82)
+	maxIntentPhraseWords    = 4
+	maxIntentClassifyWords  = 12 // длинное сообщение — вопрос по документам, классификатор не вызываем
+	maxIntentLabelTokens    = 8
+	intentClassifierPrompt  = "You route messages sent to an assistant that answers questions about a fixed set of documents. Classify the message into exactly one label: greeting, thanks (including goodbyes), smalltalk, help (what the assistant can do, how to use it), out_of_scope (requests unrelated to the documents: jokes, weather, general knowledge, writing tasks), document_question (anything that may be answered from the documents, including follow-ups to an earlier conversation). When unsure, answer document_question. Return only the label."
+)
+
+// intentPhrases — фразы правил по намерениям (в нижнем регистре, ё заменена на е). Сообщение относится
+// к намерению, только если целиком состоит из таких фраз и слов-заполнителей: "Привет, как дела?" — да,
+// "Привет, когда сессия?" — нет.
+var intentPhrases = map[string][]string{
+	IntentGreeting: {
+		"привет", "приветик", "привета", "приветствую", "здравствуй", "здравствуйте", "добрый день", "добрый вечер",
+		"доброе утро", "доброй ночи", "хай", "хелло", "салют", "hi", "hello", "hey", "good morning", "good afternoon",
+		"good evening", "сәлем", "сәлеметсіз бе",
+	},
+	IntentThanks: {
+		"спасибо", "благодарю", "спс", "сенкс", "рахмет", "thanks", "thank you", "thx", "пока", "до свидания",
+		"всего доброго", "bye", "goodbye",
+	},
+	IntentSmalltalk: {
+		"как дела", "как ты", "как поживаешь", "что нового", "ты кто", "кто ты", "ты бот", "ты человек",
+		"how are you", "who are you", "what's up", "whats up", "ок", "окей", "ok", "okay", "понятно", "ясно",
+		"хорошо", "отлично", "круто", "супер", "ага", "угу", "cool", "nice", "great",
+	},
+	IntentHelp: {
+		"помощь", "помоги", "help", "что ты умеешь", "что умеешь", "что ты можешь", "что можешь", "что ты знаешь",
+		"как тобой пользоваться", "как пользоваться", "какие вопросы можно задать", "о чем можно спросить",
+		"что можно спросить", "what can you do", "how to use",
+	},
+	IntentOutOfScope: {
+		"расскажи анекдот", "расскажи шутку", "пошути", "какая погода", "погода на завтра", "напиши стих",
+		"напиши стихотворение", "tell me a joke", "what's the weather", "write a poem",
+	},
+}
+
+// intentFillers — слова, которые не меняют намерение: обращения и усилители ("спасибо большое, бот").
+var intentFillers = map[string]bool{
+	"бот": true, "ботик": true, "друг": true, "ребята": true, "всем": true, "тебе": true, "вам": true, "очень": true,
+	"большое": true, "огромное": true, "еще": true, "раз": true, "пожалуйста": true, "же": true, "ну": true, "а": true,
+	"и": true, "снова": true, "please": true, "a": true, "lot": true, "so": true, "much": true, "there": true,
+	"you": true, "again": true, "all": true, "everyone": true, "bot": true,
+}
+
+// intentPriority — какое намерение отвечает, если в сообщении их несколько ("Привет, что ты умеешь?" — help).
+var intentPriority = []string{IntentHelp, IntentOutOfScope, IntentThanks, IntentSmalltalk, IntentGreeting}
+
+// defaultIntentReplies — ответы по умолчанию; приветствие по умолчанию берётся из шаблона промпта.
+var defaultIntentReplies = map[string]string{
+	IntentThanks:     `{{if eq .Language "en"}}You're welcome! Ask if you have more questions about the documents.{{else}}Пожалуйста! Если появятся вопросы по документам — спрашивайте.{{end}}`,
+	IntentSmalltalk:  `{{if eq .Language "en"}}I'm a document assistant, so I can't chat about everything, but I'm glad to help find information in the uploaded documents. What would you like to know?{{else}}Я ассистент по документам, поэтому поддержать разговор на любые темы не смогу, но с радостью помогу найти информацию в загруженных документах. Что вас интересует?{{end}}`,
+	IntentHelp:       `{{if eq .Language "en"}}I answer questions about the documents uploaded to this chat: I find the relevant fragments and answer based on them. Ask in your own words, for example: "What is the deadline for submitting an application?"{{else}}Я отвечаю на вопросы по документам, загруженным в этот чат: нахожу нужные фрагменты и отвечаю по ним. Спросите своими словами, например: «Какой срок подачи заявления?»{{end}}`,
+	IntentOutOfScope: `{{if eq .Language "en"}}This question is outside the uploaded documents, so I can't answer it. Ask about something covered by the chat's documents.{{else}}Этот вопрос выходит за рамки загруженных документов, поэтому ответить на него я не могу. Спросите о том, что есть в документах чата.{{end}}`,
+}
+
+// IntentResult — определённое намерение сообщения и способ, которым оно определено.
+type IntentResult struct {
+	Intent     string  `json:"intent"`
+	Method     string  `json:"method"` // "rules", "llm" или "embedding"
+	Confidence float32 `json:"confidence"`
+	Matched    string  `json:"matched,omitempty"` // фраза правил или ближайший пример классификатора
+	Error      string  `json:"error,omitempty"`   // ошибка классификатора; намерение тогда — по правилам
+}
+
+func resolveIntentClassifier(settings *models.AskSettings) string {
+	if settings == nil {
+		return IntentClassifierRules
+	}
+	switch classifier := strings.ToLower(strings.TrimSpace(settings.IntentClassifier)); classifier {
+	case IntentClassifierLLM, IntentClassifierEmbedding, IntentClassifierOff:
+		return classifier
+	default:
+		return IntentClassifierRules
+	}
+}
+
+func resolveIntentSimilarity(settings *models.AskSettings) float32 {
+	if settings == nil || settings.IntentSimilarity <= 0 || settings.IntentSimilarity > 1 {
+		return defaultIntentSimilarity
+	}
+	return settings.IntentSimilarity
+}
+
+// intentWords — слова сообщения в нижнем регистре без знаков препинания (апостроф сохраняется: "what's").
+func intentWords(query string) []string {
+	lower := strings.ReplaceAll(strings.ToLower(query), "ё", "е")
+	return strings.FieldsFunc(lower, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+}
+
+// matchIntentRules определяет намерение по фразам. Сообщение, в котором есть что-то кроме фраз
+// и заполнителей, — вопрос по документам.
+func matchIntentRules(query string) IntentResult {
+	result := IntentResult{Intent: IntentDocumentQuestion, Method: IntentClassifierRules, Confidence: 1}
+	words := intentWords(query)
+	if len(words) == 0 {
+		return result
+	}
+
+	found := make(map[string]string)
+	for i := 0; i < len(words); {
+		intent, phrase, n := longestIntentPhrase(words[i:])
+		switch {
+		case n > 0:
+			if _, ok := found[intent]; !ok {
+				found[intent] = phrase
+			}
+			i += n
+		case intentFillers[words[i]]:
+			i++
+		default:
+			return result
+		}
+	}
+	for _, intent := range intentPriority {
+		if phrase, ok := found[intent]; ok {
+			result.Intent = intent
+			result.Matched = phrase
+			return result
+		}
+	}
+	// Одни заполнители ("ну", "а") — не вопрос, но и не приветствие: пусть решит поиск.
+	return result
+}
+
+func longestIntentPhrase(words []string) (string, string, int) {
+	for n := maxIntentPhraseWords; n > 0; n-- {
+		if n > len(words) {
+			continue
+		}
+		candidate := strings.Join(words[:n], " ")
+		for intent, phrases := range intentPhrases {
+			if containsString(phrases, candidate) {
+				return intent, candidate, n
+			}
+		}
+	}
+	return "", "", 0
+}
+
+// isConversationalIntent — намерение, на которое отвечаем заготовкой без поиска.
+func isConversationalIntent(intent string) bool {
+	return intent != "" && intent != IntentDocumentQuestion
+}
+
+// routeIntent определяет намерение сообщения до поиска. Для всего, кроме вопроса по документам,
+// возвращает заготовленный ответ чата. nil — маршрутизация выключена (intentClassifier "off").
+func (s *RAGService) routeIntent(ctx context.Context, query string, chatID uuid.UUID, settings *models.AskSettings, accessLevel int) (*IntentResult, string) {
+	classifier := resolveIntentClassifier(settings)
+	if classifier == IntentClassifierOff {
+		return nil, ""
+	}
+
+	result := matchIntentRules(query)
+	if result.Intent == IntentDocumentQuestion && classifier != IntentClassifierRules && len(intentWords(query)) <= maxIntentClassifyWords && s.llm != nil {
+		var classified IntentResult
+		var err error
+		if classifier == IntentClassifierLLM {
+			classified, err = s.classifyIntentByLLM(ctx, query, chatID, settings, accessLevel)
+		} else {
+			classified, err = s.classifyIntentByEmbedding(ctx, query, settings)
+		}
+		if err != nil {
+			log.Printf("intent classifier %s failed: %v", classifier, err)
+			result.Error = err.Error()
+		} else {
+			result = classified
+		}
+	}
+
+	if !isConversationalIntent(result.Intent) {
+		return &result, ""
+	}
+	return &result, intentReply(result.Intent, query, settings)
+}
+
+// classifyIntentByLLM спрашивает у модели метку намерения; темы документов чата помогают отличить
+// вопрос не по теме от вопроса по документам.
+func (s *RAGService) classifyIntentByLLM(ctx context.Context, query string, chatID uuid.UUID, settings *models.AskSettings, accessLevel int) (IntentResult, error) {
+	content := "Message: " + strings.TrimSpace(query)
+	if topics := s.followUpTopics(ctx, chatID, accessLevel, settings); topics != "" {
+		content = "Document topics: " + topics + "\n" + content
+	}
+	raw, err := s.llm.utilityCompletion(ctx, settings, intentClassifierPrompt, content, maxIntentLabelTokens, 0)
+	if err != nil {
+		return IntentResult{}, err
+	}
+
+	result := IntentResult{Intent: IntentDocumentQuestion, Method: IntentClassifierLLM, Confidence: 1}
+	label := strings.ToLower(raw)
+	// document_question проверяем первым: остальные метки не являются его подстроками, а он — их.
+	for _, intent := range []string{IntentDocumentQuestion, IntentOutOfScope, IntentGreeting, IntentThanks, IntentSmalltalk, IntentHelp} {
+		if strings.Contains(label, intent) {
+			result.Intent = intent
+			return result, nil
+		}
+	}
+	// Непонятный ответ модели — безопасный вариант: вопрос по документам.
+	result.Confidence = 0
+	return result, nil
+}
+
+// classifyIntentByEmbedding сравнивает сообщение с фразами правил: ближайшая фраза с косинусной близостью
+// не ниже intentSimilarity задаёт намерение. Эмбеддинги фраз кэшируются вместе с эмбеддингами запросов.
+func (s *RAGService) classifyIntentByEmbedding(ctx context.Context, query string, settings *models.AskSettings) (IntentResult, error) {
+	result := IntentResult{Intent: IntentDocumentQuestion, Method: IntentClassifierEmbedding}
+	queryVec, err := s.embedQuery(ctx, strings.TrimSpace(query), settings)
+	if err != nil {
+		return result, err
+	}
+
+	var phrases, intents []string
+	for _, intent := range intentPriority {
+		for _, phrase := range intentPhrases[intent] {
+			phrases = append(phrases, phrase)
+			intents = append(intents, intent)
+		}
+	}
+	vectors, err := s.embedTextsCached(ctx, phrases, settings)
+	if err != nil {
+		return result, err
+	}
+
+	best := float32(-1)
+	for i, vec := range vectors {
+		if sim := cosineSimilarity(queryVec, vec); sim > best {
+			best = sim
+			result.Matched = phrases[i]
+			if sim >= resolveIntentSimilarity(settings) {
+				result.Intent = intents[i]
+			} else {
+				result.Intent = IntentDocumentQuestion
+			}
+		}
+	}
+	result.Confidence = best
+	return result, nil
+}
+
+// embedTextsCached считает эмбеддинги одним запросом только для текстов, которых нет в кэше.
+func (s *RAGService) embedTextsCached(ctx context.Context, texts []string, settings *models.AskSettings) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	var missing []string
+	var missingIdx []int
+	for i, text := range texts {
+		if s.embeddingCache != nil {
+			if vec, ok := s.embeddingCache.Get(embeddingCacheKey(text, settings)); ok {
+				vectors[i] = vec
+				continue
+			}
+		}
+		missing = append(missing, text)
+		missingIdx = append(missingIdx, i)
+	}
+	if len(missing) == 0 {
+		return vectors, nil
+	}
+
+	embedded, err := s.llm.EmbeddingsWithSettings(ctx, missing, settings)
+	if err != nil {
+		return nil, err
+	}
+	if len(embedded) != len(missing) {
+		return nil, fmt.Errorf("embedding provider returned %d vectors for %d texts", len(embedded), len(missing))
+	}
+	for j, vec := range embedded {
+		vectors[missingIdx[j]] = vec
+		if s.embeddingCache != nil {
+			s.embeddingCache.Put(embeddingCacheKey(missing[j], settings), vec)
+		}
+	}
+	return vectors, nil
+}
+
+// intentReply — ответ чата на намерение: intentReplies из настроек (text/template с переменными промпта),
+// для приветствия — часть greeting шаблона промпта, иначе ответ по умолчанию.
+func intentReply(intent, query string, settings *models.AskSettings) string {
+	vars := newPromptVars(query, "", settings)
+	if settings != nil {
+		if custom := strings.TrimSpace(settings.IntentReplies[intent]); custom != "" {
+			rendered, err := renderPromptPart(intent, custom, vars)
+			if err == nil {
+				return rendered
+			}
+			log.Printf("intent reply %q render failed, using default: %v", intent, err)
+		}
+	}
+	if intent == IntentGreeting {
+		return renderPart(settings, "greeting", func(p models.PromptParts) string { return p.Greeting }, vars)
+	}
+	rendered, err := renderPromptPart(intent, defaultIntentReplies[intent], vars)
+	if err != nil {
+		log.Printf("default intent reply %q render failed: %v", intent, err)
+	}
+	return rendered
+}
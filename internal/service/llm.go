@@ -1,64 +1,71 @@
 package service
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strings"
 	"time"
 	"unicode"
 
 	"github.com/katakuxiko/Diplom/internal/config"
 	"github.com/katakuxiko/Diplom/internal/models"
+	"github.com/katakuxiko/Diplom/internal/utils"
 	"github.com/sashabaranov/go-openai"
 )
 
-// LLMClient — клиент для LM Studio / OpenAI совместимых моделей
+// LLMClient — клиент моделей: локальный сервер (LM Studio, Ollama, llama.cpp) или внешний API.
+// Конкретный бэкенд выбирается через ProviderRegistry по типу провайдера из настроек чата.
 type LLMClient struct {
-	client    *openai.Client
-	embedName string
-	chatName  string
-	baseURL   string
+	registry     *ProviderRegistry
+	breaker      *circuitBreaker
+	providerType string
+	models       *openAIProvider
+	embedName    string
+	chatName     string
+	baseURL      string
 }
 
+// listMarkerPattern — нумерация или маркер списка в начале строки ("1.", "2)", "-", "•").
+var listMarkerPattern = regexp.MustCompile(`^\s*(\d+[.)]|[-*•])\s+`)
+
 const (
-	maxAutoContinuationParts = 2
-	continuePrompt           = "Продолжи ответ с того места, где остановился. Не повторяй уже сказанное и сохрани структуру ответа."
-	maxHistoryMessages       = 8
-	historyMessageMaxChars   = 1200
-	defaultHistoryCharBudget = 3500
-	maxHistoryCharBudget     = 7000
-	maxTranslateTokens       = 128
-	translateQueryPrompt     = "You rewrite a user search query into concise Russian for retrieval over Russian documents. Preserve names, abbreviations, numbers, dates, and domain terms. Return only the rewritten Russian query without explanations."
-	answerLanguageConstraint = "Answer strictly in the same language as the user's question. Do not switch language unless the user explicitly requests it."
+	maxAutoContinuationParts   = 2
+	continuePrompt             = "Продолжи ответ с того места, где остановился. Не повторяй уже сказанное и сохрани структуру ответа."
+	maxHistoryMessages         = 8
+	historyMessageMaxChars     = 1200
+	maxTranslateTokens         = 128
+	translateQueryPrompt       = "You translate a user search query into concise %[1]s for retrieval over %[1]s documents. Preserve names, abbreviations, numbers, dates, and domain terms. Return only the translated %[1]s query without explanations."
+	defaultRewriteHistoryTurns = 4
+	rewriteMessageMaxChars     = 400
+	rewriteQueryPrompt         = "You rewrite the user's follow-up question into a standalone search query for retrieval over documents. Resolve pronouns and ellipsis using the conversation. Keep the language of the follow-up question. Preserve names, abbreviations, numbers, dates, and domain terms. If the question is already standalone, return it unchanged. Return only the query without explanations."
+	multiQueryPrompt           = "You generate alternative search queries for retrieval over documents. Rephrase the question using different wording, synonyms, and likely document terminology. Keep the language of the question. Preserve names, abbreviations, numbers, and dates. Return exactly the requested number of queries, one per line, without numbering or explanations."
+	maxHypotheticalTokens      = 256
+	hydePrompt                 = "Write a short passage (3-5 sentences) that could appear in an official document and directly answers the question. Use the language of the question and formal document style. Do not mention that the passage is hypothetical."
+	answerLanguageConstraint   = "Answer strictly in the same language as the user's question. Do not switch language unless the user explicitly requests it."
 )
 
-func createChatCompletionWithContinuation(client *openai.Client, req openai.ChatCompletionRequest) (string, error) {
+func createChatCompletionWithContinuation(ctx context.Context, provider ChatProvider, req ChatRequest) (string, error) {
 	parts := make([]string, 0, maxAutoContinuationParts+1)
 	workingReq := req
 
 	for attempt := 0; attempt <= maxAutoContinuationParts; attempt++ {
-		resp, err := client.CreateChatCompletion(context.Background(), workingReq)
+		resp, err := provider.Chat(ctx, workingReq)
 		if err != nil {
-			return "", err
-		}
-		if len(resp.Choices) == 0 {
-			return "", fmt.Errorf("LLM вернул пустой ответ")
+			return partialAnswer(ctx, parts, "", err)
 		}
 
-		choice := resp.Choices[0]
-		content := strings.TrimSpace(choice.Message.Content)
+		content := strings.TrimSpace(resp.Content)
 		if content != "" {
 			parts = append(parts, content)
 		}
 
-		if strings.ToLower(strings.TrimSpace(string(choice.FinishReason))) != "length" {
+		if strings.ToLower(strings.TrimSpace(resp.FinishReason)) != "length" {
 			break
 		}
 		if attempt == maxAutoContinuationParts || content == "" {
@@ -66,8 +73,8 @@ func createChatCompletionWithContinuation(client *openai.Client, req openai.Chat
 		}
 
 		workingReq.Messages = append(workingReq.Messages,
-			openai.ChatCompletionMessage{Role: "assistant", Content: content},
-			openai.ChatCompletionMessage{Role: "user", Content: continuePrompt},
+			ChatMessage{Role: "assistant", Content: content},
+			ChatMessage{Role: "user", Content: continuePrompt},
 		)
 	}
 
@@ -78,7 +85,7 @@ func createChatCompletionWithContinuation(client *openai.Client, req openai.Chat
 	return result, nil
 }
 
-func createChatCompletionStreamWithContinuation(client *openai.Client, req openai.ChatCompletionRequest, onDelta func(string) error) (string, error) {
+func createChatCompletionStreamWithContinuation(ctx context.Context, provider ChatProvider, req ChatRequest, onDelta func(string) error) (string, error) {
 	if onDelta == nil {
 		return "", fmt.Errorf("stream callback is required")
 	}
@@ -108,38 +115,9 @@ func createChatCompletionStreamWithContinuation(client *openai.Client, req opena
 		attemptFirstTokenAt := time.Time{}
 		log.Printf("LLM stream attempt=%d started: messages=%d", attempt+1, len(workingReq.Messages))
 
-		stream, err := client.CreateChatCompletionStream(context.Background(), workingReq)
-		if err != nil {
-			log.Printf("LLM stream attempt=%d init error: %v", attempt+1, err)
-			return "", err
-		}
-
 		var partBuilder strings.Builder
-		finishReason := ""
-
-		for {
-			resp, recvErr := stream.Recv()
-			if errors.Is(recvErr, io.EOF) {
-				break
-			}
-			if recvErr != nil {
-				_ = stream.Close()
-				return "", recvErr
-			}
-			if len(resp.Choices) == 0 {
-				continue
-			}
-
-			choice := resp.Choices[0]
-			if choice.FinishReason != "" {
-				finishReason = strings.ToLower(strings.TrimSpace(string(choice.FinishReason)))
-			}
-
-			delta := choice.Delta.Content
-			if delta == "" {
-				continue
-			}
-
+		callbackFailed := false
+		rawFinishReason, err := provider.ChatStream(ctx, workingReq, func(delta string) error {
 			if attemptFirstTokenAt.IsZero() {
 				attemptFirstTokenAt = time.Now()
 				if !firstTokenLogged {
@@ -170,13 +148,19 @@ func createChatCompletionStreamWithContinuation(client *openai.Client, req opena
 
 			partBuilder.WriteString(delta)
 			if cbErr := onDelta(delta); cbErr != nil {
-				_ = stream.Close()
+				callbackFailed = true
 				log.Printf("LLM stream attempt=%d callback error after chunks=%d: %v", attempt+1, attemptDeltaChunks, cbErr)
-				return "", cbErr
+				return cbErr
 			}
+			return nil
+		})
+		if err != nil {
+			if !callbackFailed {
+				log.Printf("LLM stream attempt=%d error: %v", attempt+1, err)
+			}
+			return partialAnswer(ctx, parts, partBuilder.String(), err)
 		}
-
-		_ = stream.Close()
+		finishReason := strings.ToLower(strings.TrimSpace(rawFinishReason))
 
 		content := strings.TrimSpace(partBuilder.String())
 		if content != "" {
@@ -217,8 +201,8 @@ func createChatCompletionStreamWithContinuation(client *openai.Client, req opena
 		log.Printf("LLM stream continuation requested: next_attempt=%d", attempt+2)
 
 		workingReq.Messages = append(workingReq.Messages,
-			openai.ChatCompletionMessage{Role: "assistant", Content: content},
-			openai.ChatCompletionMessage{Role: "user", Content: continuePrompt},
+			ChatMessage{Role: "assistant", Content: content},
+			ChatMessage{Role: "user", Content: continuePrompt},
 		)
 	}
 
@@ -260,37 +244,20 @@ func truncateByRunes(value string, limit int) string {
 	return string(runes[:limit])
 }
 
-func historyCharBudget(settings *models.AskSettings) int {
-	budget := defaultHistoryCharBudget
-	if settings != nil && settings.MaxTokens > 0 {
-		dynamic := settings.MaxTokens * 2
-		if dynamic > budget {
-			budget = dynamic
-		}
-	}
-	if budget > maxHistoryCharBudget {
-		budget = maxHistoryCharBudget
-	}
-	if budget < 1200 {
-		budget = 1200
-	}
-	return budget
-}
-
-func sanitizeHistoryMessages(history []models.ChatContextMessage, settings *models.AskSettings) []openai.ChatCompletionMessage {
-	if len(history) == 0 {
+// sanitizeHistoryMessages отбирает последние сообщения истории, укладывающиеся в budget токенов.
+func sanitizeHistoryMessages(history []models.ChatContextMessage, settings *models.AskSettings, tok TokenEstimator, budget int) []ChatMessage {
+	if len(history) == 0 || budget <= 0 {
 		return nil
 	}
 	if settings != nil && !settings.EnableHistory {
 		return nil
 	}
 
-	budget := historyCharBudget(settings)
-	selected := make([]openai.ChatCompletionMessage, 0, maxHistoryMessages)
-	usedChars := 0
+	selected := make([]ChatMessage, 0, maxHistoryMessages)
+	usedTokens := 0
 
 	for i := len(history) - 1; i >= 0; i-- {
-		if len(selected) >= maxHistoryMessages || usedChars >= budget {
+		if len(selected) >= maxHistoryMessages || usedTokens >= budget {
 			break
 		}
 
@@ -305,21 +272,21 @@ func sanitizeHistoryMessages(history []models.ChatContextMessage, settings *mode
 		}
 
 		content = truncateByRunes(content, historyMessageMaxChars)
-		pieceLen := len([]rune(content))
-		if usedChars+pieceLen > budget {
-			remaining := budget - usedChars
+		pieceTokens := tok.Count(content) + tokensPerMessage
+		if usedTokens+pieceTokens > budget {
+			remaining := budget - usedTokens - tokensPerMessage
 			if remaining <= 0 {
 				break
 			}
-			content = strings.TrimSpace(truncateByRunes(content, remaining))
-			pieceLen = len([]rune(content))
+			content = strings.TrimSpace(truncateToTokens(tok, content, remaining))
 			if content == "" {
 				break
 			}
+			pieceTokens = tok.Count(content) + tokensPerMessage
 		}
 
-		selected = append(selected, openai.ChatCompletionMessage{Role: role, Content: content})
-		usedChars += pieceLen
+		selected = append(selected, ChatMessage{Role: role, Content: content})
+		usedTokens += pieceTokens
 	}
 
 	for left, right := 0, len(selected)-1; left < right; left, right = left+1, right-1 {
@@ -329,16 +296,11 @@ func sanitizeHistoryMessages(history []models.ChatContextMessage, settings *mode
 	return selected
 }
 
-func buildAnswerMessages(systemPrompt, userPrompt string, settings *models.AskSettings, history []models.ChatContextMessage) []openai.ChatCompletionMessage {
-	messages := make([]openai.ChatCompletionMessage, 0, 2+maxHistoryMessages)
-	messages = append(messages, openai.ChatCompletionMessage{Role: "system", Content: systemPrompt})
-
-	historyMessages := sanitizeHistoryMessages(history, settings)
-	if len(historyMessages) > 0 {
-		messages = append(messages, historyMessages...)
-	}
-
-	messages = append(messages, openai.ChatCompletionMessage{Role: "user", Content: userPrompt})
+func buildAnswerMessages(systemPrompt, userPrompt string, history []ChatMessage) []ChatMessage {
+	messages := make([]ChatMessage, 0, 2+len(history))
+	messages = append(messages, ChatMessage{Role: "system", Content: systemPrompt})
+	messages = append(messages, history...)
+	messages = append(messages, ChatMessage{Role: "user", Content: userPrompt})
 	return messages
 }
 
@@ -358,6 +320,9 @@ func scriptLetterCounts(query string) (latinCount, cyrillicCount int) {
 }
 
 func detectPrimaryQuestionLanguage(query string) string {
+	if utils.DetectLanguage(query) == utils.LangKazakh {
+		return utils.LangKazakh
+	}
 	latinCount, cyrillicCount := scriptLetterCounts(query)
 
 	switch {
@@ -384,6 +349,8 @@ func buildLanguagePolicyInstruction(query string) string {
 		return "LANGUAGE POLICY:\nYou MUST answer in English. Do not answer in Russian unless the user explicitly asks for Russian."
 	case "ru":
 		return "ПРАВИЛО ЯЗЫКА ОТВЕТА:\nВы ДОЛЖНЫ отвечать на русском языке. Не переходите на английский без явной просьбы пользователя."
+	case "kk":
+		return "LANGUAGE POLICY:\nYou MUST answer in Kazakh. Do not switch to Russian or English unless the user explicitly asks for it."
 	default:
 		return "LANGUAGE POLICY:\n" + answerLanguageConstraint
 	}
@@ -398,63 +365,148 @@ func applyLanguagePolicyToSystemPrompt(systemPrompt, query string) string {
 	return base + "\n\n" + policy
 }
 
-func localizedStaticReply(query, ru, en string) string {
-	if isLikelyEnglishQuery(query) {
-		return en
+// TranslateQueryForRetrieval переводит короткий поисковый запрос на язык корпуса (targetLang: "ru", "kk", "en")
+// для кросс-языкового поиска.
+func (l *LLMClient) TranslateQueryForRetrieval(ctx context.Context, query, targetLang string, settings *models.AskSettings) (string, error) {
+	input := strings.TrimSpace(query)
+	if input == "" {
+		return "", nil
 	}
-	return ru
+
+	systemPrompt := fmt.Sprintf(translateQueryPrompt, utils.LanguageName(targetLang))
+	translated, err := l.utilityCompletion(ctx, settings, systemPrompt, input, maxTranslateTokens, 0)
+	if err != nil {
+		return "", err
+	}
+	return cleanRetrievalQuery(translated), nil
 }
 
-func buildLanguageAwareUserPrompt(contextText, query string) string {
-	return fmt.Sprintf(
-		"%s\n\nCONTEXT:\n%s\n\nQUESTION:\n%s\n\nANSWER:",
-		buildLanguagePolicyInstruction(query),
-		contextText,
-		query,
-	)
+// RewriteQueryWithHistory превращает уточняющий вопрос ("а во вторник?") в самостоятельный
+// поисковый запрос с учётом последних сообщений диалога. Ответ генерируется по исходному вопросу.
+func (l *LLMClient) RewriteQueryWithHistory(ctx context.Context, query string, history []models.ChatContextMessage, settings *models.AskSettings) (string, error) {
+	input := strings.TrimSpace(query)
+	if input == "" || len(history) == 0 {
+		return input, nil
+	}
+
+	turns := defaultRewriteHistoryTurns
+	if settings != nil && settings.RewriteHistoryTurns > 0 {
+		turns = settings.RewriteHistoryTurns
+	}
+	if turns > maxHistoryMessages {
+		turns = maxHistoryMessages
+	}
+
+	recent := make([]string, 0, turns)
+	for i := len(history) - 1; i >= 0 && len(recent) < turns; i-- {
+		role, ok := normalizeHistoryRole(history[i].Role)
+		content := strings.TrimSpace(history[i].Content)
+		if !ok || content == "" {
+			continue
+		}
+		recent = append(recent, role+": "+truncateByRunes(content, rewriteMessageMaxChars))
+	}
+	if len(recent) == 0 {
+		return input, nil
+	}
+	for left, right := 0, len(recent)-1; left < right; left, right = left+1, right-1 {
+		recent[left], recent[right] = recent[right], recent[left]
+	}
+
+	userContent := fmt.Sprintf("CONVERSATION:\n%s\n\nFOLLOW-UP QUESTION:\n%s\n\nSTANDALONE QUERY:", strings.Join(recent, "\n"), input)
+	rewritten, err := l.utilityCompletion(ctx, settings, rewriteQueryPrompt, userContent, maxTranslateTokens, 0)
+	if err != nil {
+		return input, err
+	}
+	rewritten = cleanRetrievalQuery(rewritten)
+	if rewritten == "" {
+		return input, nil
+	}
+	return rewritten, nil
+}
+
+// GenerateQueryParaphrases просит LLM сформулировать n альтернативных поисковых запросов
+// к тому же вопросу (другие формулировки, синонимы, термины из документов).
+func (l *LLMClient) GenerateQueryParaphrases(ctx context.Context, query string, n int, settings *models.AskSettings) ([]string, error) {
+	input := strings.TrimSpace(query)
+	if input == "" || n <= 0 {
+		return nil, nil
+	}
+
+	userContent := fmt.Sprintf("Number of queries: %d\nQuestion: %s", n, input)
+	raw, err := l.utilityCompletion(ctx, settings, multiQueryPrompt, userContent, maxTranslateTokens*n, 0.3)
+	if err != nil {
+		return nil, err
+	}
+
+	variants := make([]string, 0, n)
+	for _, line := range strings.Split(raw, "\n") {
+		line = cleanRetrievalQuery(listMarkerPattern.ReplaceAllString(line, ""))
+		if line == "" {
+			continue
+		}
+		variants = append(variants, line)
+		if len(variants) >= n {
+			break
+		}
+	}
+	return variants, nil
 }
 
-// TranslateQueryToRussianForRetrieval переводит короткий запрос на русский для fallback retrieval.
-// Используется только когда поиск по исходному запросу не вернул релевантных чанков.
-func (l *LLMClient) TranslateQueryToRussianForRetrieval(query string, settings *models.AskSettings) (string, error) {
+// GenerateHypotheticalAnswer генерирует короткий гипотетический фрагмент документа с ответом (HyDE).
+// Фрагмент используется только для эмбеддинга при поиске и пользователю не показывается.
+func (l *LLMClient) GenerateHypotheticalAnswer(ctx context.Context, query string, settings *models.AskSettings) (string, error) {
 	input := strings.TrimSpace(query)
 	if input == "" {
 		return "", nil
 	}
+	return l.utilityCompletion(ctx, settings, hydePrompt, input, maxHypotheticalTokens, 0)
+}
 
+// utilityCompletion — короткий служебный вызов LLM (перевод, переписывание запроса и т.п.)
+// без истории и без автопродолжения.
+func (l *LLMClient) utilityCompletion(ctx context.Context, settings *models.AskSettings, systemPrompt, userContent string, maxTokens int, temperature float32) (string, error) {
 	modelName := l.chatName
 	if settings != nil && strings.TrimSpace(settings.Model) != "" {
 		modelName = settings.Model
 	}
 
-	client := l.clientForSettings(settings)
-	req := openai.ChatCompletionRequest{
+	provider, _, err := l.ChatProviderFor(settings)
+	if err != nil {
+		return "", err
+	}
+	req := withReasoningEffort(ChatRequest{
 		Model: modelName,
-		Messages: []openai.ChatCompletionMessage{
-			{Role: "system", Content: translateQueryPrompt},
-			{Role: "user", Content: input},
+		Messages: []ChatMessage{
+			{Role: "system", Content: systemPrompt},
+			{Role: "user", Content: userContent},
 		},
-		Temperature:     0,
+		Temperature:     temperature,
 		TopP:            1,
-		MaxTokens:       maxTranslateTokens,
+		MaxTokens:       maxTokens,
 		PresencePenalty: 0,
-	}
-	if effort := reasoningEffortForModel(modelName); effort != "" {
-		req.ReasoningEffort = effort
-	}
+	}, provider)
 
-	resp, err := client.CreateChatCompletion(context.Background(), req)
+	resp, err := provider.Chat(ctx, req)
 	if err != nil {
 		return "", err
 	}
-	if len(resp.Choices) == 0 {
-		return "", fmt.Errorf("LLM translation returned empty response")
-	}
+	return strings.TrimSpace(resp.Content), nil
+}
 
-	translated := strings.TrimSpace(resp.Choices[0].Message.Content)
-	translated = strings.Trim(translated, "\"'")
-	translated = strings.TrimSpace(translated)
-	return translated, nil
+// cleanRetrievalQuery убирает кавычки и служебные префиксы, которые модели любят добавлять к запросу.
+func cleanRetrievalQuery(value string) string {
+	value = strings.TrimSpace(value)
+	if idx := strings.Index(value, "\n"); idx >= 0 {
+		value = strings.TrimSpace(value[:idx])
+	}
+	for _, prefix := range []string{"STANDALONE QUERY:", "Query:", "Запрос:"} {
+		if strings.HasPrefix(value, prefix) {
+			value = strings.TrimSpace(strings.TrimPrefix(value, prefix))
+		}
+	}
+	value = strings.Trim(value, "\"'«»")
+	return strings.TrimSpace(value)
 }
 
 func reasoningEffortForModel(modelName string) string {
@@ -522,76 +574,29 @@ func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 
 // NewLLMClient создаёт новый клиент с настройками из config
 func NewLLMClient(cfg *config.Config) *LLMClient {
-	oaiCfg := openai.DefaultConfig("not-needed")
-	oaiCfg.BaseURL = normalizeAPIBase(cfg.LMBaseURL)
-	client := openai.NewClientWithConfig(oaiCfg)
+	providerType := normalizeProviderType(cfg.LMProviderType)
+	if providerType == "" {
+		providerType = ProviderTypeOpenAI
+	}
 
 	return &LLMClient{
-		client:    client,
-		embedName: cfg.EmbedModel,
-		chatName:  cfg.ChatModel,
-		baseURL:   cfg.LMBaseURL,
+		registry:     NewProviderRegistry(),
+		breaker:      newCircuitBreaker(cfg.ProviderBreakerFailures, time.Duration(cfg.ProviderBreakerCooldownSeconds)*time.Second),
+		providerType: providerType,
+		models:       newOpenAIProvider(ProviderConfig{BaseURL: cfg.LMBaseURL}),
+		embedName:    cfg.EmbedModel,
+		chatName:     cfg.ChatModel,
+		baseURL:      cfg.LMBaseURL,
 	}
 }
 
-// Embedding получает embedding текста (глобальный клиент)
-func (l *LLMClient) Embedding(text string) ([]float32, error) {
-	resp, err := l.client.CreateEmbeddings(
-		context.Background(),
-		openai.EmbeddingRequest{Model: openai.EmbeddingModel(l.embedName), Input: []string{text}},
-	)
+// Embedding получает embedding текста (локальный сервер)
+func (l *LLMClient) Embedding(ctx context.Context, text string) ([]float32, error) {
+	provider, err := l.registry.Embedding(l.providerType, ProviderConfig{BaseURL: l.baseURL})
 	if err != nil {
 		return nil, err
 	}
-	return resp.Data[0].Embedding, nil
-}
-
-// clientForSettings возвращает openai.Client, учитывая настройки провайдера (локальный или внешний)
-func (l *LLMClient) clientForSettings(s *models.AskSettings) *openai.Client {
-	provider := resolveChatProvider(s)
-	if s != nil && provider == "external" {
-		key := strings.TrimSpace(s.ExternalAPIKey)
-		if key == "" {
-			key = "not-needed"
-		}
-		cfg := openai.DefaultConfig(key)
-		if strings.TrimSpace(s.ExternalBaseURL) != "" {
-			cfg.BaseURL = normalizeAPIBase(s.ExternalBaseURL)
-		}
-		cfg.HTTPClient = &http.Client{Transport: &authTransport{apiKey: strings.TrimSpace(s.ExternalAPIKey), base: http.DefaultTransport}}
-		return openai.NewClientWithConfig(cfg)
-	}
-	return l.client
-}
-
-// clientForEmbeddingSettings возвращает openai.Client, учитывая настройки провайдера для эмбеддингов
-func (l *LLMClient) clientForEmbeddingSettings(s *models.AskSettings) *openai.Client {
-	if s == nil {
-		return l.client
-	}
-	provider := resolveEmbeddingProvider(s)
-	if provider == "external" {
-		key := strings.TrimSpace(s.EmbedExternalAPIKey)
-		if key == "" {
-			key = strings.TrimSpace(s.ExternalAPIKey)
-		}
-		if key == "" {
-			key = "not-needed"
-		}
-		cfg := openai.DefaultConfig(key)
-		if strings.TrimSpace(s.EmbedExternalBaseURL) != "" {
-			cfg.BaseURL = normalizeAPIBase(s.EmbedExternalBaseURL)
-		} else if strings.TrimSpace(s.ExternalBaseURL) != "" {
-			cfg.BaseURL = normalizeAPIBase(s.ExternalBaseURL)
-		}
-		diagKey := strings.TrimSpace(s.EmbedExternalAPIKey)
-		if diagKey == "" {
-			diagKey = strings.TrimSpace(s.ExternalAPIKey)
-		}
-		cfg.HTTPClient = &http.Client{Transport: &authTransport{apiKey: diagKey, base: http.DefaultTransport}}
-		return openai.NewClientWithConfig(cfg)
-	}
-	return l.client
+	return embedOne(ctx, provider, l.embedName, text)
 }
 
 // diagGET делает быстрый GET к указанному URL и возвращает статус и короткую часть тела
@@ -661,438 +666,155 @@ func normalizeAPIBase(raw string) string {
 	return base + "/v1"
 }
 
-// hfEmbedding выполняет вызов к Hugging Face Inference/Router API для получения эмбеддингов
-func hfEmbedding(usedBase, modelName, text, apiKey string) ([]float32, error) {
-	if modelName == "" {
-		return nil, fmt.Errorf("model name is empty for HF embedding")
-	}
-	lb := strings.ToLower(usedBase)
-	var endpoint string
-	if strings.Contains(lb, "/hf-inference/") || strings.Contains(lb, "/pipeline/") {
-		endpoint = usedBase
-	} else if strings.Contains(lb, "router.huggingface.co") {
-		esc := url.PathEscape(modelName)
-		esc = strings.ReplaceAll(esc, "%2F", "/")
-		endpoint = fmt.Sprintf("https://router.huggingface.co/hf-inference/models/%s/pipeline/feature-extraction", esc)
-	} else if strings.Contains(lb, "api-inference.huggingface.co") {
-		esc := url.PathEscape(modelName)
-		esc = strings.ReplaceAll(esc, "%2F", "/")
-		endpoint = fmt.Sprintf("https://api-inference.huggingface.co/models/%s/pipeline/feature-extraction", esc)
-	} else {
-		esc := url.PathEscape(modelName)
-		esc = strings.ReplaceAll(esc, "%2F", "/")
-		endpoint = strings.TrimRight(usedBase, "/") + "/hf-inference/models/" + esc + "/pipeline/feature-extraction"
-	}
-
-	bodyMap := map[string]interface{}{"inputs": text}
-	bodyBytes, _ := json.Marshal(bodyMap)
-	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(bodyBytes))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	if apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+apiKey)
+// EmbeddingWithSettings позволяет получать embedding, используя провайдера из настроек
+func (l *LLMClient) EmbeddingWithSettings(ctx context.Context, text string, s *models.AskSettings) ([]float32, error) {
+	modelName := l.embedName
+	if s != nil && s.EmbedModel != "" {
+		modelName = s.EmbedModel
 	}
+	providerType, cfg := l.embeddingProviderTarget(s)
+	log.Printf("Embedding request: model=%s provider=%s type=%s baseURL=%s", modelName, resolveEmbeddingProvider(s), providerType, cfg.BaseURL)
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	provider, err := l.registry.Embedding(providerType, cfg)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	b, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("hf embed error, status: %s, body: %s", resp.Status, string(b))
-	}
-
-	var parsed interface{}
-	if err := json.Unmarshal(b, &parsed); err != nil {
-		return nil, fmt.Errorf("failed to parse hf embedding response: %v body=%s", err, string(b))
-	}
-
-	var floats []float32
-	var tryExtract func(interface{}) bool
-	tryExtract = func(v interface{}) bool {
-		switch x := v.(type) {
-		case []interface{}:
-			if len(x) == 0 {
-				return false
-			}
-			switch x[0].(type) {
-			case float64:
-				floats = make([]float32, len(x))
-				for i, vv := range x {
-					if num, ok := vv.(float64); ok {
-						floats[i] = float32(num)
-					} else {
-						return false
-					}
-				}
-				return true
-			default:
-				for _, item := range x {
-					if tryExtract(item) {
-						return true
-					}
-				}
-				return false
-			}
-		default:
-			return false
+	emb, err := embedOne(ctx, provider, modelName, text)
+	if err != nil {
+		log.Printf("Embedding error (%s): %v", providerType, err)
+		if cfg.BaseURL != "" {
+			status, body := diagGETWithAuth(cfg.BaseURL, cfg.APIKey)
+			log.Printf("Embedding diagnostic GET %s -> status=%s body=%s", cfg.BaseURL, status, body)
 		}
+		return nil, err
 	}
-
-	if !tryExtract(parsed) {
-		return nil, fmt.Errorf("unexpected hf embedding response format: %s", string(b))
-	}
-	return floats, nil
+	return emb, nil
 }
 
-// EmbeddingWithSettings позволяет получать embedding, используя провайдера из настроек
-func (l *LLMClient) EmbeddingWithSettings(text string, s *models.AskSettings) ([]float32, error) {
-	client := l.clientForEmbeddingSettings(s)
+// EmbeddingsWithSettings считает эмбеддинги нескольких текстов одним запросом к провайдеру эмбеддингов чата.
+func (l *LLMClient) EmbeddingsWithSettings(ctx context.Context, texts []string, s *models.AskSettings) ([][]float32, error) {
 	modelName := l.embedName
 	if s != nil && s.EmbedModel != "" {
 		modelName = s.EmbedModel
 	}
-	usedBase := l.baseURL
-	provider := resolveEmbeddingProvider(s)
-	if s != nil {
-		if provider == "external" && strings.TrimSpace(s.EmbedExternalBaseURL) != "" {
-			usedBase = s.EmbedExternalBaseURL
-		} else if provider == "external" && strings.TrimSpace(s.ExternalBaseURL) != "" {
-			usedBase = s.ExternalBaseURL
-		}
-	}
-	apiKey := ""
-	if s != nil {
-		if provider == "external" && strings.TrimSpace(s.EmbedExternalAPIKey) != "" {
-			apiKey = s.EmbedExternalAPIKey
-		} else if provider == "external" && strings.TrimSpace(s.ExternalAPIKey) != "" {
-			apiKey = s.ExternalAPIKey
-		}
-	}
-
-	log.Printf("Embedding request: model=%s provider=%s baseURL=%s", modelName, provider, usedBase)
-
-	// Если указан Hugging Face в usedBase — вызываем HF inference напрямую
-	if strings.Contains(strings.ToLower(usedBase), "huggingface") {
-		emb, err := hfEmbedding(usedBase, modelName, text, apiKey)
-		if err != nil {
-			log.Printf("Embedding error (hf): %v", err)
-			if usedBase != "" {
-				status, body := diagGETWithAuth(usedBase, apiKey)
-				log.Printf("Embedding diagnostic GET %s -> status=%s body=%s", usedBase, status, body)
-			}
-			return nil, err
-		}
-		return emb, nil
-	}
-
-	resp, err := client.CreateEmbeddings(
-		context.Background(),
-		openai.EmbeddingRequest{Model: openai.EmbeddingModel(modelName), Input: []string{text}},
-	)
+	provider, _, err := l.EmbeddingProviderFor(s)
 	if err != nil {
-		log.Printf("Embedding error: %v", err)
-		if usedBase != "" {
-			status, body := diagGETWithAuth(usedBase, apiKey)
-			log.Printf("Embedding diagnostic GET %s -> status=%s body=%s", usedBase, status, body)
-		}
 		return nil, err
 	}
-	return resp.Data[0].Embedding, nil
-}
-
-// Ask выполняет RAG/LLM запрос с контекстом и настраиваемыми параметрами
-func (l *LLMClient) Ask(query, contextText string, settings *models.AskSettings, history []models.ChatContextMessage) (string, error) {
-	greetings := []string{"привет", "привет!", "здравствуй", "здравствуйте", "hi", "hello", "hey", "привета", "хай", "хелло"}
-	lowerQuery := strings.ToLower(strings.TrimSpace(query))
-	for _, greeting := range greetings {
-		if lowerQuery == greeting {
-			return localizedStaticReply(
-				query,
-				"Привет! 👋 Я помогу найти информацию в загруженных документах. Задайте ваш вопрос.",
-				"Hi! 👋 I can help you find information in the uploaded documents. Ask your question.",
-			), nil
-		}
-	}
-
-	if strings.TrimSpace(contextText) == "" {
-		return localizedStaticReply(
-			query,
-			"К сожалению, в загруженных документах не найдено информации по вашему запросу. Попробуйте переформулировать вопрос или загрузите дополнительные материалы.",
-			"Unfortunately, no relevant information was found in the uploaded documents for your request. Try rephrasing the question or upload additional materials.",
-		), nil
-	}
-
-	modelName := l.chatName
-	temperature := float32(0.7)
-	maxTokens := 2000
-	systemPrompt := `Ты - профессиональный аналитик документов. Твоя задача - давать точные, структурированные ответы на основе предоставленных материалов.
-
-КРИТИЧЕСКИЕ ПРАВИЛА:
-1. Используй ТОЛЬКО информацию из КОНТЕКСТА ниже
-2. Не используй знания, полученные во время обучения
-3. Если информация неполная или неоднозначная - явно укажи это
-4. При отсутствии релевантной информации отвечай: "Информация по данному вопросу отсутствует в документах"
-
-ФОРМАТИРОВАНИЕ ОТВЕТА:
-- Структурируй ответ (используй списки, подзаголовки при необходимости)
-- Будь конкретным и информативным
-- Если в контексте есть несколько релевантных фрагментов - синтезируй целостный ответ
-- Избегай упоминаний о "контексте", "документах" или своей природе как ИИ
-- Отвечай прямо на вопрос, без лишних вступлений`
-
-	if settings != nil {
-		if settings.Model != "" {
-			modelName = settings.Model
-		}
-		if settings.SystemPrompt != "" {
-			systemPrompt = settings.SystemPrompt
-		}
-		if settings.MaxTokens > 0 {
-			maxTokens = settings.MaxTokens
-		}
-		if settings.Temperature > 0 {
-			temperature = settings.Temperature
-		}
-	}
-
-	systemPrompt = applyLanguagePolicyToSystemPrompt(systemPrompt, query)
-
-	userPrompt := buildLanguageAwareUserPrompt(contextText, query)
-
-	req := openai.ChatCompletionRequest{
-		Model:           modelName,
-		Messages:        buildAnswerMessages(systemPrompt, userPrompt, settings, history),
-		Temperature:     temperature,
-		TopP:            0.9,
-		MaxTokens:       maxTokens,
-		PresencePenalty: 0.1,
+	vectors, err := provider.Embed(ctx, modelName, texts)
+	if err != nil {
+		return nil, err
 	}
-	if effort := reasoningEffortForModel(modelName); effort != "" {
-		req.ReasoningEffort = effort
+	if len(vectors) != len(texts) {
+		return nil, fmt.Errorf("%s embedding: expected %d vectors, got %d", provider.Type(), len(texts), len(vectors))
 	}
-
-	return createChatCompletionWithContinuation(l.client, req)
+	return vectors, nil
 }
 
-// AskWithSettings выполняет запрос к модели с учётом per-chat провайдера (локальный или внешний)
-func (l *LLMClient) AskWithSettings(query, contextText string, settings *models.AskSettings, history []models.ChatContextMessage) (string, error) {
-	greetings := []string{"привет", "привет!", "здравствуй", "здравствуйте", "hi", "hello", "hey", "привета", "хай", "хелло"}
-	lowerQuery := strings.ToLower(strings.TrimSpace(query))
-	for _, greeting := range greetings {
-		if lowerQuery == greeting {
-			return localizedStaticReply(
-				query,
-				"Привет! 👋 Я помогу найти информацию в загруженных документах. Задайте ваш вопрос.",
-				"Hi! 👋 I can help you find information in the uploaded documents. Ask your question.",
-			), nil
-		}
-	}
-
-	if strings.TrimSpace(contextText) == "" {
-		return localizedStaticReply(
-			query,
-			"К сожалению, в загруженных документах не найдено информации по вашему запросу. Попробуйте переформулировать вопрос или загрузите дополнительные материалы.",
-			"Unfortunately, no relevant information was found in the uploaded documents for your request. Try rephrasing the question or upload additional materials.",
-		), nil
+// Ask выполняет RAG/LLM запрос с контекстом и настраиваемыми параметрами (локальный сервер)
+func (l *LLMClient) Ask(ctx context.Context, query, contextText string, settings *models.AskSettings, history []models.ChatContextMessage) (string, error) {
+	prompt := l.BuildAnswerPrompt(query, contextText, settings, history)
+	if prompt.StaticReply != "" {
+		return prompt.StaticReply, nil
 	}
 
-	modelName := l.chatName
-	temperature := float32(0.7)
-	maxTokens := 2000
-	systemPrompt := `Ты - профессиональный аналитик документов. Твоя задача - давать точные, структурированные ответы на основе предоставленных материалов.
-
-КРИТИЧЕСКИЕ ПРАВИЛА:
-1. Используй ТОЛЬКО информацию из КОНТЕКСТА ниже
-2. Не используй знания, полученные во время обучения
-3. Если информация неполная или неоднозначная - явно укажи это
-4. При отсутствии релевантной информации отвечай: "Информация по данному вопросу отсутствует в документах"
-
-ФОРМАТИРОВАНИЕ ОТВЕТА:
-- Структурируй ответ (используй списки, подзаголовки при необходимости)
-- Будь конкретным и информативным
-- Если в контексте есть несколько релевантных фрагментов - синтезируй целостный ответ
-- Избегай упоминаний о "контексте", "документах" или своей природе как ИИ
-- Отвечай прямо на вопрос, без лишних вступлений`
-
-	if settings != nil {
-		if settings.Model != "" {
-			modelName = settings.Model
-		}
-		if settings.SystemPrompt != "" {
-			systemPrompt = settings.SystemPrompt
-		}
-		if settings.MaxTokens > 0 {
-			maxTokens = settings.MaxTokens
-		}
-		if settings.Temperature > 0 {
-			temperature = settings.Temperature
-		}
+	provider, err := l.registry.Chat(l.providerType, ProviderConfig{BaseURL: l.baseURL})
+	if err != nil {
+		return "", err
 	}
+	return createChatCompletionWithContinuation(ctx, provider, prompt.chatRequest(provider))
+}
 
-	systemPrompt = applyLanguagePolicyToSystemPrompt(systemPrompt, query)
-
-	userPrompt := buildLanguageAwareUserPrompt(contextText, query)
-
-	client := l.clientForSettings(settings)
-
-	usedBase := l.baseURL
-	provider := resolveChatProvider(settings)
-	if settings != nil {
-		if provider == "external" && strings.TrimSpace(settings.ExternalBaseURL) != "" {
-			usedBase = settings.ExternalBaseURL
-		}
+// AskWithSettings выполняет запрос к модели с учётом per-chat провайдера и цепочки резервных провайдеров.
+// Возвращает сведения о провайдере, который ответил (nil, если модель не вызывалась).
+func (l *LLMClient) AskWithSettings(ctx context.Context, query, contextText string, settings *models.AskSettings, history []models.ChatContextMessage) (string, *ProviderUsage, error) {
+	prompt := l.BuildAnswerPrompt(query, contextText, settings, history)
+	if prompt.StaticReply != "" {
+		return prompt.StaticReply, nil, nil
 	}
-	log.Printf("Chat request: model=%s provider=%s baseURL=%s", modelName, provider, usedBase)
 
-	req := openai.ChatCompletionRequest{
-		Model:           modelName,
-		Messages:        buildAnswerMessages(systemPrompt, userPrompt, settings, history),
-		Temperature:     temperature,
-		TopP:            0.9,
-		MaxTokens:       maxTokens,
-		PresencePenalty: 0.1,
-	}
-	if effort := reasoningEffortForModel(modelName); effort != "" {
-		req.ReasoningEffort = effort
+	log.Printf("Chat request: model=%s provider=%s template=%s fallbacks=%d", prompt.Model, resolveChatProvider(settings), prompt.Template, fallbackCount(settings))
+	answer, usage, err := l.chatWithFallback(ctx, settings, prompt.Model, func(ctx context.Context, provider ChatProvider, model string) (string, error) {
+		req := prompt.chatRequest(provider)
+		req.Model = model
+		return createChatCompletionWithContinuation(ctx, provider, req)
+	}, nil)
+	if errors.Is(err, ErrAnswerTimeout) {
+		log.Printf("Chat deadline exceeded: partial_chars=%d", len([]rune(answer)))
+		return answer, usage, err
 	}
-
-	answer, err := createChatCompletionWithContinuation(client, req)
 	if err != nil {
 		log.Printf("Chat error: %v", err)
-		if usedBase != "" {
-			diagKey := ""
-			if settings != nil && provider == "external" && settings.ExternalAPIKey != "" {
-				diagKey = settings.ExternalAPIKey
-			}
-			status, body := diagGETWithAuth(usedBase, diagKey)
-			log.Printf("Chat diagnostic GET %s -> status=%s body=%s", usedBase, status, body)
-		}
-		return "", err
+		return "", usage, err
 	}
-	return answer, nil
+	return answer, usage, nil
 }
 
 // AskWithSettingsStream выполняет потоковый запрос к модели с учётом per-chat провайдера.
-func (l *LLMClient) AskWithSettingsStream(query, contextText string, settings *models.AskSettings, history []models.ChatContextMessage, onDelta func(string) error) (string, error) {
+// На резервный провайдер переключается, только пока клиенту не отправлено ни одного фрагмента.
+func (l *LLMClient) AskWithSettingsStream(ctx context.Context, query, contextText string, settings *models.AskSettings, history []models.ChatContextMessage, onDelta func(string) error) (string, *ProviderUsage, error) {
 	if onDelta == nil {
-		return "", fmt.Errorf("stream callback is required")
+		return "", nil, fmt.Errorf("stream callback is required")
 	}
 
-	greetings := []string{"привет", "привет!", "здравствуй", "здравствуйте", "hi", "hello", "hey", "привета", "хай", "хелло"}
-	lowerQuery := strings.ToLower(strings.TrimSpace(query))
-	for _, greeting := range greetings {
-		if lowerQuery == greeting {
-			staticAnswer := localizedStaticReply(
-				query,
-				"Привет! 👋 Я помогу найти информацию в загруженных документах. Задайте ваш вопрос.",
-				"Hi! 👋 I can help you find information in the uploaded documents. Ask your question.",
-			)
-			if err := onDelta(staticAnswer); err != nil {
-				return "", err
-			}
-			return staticAnswer, nil
+	prompt := l.BuildAnswerPrompt(query, contextText, settings, history)
+	if prompt.StaticReply != "" {
+		if err := onDelta(prompt.StaticReply); err != nil {
+			return "", nil, err
 		}
+		return prompt.StaticReply, nil, nil
 	}
 
-	if strings.TrimSpace(contextText) == "" {
-		staticAnswer := localizedStaticReply(
-			query,
-			"К сожалению, в загруженных документах не найдено информации по вашему запросу. Попробуйте переформулировать вопрос или загрузите дополнительные материалы.",
-			"Unfortunately, no relevant information was found in the uploaded documents for your request. Try rephrasing the question or upload additional materials.",
-		)
-		if err := onDelta(staticAnswer); err != nil {
-			return "", err
-		}
-		return staticAnswer, nil
+	log.Printf("Chat stream request: model=%s provider=%s template=%s fallbacks=%d", prompt.Model, resolveChatProvider(settings), prompt.Template, fallbackCount(settings))
+	emitted := false
+	emit := func(delta string) error {
+		emitted = true
+		return onDelta(delta)
 	}
-
-	modelName := l.chatName
-	temperature := float32(0.7)
-	maxTokens := 2000
-	systemPrompt := `Ты - профессиональный аналитик документов. Твоя задача - давать точные, структурированные ответы на основе предоставленных материалов.
-
-КРИТИЧЕСКИЕ ПРАВИЛА:
-1. Используй ТОЛЬКО информацию из КОНТЕКСТА ниже
-2. Не используй знания, полученные во время обучения
-3. Если информация неполная или неоднозначная - явно укажи это
-4. При отсутствии релевантной информации отвечай: "Информация по данному вопросу отсутствует в документах"
-
-ФОРМАТИРОВАНИЕ ОТВЕТА:
-- Структурируй ответ (используй списки, подзаголовки при необходимости)
-- Будь конкретным и информативным
-- Если в контексте есть несколько релевантных фрагментов - синтезируй целостный ответ
-- Избегай упоминаний о "контексте", "документах" или своей природе как ИИ
-- Отвечай прямо на вопрос, без лишних вступлений`
-
-	if settings != nil {
-		if settings.Model != "" {
-			modelName = settings.Model
-		}
-		if settings.SystemPrompt != "" {
-			systemPrompt = settings.SystemPrompt
-		}
-		if settings.MaxTokens > 0 {
-			maxTokens = settings.MaxTokens
+	answer, usage, err := l.chatWithFallback(ctx, settings, prompt.Model, func(ctx context.Context, provider ChatProvider, model string) (string, error) {
+		req := prompt.chatRequest(provider)
+		req.Model = model
+		if provider.Capabilities().Streaming {
+			return createChatCompletionStreamWithContinuation(ctx, provider, req, emit)
 		}
-		if settings.Temperature > 0 {
-			temperature = settings.Temperature
+		// Бэкенд без стриминга: генерируем ответ целиком и отдаём его одним фрагментом.
+		answer, err := createChatCompletionWithContinuation(ctx, provider, req)
+		if answer != "" {
+			if cbErr := emit(answer); cbErr != nil {
+				return "", cbErr
+			}
 		}
+		return answer, err
+	}, func() bool { return emitted })
+	if errors.Is(err, ErrAnswerTimeout) {
+		log.Printf("Chat stream deadline exceeded: partial_chars=%d", len([]rune(answer)))
+		return answer, usage, err
 	}
-
-	systemPrompt = applyLanguagePolicyToSystemPrompt(systemPrompt, query)
-
-	userPrompt := buildLanguageAwareUserPrompt(contextText, query)
-
-	client := l.clientForSettings(settings)
-
-	usedBase := l.baseURL
-	provider := resolveChatProvider(settings)
-	if settings != nil {
-		if provider == "external" && strings.TrimSpace(settings.ExternalBaseURL) != "" {
-			usedBase = settings.ExternalBaseURL
-		}
+	if err != nil {
+		log.Printf("Chat stream error: %v", err)
+		return "", usage, err
 	}
-	log.Printf("Chat stream request: model=%s provider=%s baseURL=%s", modelName, provider, usedBase)
+	return answer, usage, nil
+}
 
-	req := openai.ChatCompletionRequest{
-		Model:           modelName,
-		Messages:        buildAnswerMessages(systemPrompt, userPrompt, settings, history),
-		Temperature:     temperature,
-		TopP:            0.9,
-		MaxTokens:       maxTokens,
-		PresencePenalty: 0.1,
-		Stream:          true,
-	}
-	if effort := reasoningEffortForModel(modelName); effort != "" {
-		req.ReasoningEffort = effort
+func fallbackCount(settings *models.AskSettings) int {
+	if settings == nil {
+		return 0
 	}
+	return len(settings.FallbackProviders)
+}
 
-	answer, err := createChatCompletionStreamWithContinuation(client, req, onDelta)
-	if err != nil {
-		log.Printf("Chat stream error: %v", err)
-		if usedBase != "" {
-			diagKey := ""
-			if settings != nil && provider == "external" && settings.ExternalAPIKey != "" {
-				diagKey = settings.ExternalAPIKey
-			}
-			status, body := diagGETWithAuth(usedBase, diagKey)
-			log.Printf("Chat stream diagnostic GET %s -> status=%s body=%s", usedBase, status, body)
-		}
-		return "", err
+// logProviderDiagnostic логирует ответ GET на адрес провайдера после ошибки запроса.
+func logProviderDiagnostic(label string, cfg ProviderConfig) {
+	if cfg.BaseURL == "" {
+		return
 	}
-	return answer, nil
+	status, body := diagGETWithAuth(cfg.BaseURL, cfg.APIKey)
+	log.Printf("%s diagnostic GET %s -> status=%s body=%s", label, cfg.BaseURL, status, body)
 }
 
-// ListModels возвращает список моделей LM Studio
-func (l *LLMClient) ListModels() ([]openai.Model, error) {
-	resp, err := l.client.ListModels(context.Background())
-	if err != nil {
-		return nil, err
-	}
-	return resp.Models, nil
+// ListModels возвращает список моделей локального сервера (OpenAI-совместимый GET /v1/models
+// есть и у LM Studio, и у Ollama, и у llama.cpp server)
+func (l *LLMClient) ListModels(ctx context.Context) ([]openai.Model, error) {
+	return l.models.listModels(ctx)
 }
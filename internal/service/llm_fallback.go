@@ -0,0 +1,353 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/katakuxiko/Diplom/internal/models"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	defaultProviderRetries  = 2
+	maxProviderRetries      = 5
+	providerBackoffBase     = 500 * time.Millisecond
+	providerBackoffMax      = 4 * time.Second
+	defaultBreakerFailures  = 3
+	defaultBreakerCooldown  = 30 * time.Second
+	breakerStateCacheSize   = 1024
+	providerErrorMaxRunes   = 300
+	providerStatusBodyLimit = 2048
+)
+
+// ErrProvidersUnavailable — ни один провайдер из цепочки чата не ответил.
+var ErrProvidersUnavailable = errors.New("all chat providers failed")
+
+// ProviderUsage — какой провайдер из цепочки ответил и что случилось с остальными.
+type ProviderUsage struct {
+	Provider     string   `json:"provider"` // "local" или "external"
+	Type         string   `json:"type"`
+	Model        string   `json:"model,omitempty"`
+	ChainIndex   int      `json:"chain_index"` // 0 — основной провайдер, дальше — fallbackProviders
+	Attempts     int      `json:"attempts"`    // всего запросов, включая повторы и неудачные провайдеры
+	FallbackUsed bool     `json:"fallback_used"`
+	Skipped      []string `json:"skipped,omitempty"` // пропущены: circuit breaker открыт
+	Failed       []string `json:"failed,omitempty"`  // провайдер: ошибка
+}
+
+// providerStatusError — HTTP-ошибка нативного API бэкенда; код статуса нужен для решения о повторе.
+type providerStatusError struct {
+	provider   string
+	path       string
+	status     string
+	statusCode int
+	body       string
+}
+
+func (e *providerStatusError) Error() string {
+	return fmt.Sprintf("%s %s error, status: %s, body: %s", e.provider, e.path, e.status, e.body)
+}
+
+// providerCandidate — один провайдер цепочки генерации.
+type providerCandidate struct {
+	index        int
+	scope        string
+	providerType string
+	cfg          ProviderConfig
+	model        string
+}
+
+func (c providerCandidate) label() string {
+	return c.scope + "/" + c.providerType
+}
+
+// breakerKey — состояние circuit breaker ведётся по адресу и ключу бэкенда; ключ API в открытом виде не хранится.
+func (c providerCandidate) breakerKey() string {
+	return hashProviderTarget(ProviderConfig{BaseURL: strings.TrimRight(strings.TrimSpace(c.cfg.BaseURL), "/"), APIKey: c.cfg.APIKey})
+}
+
+// chatProviderChain строит цепочку: основной провайдер чата, затем fallbackProviders по порядку.
+// Повторяющиеся (тот же тип, адрес и модель) записи пропускаются.
+func (l *LLMClient) chatProviderChain(settings *models.AskSettings, model string) []providerCandidate {
+	primaryType, primaryCfg := l.chatProviderTarget(settings)
+	chain := []providerCandidate{{scope: resolveChatProvider(settings), providerType: primaryType, cfg: primaryCfg, model: model}}
+	if settings == nil {
+		return chain
+	}
+
+	seen := map[string]bool{primaryType + "|" + primaryCfg.BaseURL + "|" + model: true}
+	for _, fb := range settings.FallbackProviders {
+		candidate := providerCandidate{scope: strings.ToLower(strings.TrimSpace(fb.Provider)), model: strings.TrimSpace(fb.Model)}
+		if candidate.model == "" {
+			candidate.model = model
+		}
+		candidate.providerType = normalizeProviderType(fb.ProviderType)
+		switch candidate.scope {
+		case "local":
+			if candidate.providerType == "" {
+				candidate.providerType = l.providerType
+			}
+			candidate.cfg = ProviderConfig{BaseURL: l.baseURL}
+		case "external":
+			if candidate.providerType == "" {
+				candidate.providerType = normalizeProviderType(settings.ProviderType)
+			}
+			if candidate.providerType == "" {
+				candidate.providerType = ProviderTypeOpenAI
+			}
+			candidate.cfg = ProviderConfig{BaseURL: strings.TrimSpace(fb.BaseURL), APIKey: strings.TrimSpace(settings.ExternalAPIKey)}
+			if candidate.cfg.BaseURL == "" {
+				candidate.cfg.BaseURL = strings.TrimSpace(settings.ExternalBaseURL)
+			}
+			if candidate.cfg.BaseURL == "" {
+				log.Printf("fallback provider %q skipped: no base url", fb.Provider)
+				continue
+			}
+		default:
+			log.Printf("fallback provider %q skipped: expected \"local\" or \"external\"", fb.Provider)
+			continue
+		}
+
+		key := candidate.providerType + "|" + candidate.cfg.BaseURL + "|" + candidate.model
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		candidate.index = len(chain)
+		chain = append(chain, candidate)
+	}
+	return chain
+}
+
+func resolveProviderRetries(settings *models.AskSettings) int {
+	if settings == nil || settings.ProviderRetries == 0 {
+		return defaultProviderRetries
+	}
+	if settings.ProviderRetries < 0 {
+		return 0
+	}
+	if settings.ProviderRetries > maxProviderRetries {
+		return maxProviderRetries
+	}
+	return settings.ProviderRetries
+}
+
+// chatWithFallback вызывает call для провайдеров цепочки по очереди: временные ошибки повторяются
+// с экспоненциальной задержкой, недоступный провайдер уступает следующему. Если call уже отдал
+// клиенту часть ответа (committed), переключение невозможно и ошибка возвращается сразу.
+func (l *LLMClient) chatWithFallback(ctx context.Context, settings *models.AskSettings, model string, call func(ctx context.Context, provider ChatProvider, model string) (string, error), committed func() bool) (string, *ProviderUsage, error) {
+	chain := l.chatProviderChain(settings, model)
+	retries := resolveProviderRetries(settings)
+	usage := &ProviderUsage{}
+	var lastErr error
+
+	for _, candidate := range chain {
+		if !l.breaker.allow(candidate.breakerKey()) {
+			usage.Skipped = append(usage.Skipped, candidate.label())
+			continue
+		}
+		provider, err := l.registry.Chat(candidate.providerType, candidate.cfg)
+		if err != nil {
+			l.breaker.release(candidate.breakerKey())
+			usage.Failed = append(usage.Failed, candidate.label()+": "+err.Error())
+			lastErr = err
+			continue
+		}
+
+		for attempt := 0; attempt <= retries; attempt++ {
+			if attempt > 0 {
+				if err := sleepWithContext(ctx, providerBackoff(attempt)); err != nil {
+					return "", usage, err
+				}
+			}
+			usage.Attempts++
+			var answer string
+			answer, err = call(ctx, provider, candidate.model)
+			if err == nil {
+				l.breaker.success(candidate.breakerKey())
+				usage.Provider = candidate.scope
+				usage.Type = candidate.providerType
+				usage.Model = candidate.model
+				usage.ChainIndex = candidate.index
+				usage.FallbackUsed = candidate.index > 0
+				return answer, usage, nil
+			}
+			// Отмена, срок ответа или уже отправленный клиенту текст — дальше не пробуем.
+			if ctx.Err() != nil || errors.Is(err, ErrAnswerTimeout) || (committed != nil && committed()) {
+				l.breaker.release(candidate.breakerKey())
+				usage.Provider = candidate.scope
+				usage.Type = candidate.providerType
+				usage.Model = candidate.model
+				usage.ChainIndex = candidate.index
+				return answer, usage, err
+			}
+			log.Printf("Chat provider %s (%s) attempt %d failed: %v", candidate.label(), candidate.cfg.BaseURL, attempt+1, err)
+			if !isRetryableProviderError(err) {
+				break
+			}
+		}
+
+		lastErr = err
+		usage.Failed = append(usage.Failed, candidate.label()+": "+truncateByRunes(err.Error(), providerErrorMaxRunes))
+		if !isRetryableProviderError(err) {
+			l.breaker.release(candidate.breakerKey())
+		} else if l.breaker.failure(candidate.breakerKey()) {
+			log.Printf("Circuit breaker open for %s (%s), cooldown %s", candidate.label(), candidate.cfg.BaseURL, l.breaker.cooldown)
+		}
+		logProviderDiagnostic("Chat", candidate.cfg)
+	}
+
+	if lastErr == nil {
+		return "", usage, fmt.Errorf("%w: circuit open for %s", ErrProvidersUnavailable, strings.Join(usage.Skipped, ", "))
+	}
+	return "", usage, fmt.Errorf("%w: %v", ErrProvidersUnavailable, lastErr)
+}
+
+func providerBackoff(attempt int) time.Duration {
+	delay := providerBackoffBase << (attempt - 1)
+	if delay <= 0 || delay > providerBackoffMax {
+		return providerBackoffMax
+	}
+	return delay
+}
+
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// isRetryableProviderError — временные ошибки: сеть, таймаут бэкенда, 408, 429 и 5xx.
+func isRetryableProviderError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, ErrAnswerTimeout) {
+		return false
+	}
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return retryableStatus(apiErr.HTTPStatusCode)
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return retryableStatus(reqErr.HTTPStatusCode)
+	}
+	var statusErr *providerStatusError
+	if errors.As(err, &statusErr) {
+		return retryableStatus(statusErr.statusCode)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+func retryableStatus(code int) bool {
+	return code == 408 || code == 429 || code >= 500
+}
+
+// circuitBreaker пропускает адреса, у которых подряд failures временных ошибок, на время cooldown.
+// После cooldown breaker полуоткрыт: проходит ровно одна пробная попытка, остальные запросы
+// пропускают адрес, пока она не завершится. Успех закрывает breaker, временная ошибка снова открывает.
+// Состояния хранятся в LRU ограниченного размера, так что число адресов из настроек чатов не влияет на память.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	states    *lruCache[string, *breakerState]
+}
+
+type breakerState struct {
+	failures  int
+	openUntil time.Time
+	probing   bool // полуоткрытое состояние: пробная попытка уже выполняется
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		threshold = defaultBreakerFailures
+	}
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		states:    newLRUCache[string, *breakerState](breakerStateCacheSize, 0),
+	}
+}
+
+// allow сообщает, можно ли обратиться к адресу. В полуоткрытом состоянии разрешение получает
+// только первый вызывающий; он обязан завершить попытку через success, failure или release.
+func (b *circuitBreaker) allow(key string) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state, ok := b.states.Get(key)
+	if !ok || state.failures < b.threshold {
+		return true
+	}
+	if time.Now().Before(state.openUntil) || state.probing {
+		return false
+	}
+	state.probing = true
+	return true
+}
+
+func (b *circuitBreaker) success(key string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.states.Delete(key)
+}
+
+// failure учитывает временную ошибку и возвращает true, если breaker (снова) открылся.
+func (b *circuitBreaker) failure(key string) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state, ok := b.states.Get(key)
+	if !ok {
+		state = &breakerState{}
+		b.states.Put(key, state)
+	}
+	state.probing = false
+	state.failures++
+	if state.failures < b.threshold {
+		return false
+	}
+	state.openUntil = time.Now().Add(b.cooldown)
+	return true
+}
+
+// release завершает попытку, не давшую вывода о здоровье адреса (отмена, постоянная ошибка):
+// breaker остаётся в прежнем состоянии, следующий запрос сможет выполнить пробу.
+func (b *circuitBreaker) release(key string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if state, ok := b.states.Get(key); ok {
+		state.probing = false
+	}
+}
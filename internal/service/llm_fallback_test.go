@@ -0,0 +1,68 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerHalfOpenAdmitsSingleProbe(t *testing.T) {
+	b := newCircuitBreaker(2, 20*time.Millisecond)
+	const key = "backend"
+
+	if b.failure(key) {
+		t.Fatal("breaker opened before reaching the threshold")
+	}
+	if !b.allow(key) {
+		t.Fatal("closed breaker must admit requests")
+	}
+	if !b.failure(key) {
+		t.Fatal("breaker must open at the threshold")
+	}
+	if b.allow(key) {
+		t.Fatal("open breaker must reject requests during cooldown")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !b.allow(key) {
+		t.Fatal("half-open breaker must admit a probe")
+	}
+	if b.allow(key) {
+		t.Fatal("half-open breaker must admit only one in-flight probe")
+	}
+
+	// Проба без вывода о здоровье адреса возвращает право на пробу следующему запросу.
+	b.release(key)
+	if !b.allow(key) {
+		t.Fatal("released probe must let the next request probe")
+	}
+	if !b.failure(key) {
+		t.Fatal("failed probe must reopen the breaker")
+	}
+	if b.allow(key) {
+		t.Fatal("reopened breaker must reject requests")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !b.allow(key) {
+		t.Fatal("half-open breaker must admit a probe")
+	}
+	b.success(key)
+	if !b.allow(key) || !b.allow(key) {
+		t.Fatal("successful probe must close the breaker")
+	}
+}
+
+func TestProviderCandidateBreakerKeyHidesAPIKey(t *testing.T) {
+	a := providerCandidate{cfg: ProviderConfig{BaseURL: "http://llm:8080/", APIKey: "sk-secret"}}
+	b := providerCandidate{cfg: ProviderConfig{BaseURL: "http://llm:8080", APIKey: "sk-secret"}}
+	if a.breakerKey() != b.breakerKey() {
+		t.Fatal("trailing slash must not change the breaker key")
+	}
+	other := providerCandidate{cfg: ProviderConfig{BaseURL: "http://llm:8080", APIKey: "sk-other"}}
+	if a.breakerKey() == other.breakerKey() {
+		t.Fatal("different API keys must get separate breaker states")
+	}
+	if len(a.breakerKey()) != 64 {
+		t.Fatalf("breaker key must be a sha256 hex digest, got %q", a.breakerKey())
+	}
+}
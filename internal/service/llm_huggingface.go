@@ -0,0 +1,135 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Генерация идёт через OpenAI-совместимый роутер, эмбеддинги — через pipeline feature-extraction
+// по одному тексту на запрос.
+var huggingFaceCapabilities = ProviderCapabilities{Streaming: true, Batching: false, ReasoningEffort: false}
+
+// huggingFaceProvider — Hugging Face Inference API / Router.
+type huggingFaceProvider struct {
+	chat    *openAIProvider
+	baseURL string
+	http    *http.Client
+}
+
+func newHuggingFaceProvider(cfg ProviderConfig) *huggingFaceProvider {
+	base := strings.TrimSpace(cfg.BaseURL)
+	if base == "" {
+		base = "https://router.huggingface.co"
+	}
+	return &huggingFaceProvider{
+		chat:    newOpenAICompatibleProvider(ProviderTypeHuggingFace, normalizeAPIBase(base), cfg.APIKey, huggingFaceCapabilities),
+		baseURL: base,
+		http:    newProviderHTTPClient(cfg.APIKey, embeddingHTTPTimeout),
+	}
+}
+
+func (p *huggingFaceProvider) Type() string                       { return ProviderTypeHuggingFace }
+func (p *huggingFaceProvider) Capabilities() ProviderCapabilities { return huggingFaceCapabilities }
+
+func (p *huggingFaceProvider) Chat(ctx context.Context, req ChatRequest) (ChatResponse, error) {
+	return p.chat.Chat(ctx, req)
+}
+
+func (p *huggingFaceProvider) ChatStream(ctx context.Context, req ChatRequest, onDelta func(string) error) (string, error) {
+	return p.chat.ChatStream(ctx, req, onDelta)
+}
+
+func (p *huggingFaceProvider) Embed(ctx context.Context, model string, inputs []string) ([][]float32, error) {
+	if model == "" {
+		return nil, fmt.Errorf("model name is empty for HF embedding")
+	}
+	endpoint := p.featureExtractionEndpoint(model)
+	return embedEach(ctx, inputs, func(ctx context.Context, text string) ([]float32, error) {
+		return p.embed(ctx, endpoint, text)
+	})
+}
+
+// featureExtractionEndpoint строит адрес pipeline feature-extraction для модели;
+// если в настройках уже указан полный путь к pipeline, он используется как есть.
+func (p *huggingFaceProvider) featureExtractionEndpoint(modelName string) string {
+	lb := strings.ToLower(p.baseURL)
+	esc := strings.ReplaceAll(url.PathEscape(modelName), "%2F", "/")
+	switch {
+	case strings.Contains(lb, "/hf-inference/") || strings.Contains(lb, "/pipeline/"):
+		return p.baseURL
+	case strings.Contains(lb, "router.huggingface.co"):
+		return fmt.Sprintf("https://router.huggingface.co/hf-inference/models/%s/pipeline/feature-extraction", esc)
+	case strings.Contains(lb, "api-inference.huggingface.co"):
+		return fmt.Sprintf("https://api-inference.huggingface.co/models/%s/pipeline/feature-extraction", esc)
+	default:
+		return strings.TrimRight(p.baseURL, "/") + "/hf-inference/models/" + esc + "/pipeline/feature-extraction"
+	}
+}
+
+func (p *huggingFaceProvider) embed(ctx context.Context, endpoint, text string) ([]float32, error) {
+	bodyBytes, _ := json.Marshal(map[string]interface{}{"inputs": text})
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, providerStatusBodyLimit))
+		return nil, &providerStatusError{provider: "hf", path: "feature-extraction", status: resp.Status, statusCode: resp.StatusCode, body: string(b)}
+	}
+	b, _ := io.ReadAll(resp.Body)
+
+	var parsed interface{}
+	if err := json.Unmarshal(b, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse hf embedding response: %v body=%s", err, string(b))
+	}
+	floats, ok := firstFloatVector(parsed)
+	if !ok {
+		return nil, fmt.Errorf("unexpected hf embedding response format: %s", string(b))
+	}
+	return floats, nil
+}
+
+// firstFloatVector находит первый массив чисел во вложенном JSON: HF и llama.cpp возвращают
+// эмбеддинг как [..], [[..]] или [{"embedding": [[..]]}] в зависимости от модели и версии.
+func firstFloatVector(v interface{}) ([]float32, bool) {
+	switch x := v.(type) {
+	case []interface{}:
+		if len(x) == 0 {
+			return nil, false
+		}
+		if _, isNumber := x[0].(float64); isNumber {
+			floats := make([]float32, len(x))
+			for i, item := range x {
+				num, ok := item.(float64)
+				if !ok {
+					return nil, false
+				}
+				floats[i] = float32(num)
+			}
+			return floats, true
+		}
+		for _, item := range x {
+			if floats, ok := firstFloatVector(item); ok {
+				return floats, true
+			}
+		}
+	case map[string]interface{}:
+		if inner, ok := x["embedding"]; ok {
+			return firstFloatVector(inner)
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHuggingFaceProviderChat(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer hf_token" {
+			t.Errorf("Authorization = %q", got)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, chatCompletionJSON("ответ", "stop"))
+	}))
+	defer srv.Close()
+
+	resp, err := newHuggingFaceProvider(ProviderConfig{BaseURL: srv.URL, APIKey: "hf_token"}).Chat(context.Background(), testChatRequest)
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if resp.Content != "ответ" || resp.FinishReason != "stop" {
+		t.Fatalf("response = %+v", resp)
+	}
+}
+
+func TestHuggingFaceProviderChatStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeSSE(w, streamChunk("Hel", ""), streamChunk("lo", "stop"), "[DONE]")
+	}))
+	defer srv.Close()
+
+	text, finish, err := collectStream(newHuggingFaceProvider(ProviderConfig{BaseURL: srv.URL}), testChatRequest)
+	if err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+	if text != "Hello" || finish != "stop" {
+		t.Fatalf("got %q / %q", text, finish)
+	}
+}
+
+func TestHuggingFaceProviderChatStreamMidStreamError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeSSE(w, streamChunk("partial", ""), `{"error":{"message":"Model is overloaded"}}`)
+	}))
+	defer srv.Close()
+
+	text, _, err := collectStream(newHuggingFaceProvider(ProviderConfig{BaseURL: srv.URL}), testChatRequest)
+	if err == nil || !strings.Contains(err.Error(), "overloaded") {
+		t.Fatalf("expected mid-stream error, got %v", err)
+	}
+	if text != "partial" {
+		t.Fatalf("deltas before the error = %q", text)
+	}
+}
+
+func TestHuggingFaceProviderEmbed(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path != "/hf-inference/models/org/embed-model/pipeline/feature-extraction" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		var body struct {
+			Inputs string `json:"inputs"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		// Модели feature-extraction возвращают вектор с разной вложенностью.
+		if body.Inputs == "a" {
+			io.WriteString(w, `[[0.1,0.2]]`)
+		} else {
+			io.WriteString(w, `[0.3,0.4]`)
+		}
+	}))
+	defer srv.Close()
+
+	vectors, err := newHuggingFaceProvider(ProviderConfig{BaseURL: srv.URL}).Embed(context.Background(), "org/embed-model", []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if len(vectors) != 2 || vectors[0][0] != 0.1 || vectors[1][1] != 0.4 {
+		t.Fatalf("vectors = %v", vectors)
+	}
+	// Пакетных эмбеддингов нет: по запросу на текст.
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Fatalf("requests = %d, want 2", got)
+	}
+}
+
+func TestHuggingFaceProviderStatusErrors(t *testing.T) {
+	cases := []struct {
+		status    int
+		retryable bool
+	}{
+		{http.StatusServiceUnavailable, true},
+		{http.StatusUnauthorized, false},
+	}
+	for _, tc := range cases {
+		t.Run(http.StatusText(tc.status), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				io.WriteString(w, `{"error":"Model org/embed-model is currently loading"}`)
+			}))
+			defer srv.Close()
+			p := newHuggingFaceProvider(ProviderConfig{BaseURL: srv.URL})
+
+			_, err := p.Chat(context.Background(), testChatRequest)
+			requireStatusError(t, err, tc.status, tc.retryable)
+			_, _, err = collectStream(p, testChatRequest)
+			requireStatusError(t, err, tc.status, tc.retryable)
+			_, err = p.Embed(context.Background(), "org/embed-model", []string{"a"})
+			requireStatusError(t, err, tc.status, tc.retryable)
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+var llamaCppCapabilities = ProviderCapabilities{Streaming: true, Batching: false, ReasoningEffort: false, JSONSchema: true, Tools: true}
+
+// llamaCppProvider — llama.cpp server. Генерация идёт через его OpenAI-совместимый /v1/chat/completions
+// (шаблон чата применяет сервер), эмбеддинги — через нативный /embedding.
+type llamaCppProvider struct {
+	chat *openAIProvider
+	root string
+	http *http.Client
+}
+
+func newLlamaCppProvider(cfg ProviderConfig) *llamaCppProvider {
+	root := apiRoot(cfg.BaseURL)
+	if root == "" {
+		root = "http://localhost:8080"
+	}
+	return &llamaCppProvider{
+		chat: newOpenAICompatibleProvider(ProviderTypeLlamaCpp, root+"/v1", cfg.APIKey, llamaCppCapabilities),
+		root: root,
+		http: newProviderHTTPClient(cfg.APIKey, embeddingHTTPTimeout),
+	}
+}
+
+func (p *llamaCppProvider) Type() string                       { return ProviderTypeLlamaCpp }
+func (p *llamaCppProvider) Capabilities() ProviderCapabilities { return llamaCppCapabilities }
+
+func (p *llamaCppProvider) Chat(ctx context.Context, req ChatRequest) (ChatResponse, error) {
+	return p.chat.Chat(ctx, req)
+}
+
+func (p *llamaCppProvider) ChatStream(ctx context.Context, req ChatRequest, onDelta func(string) error) (string, error) {
+	return p.chat.ChatStream(ctx, req, onDelta)
+}
+
+// Embed игнорирует model: llama.cpp server обслуживает одну модель, загруженную при старте.
+func (p *llamaCppProvider) Embed(ctx context.Context, _ string, inputs []string) ([][]float32, error) {
+	return embedEach(ctx, inputs, p.embed)
+}
+
+func (p *llamaCppProvider) embed(ctx context.Context, text string) ([]float32, error) {
+	payload, _ := json.Marshal(map[string]interface{}{"content": text})
+	req, err := http.NewRequestWithContext(ctx, "POST", p.root+"/embedding", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, providerStatusBodyLimit))
+		return nil, &providerStatusError{provider: "llama.cpp", path: "/embedding", status: resp.Status, statusCode: resp.StatusCode, body: string(b)}
+	}
+	b, _ := io.ReadAll(resp.Body)
+
+	// Старые версии сервера возвращают {"embedding": [...]}, новые — [{"index": 0, "embedding": [[...]]}].
+	var parsed interface{}
+	if err := json.Unmarshal(b, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse llama.cpp embedding response: %v body=%s", err, string(b))
+	}
+	floats, ok := firstFloatVector(parsed)
+	if !ok {
+		return nil, fmt.Errorf("unexpected llama.cpp embedding response format: %s", string(b))
+	}
+	return floats, nil
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLlamaCppProviderChat(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, chatCompletionJSON("ответ", "stop"))
+	}))
+	defer srv.Close()
+
+	// Адрес сервера задают и с /v1, и без него.
+	for _, base := range []string{srv.URL, srv.URL + "/v1"} {
+		resp, err := newLlamaCppProvider(ProviderConfig{BaseURL: base}).Chat(context.Background(), testChatRequest)
+		if err != nil {
+			t.Fatalf("Chat(%s): %v", base, err)
+		}
+		if resp.Content != "ответ" || resp.FinishReason != "stop" {
+			t.Fatalf("response = %+v", resp)
+		}
+	}
+}
+
+func TestLlamaCppProviderChatStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeSSE(w, streamChunk("Hel", ""), streamChunk("lo", ""), streamChunk("", "length"), "[DONE]")
+	}))
+	defer srv.Close()
+
+	text, finish, err := collectStream(newLlamaCppProvider(ProviderConfig{BaseURL: srv.URL}), testChatRequest)
+	if err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+	if text != "Hello" || finish != "length" {
+		t.Fatalf("got %q / %q", text, finish)
+	}
+}
+
+func TestLlamaCppProviderChatStreamMidStreamError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeSSE(w, streamChunk("partial", ""), `{"error":{"code":500,"message":"context shift is disabled","type":"server_error"}}`)
+	}))
+	defer srv.Close()
+
+	text, _, err := collectStream(newLlamaCppProvider(ProviderConfig{BaseURL: srv.URL}), testChatRequest)
+	if err == nil || !strings.Contains(err.Error(), "context shift") {
+		t.Fatalf("expected mid-stream error, got %v", err)
+	}
+	if text != "partial" {
+		t.Fatalf("deltas before the error = %q", text)
+	}
+}
+
+func TestLlamaCppProviderEmbed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/embedding" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		var body struct {
+			Content string `json:"content"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		// Старый и новый форматы ответа сервера.
+		if body.Content == "a" {
+			io.WriteString(w, `{"embedding":[0.1,0.2]}`)
+		} else {
+			io.WriteString(w, `[{"index":0,"embedding":[[0.3,0.4]]}]`)
+		}
+	}))
+	defer srv.Close()
+
+	vectors, err := newLlamaCppProvider(ProviderConfig{BaseURL: srv.URL}).Embed(context.Background(), "", []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if len(vectors) != 2 || vectors[0][1] != 0.2 || vectors[1][0] != 0.3 {
+		t.Fatalf("vectors = %v", vectors)
+	}
+}
+
+func TestLlamaCppProviderStatusErrors(t *testing.T) {
+	cases := []struct {
+		status    int
+		retryable bool
+	}{
+		{http.StatusServiceUnavailable, true},
+		{http.StatusBadRequest, false},
+	}
+	for _, tc := range cases {
+		t.Run(http.StatusText(tc.status), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				io.WriteString(w, `{"error":{"code":503,"message":"Loading model","type":"unavailable_error"}}`)
+			}))
+			defer srv.Close()
+			p := newLlamaCppProvider(ProviderConfig{BaseURL: srv.URL})
+
+			_, err := p.Chat(context.Background(), testChatRequest)
+			requireStatusError(t, err, tc.status, tc.retryable)
+			_, _, err = collectStream(p, testChatRequest)
+			requireStatusError(t, err, tc.status, tc.retryable)
+			_, err = p.Embed(context.Background(), "", []string{"a"})
+			requireStatusError(t, err, tc.status, tc.retryable)
+		})
+	}
+}
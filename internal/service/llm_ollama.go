@@ -0,0 +1,172 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var ollamaCapabilities = ProviderCapabilities{Streaming: true, Batching: true, ReasoningEffort: false, JSONSchema: true}
+
+// ollamaProvider — нативный API Ollama: /api/chat (NDJSON-стрим) и /api/embed (пакетные эмбеддинги).
+type ollamaProvider struct {
+	root       string
+	http       *http.Client
+	embeddings *http.Client
+}
+
+func newOllamaProvider(cfg ProviderConfig) *ollamaProvider {
+	root := apiRoot(cfg.BaseURL)
+	if root == "" {
+		root = "http://localhost:11434"
+	}
+	return &ollamaProvider{
+		root:       root,
+		http:       newProviderHTTPClient(cfg.APIKey, 0),
+		embeddings: newProviderHTTPClient(cfg.APIKey, embeddingHTTPTimeout),
+	}
+}
+
+func (p *ollamaProvider) Type() string                       { return ProviderTypeOllama }
+func (p *ollamaProvider) Capabilities() ProviderCapabilities { return ollamaCapabilities }
+
+type ollamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type ollamaChatRequest struct {
+	Model    string                 `json:"model"`
+	Messages []ollamaMessage        `json:"messages"`
+	Stream   bool                   `json:"stream"`
+	Options  map[string]interface{} `json:"options,omitempty"`
+	Format   json.RawMessage        `json:"format,omitempty"` // JSON Schema ответа
+}
+
+type ollamaChatChunk struct {
+	Message    ollamaMessage `json:"message"`
+	Done       bool          `json:"done"`
+	DoneReason string        `json:"done_reason"`
+	Error      string        `json:"error"`
+}
+
+func ollamaRequest(req ChatRequest, stream bool) ollamaChatRequest {
+	messages := make([]ollamaMessage, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		messages = append(messages, ollamaMessage{Role: m.Role, Content: m.Content})
+	}
+	options := map[string]interface{}{"temperature": req.Temperature}
+	if req.TopP > 0 {
+		options["top_p"] = req.TopP
+	}
+	if req.MaxTokens > 0 {
+		options["num_predict"] = req.MaxTokens
+	}
+	if req.PresencePenalty != 0 {
+		options["presence_penalty"] = req.PresencePenalty
+	}
+	return ollamaChatRequest{Model: req.Model, Messages: messages, Stream: stream, Options: options, Format: req.JSONSchema}
+}
+
+func (p *ollamaProvider) post(ctx context.Context, client *http.Client, path string, body interface{}) (*http.Response, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", p.root+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, providerStatusBodyLimit))
+		return nil, &providerStatusError{provider: "ollama", path: path, status: resp.Status, statusCode: resp.StatusCode, body: string(b)}
+	}
+	return resp, nil
+}
+
+func (p *ollamaProvider) Chat(ctx context.Context, req ChatRequest) (ChatResponse, error) {
+	resp, err := p.post(ctx, p.http, "/api/chat", ollamaRequest(req, false))
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	var chunk ollamaChatChunk
+	if err := json.NewDecoder(resp.Body).Decode(&chunk); err != nil {
+		return ChatResponse{}, fmt.Errorf("failed to parse ollama chat response: %w", err)
+	}
+	if chunk.Error != "" {
+		return ChatResponse{}, fmt.Errorf("ollama chat error: %s", chunk.Error)
+	}
+	return ChatResponse{Content: chunk.Message.Content, FinishReason: chunk.DoneReason}, nil
+}
+
+func (p *ollamaProvider) ChatStream(ctx context.Context, req ChatRequest, onDelta func(string) error) (string, error) {
+	resp, err := p.post(ctx, p.http, "/api/chat", ollamaRequest(req, true))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var chunk ollamaChatChunk
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			return "", fmt.Errorf("failed to parse ollama stream chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			return "", fmt.Errorf("ollama chat error: %s", chunk.Error)
+		}
+		if chunk.Message.Content != "" {
+			if err := onDelta(chunk.Message.Content); err != nil {
+				return "", err
+			}
+		}
+		if chunk.Done {
+			return chunk.DoneReason, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+func (p *ollamaProvider) Embed(ctx context.Context, model string, inputs []string) ([][]float32, error) {
+	resp, err := p.post(ctx, p.embeddings, "/api/embed", map[string]interface{}{"model": model, "input": inputs})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var parsed struct {
+		Embeddings [][]float32 `json:"embeddings"`
+		Error      string      `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse ollama embedding response: %w", err)
+	}
+	if parsed.Error != "" {
+		return nil, fmt.Errorf("ollama embed error: %s", parsed.Error)
+	}
+	if len(parsed.Embeddings) != len(inputs) {
+		return nil, fmt.Errorf("ollama embed: expected %d vectors, got %d", len(inputs), len(parsed.Embeddings))
+	}
+	return parsed.Embeddings, nil
+}
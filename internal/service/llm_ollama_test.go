@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// writeNDJSON отдаёт стрим Ollama: по одному JSON-объекту на строку.
+func writeNDJSON(w http.ResponseWriter, lines ...string) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+func TestOllamaProviderChat(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		var body ollamaChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		if body.Stream || body.Model != "test-model" || len(body.Messages) != 2 || body.Options["num_predict"] != float64(64) {
+			t.Errorf("unexpected request: %+v", body)
+		}
+		io.WriteString(w, `{"message":{"role":"assistant","content":"Привет"},"done":true,"done_reason":"stop"}`)
+	}))
+	defer srv.Close()
+
+	// Адрес с OpenAI-префиксом тоже должен вести в корень нативного API.
+	resp, err := newOllamaProvider(ProviderConfig{BaseURL: srv.URL + "/v1"}).Chat(context.Background(), testChatRequest)
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if resp.Content != "Привет" || resp.FinishReason != "stop" {
+		t.Fatalf("response = %+v", resp)
+	}
+}
+
+func TestOllamaProviderChatStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeNDJSON(w,
+			`{"message":{"role":"assistant","content":"Hel"},"done":false}`,
+			``,
+			`{"message":{"role":"assistant","content":"lo"},"done":false}`,
+			`{"message":{"role":"assistant","content":""},"done":true,"done_reason":"length"}`,
+		)
+	}))
+	defer srv.Close()
+
+	text, finish, err := collectStream(newOllamaProvider(ProviderConfig{BaseURL: srv.URL}), testChatRequest)
+	if err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+	if text != "Hello" || finish != "length" {
+		t.Fatalf("got %q / %q, want %q / %q", text, finish, "Hello", "length")
+	}
+}
+
+func TestOllamaProviderChatStreamMidStreamError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeNDJSON(w,
+			`{"message":{"role":"assistant","content":"partial"},"done":false}`,
+			`{"error":"model runner has unexpectedly stopped"}`,
+		)
+	}))
+	defer srv.Close()
+
+	text, _, err := collectStream(newOllamaProvider(ProviderConfig{BaseURL: srv.URL}), testChatRequest)
+	if err == nil || !strings.Contains(err.Error(), "unexpectedly stopped") {
+		t.Fatalf("expected mid-stream error, got %v", err)
+	}
+	if text != "partial" {
+		t.Fatalf("deltas before the error = %q", text)
+	}
+}
+
+func TestOllamaProviderEmbed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/embed" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		var body struct {
+			Model string   `json:"model"`
+			Input []string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Model != "embed-model" || len(body.Input) == 0 {
+			t.Errorf("unexpected request: %+v", body)
+		}
+		io.WriteString(w, `{"embeddings":[[0.1,0.2],[0.3,0.4]]}`)
+	}))
+	defer srv.Close()
+
+	p := newOllamaProvider(ProviderConfig{BaseURL: srv.URL})
+	vectors, err := p.Embed(context.Background(), "embed-model", []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if len(vectors) != 2 || vectors[1][1] != 0.4 {
+		t.Fatalf("vectors = %v", vectors)
+	}
+
+	// Сервер вернул не столько векторов, сколько текстов.
+	if _, err := p.Embed(context.Background(), "embed-model", []string{"a"}); err == nil {
+		t.Fatal("expected vector count mismatch error")
+	}
+}
+
+func TestOllamaProviderStatusErrors(t *testing.T) {
+	cases := []struct {
+		status    int
+		retryable bool
+	}{
+		{http.StatusInternalServerError, true},
+		{http.StatusNotFound, false},
+	}
+	for _, tc := range cases {
+		t.Run(http.StatusText(tc.status), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				io.WriteString(w, `{"error":"model \"test-model\" not found"}`)
+			}))
+			defer srv.Close()
+			p := newOllamaProvider(ProviderConfig{BaseURL: srv.URL})
+
+			_, err := p.Chat(context.Background(), testChatRequest)
+			requireStatusError(t, err, tc.status, tc.retryable)
+			_, _, err = collectStream(p, testChatRequest)
+			requireStatusError(t, err, tc.status, tc.retryable)
+			_, err = p.Embed(context.Background(), "embed-model", []string{"a"})
+			requireStatusError(t, err, tc.status, tc.retryable)
+		})
+	}
+}
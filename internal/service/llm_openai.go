@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+var openAICapabilities = ProviderCapabilities{Streaming: true, Batching: true, ReasoningEffort: true, JSONSchema: true, Tools: true}
+
+// openAIProvider — OpenAI-совместимый API через go-openai (LM Studio, vLLM, OpenAI, OpenRouter...).
+type openAIProvider struct {
+	client *openai.Client
+	caps   ProviderCapabilities
+	name   string
+}
+
+func newOpenAIProvider(cfg ProviderConfig) *openAIProvider {
+	return newOpenAICompatibleProvider(ProviderTypeOpenAI, normalizeAPIBase(cfg.BaseURL), cfg.APIKey, openAICapabilities)
+}
+
+// newOpenAICompatibleProvider используется и другими бэкендами, у которых генерация совместима с OpenAI
+// (роутер Hugging Face, llama.cpp server), но возможности отличаются.
+func newOpenAICompatibleProvider(name, apiBase, apiKey string, caps ProviderCapabilities) *openAIProvider {
+	key := strings.TrimSpace(apiKey)
+	if key == "" {
+		key = "not-needed"
+	}
+	cfg := openai.DefaultConfig(key)
+	if apiBase != "" {
+		cfg.BaseURL = apiBase
+	}
+	cfg.HTTPClient = newProviderHTTPClient(apiKey, 0)
+	return &openAIProvider{client: openai.NewClientWithConfig(cfg), caps: caps, name: name}
+}
+
+func (p *openAIProvider) Type() string                       { return p.name }
+func (p *openAIProvider) Capabilities() ProviderCapabilities { return p.caps }
+
+func (p *openAIProvider) request(req ChatRequest, stream bool) openai.ChatCompletionRequest {
+	messages := make([]openai.ChatCompletionMessage, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		msg := openai.ChatCompletionMessage{Role: m.Role, Content: m.Content, ToolCallID: m.ToolCallID}
+		for _, call := range m.ToolCalls {
+			msg.ToolCalls = append(msg.ToolCalls, openai.ToolCall{
+				ID:       call.ID,
+				Type:     openai.ToolTypeFunction,
+				Function: openai.FunctionCall{Name: call.Name, Arguments: call.Arguments},
+			})
+		}
+		messages = append(messages, msg)
+	}
+	out := openai.ChatCompletionRequest{
+		Model:           req.Model,
+		Messages:        messages,
+		Temperature:     req.Temperature,
+		TopP:            req.TopP,
+		MaxTokens:       req.MaxTokens,
+		PresencePenalty: req.PresencePenalty,
+		Stream:          stream,
+	}
+	if p.caps.ReasoningEffort {
+		out.ReasoningEffort = req.ReasoningEffort
+	}
+	if p.caps.JSONSchema && len(req.JSONSchema) > 0 {
+		out.ResponseFormat = &openai.ChatCompletionResponseFormat{
+			Type:       openai.ChatCompletionResponseFormatTypeJSONSchema,
+			JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{Name: "answer", Schema: req.JSONSchema},
+		}
+	}
+	if p.caps.Tools {
+		for _, tool := range req.Tools {
+			out.Tools = append(out.Tools, openai.Tool{
+				Type:     openai.ToolTypeFunction,
+				Function: &openai.FunctionDefinition{Name: tool.Name, Description: tool.Description, Parameters: tool.Parameters},
+			})
+		}
+	}
+	return out
+}
+
+// statusError приводит HTTP-ошибку go-openai к providerStatusError, как у нативных бэкендов;
+// ошибка посреди стрима (статус ответа уже 200) и сетевые ошибки возвращаются как есть.
+func (p *openAIProvider) statusError(path string, err error) error {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) && apiErr.HTTPStatusCode != 0 {
+		return &providerStatusError{provider: p.name, path: path, status: apiErr.HTTPStatus, statusCode: apiErr.HTTPStatusCode, body: apiErr.Message}
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) && reqErr.HTTPStatusCode != 0 {
+		body := string(reqErr.Body)
+		if len(body) > providerStatusBodyLimit {
+			body = body[:providerStatusBodyLimit]
+		}
+		return &providerStatusError{provider: p.name, path: path, status: reqErr.HTTPStatus, statusCode: reqErr.HTTPStatusCode, body: body}
+	}
+	return err
+}
+
+func (p *openAIProvider) Chat(ctx context.Context, req ChatRequest) (ChatResponse, error) {
+	resp, err := p.client.CreateChatCompletion(ctx, p.request(req, false))
+	if err != nil {
+		return ChatResponse{}, p.statusError("/chat/completions", err)
+	}
+	if len(resp.Choices) == 0 {
+		return ChatResponse{}, fmt.Errorf("LLM вернул пустой ответ")
+	}
+	choice := resp.Choices[0]
+	out := ChatResponse{Content: choice.Message.Content, FinishReason: string(choice.FinishReason)}
+	for _, call := range choice.Message.ToolCalls {
+		out.ToolCalls = append(out.ToolCalls, ToolCall{ID: call.ID, Name: call.Function.Name, Arguments: call.Function.Arguments})
+	}
+	return out, nil
+}
+
+func (p *openAIProvider) ChatStream(ctx context.Context, req ChatRequest, onDelta func(string) error) (string, error) {
+	stream, err := p.client.CreateChatCompletionStream(ctx, p.request(req, true))
+	if err != nil {
+		return "", p.statusError("/chat/completions", err)
+	}
+	defer stream.Close()
+
+	finishReason := ""
+	for {
+		resp, recvErr := stream.Recv()
+		if errors.Is(recvErr, io.EOF) {
+			return finishReason, nil
+		}
+		if recvErr != nil {
+			return "", recvErr
+		}
+		if len(resp.Choices) == 0 {
+			continue
+		}
+		choice := resp.Choices[0]
+		if choice.FinishReason != "" {
+			finishReason = string(choice.FinishReason)
+		}
+		if choice.Delta.Content == "" {
+			continue
+		}
+		if err := onDelta(choice.Delta.Content); err != nil {
+			return "", err
+		}
+	}
+}
+
+func (p *openAIProvider) Embed(ctx context.Context, model string, inputs []string) ([][]float32, error) {
+	resp, err := p.client.CreateEmbeddings(ctx, openai.EmbeddingRequest{Model: openai.EmbeddingModel(model), Input: inputs})
+	if err != nil {
+		return nil, p.statusError("/embeddings", err)
+	}
+	out := make([][]float32, len(inputs))
+	for i, item := range resp.Data {
+		idx := item.Index
+		if idx < 0 || idx >= len(out) {
+			idx = i
+		}
+		if idx < len(out) {
+			out[idx] = item.Embedding
+		}
+	}
+	return out, nil
+}
+
+// listModels — список моделей OpenAI-совместимого сервера (GET /models).
+func (p *openAIProvider) listModels(ctx context.Context) ([]openai.Model, error) {
+	resp, err := p.client.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Models, nil
+}
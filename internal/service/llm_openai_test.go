@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// writeSSE отдаёт события в формате Server-Sent Events так же, как OpenAI-совместимые серверы.
+func writeSSE(w http.ResponseWriter, events ...string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	for _, event := range events {
+		fmt.Fprintf(w, "data: %s\n\n", event)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+func streamChunk(content, finishReason string) string {
+	choice := map[string]interface{}{"index": 0, "delta": map[string]string{"content": content}}
+	if finishReason != "" {
+		choice["finish_reason"] = finishReason
+	}
+	b, _ := json.Marshal(map[string]interface{}{"choices": []interface{}{choice}})
+	return string(b)
+}
+
+func chatCompletionJSON(content, finishReason string) string {
+	b, _ := json.Marshal(map[string]interface{}{
+		"choices": []interface{}{map[string]interface{}{
+			"index":         0,
+			"message":       map[string]string{"role": "assistant", "content": content},
+			"finish_reason": finishReason,
+		}},
+	})
+	return string(b)
+}
+
+// collectStream собирает фрагменты ответа стрима.
+func collectStream(p ChatProvider, req ChatRequest) (string, string, error) {
+	var b strings.Builder
+	finish, err := p.ChatStream(context.Background(), req, func(delta string) error {
+		b.WriteString(delta)
+		return nil
+	})
+	return b.String(), finish, err
+}
+
+// requireStatusError проверяет, что ошибка бэкенда приведена к providerStatusError с нужным кодом.
+func requireStatusError(t *testing.T, err error, code int, retryable bool) {
+	t.Helper()
+	var statusErr *providerStatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("expected providerStatusError, got %T: %v", err, err)
+	}
+	if statusErr.statusCode != code {
+		t.Fatalf("status code = %d, want %d", statusErr.statusCode, code)
+	}
+	if got := isRetryableProviderError(err); got != retryable {
+		t.Fatalf("isRetryableProviderError = %v, want %v", got, retryable)
+	}
+}
+
+var testChatRequest = ChatRequest{
+	Model:       "test-model",
+	Messages:    []ChatMessage{{Role: "system", Content: "sys"}, {Role: "user", Content: "question"}},
+	Temperature: 0.2,
+	MaxTokens:   64,
+}
+
+func TestOpenAIProviderChat(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q", got)
+		}
+		var body struct {
+			Model    string `json:"model"`
+			Stream   bool   `json:"stream"`
+			Messages []struct {
+				Role    string `json:"role"`
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		if body.Model != "test-model" || body.Stream || len(body.Messages) != 2 || body.Messages[1].Content != "question" {
+			t.Errorf("unexpected request: %+v", body)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"choices":[{"index":0,"finish_reason":"tool_calls","message":{"role":"assistant","content":"",`+
+			`"tool_calls":[{"id":"call_1","type":"function","function":{"name":"calculator","arguments":"{\"expression\":\"2+2\"}"}}]}}]}`)
+	}))
+	defer srv.Close()
+
+	resp, err := newOpenAIProvider(ProviderConfig{BaseURL: srv.URL, APIKey: "secret"}).Chat(context.Background(), testChatRequest)
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if resp.FinishReason != "tool_calls" {
+		t.Fatalf("finish reason = %q", resp.FinishReason)
+	}
+	if len(resp.ToolCalls) != 1 || resp.ToolCalls[0].ID != "call_1" || resp.ToolCalls[0].Name != "calculator" || resp.ToolCalls[0].Arguments != `{"expression":"2+2"}` {
+		t.Fatalf("tool calls = %+v", resp.ToolCalls)
+	}
+}
+
+func TestOpenAIProviderChatStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeSSE(w, streamChunk("Hel", ""), streamChunk("lo", ""), streamChunk("", "length"), "[DONE]")
+	}))
+	defer srv.Close()
+
+	text, finish, err := collectStream(newOpenAIProvider(ProviderConfig{BaseURL: srv.URL}), testChatRequest)
+	if err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+	if text != "Hello" || finish != "length" {
+		t.Fatalf("got %q / %q, want %q / %q", text, finish, "Hello", "length")
+	}
+}
+
+func TestOpenAIProviderChatStreamMidStreamError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeSSE(w, streamChunk("partial", ""), `{"error":{"message":"model overloaded","type":"server_error"}}`)
+	}))
+	defer srv.Close()
+
+	text, _, err := collectStream(newOpenAIProvider(ProviderConfig{BaseURL: srv.URL}), testChatRequest)
+	if err == nil || !strings.Contains(err.Error(), "model overloaded") {
+		t.Fatalf("expected mid-stream error, got %v", err)
+	}
+	if text != "partial" {
+		t.Fatalf("deltas before the error = %q", text)
+	}
+	var statusErr *providerStatusError
+	if errors.As(err, &statusErr) {
+		t.Fatalf("mid-stream error must not look like an HTTP status error: %v", err)
+	}
+}
+
+func TestOpenAIProviderEmbed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/embeddings" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		var body struct {
+			Input []string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if len(body.Input) != 2 {
+			t.Errorf("inputs = %v", body.Input)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		// Порядок в ответе не обязан совпадать с порядком входов.
+		io.WriteString(w, `{"data":[{"index":1,"embedding":[0.3,0.4]},{"index":0,"embedding":[0.1,0.2]}]}`)
+	}))
+	defer srv.Close()
+
+	vectors, err := newOpenAIProvider(ProviderConfig{BaseURL: srv.URL}).Embed(context.Background(), "embed-model", []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if len(vectors) != 2 || vectors[0][0] != 0.1 || vectors[1][0] != 0.3 {
+		t.Fatalf("vectors = %v", vectors)
+	}
+}
+
+func TestOpenAIProviderStatusErrors(t *testing.T) {
+	cases := []struct {
+		name      string
+		status    int
+		body      string
+		retryable bool
+	}{
+		{"json error 503", http.StatusServiceUnavailable, `{"error":{"message":"busy","type":"server_error"}}`, true},
+		{"json error 429", http.StatusTooManyRequests, `{"error":{"message":"rate limited","type":"rate_limit"}}`, true},
+		{"json error 400", http.StatusBadRequest, `{"error":{"message":"bad model","type":"invalid_request_error"}}`, false},
+		{"plain text 502", http.StatusBadGateway, `bad gateway`, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				io.WriteString(w, tc.body)
+			}))
+			defer srv.Close()
+			p := newOpenAIProvider(ProviderConfig{BaseURL: srv.URL})
+
+			_, err := p.Chat(context.Background(), testChatRequest)
+			requireStatusError(t, err, tc.status, tc.retryable)
+			_, _, err = collectStream(p, testChatRequest)
+			requireStatusError(t, err, tc.status, tc.retryable)
+			_, err = p.Embed(context.Background(), "embed-model", []string{"a"})
+			requireStatusError(t, err, tc.status, tc.retryable)
+		})
+	}
+}
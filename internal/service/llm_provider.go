@@ -0,0 +1,395 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/katakuxiko/Diplom/internal/models"
+)
+
+// Типы бэкендов LLM (AskSettings.ProviderType / EmbedProviderType).
+const (
+	ProviderTypeOpenAI      = "openai"      // любой OpenAI-совместимый API (LM Studio, vLLM, OpenAI, OpenRouter...)
+	ProviderTypeHuggingFace = "huggingface" // Hugging Face Inference / Router
+	ProviderTypeOllama      = "ollama"      // нативный API Ollama (/api/chat, /api/embed)
+	ProviderTypeLlamaCpp    = "llamacpp"    // llama.cpp server (/v1/chat/completions, /embedding)
+)
+
+const embeddingHTTPTimeout = 30 * time.Second
+
+// ChatMessage — сообщение диалога в формате, не зависящем от бэкенда.
+// ToolCalls — вызовы инструментов в ответе ассистента, ToolCallID — ответ инструмента (role "tool").
+type ChatMessage struct {
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+}
+
+// ToolDefinition — инструмент, который модель может вызвать; Parameters — JSON Schema аргументов.
+type ToolDefinition struct {
+	Name        string
+	Description string
+	Parameters  json.RawMessage
+}
+
+// ToolCall — вызов инструмента моделью; Arguments — JSON-объект аргументов.
+type ToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// ChatRequest — параметры генерации, общие для всех бэкендов.
+// ReasoningEffort, JSONSchema и Tools передаются только провайдерам с соответствующей возможностью.
+type ChatRequest struct {
+	Model           string
+	Messages        []ChatMessage
+	Temperature     float32
+	TopP            float32
+	MaxTokens       int
+	PresencePenalty float32
+	ReasoningEffort string
+	JSONSchema      json.RawMessage // генерация по схеме; nil — свободный текст
+	Tools           []ToolDefinition
+}
+
+// ChatResponse — ответ модели и причина остановки ("stop", "length", "tool_calls", ...).
+type ChatResponse struct {
+	Content      string
+	FinishReason string
+	ToolCalls    []ToolCall
+}
+
+// ProviderCapabilities — что умеет конкретный бэкенд.
+type ProviderCapabilities struct {
+	Streaming       bool `json:"streaming"`
+	Batching        bool `json:"batching"` // несколько текстов в одном запросе эмбеддингов
+	ReasoningEffort bool `json:"reasoningEffort"`
+	JSONSchema      bool `json:"jsonSchema"` // генерация по JSON Schema (response_format / format)
+	Tools           bool `json:"tools"`      // вызов инструментов (OpenAI function calling)
+}
+
+// ChatProvider генерирует ответы модели.
+type ChatProvider interface {
+	Type() string
+	Capabilities() ProviderCapabilities
+	Chat(ctx context.Context, req ChatRequest) (ChatResponse, error)
+	// ChatStream передаёт фрагменты ответа в onDelta по мере генерации и возвращает причину остановки.
+	ChatStream(ctx context.Context, req ChatRequest, onDelta func(string) error) (string, error)
+}
+
+// EmbeddingProvider считает эмбеддинги; результат возвращается в порядке inputs.
+type EmbeddingProvider interface {
+	Type() string
+	Capabilities() ProviderCapabilities
+	Embed(ctx context.Context, model string, inputs []string) ([][]float32, error)
+}
+
+// ProviderConfig — адрес и ключ, с которыми создаётся провайдер.
+type ProviderConfig struct {
+	BaseURL string
+	APIKey  string
+}
+
+type ChatProviderFactory func(cfg ProviderConfig) ChatProvider
+type EmbeddingProviderFactory func(cfg ProviderConfig) EmbeddingProvider
+
+// ProviderInfo описывает зарегистрированный бэкенд для GET /providers.
+type ProviderInfo struct {
+	Type         string               `json:"type"`
+	Chat         bool                 `json:"chat"`
+	Embeddings   bool                 `json:"embeddings"`
+	Capabilities ProviderCapabilities `json:"capabilities"`
+}
+
+// providerInstanceCacheSize — сколько созданных клиентов держит реестр; адрес и ключ задаются
+// в настройках каждого чата, поэтому кэш ограничен и давно не используемые клиенты вытесняются.
+const providerInstanceCacheSize = 256
+
+// ProviderRegistry хранит фабрики бэкендов по типу и кэширует созданные клиенты по (тип, адрес, ключ).
+type ProviderRegistry struct {
+	mu        sync.RWMutex
+	chat      map[string]ChatProviderFactory
+	embedding map[string]EmbeddingProviderFactory
+	caps      map[string]ProviderCapabilities
+	instances *lruCache[string, interface{}]
+}
+
+// NewProviderRegistry создаёт реестр со встроенными бэкендами: openai, huggingface, ollama, llamacpp.
+func NewProviderRegistry() *ProviderRegistry {
+	r := &ProviderRegistry{
+		chat:      make(map[string]ChatProviderFactory),
+		embedding: make(map[string]EmbeddingProviderFactory),
+		caps:      make(map[string]ProviderCapabilities),
+		instances: newLRUCache[string, interface{}](providerInstanceCacheSize, 0),
+	}
+	r.Register(ProviderTypeOpenAI, openAICapabilities,
+		func(cfg ProviderConfig) ChatProvider { return newOpenAIProvider(cfg) },
+		func(cfg ProviderConfig) EmbeddingProvider { return newOpenAIProvider(cfg) })
+	r.Register(ProviderTypeHuggingFace, huggingFaceCapabilities,
+		func(cfg ProviderConfig) ChatProvider { return newHuggingFaceProvider(cfg) },
+		func(cfg ProviderConfig) EmbeddingProvider { return newHuggingFaceProvider(cfg) })
+	r.Register(ProviderTypeOllama, ollamaCapabilities,
+		func(cfg ProviderConfig) ChatProvider { return newOllamaProvider(cfg) },
+		func(cfg ProviderConfig) EmbeddingProvider { return newOllamaProvider(cfg) })
+	r.Register(ProviderTypeLlamaCpp, llamaCppCapabilities,
+		func(cfg ProviderConfig) ChatProvider { return newLlamaCppProvider(cfg) },
+		func(cfg ProviderConfig) EmbeddingProvider { return newLlamaCppProvider(cfg) })
+	return r
+}
+
+// Register добавляет (или заменяет) бэкенд; любая из фабрик может быть nil.
+func (r *ProviderRegistry) Register(providerType string, caps ProviderCapabilities, chat ChatProviderFactory, embedding EmbeddingProviderFactory) {
+	providerType = strings.ToLower(strings.TrimSpace(providerType))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if chat != nil {
+		r.chat[providerType] = chat
+	}
+	if embedding != nil {
+		r.embedding[providerType] = embedding
+	}
+	r.caps[providerType] = caps
+	r.instances.DeleteFunc(func(key string) bool { return strings.HasPrefix(key, providerType+"|") })
+}
+
+// Chat возвращает провайдер генерации указанного типа.
+func (r *ProviderRegistry) Chat(providerType string, cfg ProviderConfig) (ChatProvider, error) {
+	key := providerInstanceKey("chat", providerType, cfg)
+	if p, ok := r.cached(key).(ChatProvider); ok {
+		return p, nil
+	}
+	r.mu.RLock()
+	factory, ok := r.chat[providerType]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown chat provider type %q", providerType)
+	}
+	p := factory(cfg)
+	r.store(key, p)
+	return p, nil
+}
+
+// Embedding возвращает провайдер эмбеддингов указанного типа.
+func (r *ProviderRegistry) Embedding(providerType string, cfg ProviderConfig) (EmbeddingProvider, error) {
+	key := providerInstanceKey("embedding", providerType, cfg)
+	if p, ok := r.cached(key).(EmbeddingProvider); ok {
+		return p, nil
+	}
+	r.mu.RLock()
+	factory, ok := r.embedding[providerType]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown embedding provider type %q", providerType)
+	}
+	p := factory(cfg)
+	r.store(key, p)
+	return p, nil
+}
+
+// Capabilities возвращает возможности зарегистрированного бэкенда.
+func (r *ProviderRegistry) Capabilities(providerType string) (ProviderCapabilities, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	caps, ok := r.caps[providerType]
+	return caps, ok
+}
+
+// List возвращает зарегистрированные бэкенды с их возможностями.
+func (r *ProviderRegistry) List() []ProviderInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]ProviderInfo, 0, len(r.caps))
+	for providerType, caps := range r.caps {
+		_, chat := r.chat[providerType]
+		_, embedding := r.embedding[providerType]
+		out = append(out, ProviderInfo{Type: providerType, Chat: chat, Embeddings: embedding, Capabilities: caps})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Type < out[j].Type })
+	return out
+}
+
+func (r *ProviderRegistry) cached(key string) interface{} {
+	p, _ := r.instances.Get(key)
+	return p
+}
+
+func (r *ProviderRegistry) store(key string, p interface{}) {
+	r.instances.Put(key, p)
+}
+
+// providerInstanceKey — тип бэкенда открыто (по нему Register сбрасывает клиенты), адрес и ключ — хэшем,
+// чтобы ключи API не хранились в памяти реестра в открытом виде.
+func providerInstanceKey(kind, providerType string, cfg ProviderConfig) string {
+	return providerType + "|" + kind + "|" + hashProviderTarget(cfg)
+}
+
+// hashProviderTarget — sha256 адреса и ключа бэкенда.
+func hashProviderTarget(cfg ProviderConfig) string {
+	sum := sha256.Sum256([]byte(cfg.BaseURL + "\x00" + cfg.APIKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeProviderType приводит тип бэкенда к каноническому виду; пустая строка — тип не задан.
+func normalizeProviderType(value string) string {
+	v := strings.ToLower(strings.TrimSpace(value))
+	switch v {
+	case "openai", "openai-compatible", "openai_compatible", "lmstudio", "lm-studio":
+		return ProviderTypeOpenAI
+	case "huggingface", "hf", "hugging-face":
+		return ProviderTypeHuggingFace
+	case "ollama":
+		return ProviderTypeOllama
+	case "llamacpp", "llama.cpp", "llama-cpp", "llama_cpp":
+		return ProviderTypeLlamaCpp
+	default:
+		return v
+	}
+}
+
+// chatProviderTarget — тип бэкенда, адрес и ключ для генерации с учётом настроек чата.
+// Тип задаётся явно (ProviderType); если он не задан, используется тип локального сервера
+// для provider=local и OpenAI-совместимый API для provider=external.
+func (l *LLMClient) chatProviderTarget(s *models.AskSettings) (string, ProviderConfig) {
+	if resolveChatProvider(s) == "external" {
+		cfg := ProviderConfig{BaseURL: strings.TrimSpace(s.ExternalBaseURL), APIKey: strings.TrimSpace(s.ExternalAPIKey)}
+		if cfg.BaseURL == "" {
+			cfg.BaseURL = l.baseURL
+		}
+		providerType := normalizeProviderType(s.ProviderType)
+		if providerType == "" {
+			providerType = ProviderTypeOpenAI
+		}
+		return providerType, cfg
+	}
+
+	providerType := l.providerType
+	if s != nil && normalizeProviderType(s.ProviderType) != "" {
+		providerType = normalizeProviderType(s.ProviderType)
+	}
+	return providerType, ProviderConfig{BaseURL: l.baseURL}
+}
+
+// embeddingProviderTarget — то же для эмбеддингов. Тип бэкенда задаётся только явно (EmbedProviderType);
+// настройки чатов, где Hugging Face раньше распознавался по адресу, переносятся при миграции схемы.
+func (l *LLMClient) embeddingProviderTarget(s *models.AskSettings) (string, ProviderConfig) {
+	cfg := ProviderConfig{BaseURL: l.baseURL}
+	providerType := l.providerType
+	if resolveEmbeddingProvider(s) == "external" {
+		cfg.BaseURL = strings.TrimSpace(s.EmbedExternalBaseURL)
+		if cfg.BaseURL == "" {
+			cfg.BaseURL = strings.TrimSpace(s.ExternalBaseURL)
+		}
+		if cfg.BaseURL == "" {
+			cfg.BaseURL = l.baseURL
+		}
+		cfg.APIKey = strings.TrimSpace(s.EmbedExternalAPIKey)
+		if cfg.APIKey == "" {
+			cfg.APIKey = strings.TrimSpace(s.ExternalAPIKey)
+		}
+		providerType = ProviderTypeOpenAI
+	}
+
+	if s != nil {
+		if explicit := normalizeProviderType(s.EmbedProviderType); explicit != "" {
+			return explicit, cfg
+		}
+	}
+	return providerType, cfg
+}
+
+// ChatProviderFor возвращает провайдер генерации для настроек чата и адрес, к которому он обращается.
+func (l *LLMClient) ChatProviderFor(s *models.AskSettings) (ChatProvider, string, error) {
+	providerType, cfg := l.chatProviderTarget(s)
+	p, err := l.registry.Chat(providerType, cfg)
+	return p, cfg.BaseURL, err
+}
+
+// EmbeddingProviderFor возвращает провайдер эмбеддингов для настроек чата и адрес, к которому он обращается.
+func (l *LLMClient) EmbeddingProviderFor(s *models.AskSettings) (EmbeddingProvider, string, error) {
+	providerType, cfg := l.embeddingProviderTarget(s)
+	p, err := l.registry.Embedding(providerType, cfg)
+	return p, cfg.BaseURL, err
+}
+
+// Providers возвращает зарегистрированные бэкенды и их возможности.
+func (l *LLMClient) Providers() []ProviderInfo {
+	return l.registry.List()
+}
+
+// withReasoningEffort выставляет reasoning effort, если модель его ожидает, а бэкенд поддерживает.
+func withReasoningEffort(req ChatRequest, provider ChatProvider) ChatRequest {
+	if !provider.Capabilities().ReasoningEffort {
+		return req
+	}
+	if effort := reasoningEffortForModel(req.Model); effort != "" {
+		req.ReasoningEffort = effort
+	}
+	return req
+}
+
+// embedOne считает эмбеддинг одного текста.
+func embedOne(ctx context.Context, provider EmbeddingProvider, model, text string) ([]float32, error) {
+	vectors, err := provider.Embed(ctx, model, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) == 0 || len(vectors[0]) == 0 {
+		return nil, fmt.Errorf("%s embedding: empty response", provider.Type())
+	}
+	return vectors[0], nil
+}
+
+// embedEach — запасной путь для бэкендов без пакетного API: по одному запросу на текст.
+func embedEach(ctx context.Context, inputs []string, embed func(context.Context, string) ([]float32, error)) ([][]float32, error) {
+	out := make([][]float32, 0, len(inputs))
+	for _, input := range inputs {
+		vec, err := embed(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, vec)
+	}
+	return out, nil
+}
+
+// newProviderHTTPClient — HTTP клиент с Bearer авторизацией; timeout 0 — без ограничения (для стриминга).
+func newProviderHTTPClient(apiKey string, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &authTransport{apiKey: strings.TrimSpace(apiKey), base: http.DefaultTransport},
+	}
+}
+
+// apiRoot возвращает адрес сервера без OpenAI-префикса "/v1" и пути к endpoint —
+// нативные API Ollama и llama.cpp находятся в корне.
+func apiRoot(raw string) string {
+	u := strings.TrimRight(strings.TrimSpace(raw), "/")
+	if u == "" {
+		return ""
+	}
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		if idx := strings.Index(u, "/v1"); idx != -1 {
+			return strings.TrimRight(u[:idx], "/")
+		}
+		return u
+	}
+	path := parsed.Path
+	for _, marker := range []string{"/v1", "/api/"} {
+		if idx := strings.Index(path, marker); idx != -1 {
+			path = path[:idx]
+		}
+	}
+	return parsed.Scheme + "://" + parsed.Host + strings.TrimRight(path, "/")
+}
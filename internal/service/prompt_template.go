@@ -0,0 +1,266 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/katakuxiko/Diplom/internal/models"
+)
+
+const (
+	defaultAnswerTemperature = float32(0.7)
+	defaultAnswerMaxTokens   = 2000
+	defaultPromptName        = "default"
+
+	defaultSystemPrompt = `Ты - профессиональный аналитик документов. Твоя задача - давать точные, структурированные ответы на основе предоставленных материалов.
+
+КРИТИЧЕСКИЕ ПРАВИЛА:
+1. Используй ТОЛЬКО информацию из КОНТЕКСТА ниже
+2. Не используй знания, полученные во время обучения
+3. Если информация неполная или неоднозначная - явно укажи это
+4. При отсутствии релевантной информации отвечай: "Информация по данному вопросу отсутствует в документах"
+
+ФОРМАТИРОВАНИЕ ОТВЕТА:
+- Структурируй ответ (используй списки, подзаголовки при необходимости)
+- Будь конкретным и информативным
+- Если в контексте есть несколько релевантных фрагментов - синтезируй целостный ответ
+- Избегай упоминаний о "контексте", "документах" или своей природе как ИИ
+- Отвечай прямо на вопрос, без лишних вступлений`
+)
+
+// defaultPromptParts — шаблон по умолчанию; части пользовательского шаблона заменяют соответствующие части.
+var defaultPromptParts = models.PromptParts{
+	System:   defaultSystemPrompt + "\n\n{{.LanguagePolicy}}",
+	User:     "{{.LanguagePolicy}}\n\nCONTEXT:\n{{.Context}}\n\nQUESTION:\n{{.Question}}\n\nANSWER:",
+	Refusal:  `{{if eq .Language "en"}}Unfortunately, no relevant information was found in the uploaded documents for your request. Try rephrasing the question or upload additional materials.{{else}}К сожалению, в загруженных документах не найдено информации по вашему запросу. Попробуйте переформулировать вопрос или загрузите дополнительные материалы.{{end}}`,
+	Greeting: `{{if eq .Language "en"}}Hi! 👋 I can help you find information in the uploaded documents. Ask your question.{{else}}Привет! 👋 Я помогу найти информацию в загруженных документах. Задайте ваш вопрос.{{end}}`,
+}
+
+// PromptVars — переменные, доступные в шаблонах промптов.
+type PromptVars struct {
+	Context        string
+	Question       string
+	LanguagePolicy string
+	Language       string // "ru", "en", "kk" или "same", если язык не определён
+	ChatName       string
+	Date           string // текущая дата, YYYY-MM-DD
+}
+
+// AnswerPrompt — итоговые сообщения и параметры генерации ответа.
+// Для приветствия и других разговорных намерений и для пустого контекста модель не вызывается: ответ — StaticReply.
+type AnswerPrompt struct {
+	Template    string        `json:"template"`
+	Version     int           `json:"version,omitempty"`
+	Kind        string        `json:"kind"` // "answer", "refusal" или разговорное намерение ("greeting", "thanks", ...)
+	StaticReply string        `json:"static_reply,omitempty"`
+	Model       string        `json:"model,omitempty"`
+	MaxTokens   int           `json:"max_tokens,omitempty"`
+	Temperature float32       `json:"temperature,omitempty"`
+	Messages    []ChatMessage `json:"messages,omitempty"`
+	Tokens      *TokenBudget  `json:"tokens,omitempty"`
+	// Схема ответа запроса; передаётся бэкенду, если он поддерживает генерацию по схеме
+	ResponseSchema json.RawMessage `json:"response_schema,omitempty"`
+}
+
+func newPromptVars(query, contextText string, settings *models.AskSettings) PromptVars {
+	vars := PromptVars{
+		Context:        contextText,
+		Question:       query,
+		LanguagePolicy: buildLanguagePolicyInstruction(query),
+		Language:       detectPrimaryQuestionLanguage(query),
+		Date:           time.Now().Format("2006-01-02"),
+	}
+	if settings != nil && settings.Prompt != nil {
+		vars.ChatName = settings.Prompt.ChatName
+	}
+	return vars
+}
+
+func renderPromptPart(name, text string, vars PromptVars) (string, error) {
+	tpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := tpl.Execute(&b, vars); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// ValidatePromptParts проверяет, что все непустые части разбираются и выполняются с тестовыми переменными.
+func ValidatePromptParts(parts models.PromptParts) error {
+	sample := PromptVars{Context: "context", Question: "question", LanguagePolicy: "policy", Language: "ru", ChatName: "chat", Date: "2006-01-02"}
+	for name, text := range map[string]string{"system": parts.System, "user": parts.User, "refusal": parts.Refusal, "greeting": parts.Greeting} {
+		if text == "" {
+			continue
+		}
+		if _, err := renderPromptPart(name, text, sample); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidPromptTemplate, name, err)
+		}
+	}
+	if parts.User != "" && !strings.Contains(parts.User, ".Context") {
+		return fmt.Errorf("%w: user part must include {{.Context}}", ErrInvalidPromptTemplate)
+	}
+	return nil
+}
+
+// renderPart рендерит часть выбранного шаблона; пустая или сломанная часть заменяется частью по умолчанию.
+func renderPart(settings *models.AskSettings, name string, pick func(models.PromptParts) string, vars PromptVars) string {
+	if settings != nil && settings.Prompt != nil {
+		if custom := pick(settings.Prompt.Parts); custom != "" {
+			rendered, err := renderPromptPart(name, custom, vars)
+			if err == nil {
+				return rendered
+			}
+			log.Printf("prompt template %q v%d: %s part render failed, using default: %v", settings.Prompt.Name, settings.Prompt.Version, name, err)
+		}
+	}
+	rendered, err := renderPromptPart(name, pick(defaultPromptParts), vars)
+	if err != nil {
+		log.Printf("default prompt %s part render failed: %v", name, err)
+	}
+	return rendered
+}
+
+// RefusalReply — ответ, когда в документах не нашлось подходящего контекста.
+func (l *LLMClient) RefusalReply(query string, settings *models.AskSettings) string {
+	return renderPart(settings, "refusal", func(p models.PromptParts) string { return p.Refusal }, newPromptVars(query, "", settings))
+}
+
+// BuildAnswerPrompt рендерит шаблон промпта в итоговые сообщения модели. Используется всеми путями
+// генерации ответа (Ask, AskWithSettings, AskWithSettingsStream) и предпросмотром шаблона.
+func (l *LLMClient) BuildAnswerPrompt(query, contextText string, settings *models.AskSettings, history []models.ChatContextMessage) AnswerPrompt {
+	prompt := AnswerPrompt{Template: defaultPromptName, Kind: "answer"}
+	if settings != nil && settings.Prompt != nil && settings.Prompt.Name != "" {
+		prompt.Template = settings.Prompt.Name
+		prompt.Version = settings.Prompt.Version
+	}
+	vars := newPromptVars(query, contextText, settings)
+
+	if resolveIntentClassifier(settings) != IntentClassifierOff {
+		if intent := matchIntentRules(query); isConversationalIntent(intent.Intent) {
+			prompt.Kind = intent.Intent
+			prompt.StaticReply = intentReply(intent.Intent, query, settings)
+			return prompt
+		}
+	}
+	if strings.TrimSpace(contextText) == "" {
+		prompt.Kind = "refusal"
+		prompt.StaticReply = renderPart(settings, "refusal", func(p models.PromptParts) string { return p.Refusal }, vars)
+		return prompt
+	}
+
+	// Ответ, история и контекст делят одно окно модели (см. planTokens).
+	plan := planTokens(l.chatName, query, settings, history)
+	prompt.Model = plan.budget.Model
+	prompt.MaxTokens = plan.budget.Answer
+	prompt.Temperature = defaultAnswerTemperature
+	if settings != nil && settings.Temperature > 0 {
+		prompt.Temperature = settings.Temperature
+	}
+
+	systemPrompt := renderSystemPrompt(query, settings, vars)
+	userPrompt := renderPart(settings, "user", func(p models.PromptParts) string { return p.User }, vars)
+	prompt.Messages = buildAnswerMessages(systemPrompt, userPrompt, plan.history)
+	prompt.ResponseSchema = responseSchema(settings)
+
+	tokens := plan.budget
+	tokens.System = plan.estimator.Count(systemPrompt)
+	tokens.ContextUsed = plan.estimator.Count(contextText)
+	tokens.Question = plan.estimator.Count(userPrompt) - tokens.ContextUsed
+	tokens.updateTotal()
+	prompt.Tokens = &tokens
+	return prompt
+}
+
+// renderSystemPrompt — системная часть промпта. SystemPrompt из настроек чата по-прежнему
+// заменяет её, если выбранный шаблон системную часть не задаёт.
+func renderSystemPrompt(query string, settings *models.AskSettings, vars PromptVars) string {
+	var system string
+	if settings != nil && settings.SystemPrompt != "" && (settings.Prompt == nil || settings.Prompt.Parts.System == "") {
+		system = applyLanguagePolicyToSystemPrompt(settings.SystemPrompt, query)
+	} else {
+		system = renderPart(settings, "system", func(p models.PromptParts) string { return p.System }, vars)
+	}
+	if resolveCitationMode(settings) != CitationModeOff {
+		system = strings.TrimRight(system, "\n") + "\n\n" + citationInstruction
+	}
+	if schema := responseSchema(settings); schema != nil {
+		system = strings.TrimRight(system, "\n") + "\n\n" + fmt.Sprintf(structuredInstruction, schema)
+	}
+	return system
+}
+
+// chatRequest собирает запрос генерации по отрендеренному промпту.
+func (p AnswerPrompt) chatRequest(provider ChatProvider) ChatRequest {
+	return withResponseSchema(withReasoningEffort(ChatRequest{
+		Model:           p.Model,
+		Messages:        p.Messages,
+		Temperature:     p.Temperature,
+		TopP:            0.9,
+		MaxTokens:       p.MaxTokens,
+		PresencePenalty: 0.1,
+	}, provider), p.ResponseSchema, provider)
+}
+
+// SetPromptTemplateService подключает шаблоны промптов чатов к генерации ответов.
+func (s *RAGService) SetPromptTemplateService(svc *PromptTemplateService) {
+	s.prompts = svc
+}
+
+// withPromptTemplate подбирает шаблон промпта чата для запроса и сохраняет его в settings.Prompt.
+// Если шаблон не найден, ответ строится по шаблону по умолчанию.
+func (s *RAGService) withPromptTemplate(ctx context.Context, chatID uuid.UUID, settings *models.AskSettings) {
+	if s.prompts == nil || settings == nil || settings.Prompt != nil {
+		return
+	}
+	resolved, err := s.prompts.Resolve(ctx, chatID, settings)
+	if err != nil {
+		log.Printf("prompt template %q (version %d) for chat %s: %v", settings.PromptTemplate, settings.PromptTemplateVersion, chatID, err)
+	}
+	settings.Prompt = resolved
+}
+
+// refusalReply — ответ без генерации, когда поиск ничего не нашёл.
+func (s *RAGService) refusalReply(query string, settings *models.AskSettings) string {
+	if s.llm == nil {
+		return renderPart(settings, "refusal", func(p models.PromptParts) string { return p.Refusal }, newPromptVars(query, "", settings))
+	}
+	return s.llm.RefusalReply(query, settings)
+}
+
+// PreviewPrompt показывает, какие сообщения получит модель на этот вопрос: контекст собирается так же,
+// как в Ask (без переписывания запроса и кэша ответов), либо берётся contextOverride, если он задан.
+func (s *RAGService) PreviewPrompt(ctx context.Context, query string, topK int, chatID uuid.UUID, settings *models.AskSettings, accessLevel int, history []models.ChatContextMessage, contextOverride *string) (AnswerPrompt, []models.Chunk, RetrievalDiagnostics, error) {
+	if s.llm == nil {
+		return AnswerPrompt{}, nil, RetrievalDiagnostics{}, fmt.Errorf("llm client is nil")
+	}
+	s.withPromptTemplate(ctx, chatID, settings)
+
+	diagnostics := RetrievalDiagnostics{RetrievalQuery: strings.TrimSpace(query)}
+	var chunks []models.Chunk
+	var contextText string
+	if contextOverride != nil {
+		contextText = *contextOverride
+	} else {
+		var err error
+		if topK <= 0 {
+			topK = defaultTopK
+		}
+		var answerCtx answerContext
+		answerCtx, err = s.buildAnswerContext(ctx, query, topK, chatID, settings, accessLevel, history, &diagnostics)
+		if err != nil {
+			return AnswerPrompt{}, nil, diagnostics, err
+		}
+		chunks, contextText = answerCtx.chunks, answerCtx.text
+	}
+	return s.llm.BuildAnswerPrompt(query, contextText, settings, history), chunks, diagnostics, nil
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/katakuxiko/Diplom/internal/dto"
+	"github.com/katakuxiko/Diplom/internal/models"
+	"github.com/katakuxiko/Diplom/internal/repository"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPromptTemplateNotFound  = errors.New("prompt template not found")
+	ErrInvalidPromptTemplate   = errors.New("invalid prompt template")
+	ErrInvalidPromptTemplateID = errors.New("template name must be 1-100 characters: letters, digits, '-', '_' or '.'")
+)
+
+var promptTemplateNamePattern = regexp.MustCompile(`^[\p{L}\p{N}_.-]{1,100}$`)
+
+// PromptTemplateService хранит версионированные шаблоны промптов чата и подбирает шаблон для запроса.
+type PromptTemplateService struct {
+	repo *repository.PromptTemplateRepository
+}
+
+func NewPromptTemplateService(repo *repository.PromptTemplateRepository) *PromptTemplateService {
+	return &PromptTemplateService{repo: repo}
+}
+
+func (s *PromptTemplateService) List(ctx context.Context, chatID uuid.UUID) ([]models.ChatPromptTemplate, error) {
+	return s.repo.ListByChat(ctx, chatID)
+}
+
+func (s *PromptTemplateService) Versions(ctx context.Context, chatID uuid.UUID, name string) ([]models.ChatPromptTemplate, error) {
+	versions, err := s.repo.ListVersions(ctx, chatID, strings.TrimSpace(name))
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, ErrPromptTemplateNotFound
+	}
+	return versions, nil
+}
+
+// Save проверяет части шаблона и сохраняет их новой версией шаблона с этим именем.
+func (s *PromptTemplateService) Save(ctx context.Context, chatID uuid.UUID, req dto.PromptTemplateRequest) (*models.ChatPromptTemplate, error) {
+	name := strings.TrimSpace(req.Name)
+	if !promptTemplateNamePattern.MatchString(name) {
+		return nil, ErrInvalidPromptTemplateID
+	}
+	parts := models.PromptParts{
+		System:   strings.TrimSpace(req.System),
+		User:     strings.TrimSpace(req.User),
+		Refusal:  strings.TrimSpace(req.Refusal),
+		Greeting: strings.TrimSpace(req.Greeting),
+	}
+	if err := ValidatePromptParts(parts); err != nil {
+		return nil, err
+	}
+
+	tpl := &models.ChatPromptTemplate{
+		ChatID:      chatID,
+		Name:        name,
+		Description: strings.TrimSpace(req.Description),
+		PromptParts: parts,
+	}
+	if err := s.repo.CreateVersion(ctx, tpl); err != nil {
+		return nil, err
+	}
+	return tpl, nil
+}
+
+// Delete удаляет все версии шаблона.
+func (s *PromptTemplateService) Delete(ctx context.Context, chatID uuid.UUID, name string) error {
+	deleted, err := s.repo.Delete(ctx, chatID, strings.TrimSpace(name))
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrPromptTemplateNotFound
+	}
+	return nil
+}
+
+// Resolve подбирает шаблон для запроса по AskSettings.PromptTemplate / PromptTemplateVersion
+// и подставляет название чата. Без имени шаблона возвращается шаблон по умолчанию.
+func (s *PromptTemplateService) Resolve(ctx context.Context, chatID uuid.UUID, settings *models.AskSettings) (*models.ResolvedPrompt, error) {
+	resolved := &models.ResolvedPrompt{}
+	if chatName, err := s.repo.ChatName(ctx, chatID); err == nil {
+		resolved.ChatName = chatName
+	}
+	if settings == nil || strings.TrimSpace(settings.PromptTemplate) == "" {
+		return resolved, nil
+	}
+
+	tpl, err := s.repo.Get(ctx, chatID, strings.TrimSpace(settings.PromptTemplate), settings.PromptTemplateVersion)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return resolved, ErrPromptTemplateNotFound
+		}
+		return resolved, err
+	}
+	resolved.Name = tpl.Name
+	resolved.Version = tpl.Version
+	resolved.Parts = tpl.PromptParts
+	return resolved, nil
+}
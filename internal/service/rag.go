@@ -1,8 +1,11 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"sort"
 	"strings"
 	"time"
@@ -18,31 +21,79 @@ import (
 type RAGService struct {
 	ChunkRepository *repository.ChunkRepository
 	llm             *LLMClient
+	embeddingCache  *lruCache[string, []float32]
+	answerCache     *answerCache
+	synonyms        *SynonymService
+	prompts         *PromptTemplateService
+	documents       *repository.DocumentRepository // list_documents агента и темы следующих вопросов
 }
 
 type RetrievalDiagnostics struct {
-	RetrievalMode     string  `json:"retrieval_mode"`
-	RetrievalQuery    string  `json:"retrieval_query,omitempty"`
-	FallbackUsed      bool    `json:"fallback_used"`
-	FallbackQuery     string  `json:"fallback_query,omitempty"`
-	TopK              int     `json:"top_k"`
-	ExpandedTopK      int     `json:"expanded_top_k"`
-	VectorCandidates  int     `json:"vector_candidates"`
-	KeywordCandidates int     `json:"keyword_candidates"`
-	CandidatesTotal   int     `json:"candidates_total"`
-	SelectedChunks    int     `json:"selected_chunks"`
-	MaxCosineDistance float32 `json:"max_cosine_distance"`
-	MaxDistanceGap    float32 `json:"max_distance_gap"`
-	MinChunkChars     int     `json:"min_chunk_chars"`
-	ContextBudget     int     `json:"context_budget"`
-	ContextCharsUsed  int     `json:"context_chars_used"`
+	RetrievalMode     string                   `json:"retrieval_mode"`
+	RetrievalQuery    string                   `json:"retrieval_query,omitempty"`
+	OriginalQuery     string                   `json:"original_query,omitempty"`
+	QueryRewritten    bool                     `json:"query_rewritten"`
+	FallbackUsed      bool                     `json:"fallback_used"`
+	FallbackQuery     string                   `json:"fallback_query,omitempty"`
+	QueryLanguage     string                   `json:"query_language,omitempty"`
+	CorpusLanguages   []string                 `json:"corpus_languages,omitempty"`
+	TranslatedQueries map[string]string        `json:"translated_queries,omitempty"`
+	AppliedFilters    *models.RetrievalFilters `json:"applied_filters,omitempty"`
+	Candidates        []CandidateExplanation   `json:"candidates,omitempty"` // только в режиме explain
+	TopK              int                      `json:"top_k"`
+	ExpandedTopK      int                      `json:"expanded_top_k"`
+	VectorCandidates  int                      `json:"vector_candidates"`
+	KeywordCandidates int                      `json:"keyword_candidates"`
+	CandidatesTotal   int                      `json:"candidates_total"`
+	SelectedChunks    int                      `json:"selected_chunks"`
+	MaxCosineDistance float32                  `json:"max_cosine_distance"`
+	MaxDistanceGap    float32                  `json:"max_distance_gap"`
+	MinChunkChars     int                      `json:"min_chunk_chars"`
+	VectorWeight      float32                  `json:"vector_weight"`
+	KeywordWeight     float32                  `json:"keyword_weight"`
+	RRFWeight         float32                  `json:"rrf_weight"`
+	RRFDenominator    float32                  `json:"rrf_denominator"`
+	RecencyWeight     float32                  `json:"recency_weight,omitempty"`
+	RecencyHalfLife   int                      `json:"recency_half_life_days,omitempty"`
+	MMRApplied        bool                     `json:"mmr_applied"`
+	MMRLambda         float32                  `json:"mmr_lambda,omitempty"`
+	RedundantDropped  int                      `json:"redundant_dropped"`
+	NeighborWindow    int                      `json:"neighbor_window,omitempty"`
+	NeighborsAdded    int                      `json:"neighbors_added"`
+	QueryStrategy     string                   `json:"query_strategy"`
+	QueryVariants     []string                 `json:"query_variants,omitempty"`
+	HypotheticalDoc   string                   `json:"hypothetical_doc,omitempty"`
+	QueryExpansions   map[string][]string      `json:"query_expansions,omitempty"` // раскрытия по словарю синонимов чата
+	ParentSections    int                      `json:"parent_sections,omitempty"`
+	ChildrenCollapsed int                      `json:"children_collapsed,omitempty"`
+	ContextBudget     int                      `json:"context_budget"` // токенов под контекст документов
+	ContextCharsUsed  int                      `json:"context_chars_used"`
+	AnswerCacheHit    bool                     `json:"answer_cache_hit"`
+	AnswerCacheQuery  string                   `json:"answer_cache_query,omitempty"` // запрос, ответ на который отдан из кэша
+	AnswerCacheSim    float32                  `json:"answer_cache_similarity,omitempty"`
+	TokenBudget       *TokenBudget             `json:"token_budget,omitempty"`
+	AnswerTimedOut    bool                     `json:"answer_timed_out,omitempty"` // ответ прерван по сроку answerTimeoutSeconds
+	CitationMode      string                   `json:"citation_mode,omitempty"`
+	CitationsInvalid  int                      `json:"citations_invalid,omitempty"` // ссылки на несуществующие фрагменты
+	Citations         []Citation               `json:"-"`                           // отдаются отдельным полем ответа
+	Grounding         *GroundingReport         `json:"-"`                           // отдаётся отдельным полем ответа
+	Structured        *StructuredOutput        `json:"-"`                           // отдаётся отдельным полем ответа
+	Provider          *ProviderUsage           `json:"provider,omitempty"`          // провайдер генерации, который ответил
+	Agent             *AgentDiagnostics        `json:"agent,omitempty"`             // вызовы инструментов в режиме агента
+	Intent            *IntentResult            `json:"-"`                           // отдаётся отдельным полем ответа
+}
+
+// HybridWeights — веса гибридного ранжирования (vector + keyword + RRF).
+type HybridWeights struct {
+	Vector         float32 `json:"vector"`
+	Keyword        float32 `json:"keyword"`
+	RRF            float32 `json:"rrf"`
+	RRFDenominator float32 `json:"rrf_denominator"`
 }
 
 const (
 	defaultTopK           = 5
 	maxExpandedCandidates = 30
-	defaultContextBudget  = 8000
-	maxContextBudget      = 20000
 	defaultMinChunkChars  = 50
 	defaultMaxCosineDist  = float32(0.60)
 	defaultMaxDistanceGap = float32(0.18)
@@ -50,42 +101,72 @@ const (
 	defaultKeywordWeight  = float32(0.28)
 	defaultRRFWeight      = float32(0.10)
 	defaultRRFDenominator = float32(60.0)
+	defaultMMRLambda      = float32(0.7)
+	defaultDuplicateSim   = float32(0.92)
 )
 
+// chunkFilterStats описывает, что произошло с кандидатами при отборе.
+type chunkFilterStats struct {
+	RedundantDropped int
+	Decisions        map[string]string // ключ чанка (makeChunkKey) -> решение фильтра
+}
+
 func NewRAGService(ChunkRepository *repository.ChunkRepository, llm *LLMClient) *RAGService {
-	return &RAGService{ChunkRepository: ChunkRepository, llm: llm}
+	return &RAGService{
+		ChunkRepository: ChunkRepository,
+		llm:             llm,
+		embeddingCache:  newLRUCache[string, []float32](queryEmbeddingCacheSize, queryEmbeddingCacheTTL),
+		answerCache:     newAnswerCache(),
+	}
 }
 
-func (s *RAGService) Ask(query string, topK int, chatID uuid.UUID, settings *models.AskSettings, accessLevel int, history []models.ChatContextMessage) (string, []models.Chunk, error) {
-	answer, chunks, _, err := s.AskWithDiagnostics(query, topK, chatID, settings, accessLevel, history)
+func (s *RAGService) Ask(ctx context.Context, query string, topK int, chatID uuid.UUID, settings *models.AskSettings, accessLevel int, history []models.ChatContextMessage) (string, []models.Chunk, error) {
+	answer, chunks, _, err := s.AskWithDiagnostics(ctx, query, topK, chatID, settings, accessLevel, history)
 	return answer, chunks, err
 }
 
-func (s *RAGService) AskWithDiagnostics(query string, topK int, chatID uuid.UUID, settings *models.AskSettings, accessLevel int, history []models.ChatContextMessage) (string, []models.Chunk, RetrievalDiagnostics, error) {
-	return s.askWithDiagnosticsInternal(query, topK, chatID, settings, accessLevel, history, func(q, ctx string, askSettings *models.AskSettings, askHistory []models.ChatContextMessage) (string, error) {
+func (s *RAGService) AskWithDiagnostics(ctx context.Context, query string, topK int, chatID uuid.UUID, settings *models.AskSettings, accessLevel int, history []models.ChatContextMessage) (string, []models.Chunk, RetrievalDiagnostics, error) {
+	return s.askWithDiagnosticsInternal(ctx, query, topK, chatID, settings, accessLevel, history, nil, func(askCtx context.Context, q, contextText string, askSettings *models.AskSettings, askHistory []models.ChatContextMessage) (string, *ProviderUsage, error) {
 		if s.llm == nil {
-			return "", fmt.Errorf("llm client is nil")
+			return "", nil, fmt.Errorf("llm client is nil")
 		}
-		return s.llm.AskWithSettings(q, ctx, askSettings, askHistory)
+		return s.llm.AskWithSettings(askCtx, q, contextText, askSettings, askHistory)
 	})
 }
 
-func (s *RAGService) AskWithDiagnosticsStream(query string, topK int, chatID uuid.UUID, settings *models.AskSettings, accessLevel int, history []models.ChatContextMessage, onDelta func(string) error) (string, []models.Chunk, RetrievalDiagnostics, error) {
+func (s *RAGService) AskWithDiagnosticsStream(ctx context.Context, query string, topK int, chatID uuid.UUID, settings *models.AskSettings, accessLevel int, history []models.ChatContextMessage, onDelta func(string) error, onToolStep func(AgentStep) error) (string, []models.Chunk, RetrievalDiagnostics, error) {
 	if onDelta == nil {
 		return "", nil, RetrievalDiagnostics{}, fmt.Errorf("stream callback is nil")
 	}
 
-	answer, chunks, diagnostics, err := s.askWithDiagnosticsInternal(query, topK, chatID, settings, accessLevel, history, func(q, ctx string, askSettings *models.AskSettings, askHistory []models.ChatContextMessage) (string, error) {
+	// Политики refuse и regenerate заменяют неподтверждённый ответ, а ответ по схеме может быть исправлен
+	// моделью или очищен от текста вокруг JSON, поэтому их нельзя стримить: фрагменты копятся,
+	// а клиенту уходит один итоговый фрагмент после проверки.
+	withhold := groundingWithholdsStream(settings) || responseSchema(settings) != nil
+	streamDelta := onDelta
+	if withhold {
+		streamDelta = func(string) error { return nil }
+	}
+
+	answer, chunks, diagnostics, err := s.askWithDiagnosticsInternal(ctx, query, topK, chatID, settings, accessLevel, history, onToolStep, func(askCtx context.Context, q, contextText string, askSettings *models.AskSettings, askHistory []models.ChatContextMessage) (string, *ProviderUsage, error) {
 		if s.llm == nil {
-			return "", fmt.Errorf("llm client is nil")
+			return "", nil, fmt.Errorf("llm client is nil")
 		}
-		return s.llm.AskWithSettingsStream(q, ctx, askSettings, askHistory, onDelta)
+		return s.llm.AskWithSettingsStream(askCtx, q, contextText, askSettings, askHistory, streamDelta)
 	})
+	if errors.Is(err, ErrAnswerTimeout) {
+		// Частичный ответ уже отправлен фрагментами (или, если фрагменты копились, придёт только в итоговом
+		// ответе); вызывающий сообщит клиенту об истечении срока.
+		return answer, chunks, diagnostics, err
+	}
 	if err != nil {
 		return "", nil, diagnostics, err
 	}
 
-	if len(chunks) == 0 && strings.TrimSpace(answer) != "" {
+	// Ответ без генерации (нет контекста или взят из кэша), ответ агента, который генерируется
+	// без стриминга, и проверенный ответ, фрагменты которого копились, отдаём одним фрагментом.
+	agentAnswered := diagnostics.Agent != nil && diagnostics.Agent.Fallback == ""
+	if (len(chunks) == 0 || diagnostics.AnswerCacheHit || agentAnswered || withhold) && strings.TrimSpace(answer) != "" {
 		if cbErr := onDelta(answer); cbErr != nil {
 			return "", nil, diagnostics, cbErr
 		}
@@ -94,12 +175,25 @@ func (s *RAGService) AskWithDiagnosticsStream(query string, topK int, chatID uui
 	return answer, chunks, diagnostics, nil
 }
 
-func (s *RAGService) askWithDiagnosticsInternal(query string, topK int, chatID uuid.UUID, settings *models.AskSettings, accessLevel int, history []models.ChatContextMessage, askFn func(query, contextText string, settings *models.AskSettings, history []models.ChatContextMessage) (string, error)) (string, []models.Chunk, RetrievalDiagnostics, error) {
+// Retrieve выполняет только поиск (без генерации ответа) с теми же настройками, что и Ask.
+// Используется для сравнения стратегий поиска на контрольных вопросах.
+func (s *RAGService) Retrieve(ctx context.Context, query string, topK int, chatID uuid.UUID, settings *models.AskSettings, accessLevel int) ([]models.Chunk, RetrievalDiagnostics, error) {
+	diagnostics := RetrievalDiagnostics{RetrievalQuery: strings.TrimSpace(query)}
+	chunks, err := s.retrieveChunksForQuery(ctx, query, topK, chatID, settings, accessLevel, &diagnostics)
+	return chunks, diagnostics, err
+}
+
+func (s *RAGService) askWithDiagnosticsInternal(ctx context.Context, query string, topK int, chatID uuid.UUID, settings *models.AskSettings, accessLevel int, history []models.ChatContextMessage, onToolStep func(AgentStep) error, askFn func(ctx context.Context, query, contextText string, settings *models.AskSettings, history []models.ChatContextMessage) (string, *ProviderUsage, error)) (string, []models.Chunk, RetrievalDiagnostics, error) {
 	if askFn == nil {
 		return "", nil, RetrievalDiagnostics{}, fmt.Errorf("ask function is nil")
 	}
 
+	// Срок ответа чата распространяется на весь запрос: переписывание, поиск и генерацию.
+	ctx, cancel := withAnswerDeadline(ctx, settings)
+	defer cancel()
+
 	diagnostics := RetrievalDiagnostics{}
+	s.withPromptTemplate(ctx, chatID, settings)
 
 	if topK <= 0 {
 		topK = defaultTopK
@@ -107,57 +201,175 @@ func (s *RAGService) askWithDiagnosticsInternal(query string, topK int, chatID u
 	diagnostics.TopK = topK
 	diagnostics.RetrievalQuery = strings.TrimSpace(query)
 
-	filteredChunks, retrieveErr := s.retrieveChunksForQuery(query, topK, chatID, settings, accessLevel, &diagnostics)
-	if retrieveErr != nil {
-		return "", nil, diagnostics, retrieveErr
+	// Приветствие, благодарность, вопрос не по теме и т.п. отвечаем заготовкой чата без поиска.
+	intent, intentReply := s.routeIntent(ctx, query, chatID, settings, accessLevel)
+	diagnostics.Intent = intent
+	if intentReply != "" {
+		diagnostics.RetrievalMode = "intent"
+		return intentReply, nil, diagnostics, nil
+	}
+
+	// Уточняющий вопрос переписываем в самостоятельный запрос только для поиска;
+	// генератор по-прежнему получает исходный вопрос и историю.
+	retrievalQuery := query
+	if settings != nil && settings.EnableQueryRewrite && len(history) > 0 && s.llm != nil {
+		rewritten, rewriteErr := s.llm.RewriteQueryWithHistory(ctx, query, history, settings)
+		if rewriteErr != nil {
+			log.Printf("query rewrite failed: %v", rewriteErr)
+		} else if rewritten = strings.TrimSpace(rewritten); rewritten != "" && !sameNormalizedQuery(query, rewritten) {
+			retrievalQuery = rewritten
+			diagnostics.RetrievalQuery = rewritten
+			diagnostics.QueryRewritten = true
+			diagnostics.OriginalQuery = strings.TrimSpace(query)
+		}
 	}
 
-	if len(filteredChunks) == 0 && shouldTryRussianFallback(query) && s.llm != nil {
-		translatedQuery, translateErr := s.llm.TranslateQueryToRussianForRetrieval(query, settings)
-		if translateErr != nil {
-			log.Printf("retrieval translation fallback failed: %v", translateErr)
+	// Семантический кэш ответов: похожий вопрос при неизменном корпусе чата отвечаем сохранённым ответом.
+	var cacheEmbedding []float32
+	var corpusVersion string
+	useAnswerCache := answerCacheApplicable(settings, history) && s.answerCache != nil && s.ChunkRepository != nil
+	if useAnswerCache {
+		var cacheErr error
+		if corpusVersion, cacheErr = s.ChunkRepository.CorpusVersion(ctx, chatID); cacheErr == nil {
+			cacheEmbedding, cacheErr = s.embedQuery(ctx, retrievalQuery, settings)
+		}
+		if cacheErr != nil {
+			log.Printf("answer cache lookup failed: %v", cacheErr)
+			useAnswerCache = false
 		} else {
-			translatedQuery = strings.TrimSpace(translatedQuery)
-			if translatedQuery != "" && !sameNormalizedQuery(query, translatedQuery) {
-				fallbackDiagnostics := RetrievalDiagnostics{TopK: topK, RetrievalQuery: translatedQuery}
-				fallbackChunks, fallbackErr := s.retrieveChunksForQuery(translatedQuery, topK, chatID, settings, accessLevel, &fallbackDiagnostics)
-				if fallbackErr != nil {
-					log.Printf("retrieval fallback search failed: %v", fallbackErr)
-				} else if len(fallbackChunks) > 0 {
-					fallbackDiagnostics.FallbackUsed = true
-					fallbackDiagnostics.FallbackQuery = translatedQuery
-					diagnostics = fallbackDiagnostics
-					filteredChunks = fallbackChunks
-				} else {
-					diagnostics.FallbackQuery = translatedQuery
-				}
+			threshold, _ := resolveAnswerCacheSettings(settings)
+			if cached, sim := s.answerCache.lookup(chatID, answerCacheScope(settings, accessLevel, topK), corpusVersion, cacheEmbedding, threshold); cached != nil {
+				cachedDiagnostics := cached.diagnostics
+				cachedDiagnostics.AnswerCacheHit = true
+				cachedDiagnostics.AnswerCacheQuery = cached.query
+				cachedDiagnostics.AnswerCacheSim = sim
+				cachedDiagnostics.Intent = diagnostics.Intent
+				return cached.answer, append([]models.Chunk(nil), cached.chunks...), cachedDiagnostics, nil
 			}
 		}
 	}
 
-	// Если после фильтрации не осталось чанков
-	if len(filteredChunks) == 0 {
-		return "К сожалению, в загруженных документах не найдено релевантной информации по вашему запросу. Попробуйте переформулировать вопрос или загрузите дополнительные материалы.", nil, diagnostics, nil
+	var (
+		answerCtx answerContext
+		answer    string
+		usage     *ProviderUsage
+		err       error
+	)
+	// В режиме агента модель сама ищет фрагменты инструментами; без поддержки инструментов
+	// у провайдера ответ строится обычным поиском.
+	agentHandled := false
+	startTime := time.Now()
+	if agentModeEnabled(settings) {
+		answer, answerCtx, usage, agentHandled, err = s.runAgent(ctx, query, topK, chatID, settings, accessLevel, history, onToolStep, &diagnostics)
+		if agentHandled && err == nil && answer == "" {
+			answer = s.refusalReply(query, settings)
+		}
 	}
-
-	// Build normalized, compact context with a character budget
-	// Heuristic: cap context to ~8000 chars (~2k tokens), enough for fast responses
-	contextBudget := defaultContextBudget
-	if settings != nil && settings.MaxTokens > 0 {
-		// Leave room for answer; use ~1.5x of answer tokens as context chars (approx 4 chars per token)
-		budget := int(float32(settings.MaxTokens) * 1.5 * 4)
-		if budget > 0 {
-			if budget > maxContextBudget {
-				budget = maxContextBudget
+	if !agentHandled {
+		var retrieveErr error
+		answerCtx, retrieveErr = s.buildAnswerContext(ctx, retrievalQuery, topK, chatID, settings, accessLevel, history, &diagnostics)
+		if retrieveErr != nil {
+			if deadlineExceeded(ctx, retrieveErr) {
+				diagnostics.AnswerTimedOut = true
+				return "", nil, diagnostics, fmt.Errorf("%w: %v", ErrAnswerTimeout, retrieveErr)
 			}
-			contextBudget = budget
+			return "", nil, diagnostics, retrieveErr
+		}
+
+		// Если после фильтрации не осталось чанков
+		if len(answerCtx.chunks) == 0 {
+			return s.refusalReply(query, settings), nil, diagnostics, nil
+		}
+
+		startTime = time.Now()
+		answer, usage, err = askFn(ctx, query, answerCtx.text, settings, history)
+	}
+	filteredChunks := answerCtx.chunks
+	fmt.Printf("⏱️  LLM response time: %v\n", time.Since(startTime))
+	diagnostics.Provider = usage
+	// Ссылки [n] проверяем и в частичном ответе: клиент получит его вместе с citations.
+	// Ответ по схеме ссылок не содержит: resolveCitationMode для него выключает их.
+	answer = citeAnswer(answer, answerCtx.fragments, settings, &diagnostics)
+	if err != nil && deadlineExceeded(ctx, err) {
+		// Частичный ответ не кэшируем: он обрезан по сроку.
+		diagnostics.AnswerTimedOut = true
+		if !errors.Is(err, ErrAnswerTimeout) {
+			err = fmt.Errorf("%w: %v", ErrAnswerTimeout, err)
 		}
+		return answer, filteredChunks, diagnostics, err
+	}
+	if err != nil {
+		return "", nil, diagnostics, fmt.Errorf("llm error: %w", err)
+	}
+
+	// Частичный ответ по сроку не проверяем: его всё равно нельзя перегенерировать.
+	// Ответ по схеме — JSON, а не предложения, поэтому вместо проверки по источникам он проверяется по схеме.
+	if responseSchema(settings) != nil {
+		answer = s.structureAnswer(ctx, answer, usage, settings, &diagnostics)
+	} else if resolveGroundingCheck(settings) != GroundingCheckOff {
+		answer = s.enforceGrounding(ctx, query, answer, answerCtx, settings, history, &diagnostics)
+	}
+
+	if useAnswerCache && strings.TrimSpace(answer) != "" {
+		_, ttl := resolveAnswerCacheSettings(settings)
+		s.answerCache.store(chatID, &cachedAnswer{
+			scope:         answerCacheScope(settings, accessLevel, topK),
+			query:         strings.TrimSpace(retrievalQuery),
+			embedding:     cacheEmbedding,
+			answer:        answer,
+			chunks:        append([]models.Chunk(nil), filteredChunks...),
+			diagnostics:   diagnostics,
+			corpusVersion: corpusVersion,
+			expiresAt:     time.Now().Add(ttl),
+		})
+	}
+
+	return answer, filteredChunks, diagnostics, nil
+}
+
+// answerContext — найденные чанки и собранный из них контекст промпта.
+type answerContext struct {
+	chunks    []models.Chunk // источники ответа
+	text      string
+	fragments []models.Chunk // попавшие в текст фрагменты в порядке нумерации "Фрагмент n"
+}
+
+// buildAnswerContext находит чанки для вопроса и собирает из них текст контекста для промпта
+// (в пределах токенного бюджета контекста и с соседними чанками). Без найденных чанков контекст пустой.
+func (s *RAGService) buildAnswerContext(ctx context.Context, query string, topK int, chatID uuid.UUID, settings *models.AskSettings, accessLevel int, history []models.ChatContextMessage, diagnostics *RetrievalDiagnostics) (answerContext, error) {
+	filteredChunks, err := s.retrieveChunksForQuery(ctx, query, topK, chatID, settings, accessLevel, diagnostics)
+	if err != nil || len(filteredChunks) == 0 {
+		return answerContext{}, err
 	}
+
+	// Контекст получает то, что осталось от окна модели после ответа, промпта и истории.
+	defaultModel := ""
+	if s.llm != nil {
+		defaultModel = s.llm.chatName
+	}
+	plan := planTokens(defaultModel, query, settings, history)
+	tok := plan.estimator
+	contextBudget := plan.budget.Context
 	diagnostics.ContextBudget = contextBudget
 
+	// Подтягиваем соседние чанки документа, чтобы ответ из "таблицы ниже" попал в контекст.
+	contextChunks := filteredChunks
+	// В режиме parent контекст уже состоит из целых разделов — соседи не нужны.
+	if window := resolveNeighborWindow(settings); window > 0 && diagnostics.RetrievalMode != "parent" {
+		diagnostics.NeighborWindow = window
+		expanded, added, expandErr := s.expandWithNeighbors(ctx, filteredChunks, window, tok, contextBudget, accessLevel)
+		if expandErr != nil {
+			log.Printf("neighbor expansion failed: %v", expandErr)
+		} else {
+			contextChunks = expanded
+			diagnostics.NeighborsAdded = added
+		}
+	}
+
 	var b strings.Builder
-	used := 0
-	for i, ch := range filteredChunks {
+	fragments := make([]models.Chunk, 0, len(contextChunks))
+	used, usedChars := 0, 0
+	for i, ch := range contextChunks {
 		normalized := utils.NormalizeText(ch.Text)
 		sourceLabel := strings.TrimSpace(ch.DocName)
 		if sourceLabel == "" {
@@ -168,35 +380,33 @@ func (s *RAGService) askWithDiagnosticsInternal(query string, topK int, chatID u
 			header = fmt.Sprintf("Фрагмент %d [%s]: ", i+1, sourceLabel)
 		}
 		piece := header + normalized + "\n"
-		pieceLen := len([]rune(piece))
-		if used+pieceLen > contextBudget {
+		pieceTokens := tok.Count(piece)
+		if used+pieceTokens > contextBudget {
 			remaining := contextBudget - used
 			if remaining <= 0 {
 				break
 			}
-			piece = utils.TruncateByChars(piece, remaining)
-			pieceLen = len([]rune(piece))
+			piece = truncateToTokens(tok, piece, remaining)
+			pieceTokens = tok.Count(piece)
 		}
 		b.WriteString(piece)
-		used += pieceLen
+		fragments = append(fragments, ch)
+		used += pieceTokens
+		usedChars += len([]rune(piece))
 		if used >= contextBudget {
 			break
 		}
 	}
-	diagnostics.ContextCharsUsed = used
-	ctx := b.String()
-
-	startTime := time.Now()
-	answer, err := askFn(query, ctx, settings, history)
-	fmt.Printf("⏱️  LLM response time: %v\n", time.Since(startTime))
-	if err != nil {
-		return "", nil, diagnostics, fmt.Errorf("llm error: %w", err)
-	}
+	diagnostics.ContextCharsUsed = usedChars
 
-	return answer, filteredChunks, diagnostics, nil
+	budget := plan.budget
+	budget.ContextUsed = used
+	budget.updateTotal()
+	diagnostics.TokenBudget = &budget
+	return answerContext{chunks: filteredChunks, text: b.String(), fragments: fragments}, nil
 }
 
-func (s *RAGService) retrieveChunksForQuery(query string, topK int, chatID uuid.UUID, settings *models.AskSettings, accessLevel int, diagnostics *RetrievalDiagnostics) ([]models.Chunk, error) {
+func (s *RAGService) retrieveChunksForQuery(ctx context.Context, query string, topK int, chatID uuid.UUID, settings *models.AskSettings, accessLevel int, diagnostics *RetrievalDiagnostics) ([]models.Chunk, error) {
 	if diagnostics == nil {
 		return nil, fmt.Errorf("diagnostics is nil")
 	}
@@ -206,12 +416,16 @@ func (s *RAGService) retrieveChunksForQuery(query string, topK int, chatID uuid.
 	}
 	diagnostics.TopK = topK
 
-	retrievalMode := resolveRetrievalMode(settings)
+	retrievalMode := ResolveRetrievalMode(settings)
 	diagnostics.RetrievalMode = retrievalMode
 
 	expandedTopK := topK * 2
-	if retrievalMode == "hybrid" {
+	switch retrievalMode {
+	case "hybrid":
 		expandedTopK = topK * 3
+	case "parent":
+		// Несколько дочерних чанков могут принадлежать одному разделу — берём запас.
+		expandedTopK = topK * 4
 	}
 	if expandedTopK > maxExpandedCandidates {
 		expandedTopK = maxExpandedCandidates
@@ -220,97 +434,191 @@ func (s *RAGService) retrieveChunksForQuery(query string, topK int, chatID uuid.
 		expandedTopK = topK
 	}
 	diagnostics.ExpandedTopK = expandedTopK
+	diagnostics.AppliedFilters = retrievalFilters(settings)
 
 	minChunkChars, maxCosineDistance, maxDistanceGap := resolveRetrievalThresholds(settings)
 	diagnostics.MinChunkChars = minChunkChars
 	diagnostics.MaxCosineDistance = maxCosineDistance
 	diagnostics.MaxDistanceGap = maxDistanceGap
 
+	weights := ResolveHybridWeights(settings)
+	if retrievalMode == "hybrid" || retrievalMode == "parent" {
+		diagnostics.VectorWeight = weights.Vector
+		diagnostics.KeywordWeight = weights.Keyword
+		diagnostics.RRFWeight = weights.RRF
+		diagnostics.RRFDenominator = weights.RRFDenominator
+	}
+
+	strategy := ResolveQueryStrategy(settings)
+	diagnostics.QueryStrategy = strategy
+
+	var candidates []models.Chunk
+	var collectErr error
+	switch strategy {
+	case "hyde":
+		candidates, collectErr = s.collectHyDECandidates(ctx, query, retrievalMode, expandedTopK, chatID, settings, accessLevel, weights, diagnostics)
+	case "multi_query":
+		candidates, collectErr = s.collectMultiQueryCandidates(ctx, query, retrievalMode, expandedTopK, chatID, settings, accessLevel, weights, diagnostics)
+	default:
+		candidates, collectErr = s.collectCandidates(ctx, query, query, retrievalMode, expandedTopK, chatID, settings, accessLevel, weights, diagnostics)
+	}
+	if collectErr != nil {
+		return nil, collectErr
+	}
+
+	// Кросс-языковой поиск: запрос переводится на языки корпуса, отличные от языка запроса.
+	crossMode := resolveCrossLingualMode(settings)
+	var targetLangs []string
+	if crossMode != "off" {
+		targetLangs = s.resolveCrossLingualTargets(ctx, query, chatID, settings, diagnostics)
+	}
+	if crossMode == "always" && len(targetLangs) > 0 {
+		translatedLists := s.collectTranslatedCandidates(ctx, query, targetLangs, retrievalMode, expandedTopK, chatID, settings, accessLevel, weights, diagnostics)
+		if len(translatedLists) > 0 {
+			candidates = fuseRankedLists(append([][]models.Chunk{candidates}, translatedLists...), weights.RRFDenominator, expandedTopK*2)
+		}
+	}
+
+	filteredChunks := s.selectFromCandidates(ctx, candidates, topK, retrievalMode, settings, accessLevel, diagnostics, "primary")
+
+	if len(filteredChunks) == 0 && crossMode == "fallback" && len(targetLangs) > 0 {
+		translatedLists := s.collectTranslatedCandidates(ctx, query, targetLangs, retrievalMode, expandedTopK, chatID, settings, accessLevel, weights, diagnostics)
+		if len(translatedLists) > 0 {
+			fallbackCandidates := fuseRankedLists(translatedLists, weights.RRFDenominator, expandedTopK*2)
+			filteredChunks = s.selectFromCandidates(ctx, fallbackCandidates, topK, retrievalMode, settings, accessLevel, diagnostics, "cross_lingual_fallback")
+			diagnostics.FallbackUsed = len(filteredChunks) > 0
+		}
+	}
+
+	return filteredChunks, nil
+}
+
+// selectFromCandidates отбирает итоговые чанки из ранжированных кандидатов:
+// схлопывание по родительским разделам, пороги релевантности, MMR и подстановка разделов.
+// stage подписывает кандидатов в режиме explain ("primary", "cross_lingual_fallback").
+func (s *RAGService) selectFromCandidates(ctx context.Context, candidates []models.Chunk, topK int, retrievalMode string, settings *models.AskSettings, accessLevel int, diagnostics *RetrievalDiagnostics, stage string) []models.Chunk {
+	if weight, halfLife := resolveRecency(settings); weight > 0 {
+		candidates = applyRecency(candidates, settings)
+		diagnostics.RecencyWeight = weight
+		diagnostics.RecencyHalfLife = halfLife
+	}
+	allCandidates := candidates
+	if retrievalMode == "parent" {
+		var collapsed int
+		candidates, collapsed = collapseByParent(candidates)
+		diagnostics.ChildrenCollapsed = collapsed
+	}
+
+	diagnostics.CandidatesTotal = len(candidates)
+	mmrEnabled, mmrLambda, _ := resolveMMRSettings(settings)
+	diagnostics.MMRApplied = mmrEnabled
+	if mmrEnabled {
+		diagnostics.MMRLambda = mmrLambda
+	}
+
+	filteredChunks, filterStats := s.filterRelevantChunks(candidates, topK, settings)
+	diagnostics.SelectedChunks = len(filteredChunks)
+	diagnostics.RedundantDropped = filterStats.RedundantDropped
+	if explainEnabled(settings) {
+		diagnostics.Candidates = append(diagnostics.Candidates, buildCandidateExplanations(allCandidates, filterStats.Decisions, stage)...)
+	}
+
+	if retrievalMode == "parent" && len(filteredChunks) > 0 {
+		withParents, attached, parentErr := s.attachParentSections(ctx, filteredChunks, accessLevel)
+		if parentErr != nil {
+			log.Printf("parent sections lookup failed: %v", parentErr)
+		} else {
+			filteredChunks = withParents
+			diagnostics.ParentSections = attached
+		}
+	}
+
+	return filteredChunks
+}
+
+// collectCandidates выполняет поиск кандидатов для одного запроса в выбранном режиме.
+// embedText — текст, который эмбеддится для векторного поиска (для HyDE это гипотетический ответ),
+// query используется для лексического поиска и keyword-оценок.
+func (s *RAGService) collectCandidates(ctx context.Context, query, embedText, retrievalMode string, expandedTopK int, chatID uuid.UUID, settings *models.AskSettings, accessLevel int, weights HybridWeights, diagnostics *RetrievalDiagnostics) ([]models.Chunk, error) {
+	expansion := s.expandQuery(ctx, query, chatID, diagnostics)
+
 	var vectorChunks []models.Chunk
 	if retrievalMode != "keyword" {
-		v, embErr := s.llm.EmbeddingWithSettings(query, settings)
+		// Раскрытия словаря добавляем только к самому запросу (не к гипотетическому ответу HyDE).
+		if synonymsInEmbedding(settings) && embedText == query {
+			embedText = expansionEmbedText(embedText, expansion)
+		}
+		v, embErr := s.embedQuery(ctx, embedText, settings)
 		if embErr != nil {
 			return nil, fmt.Errorf("embedding error: %w", embErr)
 		}
 		vec := pgvector.NewVector(v)
 
-		vectorResult, searchErr := s.ChunkRepository.SearchByVector(vec, expandedTopK, chatID, accessLevel)
+		vectorResult, searchErr := s.ChunkRepository.SearchByVector(ctx, vec, expandedTopK, chatID, accessLevel, retrievalFilters(settings), vectorSearchParams(settings))
 		if searchErr != nil {
 			return nil, fmt.Errorf("search error: %w", searchErr)
 		}
 		for i := range vectorResult {
 			vectorResult[i].RetrievalSource = "vector"
 			vectorResult[i].HybridScore = cosineDistanceToSimilarity(vectorResult[i].Score)
+			vectorResult[i].VectorRank = i + 1
 		}
 		vectorChunks = vectorResult
-		diagnostics.VectorCandidates = len(vectorChunks)
-	} else {
-		diagnostics.VectorCandidates = 0
+		diagnostics.VectorCandidates += len(vectorChunks)
 	}
 
-	var candidates []models.Chunk
 	switch retrievalMode {
 	case "vector":
-		candidates = vectorChunks
-		diagnostics.KeywordCandidates = 0
+		return vectorChunks, nil
 	case "keyword":
-		keywordChunks, kErr := s.ChunkRepository.SearchByKeyword(query, expandedTopK, chatID, accessLevel)
+		keywordChunks, kErr := s.ChunkRepository.SearchByKeyword(ctx, query, expandedTopK, chatID, accessLevel, retrievalFilters(settings), expansion.keywordPhrases())
 		if kErr != nil {
 			return nil, fmt.Errorf("keyword search error: %w", kErr)
 		}
-		diagnostics.KeywordCandidates = len(keywordChunks)
-		candidates = s.rankKeywordCandidates(query, keywordChunks)
+		diagnostics.KeywordCandidates += len(keywordChunks)
+		return s.rankKeywordCandidates(query, keywordChunks, expansion), nil
 	default:
-		keywordChunks, kErr := s.ChunkRepository.SearchByKeyword(query, expandedTopK, chatID, accessLevel)
+		keywordChunks, kErr := s.ChunkRepository.SearchByKeyword(ctx, query, expandedTopK, chatID, accessLevel, retrievalFilters(settings), expansion.keywordPhrases())
 		if kErr != nil {
 			return nil, fmt.Errorf("keyword search error: %w", kErr)
 		}
-		diagnostics.KeywordCandidates = len(keywordChunks)
-		keywordChunks = s.rankKeywordCandidates(query, keywordChunks)
-		candidates = s.mergeHybridCandidates(query, vectorChunks, keywordChunks, expandedTopK*2)
+		diagnostics.KeywordCandidates += len(keywordChunks)
+		keywordChunks = s.rankKeywordCandidates(query, keywordChunks, expansion)
+		return s.mergeHybridCandidates(query, vectorChunks, keywordChunks, expandedTopK*2, weights, expansion), nil
 	}
-
-	diagnostics.CandidatesTotal = len(candidates)
-	filteredChunks := s.filterRelevantChunks(candidates, topK, settings)
-	diagnostics.SelectedChunks = len(filteredChunks)
-
-	return filteredChunks, nil
 }
 
-func shouldTryRussianFallback(query string) bool {
-	q := strings.TrimSpace(strings.ToLower(query))
-	if q == "" {
-		return false
+func sameNormalizedQuery(a, b string) bool {
+	normalize := func(v string) string {
+		return strings.ToLower(strings.Join(strings.Fields(strings.TrimSpace(v)), " "))
 	}
+	return normalize(a) == normalize(b)
+}
 
-	latinCount := 0
-	cyrillicCount := 0
-	for _, r := range q {
-		if unicode.In(r, unicode.Latin) {
-			latinCount++
-		}
-		if unicode.In(r, unicode.Cyrillic) {
-			cyrillicCount++
-		}
+// retrievalFilters возвращает фильтры запроса по метаданным документов (nil — без фильтров).
+func retrievalFilters(settings *models.AskSettings) *models.RetrievalFilters {
+	if settings == nil || settings.Filters.IsEmpty() {
+		return nil
 	}
-
-	return latinCount >= 3 && cyrillicCount == 0
+	return settings.Filters
 }
 
-func sameNormalizedQuery(a, b string) bool {
-	normalize := func(v string) string {
-		return strings.ToLower(strings.Join(strings.Fields(strings.TrimSpace(v)), " "))
+// vectorSearchParams возвращает точность приблизительного поиска (ef_search / probes) для запроса.
+func vectorSearchParams(settings *models.AskSettings) repository.VectorSearchParams {
+	if settings == nil {
+		return repository.VectorSearchParams{}
 	}
-	return normalize(a) == normalize(b)
+	return repository.VectorSearchParams{EfSearch: settings.HNSWEfSearch, Probes: settings.IVFProbes}
 }
 
-func resolveRetrievalMode(settings *models.AskSettings) string {
+// ResolveRetrievalMode возвращает режим поиска чата; по умолчанию — hybrid.
+func ResolveRetrievalMode(settings *models.AskSettings) string {
 	if settings == nil {
 		return "hybrid"
 	}
 	mode := strings.ToLower(strings.TrimSpace(settings.RetrievalMode))
 	switch mode {
-	case "vector", "keyword", "hybrid":
+	case "vector", "keyword", "hybrid", "parent":
 		return mode
 	default:
 		return "hybrid"
@@ -336,21 +644,88 @@ func resolveRetrievalThresholds(settings *models.AskSettings) (int, float32, flo
 	return minChunkLen, maxCosineDist, maxGapFromTopHit
 }
 
+// ResolveHybridWeights возвращает веса гибридного ранжирования с учётом настроек чата.
+func ResolveHybridWeights(settings *models.AskSettings) HybridWeights {
+	weights := HybridWeights{
+		Vector:         defaultVectorWeight,
+		Keyword:        defaultKeywordWeight,
+		RRF:            defaultRRFWeight,
+		RRFDenominator: defaultRRFDenominator,
+	}
+	if settings != nil {
+		// Заданный вес, в том числе 0, используется как есть; отрицательные веса игнорируются.
+		if settings.VectorWeight != nil && *settings.VectorWeight >= 0 {
+			weights.Vector = *settings.VectorWeight
+		}
+		if settings.KeywordWeight != nil && *settings.KeywordWeight >= 0 {
+			weights.Keyword = *settings.KeywordWeight
+		}
+		if settings.RRFWeight != nil && *settings.RRFWeight >= 0 {
+			weights.RRF = *settings.RRFWeight
+		}
+		if settings.RRFDenominator > 0 {
+			weights.RRFDenominator = settings.RRFDenominator
+		}
+	}
+
+	return weights
+}
+
 // ApplyRetrievalThresholds используется для калибровки порогов на evaluation run.
 func (s *RAGService) ApplyRetrievalThresholds(chunks []models.Chunk, topK int, settings *models.AskSettings) []models.Chunk {
-	return s.filterRelevantChunks(chunks, topK, settings)
+	filtered, _ := s.filterRelevantChunks(chunks, topK, settings)
+	return filtered
 }
 
-// filterRelevantChunks фильтрует чанки по релевантности
-func (s *RAGService) filterRelevantChunks(chunks []models.Chunk, maxChunks int, settings *models.AskSettings) []models.Chunk {
+// ApplyHybridRanking используется для калибровки весов гибридного ранжирования на evaluation run:
+// объединяет заранее найденные vector- и keyword-кандидаты и применяет пороги отбора.
+func (s *RAGService) ApplyHybridRanking(query string, vectorChunks, keywordChunks []models.Chunk, topK int, settings *models.AskSettings) []models.Chunk {
+	if topK <= 0 {
+		topK = defaultTopK
+	}
+	keywordRanked := s.rankKeywordCandidates(query, keywordChunks, nil)
+	maxCandidates := (len(vectorChunks) + len(keywordRanked)) * 2
+	merged := s.mergeHybridCandidates(query, vectorChunks, keywordRanked, maxCandidates, ResolveHybridWeights(settings), nil)
+	filtered, _ := s.filterRelevantChunks(merged, topK, settings)
+	return filtered
+}
+
+// PassesDistanceThreshold сообщает, что хотя бы один чанк найден векторным поиском
+// с косинусным расстоянием не больше maxCosineDistance.
+func PassesDistanceThreshold(chunks []models.Chunk, maxCosineDistance float32) bool {
+	for _, ch := range chunks {
+		if ch.Score > 0 && ch.Score <= maxCosineDistance {
+			return true
+		}
+	}
+	return false
+}
+
+// ReferenceOverlapScore оценивает лексическое совпадение чанка с эталонной подсказкой
+// (ожидаемый ответ, источник) из контрольного вопроса.
+func ReferenceOverlapScore(reference string, ch models.Chunk) float32 {
+	return lexicalOverlapScore(reference, ch.DocName+" "+ch.Text)
+}
+
+// filterRelevantChunks фильтрует чанки по релевантности.
+// Для каждого кандидата в stats.Decisions записывается причина, по которой он отобран или отброшен.
+func (s *RAGService) filterRelevantChunks(chunks []models.Chunk, maxChunks int, settings *models.AskSettings) ([]models.Chunk, chunkFilterStats) {
+	stats := chunkFilterStats{Decisions: make(map[string]string, len(chunks))}
 	if len(chunks) == 0 {
-		return chunks
+		return chunks, stats
 	}
 	if maxChunks <= 0 {
 		maxChunks = defaultTopK
 	}
 
 	minChunkLen, maxCosineDist, maxGapFromTopHit := resolveRetrievalThresholds(settings)
+	mmrEnabled, mmrLambda, duplicateSim := resolveMMRSettings(settings)
+
+	// Без MMR достаточно первых maxChunks прошедших фильтр; с MMR отбираем из всех прошедших.
+	limit := maxChunks
+	if mmrEnabled {
+		limit = len(chunks)
+	}
 
 	useScore := false
 	bestScore := float32(1e9)
@@ -366,47 +741,185 @@ func (s *RAGService) filterRelevantChunks(chunks []models.Chunk, maxChunks int,
 	// Отбираем содержательные фрагменты и, если доступен score, режем хвост по distance.
 	filtered := make([]models.Chunk, 0, maxChunks)
 	for _, ch := range chunks {
-		if len([]rune(strings.TrimSpace(ch.Text))) < minChunkLen {
+		key := makeChunkKey(ch)
+		if n := len([]rune(strings.TrimSpace(ch.Text))); n < minChunkLen {
+			stats.Decisions[key] = fmt.Sprintf("%s: %d < %d chars", reasonTooShort, n, minChunkLen)
 			continue
 		}
 
 		if useScore && ch.Score > 0 {
 			if ch.Score > maxCosineDist {
+				stats.Decisions[key] = fmt.Sprintf("%s: %.3f > %.3f", reasonMaxCosineDistance, ch.Score, maxCosineDist)
 				continue
 			}
 			if ch.Score-bestScore > maxGapFromTopHit {
+				stats.Decisions[key] = fmt.Sprintf("%s: %.3f - %.3f > %.3f", reasonMaxDistanceGap, ch.Score, bestScore, maxGapFromTopHit)
 				continue
 			}
 		}
 
-		filtered = append(filtered, ch)
-		if len(filtered) >= maxChunks {
-			break
+		if len(filtered) >= limit {
+			stats.Decisions[key] = fmt.Sprintf("%s: %d", reasonTopKLimit, maxChunks)
+			continue
 		}
+		stats.Decisions[key] = reasonSelected
+		filtered = append(filtered, ch)
 	}
 
+	// Если пороги отсекли всё — берём первые достаточно длинные фрагменты, игнорируя distance.
 	if len(filtered) == 0 {
 		for _, ch := range chunks {
 			if len([]rune(strings.TrimSpace(ch.Text))) >= minChunkLen {
+				key := makeChunkKey(ch)
+				stats.Decisions[key] = reasonFallbackSelected + " (" + stats.Decisions[key] + ")"
 				filtered = append(filtered, ch)
-				if len(filtered) >= maxChunks {
+				if len(filtered) >= limit {
 					break
 				}
 			}
 		}
 	}
 
-	return filtered
+	if mmrEnabled {
+		passed := filtered
+		filtered, stats.RedundantDropped = selectByMMR(passed, maxChunks, mmrLambda, duplicateSim)
+		chosen := make(map[string]struct{}, len(filtered))
+		for _, ch := range filtered {
+			chosen[makeChunkKey(ch)] = struct{}{}
+		}
+		for _, ch := range passed {
+			if _, ok := chosen[makeChunkKey(ch)]; !ok {
+				stats.Decisions[makeChunkKey(ch)] = reasonMMRNotSelected
+			}
+		}
+	}
+
+	return filtered, stats
+}
+
+func resolveMMRSettings(settings *models.AskSettings) (bool, float32, float32) {
+	if settings == nil || !settings.EnableMMR {
+		return false, 0, 0
+	}
+
+	lambda := defaultMMRLambda
+	if settings.MMRLambda > 0 && settings.MMRLambda <= 1 {
+		lambda = settings.MMRLambda
+	}
+	duplicateSim := defaultDuplicateSim
+	if settings.DuplicateSimilarity > 0 && settings.DuplicateSimilarity <= 1 {
+		duplicateSim = settings.DuplicateSimilarity
+	}
+
+	return true, lambda, duplicateSim
 }
 
-func (s *RAGService) rankKeywordCandidates(query string, chunks []models.Chunk) []models.Chunk {
+// selectByMMR выбирает до maxChunks кандидатов по maximal marginal relevance:
+// lambda*relevance - (1-lambda)*max_similarity_to_selected. Кандидаты, почти совпадающие
+// с уже выбранными (similarity >= duplicateSim), отбрасываются как избыточные.
+func selectByMMR(candidates []models.Chunk, maxChunks int, lambda, duplicateSim float32) ([]models.Chunk, int) {
+	if len(candidates) == 0 {
+		return candidates, 0
+	}
+
+	relevance := make([]float32, len(candidates))
+	maxRelevance := float32(0)
+	for i, ch := range candidates {
+		relevance[i] = chunkRelevance(ch)
+		if relevance[i] > maxRelevance {
+			maxRelevance = relevance[i]
+		}
+	}
+	if maxRelevance > 0 {
+		for i := range relevance {
+			relevance[i] /= maxRelevance
+		}
+	}
+
+	remaining := make([]int, len(candidates))
+	for i := range remaining {
+		remaining[i] = i
+	}
+
+	selected := make([]models.Chunk, 0, maxChunks)
+	redundant := 0
+	for len(selected) < maxChunks && len(remaining) > 0 {
+		bestPos := -1
+		bestScore := float32(0)
+		kept := remaining[:0]
+		for _, idx := range remaining {
+			maxSim := float32(0)
+			for _, sel := range selected {
+				if sim := chunkSimilarity(candidates[idx], sel); sim > maxSim {
+					maxSim = sim
+				}
+			}
+			if len(selected) > 0 && maxSim >= duplicateSim {
+				redundant++
+				continue
+			}
+
+			score := lambda*relevance[idx] - (1-lambda)*maxSim
+			if bestPos < 0 || score > bestScore {
+				bestPos = len(kept)
+				bestScore = score
+			}
+			kept = append(kept, idx)
+		}
+		remaining = kept
+		if bestPos < 0 {
+			break
+		}
+
+		selected = append(selected, candidates[remaining[bestPos]])
+		remaining = append(remaining[:bestPos], remaining[bestPos+1:]...)
+	}
+
+	return selected, redundant
+}
+
+// chunkRelevance возвращает лучшую доступную оценку релевантности кандидата.
+func chunkRelevance(ch models.Chunk) float32 {
+	if ch.HybridScore > 0 {
+		return ch.HybridScore
+	}
+	if ch.Score > 0 {
+		return cosineDistanceToSimilarity(ch.Score)
+	}
+	return ch.KeywordScore
+}
+
+// chunkSimilarity — cosine similarity эмбеддингов; без эмбеддингов — лексическое пересечение.
+func chunkSimilarity(a, b models.Chunk) float32 {
+	va := a.Embedding.Slice()
+	vb := b.Embedding.Slice()
+	if len(va) > 0 && len(va) == len(vb) {
+		return cosineSimilarity(va, vb)
+	}
+	return lexicalOverlapScore(a.Text, b.Text)
+}
+
+func cosineSimilarity(a, b []float32) float32 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return float32(dot / (math.Sqrt(normA) * math.Sqrt(normB)))
+}
+
+func (s *RAGService) rankKeywordCandidates(query string, chunks []models.Chunk, expansion queryExpansion) []models.Chunk {
 	if len(chunks) == 0 {
 		return chunks
 	}
 
 	ranked := make([]models.Chunk, 0, len(chunks))
 	for _, ch := range chunks {
-		ch.KeywordScore = lexicalOverlapScore(query, ch.Text)
+		ch.KeywordScore = expandedOverlapScore(query, ch.Text, expansion)
 		ch.HybridScore = ch.KeywordScore
 		ch.RetrievalSource = "keyword"
 		ranked = append(ranked, ch)
@@ -418,11 +931,14 @@ func (s *RAGService) rankKeywordCandidates(query string, chunks []models.Chunk)
 		}
 		return ranked[i].KeywordScore > ranked[j].KeywordScore
 	})
+	for i := range ranked {
+		ranked[i].KeywordRank = i + 1
+	}
 
 	return ranked
 }
 
-func (s *RAGService) mergeHybridCandidates(query string, vectorChunks, keywordChunks []models.Chunk, maxCandidates int) []models.Chunk {
+func (s *RAGService) mergeHybridCandidates(query string, vectorChunks, keywordChunks []models.Chunk, maxCandidates int, weights HybridWeights, expansion queryExpansion) []models.Chunk {
 	type scoredCandidate struct {
 		chunk       models.Chunk
 		vectorRank  int
@@ -446,7 +962,7 @@ func (s *RAGService) mergeHybridCandidates(query string, vectorChunks, keywordCh
 			vectorRank:  i,
 			keywordRank: -1,
 			vectorSim:   cosineDistanceToSimilarity(ch.Score),
-			keywordSim:  lexicalOverlapScore(query, ch.Text),
+			keywordSim:  expandedOverlapScore(query, ch.Text, expansion),
 		}
 		cand.chunk.KeywordScore = cand.keywordSim
 		cand.chunk.RetrievalSource = "vector"
@@ -457,7 +973,7 @@ func (s *RAGService) mergeHybridCandidates(query string, vectorChunks, keywordCh
 		key := makeChunkKey(ch)
 		kwScore := ch.KeywordScore
 		if kwScore <= 0 {
-			kwScore = lexicalOverlapScore(query, ch.Text)
+			kwScore = expandedOverlapScore(query, ch.Text, expansion)
 		}
 
 		if cand, ok := candidates[key]; ok {
@@ -485,12 +1001,15 @@ func (s *RAGService) mergeHybridCandidates(query string, vectorChunks, keywordCh
 
 	merged := make([]scoredCandidate, 0, len(candidates))
 	for _, cand := range candidates {
-		cand.rrf = rrf(cand.vectorRank) + rrf(cand.keywordRank)
-		cand.total = defaultVectorWeight*cand.vectorSim + defaultKeywordWeight*cand.keywordSim + defaultRRFWeight*cand.rrf
+		cand.rrf = rrf(cand.vectorRank, weights.RRFDenominator) + rrf(cand.keywordRank, weights.RRFDenominator)
+		cand.total = weights.Vector*cand.vectorSim + weights.Keyword*cand.keywordSim + weights.RRF*cand.rrf
 		if cand.vectorRank < 0 {
-			cand.total = defaultKeywordWeight*cand.keywordSim + defaultRRFWeight*cand.rrf
+			cand.total = weights.Keyword*cand.keywordSim + weights.RRF*cand.rrf
 		}
 		cand.chunk.HybridScore = cand.total
+		cand.chunk.RRFScore = cand.rrf
+		cand.chunk.VectorRank = cand.vectorRank + 1
+		cand.chunk.KeywordRank = cand.keywordRank + 1
 		merged = append(merged, *cand)
 	}
 
@@ -525,11 +1044,14 @@ func makeChunkKey(ch models.Chunk) string {
 	return strings.TrimSpace(ch.DocName) + "::" + strings.TrimSpace(ch.ChunkName) + "::" + utils.TruncateByChars(strings.TrimSpace(ch.Text), 80)
 }
 
-func rrf(rank int) float32 {
+func rrf(rank int, denominator float32) float32 {
 	if rank < 0 {
 		return 0
 	}
-	return 1.0 / (defaultRRFDenominator + float32(rank+1))
+	if denominator <= 0 {
+		denominator = defaultRRFDenominator
+	}
+	return 1.0 / (denominator + float32(rank+1))
 }
 
 func cosineDistanceToSimilarity(distance float32) float32 {
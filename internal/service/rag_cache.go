@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/katakuxiko/Diplom/internal/models"
+)
+
+const (
+	queryEmbeddingCacheSize      = 2000
+	queryEmbeddingCacheTTL       = 6 * time.Hour
+	answerCacheMaxEntriesPerChat = 200
+	defaultAnswerCacheSimilarity = float32(0.95)
+	defaultAnswerCacheTTLMinutes = 24 * 60
+)
+
+// embeddingCacheKey — эмбеддинг зависит от провайдера, адреса и модели, поэтому они входят в ключ.
+func embeddingCacheKey(text string, settings *models.AskSettings) string {
+	provider := resolveEmbeddingProvider(settings)
+	providerType, base, model := "", "", ""
+	if settings != nil {
+		providerType = normalizeProviderType(settings.EmbedProviderType)
+		model = strings.TrimSpace(settings.EmbedModel)
+		if provider == "external" {
+			base = strings.TrimSpace(settings.EmbedExternalBaseURL)
+			if base == "" {
+				base = strings.TrimSpace(settings.ExternalBaseURL)
+			}
+		}
+	}
+	return provider + ":" + providerType + "|" + base + "|" + model + "|" + normalizeCacheText(text)
+}
+
+// normalizeCacheText приводит запрос к виду, в котором одинаковые по смыслу формулировки совпадают:
+// нижний регистр, схлопнутые пробелы, без завершающей пунктуации.
+func normalizeCacheText(text string) string {
+	text = strings.ToLower(strings.Join(strings.Fields(text), " "))
+	return strings.TrimRight(text, " ?!.…")
+}
+
+// embedQuery возвращает эмбеддинг поискового запроса, используя LRU-кэш.
+func (s *RAGService) embedQuery(ctx context.Context, text string, settings *models.AskSettings) ([]float32, error) {
+	key := embeddingCacheKey(text, settings)
+	if s.embeddingCache != nil {
+		if vec, ok := s.embeddingCache.Get(key); ok {
+			return vec, nil
+		}
+	}
+	vec, err := s.llm.EmbeddingWithSettings(ctx, text, settings)
+	if err != nil {
+		return nil, err
+	}
+	if s.embeddingCache != nil {
+		s.embeddingCache.Put(key, vec)
+	}
+	return vec, nil
+}
+
+// cachedAnswer — ответ, сохранённый в семантическом кэше чата.
+type cachedAnswer struct {
+	scope         string // уровень доступа и модель: ответы для разных областей не смешиваются
+	query         string
+	embedding     []float32
+	answer        string
+	chunks        []models.Chunk
+	diagnostics   RetrievalDiagnostics
+	corpusVersion string
+	expiresAt     time.Time
+}
+
+// answerCache — семантический кэш ответов: хранит последние ответы по каждому чату
+// и отдаёт ответ на новый запрос, если его эмбеддинг достаточно близок к сохранённому.
+type answerCache struct {
+	mu      sync.Mutex
+	entries map[uuid.UUID][]*cachedAnswer // в начале — самые свежие
+	hits    int64
+	misses  int64
+}
+
+func newAnswerCache() *answerCache {
+	return &answerCache{entries: make(map[uuid.UUID][]*cachedAnswer)}
+}
+
+func (c *answerCache) lookup(chatID uuid.UUID, scope, corpusVersion string, embedding []float32, threshold float32) (*cachedAnswer, float32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	var best *cachedAnswer
+	bestSim := float32(0)
+	kept := c.entries[chatID][:0]
+	for _, entry := range c.entries[chatID] {
+		// Записи, устаревшие по времени или по версии корпуса, удаляем сразу.
+		if now.After(entry.expiresAt) || entry.corpusVersion != corpusVersion {
+			continue
+		}
+		kept = append(kept, entry)
+		if entry.scope != scope {
+			continue
+		}
+		if sim := cosineSimilarity(embedding, entry.embedding); sim >= threshold && sim > bestSim {
+			best, bestSim = entry, sim
+		}
+	}
+	c.entries[chatID] = kept
+
+	if best == nil {
+		c.misses++
+		return nil, 0
+	}
+	c.hits++
+	return best, bestSim
+}
+
+func (c *answerCache) store(chatID uuid.UUID, entry *cachedAnswer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := append([]*cachedAnswer{entry}, c.entries[chatID]...)
+	if len(entries) > answerCacheMaxEntriesPerChat {
+		entries = entries[:answerCacheMaxEntriesPerChat]
+	}
+	c.entries[chatID] = entries
+}
+
+func (c *answerCache) invalidate(chatID uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, chatID)
+}
+
+func (c *answerCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[uuid.UUID][]*cachedAnswer)
+}
+
+func (c *answerCache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	size := 0
+	for _, entries := range c.entries {
+		size += len(entries)
+	}
+	return newCacheStats(size, 0, c.hits, c.misses)
+}
+
+func resolveAnswerCacheSettings(settings *models.AskSettings) (float32, time.Duration) {
+	threshold := defaultAnswerCacheSimilarity
+	ttlMinutes := defaultAnswerCacheTTLMinutes
+	if settings != nil {
+		if settings.AnswerCacheSimilarity > 0 && settings.AnswerCacheSimilarity <= 1 {
+			threshold = settings.AnswerCacheSimilarity
+		}
+		if settings.AnswerCacheTTLMinutes > 0 {
+			ttlMinutes = settings.AnswerCacheTTLMinutes
+		}
+	}
+	return threshold, time.Duration(ttlMinutes) * time.Minute
+}
+
+// answerCacheApplicable — кэшировать можно только ответы, не зависящие от конкретного запроса:
+// без истории диалога, фильтров и режима explain.
+func answerCacheApplicable(settings *models.AskSettings, history []models.ChatContextMessage) bool {
+	if settings == nil || !settings.EnableAnswerCache {
+		return false
+	}
+	if len(history) > 0 && (settings.EnableHistory || settings.EnableQueryRewrite) {
+		return false
+	}
+	return settings.Filters.IsEmpty() && !settings.Explain && len(settings.ResponseSchema) == 0 && !settings.AgentMode
+}
+
+// answerCacheScope — отпечаток всего, что влияет на ответ, кроме вопроса и корпуса: настройки чата
+// (модель, провайдер и адрес, температура, режим и веса поиска, синонимы, ссылки, проверка по источникам…),
+// выбранный шаблон промпта, top_k и уровень доступа. Ключи API и параметры самого кэша в отпечаток не входят.
+func answerCacheScope(settings *models.AskSettings, accessLevel, topK int) string {
+	var scoped models.AskSettings
+	prompt := ""
+	if settings != nil {
+		scoped = *settings
+		if settings.Prompt != nil && settings.Prompt.Name != "" {
+			prompt = fmt.Sprintf("%s@%d", settings.Prompt.Name, settings.Prompt.Version)
+		}
+	}
+	scoped.ExternalAPIKey = ""
+	scoped.EmbedExternalAPIKey = ""
+	scoped.EnableAnswerCache = false
+	scoped.AnswerCacheSimilarity = 0
+	scoped.AnswerCacheTTLMinutes = 0
+	scoped.RequestsLimit = 0
+	scoped.RequestsWindow = 0
+
+	raw, _ := json.Marshal(struct {
+		Settings    models.AskSettings `json:"settings"`
+		Weights     HybridWeights      `json:"weights"`
+		Prompt      string             `json:"prompt"`
+		AccessLevel int                `json:"access_level"`
+		TopK        int                `json:"top_k"`
+	}{scoped, ResolveHybridWeights(settings), prompt, accessLevel, topK})
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:16])
+}
+
+// InvalidateAnswerCache сбрасывает кэш ответов чата: вызывается после любого изменения документов чата,
+// его настроек или словаря синонимов.
+func (s *RAGService) InvalidateAnswerCache(chatID uuid.UUID) {
+	if s.answerCache != nil {
+		s.answerCache.invalidate(chatID)
+	}
+}
+
+// ClearCaches очищает кэш эмбеддингов запросов и кэш ответов.
+func (s *RAGService) ClearCaches() {
+	if s.embeddingCache != nil {
+		s.embeddingCache.Clear()
+	}
+	if s.answerCache != nil {
+		s.answerCache.clear()
+	}
+}
+
+// CacheStats возвращает размер и долю попаданий кэшей.
+func (s *RAGService) CacheStats() map[string]CacheStats {
+	stats := make(map[string]CacheStats, 2)
+	if s.embeddingCache != nil {
+		stats["query_embeddings"] = s.embeddingCache.Stats()
+	}
+	if s.answerCache != nil {
+		stats["answers"] = s.answerCache.stats()
+	}
+	return stats
+}
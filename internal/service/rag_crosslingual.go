@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/katakuxiko/Diplom/internal/models"
+	"github.com/katakuxiko/Diplom/internal/utils"
+)
+
+// maxCrossLingualTargets ограничивает число переводов запроса (и параллельных поисков).
+const maxCrossLingualTargets = 3
+
+// resolveCrossLingualMode: "fallback" (по умолчанию) — переводить запрос, только если по исходному
+// ничего не найдено; "always" — всегда искать и на других языках корпуса; "off" — не переводить.
+func resolveCrossLingualMode(settings *models.AskSettings) string {
+	if settings == nil {
+		return "fallback"
+	}
+	mode := strings.ToLower(strings.TrimSpace(settings.CrossLingualMode))
+	switch mode {
+	case "always", "off":
+		return mode
+	default:
+		return "fallback"
+	}
+}
+
+// resolveCrossLingualTargets возвращает языки корпуса, на которые нужно перевести запрос.
+// Языки корпуса берутся из настроек чата, а если они не заданы — из языков загруженных чанков.
+func (s *RAGService) resolveCrossLingualTargets(ctx context.Context, query string, chatID uuid.UUID, settings *models.AskSettings, diagnostics *RetrievalDiagnostics) []string {
+	queryLang := utils.DetectLanguage(query)
+	diagnostics.QueryLanguage = queryLang
+	if queryLang == "" || s.llm == nil {
+		return nil
+	}
+
+	var corpus []string
+	if settings != nil && len(settings.CorpusLanguages) > 0 {
+		corpus = settings.CorpusLanguages
+	} else if s.ChunkRepository != nil {
+		languages, err := s.ChunkRepository.ListLanguages(ctx, chatID)
+		if err != nil {
+			log.Printf("corpus languages lookup failed: %v", err)
+			return nil
+		}
+		corpus = languages
+	}
+
+	targets := make([]string, 0, len(corpus))
+	seen := make(map[string]struct{}, len(corpus))
+	for _, lang := range corpus {
+		lang = strings.ToLower(strings.TrimSpace(lang))
+		if lang == "" {
+			continue
+		}
+		if _, ok := seen[lang]; ok {
+			continue
+		}
+		seen[lang] = struct{}{}
+		diagnostics.CorpusLanguages = append(diagnostics.CorpusLanguages, lang)
+		if lang != queryLang && len(targets) < maxCrossLingualTargets {
+			targets = append(targets, lang)
+		}
+	}
+	return targets
+}
+
+// collectTranslatedCandidates параллельно переводит запрос на каждый язык из targetLangs
+// и ищет кандидатов по переводу. Возвращает ранжированные списки для последующего RRF.
+func (s *RAGService) collectTranslatedCandidates(ctx context.Context, query string, targetLangs []string, retrievalMode string, expandedTopK int, chatID uuid.UUID, settings *models.AskSettings, accessLevel int, weights HybridWeights, diagnostics *RetrievalDiagnostics) [][]models.Chunk {
+	type translatedResult struct {
+		lang       string
+		query      string
+		candidates []models.Chunk
+		local      RetrievalDiagnostics
+	}
+
+	results := make([]translatedResult, len(targetLangs))
+	var wg sync.WaitGroup
+	for i, lang := range targetLangs {
+		wg.Add(1)
+		go func(i int, lang string) {
+			defer wg.Done()
+			res := translatedResult{lang: lang}
+			translated, err := s.llm.TranslateQueryForRetrieval(ctx, query, lang, settings)
+			if err != nil {
+				log.Printf("retrieval translation to %s failed: %v", lang, err)
+				results[i] = res
+				return
+			}
+			translated = strings.TrimSpace(translated)
+			if translated == "" || sameNormalizedQuery(query, translated) {
+				results[i] = res
+				return
+			}
+			res.query = translated
+			candidates, err := s.collectCandidates(ctx, translated, translated, retrievalMode, expandedTopK, chatID, settings, accessLevel, weights, &res.local)
+			if err != nil {
+				log.Printf("retrieval search for %s translation failed: %v", lang, err)
+			} else {
+				res.candidates = candidates
+			}
+			results[i] = res
+		}(i, lang)
+	}
+	wg.Wait()
+
+	lists := make([][]models.Chunk, 0, len(results))
+	queries := make([]string, 0, len(results))
+	for _, res := range results {
+		if res.query == "" {
+			continue
+		}
+		if diagnostics.TranslatedQueries == nil {
+			diagnostics.TranslatedQueries = make(map[string]string, len(results))
+		}
+		diagnostics.TranslatedQueries[res.lang] = res.query
+		diagnostics.VectorCandidates += res.local.VectorCandidates
+		diagnostics.KeywordCandidates += res.local.KeywordCandidates
+		queries = append(queries, res.query)
+		if len(res.candidates) > 0 {
+			lists = append(lists, res.candidates)
+		}
+	}
+	diagnostics.FallbackQuery = strings.Join(queries, " | ")
+	return lists
+}
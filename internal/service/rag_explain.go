@@ -0,0 +1,89 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/katakuxiko/Diplom/internal/models"
+	"github.com/katakuxiko/Diplom/internal/utils"
+)
+
+// Причины решений filterRelevantChunks (режим explain).
+const (
+	reasonSelected          = "selected"
+	reasonFallbackSelected  = "fallback_selected"
+	reasonTooShort          = "too_short"
+	reasonMaxCosineDistance = "max_cosine_distance"
+	reasonMaxDistanceGap    = "max_distance_gap"
+	reasonTopKLimit         = "top_k_limit"
+	reasonMMRNotSelected    = "mmr_not_selected"
+	reasonCollapsedToParent = "collapsed_into_parent"
+
+	explainPreviewChars = 160
+)
+
+// CandidateExplanation описывает одного кандидата поиска: его позиции в выдачах,
+// оценки ранжирования и решение фильтра с причиной.
+type CandidateExplanation struct {
+	Stage           string    `json:"stage"`
+	Rank            int       `json:"rank"`
+	ChunkID         uuid.UUID `json:"chunk_id"`
+	DocID           uuid.UUID `json:"doc_id"`
+	DocName         string    `json:"doc_name"`
+	ChunkName       string    `json:"chunk_name"`
+	TextChars       int       `json:"text_chars"`
+	TextPreview     string    `json:"text_preview"`
+	VectorRank      int       `json:"vector_rank,omitempty"`
+	KeywordRank     int       `json:"keyword_rank,omitempty"`
+	VectorDistance  float32   `json:"vector_distance,omitempty"`
+	KeywordScore    float32   `json:"keyword_score"`
+	RRF             float32   `json:"rrf"`
+	HybridTotal     float32   `json:"hybrid_total"`
+	Recency         float32   `json:"recency,omitempty"`
+	RetrievalSource string    `json:"retrieval_source"`
+	Decision        string    `json:"decision"` // "selected" или "dropped"
+	Reason          string    `json:"reason"`
+}
+
+func explainEnabled(settings *models.AskSettings) bool {
+	return settings != nil && settings.Explain
+}
+
+// buildCandidateExplanations собирает объяснения для всех кандидатов в порядке ранжирования.
+// Кандидаты, которых нет в decisions, были схлопнуты в родительский раздел до фильтрации.
+func buildCandidateExplanations(candidates []models.Chunk, decisions map[string]string, stage string) []CandidateExplanation {
+	out := make([]CandidateExplanation, 0, len(candidates))
+	for i, ch := range candidates {
+		reason, ok := decisions[makeChunkKey(ch)]
+		if !ok {
+			reason = reasonCollapsedToParent
+		}
+		decision := "dropped"
+		if reason == reasonSelected || strings.HasPrefix(reason, reasonFallbackSelected) {
+			decision = "selected"
+		}
+
+		text := strings.TrimSpace(ch.Text)
+		out = append(out, CandidateExplanation{
+			Stage:           stage,
+			Rank:            i + 1,
+			ChunkID:         ch.ID,
+			DocID:           ch.DocID,
+			DocName:         ch.DocName,
+			ChunkName:       ch.ChunkName,
+			TextChars:       len([]rune(text)),
+			TextPreview:     utils.TruncateByChars(utils.NormalizeText(text), explainPreviewChars),
+			VectorRank:      ch.VectorRank,
+			KeywordRank:     ch.KeywordRank,
+			VectorDistance:  ch.Score,
+			KeywordScore:    ch.KeywordScore,
+			RRF:             ch.RRFScore,
+			HybridTotal:     ch.HybridScore,
+			Recency:         ch.RecencyScore,
+			RetrievalSource: ch.RetrievalSource,
+			Decision:        decision,
+			Reason:          reason,
+		})
+	}
+	return out
+}
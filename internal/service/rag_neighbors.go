@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/katakuxiko/Diplom/internal/models"
+)
+
+const (
+	maxNeighborWindow     = 3
+	passageHeaderTokens   = 12
+	maxOverlapWordsToTrim = 80
+)
+
+// neighborSpan — непрерывный диапазон чанков документа, собранный вокруг найденного чанка.
+type neighborSpan struct {
+	hit    models.Chunk
+	docID  uuid.UUID
+	lo, hi int
+	merged bool
+}
+
+func resolveNeighborWindow(settings *models.AskSettings) int {
+	if settings == nil || settings.NeighborWindow <= 0 {
+		return 0
+	}
+	if settings.NeighborWindow > maxNeighborWindow {
+		return maxNeighborWindow
+	}
+	return settings.NeighborWindow
+}
+
+// expandWithNeighbors расширяет каждый найденный чанк соседними чанками того же документа
+// (до window штук с каждой стороны) и склеивает их в один непрерывный фрагмент.
+// Расширение идёт по шагам (сначала ±1 для всех, затем ±2 ...), пока укладывается в budget токенов.
+// Возвращает фрагменты в порядке ранжирования и число добавленных соседних чанков.
+func (s *RAGService) expandWithNeighbors(ctx context.Context, hits []models.Chunk, window int, tok TokenEstimator, budget, accessLevel int) ([]models.Chunk, int, error) {
+	if window <= 0 || len(hits) == 0 || s.ChunkRepository == nil {
+		return hits, 0, nil
+	}
+
+	// Загружаем соседей одним запросом на документ.
+	type docRange struct{ lo, hi int }
+	ranges := make(map[uuid.UUID]*docRange)
+	for _, hit := range hits {
+		if hit.DocID == uuid.Nil {
+			continue
+		}
+		lo, hi := hit.ChunkIndex-window, hit.ChunkIndex+window
+		if lo < 0 {
+			lo = 0
+		}
+		if r, ok := ranges[hit.DocID]; ok {
+			if lo < r.lo {
+				r.lo = lo
+			}
+			if hi > r.hi {
+				r.hi = hi
+			}
+			continue
+		}
+		ranges[hit.DocID] = &docRange{lo: lo, hi: hi}
+	}
+
+	siblings := make(map[uuid.UUID]map[int]models.Chunk, len(ranges))
+	for docID, r := range ranges {
+		chunks, err := s.ChunkRepository.FindByDocIndexRange(ctx, docID, r.lo, r.hi, accessLevel)
+		if err != nil {
+			return hits, 0, err
+		}
+		byIndex := make(map[int]models.Chunk, len(chunks))
+		for _, ch := range chunks {
+			if _, exists := byIndex[ch.ChunkIndex]; !exists {
+				byIndex[ch.ChunkIndex] = ch
+			}
+		}
+		siblings[docID] = byIndex
+	}
+
+	spans := make([]*neighborSpan, 0, len(hits))
+	for _, hit := range hits {
+		spans = append(spans, &neighborSpan{hit: hit, docID: hit.DocID, lo: hit.ChunkIndex, hi: hit.ChunkIndex})
+	}
+
+	spanText := func(sp *neighborSpan, lo, hi int) string {
+		if sp.docID == uuid.Nil {
+			return sp.hit.Text
+		}
+		byIndex := siblings[sp.docID]
+		text := ""
+		for idx := lo; idx <= hi; idx++ {
+			ch, ok := byIndex[idx]
+			if !ok {
+				if idx == sp.hit.ChunkIndex {
+					ch = sp.hit
+				} else {
+					continue
+				}
+			}
+			text = joinOverlappingTexts(text, ch.Text)
+		}
+		return text
+	}
+
+	used := 0
+	for _, sp := range spans {
+		used += tok.Count(sp.hit.Text) + passageHeaderTokens
+	}
+
+	added := 0
+	for step := 1; step <= window; step++ {
+		for _, sp := range spans {
+			if sp.docID == uuid.Nil {
+				continue
+			}
+			byIndex := siblings[sp.docID]
+			for _, idx := range []int{sp.lo - 1, sp.hi + 1} {
+				if _, ok := byIndex[idx]; !ok || spanCovers(spans, sp.docID, idx) {
+					continue
+				}
+				lo, hi := sp.lo, sp.hi
+				if idx < lo {
+					lo = idx
+				} else {
+					hi = idx
+				}
+				before := tok.Count(spanText(sp, sp.lo, sp.hi))
+				after := tok.Count(spanText(sp, lo, hi))
+				if used+after-before > budget {
+					continue
+				}
+				sp.lo, sp.hi = lo, hi
+				used += after - before
+				added++
+			}
+		}
+	}
+
+	// Соприкасающиеся диапазоны одного документа сливаем в фрагмент с более высоким рангом.
+	for i, sp := range spans {
+		if sp.merged || sp.docID == uuid.Nil {
+			continue
+		}
+		for _, other := range spans[i+1:] {
+			if other.merged || other.docID != sp.docID {
+				continue
+			}
+			if other.lo <= sp.hi+1 && other.hi >= sp.lo-1 {
+				if other.lo < sp.lo {
+					sp.lo = other.lo
+				}
+				if other.hi > sp.hi {
+					sp.hi = other.hi
+				}
+				other.merged = true
+			}
+		}
+	}
+
+	passages := make([]models.Chunk, 0, len(spans))
+	for _, sp := range spans {
+		if sp.merged {
+			continue
+		}
+		passage := sp.hit
+		passage.Text = spanText(sp, sp.lo, sp.hi)
+		passages = append(passages, passage)
+	}
+
+	return passages, added, nil
+}
+
+func spanCovers(spans []*neighborSpan, docID uuid.UUID, idx int) bool {
+	for _, sp := range spans {
+		if sp.docID == docID && idx >= sp.lo && idx <= sp.hi {
+			return true
+		}
+	}
+	return false
+}
+
+// joinOverlappingTexts склеивает соседние чанки, убирая повтор overlap-слов на стыке.
+func joinOverlappingTexts(prev, next string) string {
+	prev = strings.TrimSpace(prev)
+	next = strings.TrimSpace(next)
+	if prev == "" {
+		return next
+	}
+	if next == "" {
+		return prev
+	}
+
+	prevWords := strings.Fields(prev)
+	nextWords := strings.Fields(next)
+	maxOverlap := maxOverlapWordsToTrim
+	if len(prevWords) < maxOverlap {
+		maxOverlap = len(prevWords)
+	}
+	if len(nextWords) < maxOverlap {
+		maxOverlap = len(nextWords)
+	}
+
+	for n := maxOverlap; n > 0; n-- {
+		if strings.Join(prevWords[len(prevWords)-n:], " ") == strings.Join(nextWords[:n], " ") {
+			if n == len(nextWords) {
+				return prev
+			}
+			return prev + " " + strings.Join(nextWords[n:], " ")
+		}
+	}
+
+	return prev + " " + next
+}
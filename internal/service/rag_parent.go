@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/katakuxiko/Diplom/internal/models"
+)
+
+const (
+	// Размеры для parent-document retrieval: крупный раздел уходит в контекст,
+	// мелкие дочерние чанки используются только для поиска.
+	ParentSectionWords = 900
+	ChildChunkWords    = 120
+	ChildChunkOverlap  = 20
+)
+
+// IsParentRetrieval сообщает, что чат использует режим parent-document retrieval
+// и при загрузке документов нужно сохранять разделы и дочерние чанки.
+func IsParentRetrieval(settings *models.AskSettings) bool {
+	return ResolveRetrievalMode(settings) == "parent"
+}
+
+// collapseByParent оставляет по одному (лучшему по рангу) дочернему чанку на родительский раздел.
+// Кандидаты должны быть уже отсортированы по убыванию релевантности.
+func collapseByParent(candidates []models.Chunk) ([]models.Chunk, int) {
+	seen := make(map[uuid.UUID]struct{}, len(candidates))
+	out := make([]models.Chunk, 0, len(candidates))
+	collapsed := 0
+	for _, ch := range candidates {
+		if ch.ParentID != nil {
+			if _, ok := seen[*ch.ParentID]; ok {
+				collapsed++
+				continue
+			}
+			seen[*ch.ParentID] = struct{}{}
+		}
+		out = append(out, ch)
+	}
+	return out, collapsed
+}
+
+// attachParentSections подменяет текст найденных дочерних чанков текстом родительского раздела.
+// Исходный текст дочернего чанка сохраняется в MatchedText. Возвращает число подставленных разделов.
+func (s *RAGService) attachParentSections(ctx context.Context, chunks []models.Chunk, accessLevel int) ([]models.Chunk, int, error) {
+	if s.ChunkRepository == nil {
+		return chunks, 0, nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(chunks))
+	for _, ch := range chunks {
+		if ch.ParentID != nil {
+			ids = append(ids, *ch.ParentID)
+		}
+	}
+	if len(ids) == 0 {
+		return chunks, 0, nil
+	}
+
+	sections, err := s.ChunkRepository.FindSectionsByIDs(ctx, ids, accessLevel)
+	if err != nil {
+		return chunks, 0, err
+	}
+	byID := make(map[uuid.UUID]models.DocumentSection, len(sections))
+	for _, section := range sections {
+		byID[section.ID] = section
+	}
+
+	attached := 0
+	out := make([]models.Chunk, len(chunks))
+	for i, ch := range chunks {
+		out[i] = ch
+		if ch.ParentID == nil {
+			continue
+		}
+		section, ok := byID[*ch.ParentID]
+		if !ok || section.Text == "" {
+			continue
+		}
+		out[i].MatchedText = ch.Text
+		out[i].Text = section.Text
+		attached++
+	}
+	return out, attached, nil
+}
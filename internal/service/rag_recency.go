@@ -0,0 +1,77 @@
+package service
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/katakuxiko/Diplom/internal/models"
+)
+
+const (
+	defaultRecencyHalfLifeDays = 365
+	maxRecencyWeight           = float32(0.5)
+)
+
+// resolveRecency возвращает вес свежести в итоговом score и период полураспада в днях.
+// Вес 0 — ранжирование по свежести выключено.
+func resolveRecency(settings *models.AskSettings) (float32, int) {
+	if settings == nil || settings.RecencyWeight <= 0 {
+		return 0, 0
+	}
+	weight := settings.RecencyWeight
+	if weight > maxRecencyWeight {
+		weight = maxRecencyWeight
+	}
+	halfLife := settings.RecencyHalfLifeDays
+	if halfLife <= 0 {
+		halfLife = defaultRecencyHalfLifeDays
+	}
+	return weight, halfLife
+}
+
+// recencyReference — момент, от которого считается возраст документа: дата "as of" запроса или текущее время.
+func recencyReference(settings *models.AskSettings) time.Time {
+	if settings != nil && settings.Filters != nil {
+		if asOf := settings.Filters.AsOfBefore(); asOf != nil {
+			return *asOf
+		}
+	}
+	return time.Now()
+}
+
+// recencyScore — экспоненциальное затухание: документ возраста halfLife дней получает 0.5.
+// Документы без даты считаются нейтральными (0.5), документы "из будущего" — свежими (1).
+func recencyScore(docDate *time.Time, reference time.Time, halfLifeDays int) float32 {
+	if docDate == nil || docDate.IsZero() {
+		return 0.5
+	}
+	ageDays := reference.Sub(*docDate).Hours() / 24
+	if ageDays <= 0 {
+		return 1
+	}
+	return float32(math.Pow(0.5, ageDays/float64(halfLifeDays)))
+}
+
+// applyRecency смешивает итоговый score кандидатов со свежестью документа
+// (score = (1-w)·score + w·recency) и пересортировывает кандидатов.
+// При равной релевантности выше оказывается более новая редакция документа.
+func applyRecency(candidates []models.Chunk, settings *models.AskSettings) []models.Chunk {
+	weight, halfLife := resolveRecency(settings)
+	if weight <= 0 || len(candidates) == 0 {
+		return candidates
+	}
+
+	reference := recencyReference(settings)
+	out := make([]models.Chunk, len(candidates))
+	copy(out, candidates)
+	for i := range out {
+		recency := recencyScore(out[i].DocumentDate, reference, halfLife)
+		out[i].RecencyScore = recency
+		out[i].HybridScore = (1-weight)*chunkRelevance(out[i]) + weight*recency
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].HybridScore > out[j].HybridScore
+	})
+	return out
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/katakuxiko/Diplom/internal/models"
+	"github.com/katakuxiko/Diplom/internal/utils"
+)
+
+const (
+	defaultMultiQueryCount  = 3
+	maxMultiQueryCount      = 5
+	hypotheticalDocMaxChars = 400
+)
+
+// ResolveQueryStrategy определяет, как из вопроса строятся поисковые запросы:
+// "single" — один запрос, "multi_query" — перефразировки + RRF, "hyde" — эмбеддинг гипотетического ответа.
+func ResolveQueryStrategy(settings *models.AskSettings) string {
+	if settings == nil {
+		return "single"
+	}
+	strategy := strings.ToLower(strings.TrimSpace(settings.QueryStrategy))
+	switch strategy {
+	case "multi_query", "hyde":
+		return strategy
+	default:
+		return "single"
+	}
+}
+
+func resolveMultiQueryCount(settings *models.AskSettings) int {
+	if settings == nil || settings.MultiQueryCount <= 0 {
+		return defaultMultiQueryCount
+	}
+	if settings.MultiQueryCount > maxMultiQueryCount {
+		return maxMultiQueryCount
+	}
+	return settings.MultiQueryCount
+}
+
+// collectHyDECandidates ищет по эмбеддингу гипотетического ответа, сгенерированного LLM.
+// Лексический поиск по-прежнему идёт по исходному запросу. При ошибке генерации используется сам вопрос.
+func (s *RAGService) collectHyDECandidates(ctx context.Context, query, retrievalMode string, expandedTopK int, chatID uuid.UUID, settings *models.AskSettings, accessLevel int, weights HybridWeights, diagnostics *RetrievalDiagnostics) ([]models.Chunk, error) {
+	embedText := query
+	if retrievalMode != "keyword" && s.llm != nil {
+		hypothetical, err := s.llm.GenerateHypotheticalAnswer(ctx, query, settings)
+		if err != nil {
+			log.Printf("hyde generation failed: %v", err)
+		} else if hypothetical = strings.TrimSpace(hypothetical); hypothetical != "" {
+			embedText = hypothetical
+			diagnostics.HypotheticalDoc = utils.TruncateByChars(hypothetical, hypotheticalDocMaxChars)
+		}
+	}
+
+	return s.collectCandidates(ctx, query, embedText, retrievalMode, expandedTopK, chatID, settings, accessLevel, weights, diagnostics)
+}
+
+// collectMultiQueryCandidates ищет по исходному запросу и его перефразировкам,
+// затем объединяет ранжированные списки через reciprocal rank fusion.
+func (s *RAGService) collectMultiQueryCandidates(ctx context.Context, query, retrievalMode string, expandedTopK int, chatID uuid.UUID, settings *models.AskSettings, accessLevel int, weights HybridWeights, diagnostics *RetrievalDiagnostics) ([]models.Chunk, error) {
+	queries := []string{query}
+	if s.llm != nil {
+		paraphrases, err := s.llm.GenerateQueryParaphrases(ctx, query, resolveMultiQueryCount(settings), settings)
+		if err != nil {
+			log.Printf("multi-query generation failed: %v", err)
+		}
+		for _, p := range paraphrases {
+			duplicate := false
+			for _, existing := range queries {
+				if sameNormalizedQuery(existing, p) {
+					duplicate = true
+					break
+				}
+			}
+			if !duplicate {
+				queries = append(queries, p)
+				diagnostics.QueryVariants = append(diagnostics.QueryVariants, p)
+			}
+		}
+	}
+
+	lists := make([][]models.Chunk, 0, len(queries))
+	for i, q := range queries {
+		candidates, err := s.collectCandidates(ctx, q, q, retrievalMode, expandedTopK, chatID, settings, accessLevel, weights, diagnostics)
+		if err != nil {
+			// Исходный запрос обязателен, перефразировки — нет.
+			if i == 0 {
+				return nil, err
+			}
+			log.Printf("multi-query variant search failed (%q): %v", q, err)
+			continue
+		}
+		lists = append(lists, candidates)
+	}
+
+	return fuseRankedLists(lists, weights.RRFDenominator, expandedTopK*2), nil
+}
+
+// fuseRankedLists объединяет несколько ранжированных списков кандидатов через RRF.
+// Для каждого чанка сохраняется лучшая cosine distance (для порогов отбора) и лучшие оценки.
+func fuseRankedLists(lists [][]models.Chunk, denominator float32, maxCandidates int) []models.Chunk {
+	if len(lists) == 1 {
+		return lists[0]
+	}
+
+	type fused struct {
+		chunk models.Chunk
+		score float32
+	}
+	byKey := make(map[string]*fused)
+	for _, list := range lists {
+		for rank, ch := range list {
+			key := makeChunkKey(ch)
+			item, ok := byKey[key]
+			if !ok {
+				item = &fused{chunk: ch}
+				byKey[key] = item
+			} else {
+				if ch.Score > 0 && (item.chunk.Score <= 0 || ch.Score < item.chunk.Score) {
+					item.chunk.Score = ch.Score
+				}
+				if ch.KeywordScore > item.chunk.KeywordScore {
+					item.chunk.KeywordScore = ch.KeywordScore
+				}
+				if ch.HybridScore > item.chunk.HybridScore {
+					item.chunk.HybridScore = ch.HybridScore
+				}
+			}
+			item.score += rrf(rank, denominator)
+		}
+	}
+
+	merged := make([]fused, 0, len(byKey))
+	for _, item := range byKey {
+		item.chunk.RRFScore = item.score
+		merged = append(merged, *item)
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		if merged[i].score == merged[j].score {
+			return merged[i].chunk.HybridScore > merged[j].chunk.HybridScore
+		}
+		return merged[i].score > merged[j].score
+	})
+
+	if maxCandidates <= 0 || maxCandidates > len(merged) {
+		maxCandidates = len(merged)
+	}
+	result := make([]models.Chunk, 0, maxCandidates)
+	for _, item := range merged[:maxCandidates] {
+		result = append(result, item.chunk)
+	}
+	return result
+}
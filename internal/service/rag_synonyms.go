@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/katakuxiko/Diplom/internal/models"
+)
+
+const maxKeywordExpansions = 6
+
+// queryExpansion — раскрытия терминов запроса по словарю чата: формулировка из запроса -> альтернативы.
+type queryExpansion map[string][]string
+
+// SetSynonymService подключает словарь синонимов чата к поиску.
+func (s *RAGService) SetSynonymService(svc *SynonymService) {
+	s.synonyms = svc
+}
+
+// expandQuery раскрывает термины запроса по словарю чата и отмечает раскрытия в диагностике.
+func (s *RAGService) expandQuery(ctx context.Context, query string, chatID uuid.UUID, diagnostics *RetrievalDiagnostics) queryExpansion {
+	if s.synonyms == nil {
+		return nil
+	}
+	expansions, err := s.synonyms.Expand(ctx, chatID, query)
+	if err != nil {
+		log.Printf("synonym expansion failed: %v", err)
+		return nil
+	}
+	if len(expansions) > 0 && diagnostics != nil {
+		if diagnostics.QueryExpansions == nil {
+			diagnostics.QueryExpansions = make(map[string][]string, len(expansions))
+		}
+		for form, alts := range expansions {
+			diagnostics.QueryExpansions[form] = alts
+		}
+	}
+	return expansions
+}
+
+// alternatives возвращает все альтернативные формулировки без повторов.
+func (e queryExpansion) alternatives() []string {
+	var out []string
+	for _, alts := range e {
+		for _, alt := range alts {
+			if !containsString(out, alt) {
+				out = append(out, alt)
+			}
+		}
+	}
+	return out
+}
+
+// keywordPhrases — дополнительные фразы для лексического поиска (ограничены, чтобы не раздувать SQL).
+func (e queryExpansion) keywordPhrases() []string {
+	phrases := e.alternatives()
+	if len(phrases) > maxKeywordExpansions {
+		phrases = phrases[:maxKeywordExpansions]
+	}
+	return phrases
+}
+
+// expansionEmbedText дописывает раскрытия к тексту запроса для эмбеддинга.
+func expansionEmbedText(query string, e queryExpansion) string {
+	alts := e.alternatives()
+	if len(alts) == 0 {
+		return query
+	}
+	return query + " (" + strings.Join(alts, "; ") + ")"
+}
+
+func synonymsInEmbedding(settings *models.AskSettings) bool {
+	return settings != nil && settings.ExpandEmbeddingWithSynonyms
+}
+
+// expandedOverlapScore — доля терминов запроса, найденных в тексте, где термин засчитывается,
+// если в тексте есть он сам или любое раскрытие формулировки, в которую он входит.
+func expandedOverlapScore(query, text string, e queryExpansion) float32 {
+	if len(e) == 0 {
+		return lexicalOverlapScore(query, text)
+	}
+	terms := queryTerms(query)
+	if len(terms) == 0 {
+		return 0
+	}
+
+	lowerText := strings.ToLower(text)
+	normalizedText := normalizeSynonymText(text)
+	hits := 0
+	for _, term := range terms {
+		if strings.Contains(lowerText, term) {
+			hits++
+			continue
+		}
+		for form, alts := range e {
+			if !containsString(strings.Fields(form), term) {
+				continue
+			}
+			if containsAnyPhrase(normalizedText, alts) {
+				hits++
+				break
+			}
+		}
+	}
+
+	return float32(hits) / float32(len(terms))
+}
+
+func containsAnyPhrase(normalizedText string, phrases []string) bool {
+	for _, phrase := range phrases {
+		if strings.Contains(normalizedText, phrase) {
+			return true
+		}
+	}
+	return false
+}
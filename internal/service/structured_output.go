@@ -0,0 +1,367 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/katakuxiko/Diplom/internal/models"
+)
+
+const (
+	defaultStructuredRetries = 2
+	maxStructuredRetries     = 5
+	maxResponseSchemaBytes   = 16 * 1024
+	maxSchemaErrors          = 20
+
+	structuredInstruction = `ФОРМАТ ОТВЕТА:
+- Ответь только JSON-значением, соответствующим JSON Schema ниже, без пояснений и без markdown
+- Значения бери из КОНТЕКСТА; если сведений нет, используй null там, где схема это допускает
+СХЕМА:
+%s`
+
+	structuredRepairPrompt = `Исправь JSON так, чтобы он соответствовал JSON Schema. Сохрани значения исходного ответа.
+Ответь только исправленным JSON, без пояснений и без markdown.`
+)
+
+// ErrInvalidResponseSchema — схема ответа из запроса не является JSON Schema-объектом.
+var ErrInvalidResponseSchema = errors.New("invalid response schema")
+
+// StructuredOutput — результат ответа по JSON Schema: разобранный объект и ход проверки.
+type StructuredOutput struct {
+	Data        interface{} `json:"-"` // отдаётся отдельным полем ответа
+	Valid       bool        `json:"valid"`
+	Constrained bool        `json:"constrained"`      // бэкенд генерировал по схеме (response_format / format)
+	Repairs     int         `json:"repairs"`          // сколько раз ответ отправлялся на исправление
+	Errors      []string    `json:"errors,omitempty"` // ошибки последней проверки
+}
+
+// ValidateResponseSchema проверяет схему из запроса: JSON-объект не больше 16 КБ.
+func ValidateResponseSchema(schema json.RawMessage) error {
+	if len(schema) > maxResponseSchemaBytes {
+		return fmt.Errorf("%w: larger than %d bytes", ErrInvalidResponseSchema, maxResponseSchemaBytes)
+	}
+	var parsed map[string]interface{}
+	if err := json.Unmarshal(schema, &parsed); err != nil {
+		return fmt.Errorf("%w: expected JSON object: %v", ErrInvalidResponseSchema, err)
+	}
+	switch t := parsed["type"].(type) {
+	case nil, string, []interface{}:
+	default:
+		return fmt.Errorf("%w: \"type\" must be a string or an array, got %T", ErrInvalidResponseSchema, t)
+	}
+	return nil
+}
+
+// responseSchema — схема ответа запроса; nil, если ответ в свободной форме.
+func responseSchema(settings *models.AskSettings) json.RawMessage {
+	if settings == nil || len(settings.ResponseSchema) == 0 {
+		return nil
+	}
+	return settings.ResponseSchema
+}
+
+func resolveStructuredRetries(settings *models.AskSettings) int {
+	if settings == nil || settings.StructuredRetries == 0 {
+		return defaultStructuredRetries
+	}
+	if settings.StructuredRetries < 0 {
+		return 0
+	}
+	if settings.StructuredRetries > maxStructuredRetries {
+		return maxStructuredRetries
+	}
+	return settings.StructuredRetries
+}
+
+// withResponseSchema передаёт схему бэкенду, если он умеет генерировать по ней.
+func withResponseSchema(req ChatRequest, schema json.RawMessage, provider ChatProvider) ChatRequest {
+	if len(schema) > 0 && provider.Capabilities().JSONSchema {
+		req.JSONSchema = schema
+	}
+	return req
+}
+
+// structureAnswer разбирает ответ модели по схеме запроса; невалидный ответ отправляется на исправление
+// не больше structuredRetries раз. Возвращает JSON из ответа (или исходный текст, если JSON так и не получен).
+func (s *RAGService) structureAnswer(ctx context.Context, answer string, usage *ProviderUsage, settings *models.AskSettings, diagnostics *RetrievalDiagnostics) string {
+	schema := responseSchema(settings)
+	out := &StructuredOutput{}
+	diagnostics.Structured = out
+	if usage == nil || s.llm == nil {
+		out.Errors = []string{"answer was not generated by the model"}
+		return answer
+	}
+	if caps, ok := s.llm.registry.Capabilities(usage.Type); ok {
+		out.Constrained = caps.JSONSchema
+	}
+
+	var schemaDoc map[string]interface{}
+	if err := json.Unmarshal(schema, &schemaDoc); err != nil {
+		out.Errors = []string{err.Error()}
+		return answer
+	}
+
+	text, data, errs := parseStructured(answer, schemaDoc)
+	for retries := resolveStructuredRetries(settings); len(errs) > 0 && out.Repairs < retries; {
+		out.Repairs++
+		repaired, repairUsage, err := s.llm.repairStructuredAnswer(ctx, settings, schema, answer, errs)
+		if err != nil {
+			log.Printf("structured answer repair failed: %v", err)
+			break
+		}
+		if repairUsage != nil && repairUsage.FallbackUsed {
+			log.Printf("structured answer repaired by fallback provider %s/%s", repairUsage.Type, repairUsage.Model)
+		}
+		answer = repaired
+		text, data, errs = parseStructured(answer, schemaDoc)
+	}
+
+	out.Errors = errs
+	out.Valid = len(errs) == 0
+	if !out.Valid {
+		return answer
+	}
+	out.Data = data
+	return text
+}
+
+// parseStructured выделяет JSON из ответа и проверяет его по схеме.
+func parseStructured(answer string, schema map[string]interface{}) (string, interface{}, []string) {
+	text := extractJSON(answer)
+	var data interface{}
+	if err := json.Unmarshal([]byte(text), &data); err != nil {
+		return text, nil, []string{"$: invalid JSON: " + err.Error()}
+	}
+	var errs []string
+	validateSchema(schema, data, "$", &errs)
+	return text, data, errs
+}
+
+// extractJSON убирает markdown-ограждение и текст вокруг первого JSON-объекта или массива.
+func extractJSON(answer string) string {
+	text := strings.TrimSpace(answer)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text, "```json")
+		text = strings.TrimPrefix(text, "```")
+		if i := strings.LastIndex(text, "```"); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+	}
+	start := strings.IndexAny(text, "{[")
+	if start < 0 {
+		return text
+	}
+	closer := "}"
+	if text[start] == '[' {
+		closer = "]"
+	}
+	if end := strings.LastIndex(text, closer); end > start {
+		return text[start : end+1]
+	}
+	return text[start:]
+}
+
+// validateSchema проверяет значение по подмножеству JSON Schema: type, enum, const, properties, required,
+// additionalProperties, items, min/maxItems, min/maxLength, pattern, minimum/maximum, anyOf и oneOf.
+// $ref и format не проверяются.
+func validateSchema(schema map[string]interface{}, value interface{}, path string, errs *[]string) {
+	fail := func(format string, args ...interface{}) {
+		if len(*errs) < maxSchemaErrors {
+			*errs = append(*errs, path+": "+fmt.Sprintf(format, args...))
+		}
+	}
+
+	if t, ok := schema["type"]; ok && !schemaTypeMatches(t, value) {
+		fail("expected %v, got %s", t, jsonTypeName(value))
+		return
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok && !containsJSONValue(enum, value) {
+		fail("must be one of %v", enum)
+	}
+	if c, ok := schema["const"]; ok && !reflect.DeepEqual(c, value) {
+		fail("must be %v", c)
+	}
+	for _, key := range []string{"anyOf", "oneOf"} {
+		variants, ok := schema[key].([]interface{})
+		if !ok || len(variants) == 0 {
+			continue
+		}
+		matched := false
+		for _, variant := range variants {
+			if sub, ok := variant.(map[string]interface{}); ok {
+				var subErrs []string
+				validateSchema(sub, value, path, &subErrs)
+				if len(subErrs) == 0 {
+					matched = true
+					break
+				}
+			}
+		}
+		if !matched {
+			fail("does not match any schema in %s", key)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if key, ok := name.(string); ok {
+					if _, present := v[key]; !present {
+						fail("missing required property %q", key)
+					}
+				}
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if sub, ok := properties[key].(map[string]interface{}); ok {
+				validateSchema(sub, v[key], path+"."+key, errs)
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					fail("unexpected property %q", key)
+				}
+			case map[string]interface{}:
+				validateSchema(extra, v[key], path+"."+key, errs)
+			}
+		}
+	case []interface{}:
+		if n, ok := schemaNumber(schema, "minItems"); ok && float64(len(v)) < n {
+			fail("expected at least %v items", n)
+		}
+		if n, ok := schemaNumber(schema, "maxItems"); ok && float64(len(v)) > n {
+			fail("expected at most %v items", n)
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				validateSchema(items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	case string:
+		length := float64(len([]rune(v)))
+		if n, ok := schemaNumber(schema, "minLength"); ok && length < n {
+			fail("expected at least %v characters", n)
+		}
+		if n, ok := schemaNumber(schema, "maxLength"); ok && length > n {
+			fail("expected at most %v characters", n)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(v) {
+				fail("does not match pattern %q", pattern)
+			}
+		}
+	case float64:
+		if n, ok := schemaNumber(schema, "minimum"); ok && v < n {
+			fail("must be >= %v", n)
+		}
+		if n, ok := schemaNumber(schema, "maximum"); ok && v > n {
+			fail("must be <= %v", n)
+		}
+	}
+}
+
+func schemaNumber(schema map[string]interface{}, key string) (float64, bool) {
+	n, ok := schema[key].(float64)
+	return n, ok
+}
+
+func schemaTypeMatches(t interface{}, value interface{}) bool {
+	switch typed := t.(type) {
+	case string:
+		return jsonTypeIs(typed, value)
+	case []interface{}:
+		for _, item := range typed {
+			if name, ok := item.(string); ok && jsonTypeIs(name, value) {
+				return true
+			}
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+func jsonTypeIs(name string, value interface{}) bool {
+	switch name {
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	default:
+		return jsonTypeName(value) == name
+	}
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func containsJSONValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// repairStructuredAnswer просит модель исправить ответ по списку ошибок проверки схемы. Запрос идёт
+// через ту же цепочку резервных провайдеров, что и ответ: если основной недоступен, ответ исправит резервный.
+func (l *LLMClient) repairStructuredAnswer(ctx context.Context, settings *models.AskSettings, schema json.RawMessage, answer string, errs []string) (string, *ProviderUsage, error) {
+	maxTokens := defaultAnswerMaxTokens
+	if settings != nil && settings.MaxTokens > 0 {
+		maxTokens = settings.MaxTokens
+	}
+	content := "СХЕМА:\n" + string(schema) + "\n\nОТВЕТ:\n" + answer + "\n\nОШИБКИ:\n- " + strings.Join(errs, "\n- ")
+	return l.chatWithFallback(ctx, settings, answerModel(l.chatName, settings), func(ctx context.Context, provider ChatProvider, model string) (string, error) {
+		req := withResponseSchema(withReasoningEffort(ChatRequest{
+			Model: model,
+			Messages: []ChatMessage{
+				{Role: "system", Content: structuredRepairPrompt},
+				{Role: "user", Content: content},
+			},
+			Temperature: 0,
+			TopP:        1,
+			MaxTokens:   maxTokens,
+		}, provider), schema, provider)
+		resp, err := provider.Chat(ctx, req)
+		if err != nil {
+			return "", err
+		}
+		return resp.Content, nil
+	}, nil)
+}
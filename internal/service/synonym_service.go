@@ -0,0 +1,262 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/katakuxiko/Diplom/internal/dto"
+	"github.com/katakuxiko/Diplom/internal/models"
+	"github.com/katakuxiko/Diplom/internal/repository"
+	"github.com/lib/pq"
+)
+
+const (
+	maxSynonymsPerTerm = 20
+	maxSynonymImport   = 5000
+)
+
+var (
+	ErrSynonymNotFound    = errors.New("synonym entry not found")
+	ErrInvalidSynonym     = errors.New("term and at least one synonym are required")
+	ErrInvalidSynonymKind = errors.New("kind must be synonym or abbreviation")
+	ErrSynonymTermExists  = errors.New("term already exists in the chat dictionary")
+)
+
+// SynonymService управляет словарём синонимов и сокращений чата и расширяет по нему поисковые запросы.
+// Словарь чата кэшируется в памяти и сбрасывается при любом изменении через сервис.
+type SynonymService struct {
+	repo  *repository.SynonymRepository
+	mu    sync.RWMutex
+	cache map[uuid.UUID][]models.ChatSynonym
+}
+
+func NewSynonymService(repo *repository.SynonymRepository) *SynonymService {
+	return &SynonymService{repo: repo, cache: make(map[uuid.UUID][]models.ChatSynonym)}
+}
+
+func (s *SynonymService) List(ctx context.Context, chatID uuid.UUID) ([]models.ChatSynonym, error) {
+	return s.repo.ListByChat(ctx, chatID)
+}
+
+func (s *SynonymService) Create(ctx context.Context, chatID uuid.UUID, req dto.SynonymRequest) (*models.ChatSynonym, error) {
+	entry, err := buildSynonymEntry(chatID, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.Upsert(ctx, entry); err != nil {
+		return nil, err
+	}
+	s.invalidate(chatID)
+	return entry, nil
+}
+
+func (s *SynonymService) Update(ctx context.Context, chatID, id uuid.UUID, req dto.SynonymRequest) (*models.ChatSynonym, error) {
+	existing, err := s.repo.GetByID(ctx, chatID, id)
+	if err != nil {
+		return nil, ErrSynonymNotFound
+	}
+	entry, err := buildSynonymEntry(chatID, req)
+	if err != nil {
+		return nil, err
+	}
+	entry.ID = existing.ID
+	entry.CreatedDate = existing.CreatedDate
+	// Уникальный индекс (chat_id, term) не даёт переименовать термин в уже существующий.
+	taken, err := s.repo.TermExists(ctx, chatID, entry.Term, entry.ID)
+	if err != nil {
+		return nil, err
+	}
+	if taken {
+		return nil, ErrSynonymTermExists
+	}
+	if err := s.repo.Update(ctx, entry); err != nil {
+		return nil, err
+	}
+	s.invalidate(chatID)
+	return entry, nil
+}
+
+func (s *SynonymService) Delete(ctx context.Context, chatID, id uuid.UUID) error {
+	deleted, err := s.repo.Delete(ctx, chatID, id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrSynonymNotFound
+	}
+	s.invalidate(chatID)
+	return nil
+}
+
+// ImportCSV загружает словарь из CSV: term,synonyms[,kind], где синонимы разделены ";" или "|".
+// Строка заголовка (term,...) пропускается; существующие термины перезаписываются.
+func (s *SynonymService) ImportCSV(ctx context.Context, chatID uuid.UUID, r io.Reader) (*dto.SynonymImportResponse, error) {
+	// Даже при ошибке посередине часть строк уже сохранена — кэш словаря сбрасываем в любом случае.
+	defer s.invalidate(chatID)
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	resp := &dto.SynonymImportResponse{}
+	line := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return resp, fmt.Errorf("csv line %d: %w", line, err)
+		}
+		if line > maxSynonymImport {
+			resp.Errors = append(resp.Errors, fmt.Sprintf("import limited to %d lines", maxSynonymImport))
+			break
+		}
+		if line == 1 && len(record) > 0 && strings.EqualFold(strings.TrimSpace(record[0]), "term") {
+			continue
+		}
+		if len(record) < 2 {
+			resp.Skipped++
+			resp.Errors = append(resp.Errors, fmt.Sprintf("line %d: expected term,synonyms[,kind]", line))
+			continue
+		}
+
+		req := dto.SynonymRequest{
+			Term: record[0],
+			Synonyms: strings.FieldsFunc(record[1], func(r rune) bool {
+				return r == ';' || r == '|'
+			}),
+		}
+		if len(record) > 2 {
+			req.Kind = record[2]
+		}
+		entry, err := buildSynonymEntry(chatID, req)
+		if err != nil {
+			resp.Skipped++
+			resp.Errors = append(resp.Errors, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+		if err := s.repo.Upsert(ctx, entry); err != nil {
+			return resp, err
+		}
+		resp.Imported++
+	}
+
+	return resp, nil
+}
+
+// Expand находит в запросе термины словаря чата и возвращает их раскрытия:
+// найденная формулировка -> альтернативные формулировки. Совпадение — по целым словам без учёта регистра.
+func (s *SynonymService) Expand(ctx context.Context, chatID uuid.UUID, query string) (map[string][]string, error) {
+	entries, err := s.dictionary(ctx, chatID)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+
+	normalizedQuery := " " + normalizeSynonymText(query) + " "
+	expansions := make(map[string][]string)
+	for _, entry := range entries {
+		forms := append([]string{entry.Term}, entry.Synonyms...)
+		matchable := forms
+		if entry.Kind == models.SynonymKindAbbreviation {
+			matchable = forms[:1]
+		}
+		for _, form := range matchable {
+			if !strings.Contains(normalizedQuery, " "+form+" ") {
+				continue
+			}
+			for _, alt := range forms {
+				if alt != form && !containsString(expansions[form], alt) {
+					expansions[form] = append(expansions[form], alt)
+				}
+			}
+		}
+	}
+	if len(expansions) == 0 {
+		return nil, nil
+	}
+	return expansions, nil
+}
+
+func (s *SynonymService) dictionary(ctx context.Context, chatID uuid.UUID) ([]models.ChatSynonym, error) {
+	s.mu.RLock()
+	entries, ok := s.cache[chatID]
+	s.mu.RUnlock()
+	if ok {
+		return entries, nil
+	}
+
+	entries, err := s.repo.ListByChat(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.cache[chatID] = entries
+	s.mu.Unlock()
+	return entries, nil
+}
+
+func (s *SynonymService) invalidate(chatID uuid.UUID) {
+	s.mu.Lock()
+	delete(s.cache, chatID)
+	s.mu.Unlock()
+}
+
+func buildSynonymEntry(chatID uuid.UUID, req dto.SynonymRequest) (*models.ChatSynonym, error) {
+	term := normalizeSynonymText(req.Term)
+	kind := strings.ToLower(strings.TrimSpace(req.Kind))
+	if kind == "" {
+		kind = models.SynonymKindSynonym
+	}
+	if kind != models.SynonymKindSynonym && kind != models.SynonymKindAbbreviation {
+		return nil, ErrInvalidSynonymKind
+	}
+
+	synonyms := make([]string, 0, len(req.Synonyms))
+	for _, syn := range req.Synonyms {
+		syn = normalizeSynonymText(syn)
+		if syn == "" || syn == term || containsString(synonyms, syn) {
+			continue
+		}
+		synonyms = append(synonyms, syn)
+		if len(synonyms) == maxSynonymsPerTerm {
+			break
+		}
+	}
+	if term == "" || len(synonyms) == 0 {
+		return nil, ErrInvalidSynonym
+	}
+
+	return &models.ChatSynonym{
+		ChatID:   chatID,
+		Term:     term,
+		Synonyms: pq.StringArray(synonyms),
+		Kind:     kind,
+	}, nil
+}
+
+// normalizeSynonymText приводит текст к словам в нижнем регистре через один пробел (без пунктуации),
+// чтобы термины словаря и запросы сравнивались одинаково.
+func normalizeSynonymText(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/katakuxiko/Diplom/internal/models"
+)
+
+const (
+	tokensPerMessage      = 4 // служебные токены роли и разделителей на сообщение
+	tokensReplyPriming    = 3
+	minContextTokens      = 256
+	maxContextTokens      = 16000
+	maxHistoryTokens      = 2000
+	historyBudgetShare    = 0.3
+	contextSafetyFraction = 0.05
+	minContextSafety      = 32
+)
+
+// TokenBudget — распределение контекстного окна модели между частями запроса.
+// Лимиты (answer, history, context) задают бюджет, *_used — сколько фактически занято.
+// Все счётчики — оценки: словарей BPE в сервисе нет (см. scriptRatioEstimator).
+type TokenBudget struct {
+	Model          string `json:"model"`
+	TokenEstimator string `json:"token_estimator"` // оценщик, а не токенизатор модели: "cl100k-ratio", "approx"...
+	Estimated      bool   `json:"estimated"`       // всегда true: счётчики приближённые, а не от токенизатора модели
+	ContextWindow  int    `json:"context_window"`
+	Answer         int    `json:"answer"`   // зарезервировано под ответ (max_tokens)
+	System         int    `json:"system"`   // системный промпт
+	Question       int    `json:"question"` // пользовательский промпт без контекста
+	Overhead       int    `json:"overhead"` // служебные токены сообщений
+	History        int    `json:"history"`
+	HistoryUsed    int    `json:"history_used"`
+	Context        int    `json:"context"`
+	ContextUsed    int    `json:"context_used"`
+	Total          int    `json:"total"` // всё, кроме неиспользованных лимитов
+}
+
+func (b *TokenBudget) updateTotal() {
+	b.Total = b.System + b.Question + b.Overhead + b.HistoryUsed + b.ContextUsed + b.Answer
+}
+
+// tokenPlan — бюджет вместе с оценщиком токенов и историей, уместившейся в свой лимит.
+type tokenPlan struct {
+	budget    TokenBudget
+	estimator TokenEstimator
+	history   []ChatMessage
+}
+
+// answerModel — модель генерации: из настроек чата или модель сервера по умолчанию.
+func answerModel(defaultModel string, settings *models.AskSettings) string {
+	if settings != nil && strings.TrimSpace(settings.Model) != "" {
+		return settings.Model
+	}
+	return defaultModel
+}
+
+// planTokens делит окно модели: сначала ответ (maxTokens, не больше половины окна), системный промпт
+// и вопрос, затем история (до 30% остатка), всё оставшееся — контекст документов.
+func planTokens(defaultModel, query string, settings *models.AskSettings, history []models.ChatContextMessage) tokenPlan {
+	model := answerModel(defaultModel, settings)
+	spec := resolveModelSpec(model, settings)
+	tok := tokenEstimatorByName(spec.TokenEstimator)
+	budget := TokenBudget{Model: model, TokenEstimator: tok.Name(), Estimated: true, ContextWindow: spec.ContextWindow}
+
+	budget.Answer = defaultAnswerMaxTokens
+	if settings != nil && settings.MaxTokens > 0 {
+		budget.Answer = settings.MaxTokens
+	}
+	if budget.Answer > spec.ContextWindow/2 {
+		budget.Answer = spec.ContextWindow / 2
+	}
+
+	vars := newPromptVars(query, "", settings)
+	budget.System = tok.Count(renderSystemPrompt(query, settings, vars))
+	budget.Question = tok.Count(renderPart(settings, "user", func(p models.PromptParts) string { return p.User }, vars))
+
+	safety := int(float64(spec.ContextWindow) * contextSafetyFraction)
+	if safety < minContextSafety {
+		safety = minContextSafety
+	}
+	available := spec.ContextWindow - budget.Answer - budget.System - budget.Question - 2*tokensPerMessage - tokensReplyPriming - safety
+
+	plan := tokenPlan{estimator: tok}
+	if settings == nil || settings.EnableHistory {
+		budget.History = int(float64(available) * historyBudgetShare)
+		if budget.History > maxHistoryTokens {
+			budget.History = maxHistoryTokens
+		}
+		plan.history = sanitizeHistoryMessages(history, settings, tok, budget.History)
+		for _, msg := range plan.history {
+			budget.HistoryUsed += tok.Count(msg.Content) + tokensPerMessage
+		}
+	}
+	budget.Overhead = 2*tokensPerMessage + tokensReplyPriming
+
+	budget.Context = available - budget.HistoryUsed
+	if budget.Context > maxContextTokens {
+		budget.Context = maxContextTokens
+	}
+	if budget.Context < minContextTokens {
+		budget.Context = minContextTokens
+	}
+	budget.updateTotal()
+	plan.budget = budget
+	return plan
+}
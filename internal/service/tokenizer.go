@@ -0,0 +1,227 @@
+package service
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/katakuxiko/Diplom/internal/models"
+)
+
+// Оценщики числа токенов. Настоящего BPE (словарей cl100k/o200k и т.п.) в сервисе нет — это известное
+// ограничение: без сети словари не загрузить, а встроенных нет. Поэтому названия оценщиков с суффиксом
+// "-ratio" обозначают набор коэффициентов «букв на токен» для семейства, а не его токенизатор.
+const (
+	TokenEstimatorCL100K        = "cl100k-ratio"
+	TokenEstimatorO200K         = "o200k-ratio"
+	TokenEstimatorLlama3        = "llama3-ratio"
+	TokenEstimatorSentencePiece = "sentencepiece-ratio"
+	TokenEstimatorApprox        = "approx"
+
+	defaultModelContextWindow = 8192
+)
+
+// legacyTokenEstimators — прежние названия из настроек чатов ("tokenizer": "cl100k" и т.д.).
+var legacyTokenEstimators = map[string]string{
+	"cl100k":        TokenEstimatorCL100K,
+	"o200k":         TokenEstimatorO200K,
+	"llama3":        TokenEstimatorLlama3,
+	"sentencepiece": TokenEstimatorSentencePiece,
+}
+
+// TokenEstimator оценивает число токенов текста для семейства моделей. Все реализации приближённые.
+type TokenEstimator interface {
+	Name() string
+	Count(text string) int
+}
+
+// pretokenPattern — разбиение текста на слова, числа и пунктуацию, как в tiktoken (cl100k_base),
+// без lookahead, которого нет в RE2. Внутри куска число токенов оценивается по алфавиту.
+var pretokenPattern = regexp.MustCompile(`(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+`)
+
+// scriptRatioEstimator оценивает число токенов без словарей BPE (они в сервис не входят): после
+// разбиения на куски длина слова делится на среднее число букв на токен для его алфавита.
+// Результат — оценка, а не точный подсчёт токенизатора модели.
+type scriptRatioEstimator struct {
+	name     string
+	latin    float64 // букв латиницы на токен
+	cyrillic float64 // букв кириллицы на токен
+	other    float64 // прочих букв на токен
+}
+
+func (t scriptRatioEstimator) Name() string { return t.name }
+
+func (t scriptRatioEstimator) Count(text string) int {
+	if text == "" {
+		return 0
+	}
+	total := 0
+	for _, piece := range pretokenPattern.FindAllString(text, -1) {
+		total += t.pieceTokens(piece)
+	}
+	return total
+}
+
+func (t scriptRatioEstimator) pieceTokens(piece string) int {
+	var latin, cyrillic, other int
+	for _, r := range piece {
+		switch {
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		case unicode.IsLetter(r):
+			other++
+		}
+	}
+	if latin+cyrillic+other == 0 {
+		// Число (до трёх цифр) и пробелы — один токен, пунктуация — примерно по два символа.
+		trimmed := strings.TrimSpace(piece)
+		if trimmed == "" || unicode.IsDigit([]rune(trimmed)[0]) {
+			return 1
+		}
+		return (utf8.RuneCountInString(trimmed) + 1) / 2
+	}
+	estimate := float64(latin)/t.latin + float64(cyrillic)/t.cyrillic + float64(other)/t.other
+	if estimate < 1 {
+		return 1
+	}
+	return int(math.Ceil(estimate))
+}
+
+// approxEstimator — для неизвестных моделей: символ на три, с запасом для нелатинских текстов.
+type approxEstimator struct{}
+
+func (approxEstimator) Name() string { return TokenEstimatorApprox }
+
+func (approxEstimator) Count(text string) int {
+	return (utf8.RuneCountInString(text) + 2) / 3
+}
+
+var tokenEstimators = map[string]TokenEstimator{
+	TokenEstimatorCL100K:        scriptRatioEstimator{name: TokenEstimatorCL100K, latin: 5.0, cyrillic: 2.3, other: 1.0},
+	TokenEstimatorO200K:         scriptRatioEstimator{name: TokenEstimatorO200K, latin: 5.2, cyrillic: 3.8, other: 1.5},
+	TokenEstimatorLlama3:        scriptRatioEstimator{name: TokenEstimatorLlama3, latin: 4.8, cyrillic: 3.0, other: 1.2},
+	TokenEstimatorSentencePiece: scriptRatioEstimator{name: TokenEstimatorSentencePiece, latin: 4.0, cyrillic: 2.0, other: 1.0},
+	TokenEstimatorApprox:        approxEstimator{},
+}
+
+// normalizeTokenEstimator приводит название оценщика к каноническому, принимая и прежние названия;
+// пустая строка — название неизвестно.
+func normalizeTokenEstimator(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if canonical, ok := legacyTokenEstimators[name]; ok {
+		return canonical
+	}
+	if _, ok := tokenEstimators[name]; ok {
+		return name
+	}
+	return ""
+}
+
+// tokenEstimatorByName возвращает оценщик по названию; неизвестное название — приближённый подсчёт.
+func tokenEstimatorByName(name string) TokenEstimator {
+	if canonical := normalizeTokenEstimator(name); canonical != "" {
+		return tokenEstimators[canonical]
+	}
+	return tokenEstimators[TokenEstimatorApprox]
+}
+
+// truncateToTokens обрезает текст по границе руны так, чтобы он укладывался в limit токенов.
+func truncateToTokens(tok TokenEstimator, text string, limit int) string {
+	if limit <= 0 {
+		return ""
+	}
+	if tok.Count(text) <= limit {
+		return text
+	}
+	runes := []rune(text)
+	lo, hi := 0, len(runes)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if tok.Count(string(runes[:mid])) <= limit {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return string(runes[:lo])
+}
+
+// ModelSpec — размер контекстного окна и оценщик числа токенов семейства моделей.
+type ModelSpec struct {
+	Pattern        string `json:"pattern"` // подстрока имени модели (после последнего "/")
+	ContextWindow  int    `json:"context_window"`
+	TokenEstimator string `json:"token_estimator"`
+}
+
+// modelRegistry — известные семейства моделей; выбирается самый длинный совпавший шаблон.
+var modelRegistry = []ModelSpec{
+	{Pattern: "gpt-4o", ContextWindow: 128000, TokenEstimator: TokenEstimatorO200K},
+	{Pattern: "gpt-4.1", ContextWindow: 1047576, TokenEstimator: TokenEstimatorO200K},
+	{Pattern: "gpt-4-turbo", ContextWindow: 128000, TokenEstimator: TokenEstimatorCL100K},
+	{Pattern: "gpt-4", ContextWindow: 8192, TokenEstimator: TokenEstimatorCL100K},
+	{Pattern: "gpt-3.5-turbo", ContextWindow: 16385, TokenEstimator: TokenEstimatorCL100K},
+	{Pattern: "gpt-oss", ContextWindow: 131072, TokenEstimator: TokenEstimatorO200K},
+	{Pattern: "o1-", ContextWindow: 200000, TokenEstimator: TokenEstimatorO200K},
+	{Pattern: "o3-", ContextWindow: 200000, TokenEstimator: TokenEstimatorO200K},
+	{Pattern: "o4-", ContextWindow: 200000, TokenEstimator: TokenEstimatorO200K},
+	{Pattern: "lfm2", ContextWindow: 32768, TokenEstimator: TokenEstimatorLlama3},
+	{Pattern: "llama-3", ContextWindow: 8192, TokenEstimator: TokenEstimatorLlama3},
+	{Pattern: "llama3", ContextWindow: 8192, TokenEstimator: TokenEstimatorLlama3},
+	{Pattern: "llama-3.1", ContextWindow: 131072, TokenEstimator: TokenEstimatorLlama3},
+	{Pattern: "llama3.1", ContextWindow: 131072, TokenEstimator: TokenEstimatorLlama3},
+	{Pattern: "llama-3.2", ContextWindow: 131072, TokenEstimator: TokenEstimatorLlama3},
+	{Pattern: "llama3.2", ContextWindow: 131072, TokenEstimator: TokenEstimatorLlama3},
+	{Pattern: "llama-3.3", ContextWindow: 131072, TokenEstimator: TokenEstimatorLlama3},
+	{Pattern: "llama3.3", ContextWindow: 131072, TokenEstimator: TokenEstimatorLlama3},
+	{Pattern: "qwen", ContextWindow: 32768, TokenEstimator: TokenEstimatorLlama3},
+	{Pattern: "mistral", ContextWindow: 32768, TokenEstimator: TokenEstimatorSentencePiece},
+	{Pattern: "mixtral", ContextWindow: 32768, TokenEstimator: TokenEstimatorSentencePiece},
+	{Pattern: "gemma", ContextWindow: 8192, TokenEstimator: TokenEstimatorSentencePiece},
+	{Pattern: "gemma-3", ContextWindow: 131072, TokenEstimator: TokenEstimatorSentencePiece},
+	{Pattern: "gemma3", ContextWindow: 131072, TokenEstimator: TokenEstimatorSentencePiece},
+	{Pattern: "phi-4", ContextWindow: 16384, TokenEstimator: TokenEstimatorCL100K},
+	{Pattern: "deepseek", ContextWindow: 65536, TokenEstimator: TokenEstimatorLlama3},
+}
+
+// lookupModelSpec подбирает запись реестра по имени модели; для неизвестной модели —
+// окно по умолчанию и приближённый подсчёт.
+func lookupModelSpec(model string) ModelSpec {
+	base := strings.ToLower(strings.TrimSpace(model))
+	if i := strings.LastIndex(base, "/"); i >= 0 {
+		base = base[i+1:]
+	}
+	best := ModelSpec{ContextWindow: defaultModelContextWindow, TokenEstimator: TokenEstimatorApprox}
+	for _, spec := range modelRegistry {
+		if strings.Contains(base, spec.Pattern) && len(spec.Pattern) > len(best.Pattern) {
+			best = spec
+		}
+	}
+	return best
+}
+
+// resolveModelSpec — запись реестра с учётом переопределений из настроек чата.
+func resolveModelSpec(model string, settings *models.AskSettings) ModelSpec {
+	spec := lookupModelSpec(model)
+	if settings != nil {
+		if settings.ContextWindow > 0 {
+			spec.ContextWindow = settings.ContextWindow
+		}
+		name := settings.TokenEstimator
+		if strings.TrimSpace(name) == "" {
+			name = settings.Tokenizer
+		}
+		if canonical := normalizeTokenEstimator(name); canonical != "" {
+			spec.TokenEstimator = canonical
+		}
+	}
+	return spec
+}
+
+// ModelRegistry возвращает встроенный реестр моделей.
+func ModelRegistry() []ModelSpec {
+	return append([]ModelSpec(nil), modelRegistry...)
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/katakuxiko/Diplom/internal/dto"
+	"github.com/katakuxiko/Diplom/internal/repository"
+)
+
+const (
+	defaultBenchmarkSamples = 50
+	maxBenchmarkSamples     = 500
+	defaultBenchmarkK       = 10
+	maxBenchmarkK           = 100
+)
+
+var ErrNoEmbeddings = errors.New("no chunks with embeddings to benchmark")
+
+// VectorIndexService обслуживает векторный индекс: состояние, перестроение и замер recall.
+type VectorIndexService struct {
+	repo     *repository.VectorIndexRepository
+	defaults repository.VectorIndexConfig
+}
+
+func NewVectorIndexService(repo *repository.VectorIndexRepository, defaults repository.VectorIndexConfig) *VectorIndexService {
+	return &VectorIndexService{repo: repo, defaults: repository.NormalizeVectorIndexConfig(defaults)}
+}
+
+// EnsureIndex создаёт индекс из конфигурации сервера, если векторного индекса ещё нет
+// (ivfflat — только при достаточном числе эмбеддингов, см. repository.MinIVFFlatRows).
+func (s *VectorIndexService) EnsureIndex() (bool, error) {
+	return s.repo.EnsureIndex(s.defaults)
+}
+
+func (s *VectorIndexService) Health() (*dto.VectorIndexHealthResponse, error) {
+	indexes, err := s.repo.ListIndexes()
+	if err != nil {
+		return nil, err
+	}
+	stats, err := s.repo.TableStats()
+	if err != nil {
+		return nil, err
+	}
+	searchSettings, err := s.repo.CurrentSearchSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &dto.VectorIndexHealthResponse{
+		ConfiguredType:      s.defaults.Type,
+		Indexes:             indexes,
+		Table:               stats,
+		RecommendedIVFLists: repository.RecommendedIVFLists(stats.RowsWithEmbedding),
+		SearchSettings:      searchSettings,
+	}
+	if len(indexes) == 0 {
+		resp.Warnings = append(resp.Warnings, "vector index is missing, search falls back to sequential scan")
+		if s.defaults.Type == "ivfflat" && stats.RowsWithEmbedding < repository.MinIVFFlatRows {
+			resp.Warnings = append(resp.Warnings, fmt.Sprintf("ivfflat is created at startup once chunks have %d embeddings, or via rebuild", repository.MinIVFFlatRows))
+		}
+	}
+	if len(indexes) > 1 {
+		resp.Warnings = append(resp.Warnings, "several vector indexes exist, rebuild to keep one")
+	}
+	for _, idx := range indexes {
+		if !idx.Valid {
+			resp.Warnings = append(resp.Warnings, fmt.Sprintf("index %s is invalid, reindex required", idx.Name))
+		}
+		if idx.Method != s.defaults.Type {
+			resp.Warnings = append(resp.Warnings, fmt.Sprintf("index %s uses %s while %s is configured", idx.Name, idx.Method, s.defaults.Type))
+		}
+	}
+	if stats.LastAnalyze == nil && stats.LastAutoAnalyze == nil {
+		resp.Warnings = append(resp.Warnings, "chunks table has never been analyzed")
+	}
+	return resp, nil
+}
+
+// Rebuild пересоздаёт индекс; незаданные в req параметры берутся из конфигурации сервера.
+func (s *VectorIndexService) Rebuild(req dto.VectorIndexRebuildRequest) (repository.VectorIndexConfig, error) {
+	cfg := s.defaults
+	if req.Type != "" {
+		cfg.Type = req.Type
+	}
+	if req.Lists > 0 {
+		cfg.Lists = req.Lists
+	}
+	if req.M > 0 {
+		cfg.M = req.M
+	}
+	if req.EfConstruction > 0 {
+		cfg.EfConstruction = req.EfConstruction
+	}
+	return s.repo.Rebuild(cfg)
+}
+
+func (s *VectorIndexService) Reindex() error {
+	return s.repo.Reindex()
+}
+
+// Benchmark сравнивает выдачу индекса с точным перебором на эмбеддингах случайных чанков
+// и считает recall@k: долю точных соседей, найденных приблизительным поиском.
+func (s *VectorIndexService) Benchmark(req dto.VectorIndexBenchmarkRequest) (*dto.VectorIndexBenchmarkResponse, error) {
+	samplesCount := req.Samples
+	if samplesCount <= 0 {
+		samplesCount = defaultBenchmarkSamples
+	}
+	if samplesCount > maxBenchmarkSamples {
+		samplesCount = maxBenchmarkSamples
+	}
+	k := req.K
+	if k <= 0 {
+		k = defaultBenchmarkK
+	}
+	if k > maxBenchmarkK {
+		k = maxBenchmarkK
+	}
+
+	var chatID *uuid.UUID
+	if req.ChatID != "" {
+		id, err := uuid.Parse(req.ChatID)
+		if err != nil {
+			return nil, err
+		}
+		chatID = &id
+	}
+
+	samples, err := s.repo.SampleEmbeddings(chatID, samplesCount)
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) == 0 {
+		return nil, ErrNoEmbeddings
+	}
+
+	indexes, err := s.repo.ListIndexes()
+	if err != nil {
+		return nil, err
+	}
+
+	params := repository.VectorSearchParams{EfSearch: req.EfSearch, Probes: req.Probes}
+	approxLatencies := make([]float64, 0, len(samples))
+	exactLatencies := make([]float64, 0, len(samples))
+	recallSum, recallMin := 0.0, 1.0
+
+	for _, sample := range samples {
+		// Бенчмарк по всем чанкам сравнивает соседей в пределах чата образца — как в реальном поиске.
+		scope := chatID
+		if scope == nil {
+			sampleChat := sample.ChatID
+			scope = &sampleChat
+		}
+
+		start := time.Now()
+		exact, err := s.repo.NearestIDs(sample.Embedding, scope, k, params, true)
+		if err != nil {
+			return nil, err
+		}
+		exactLatencies = append(exactLatencies, msSince(start))
+
+		start = time.Now()
+		approx, err := s.repo.NearestIDs(sample.Embedding, scope, k, params, false)
+		if err != nil {
+			return nil, err
+		}
+		approxLatencies = append(approxLatencies, msSince(start))
+
+		recall := recallAt(exact, approx)
+		recallSum += recall
+		if recall < recallMin {
+			recallMin = recall
+		}
+	}
+
+	approxAvg, approxP95 := latencyStats(approxLatencies)
+	exactAvg, exactP95 := latencyStats(exactLatencies)
+	return &dto.VectorIndexBenchmarkResponse{
+		Samples:          len(samples),
+		K:                k,
+		EfSearch:         params.EfSearch,
+		Probes:           params.Probes,
+		Indexes:          indexes,
+		RecallAvg:        recallSum / float64(len(samples)),
+		RecallMin:        recallMin,
+		ApproxLatencyAvg: approxAvg,
+		ApproxLatencyP95: approxP95,
+		ExactLatencyAvg:  exactAvg,
+		ExactLatencyP95:  exactP95,
+	}, nil
+}
+
+func recallAt(exact, approx []uuid.UUID) float64 {
+	if len(exact) == 0 {
+		return 1
+	}
+	found := make(map[uuid.UUID]struct{}, len(approx))
+	for _, id := range approx {
+		found[id] = struct{}{}
+	}
+	hits := 0
+	for _, id := range exact {
+		if _, ok := found[id]; ok {
+			hits++
+		}
+	}
+	return float64(hits) / float64(len(exact))
+}
+
+func latencyStats(values []float64) (avg, p95 float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	sum := 0.0
+	for _, v := range sorted {
+		sum += v
+	}
+	idx := int(float64(len(sorted))*0.95+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sum / float64(len(sorted)), sorted[idx]
+}
+
+func msSince(start time.Time) float64 {
+	return float64(time.Since(start).Microseconds()) / 1000
+}
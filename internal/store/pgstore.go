@@ -25,6 +25,9 @@ func NewPgStore(conn string) (*gorm.DB, error) {
 		&models.ChatAdmin{},
 		&models.Document{},
 		&models.Chunk{},
+		&models.DocumentSection{},
+		&models.ChatSynonym{},
+		&models.ChatPromptTemplate{},
 		&models.ChatSetting{},
 		&models.Role{},
 		&models.ChatUser{},
@@ -49,22 +52,48 @@ func ensureSchema(db *gorm.DB) error {
 	stmts := []string{
 		`ALTER TABLE documents ADD COLUMN IF NOT EXISTS tags text[] NOT NULL DEFAULT '{}'::text[];`,
 		`CREATE INDEX IF NOT EXISTS documents_tags_gin_idx ON documents USING GIN (tags);`,
+		`UPDATE chunks
+		 SET chunk_index = substring(chunk_name from '_chunk_([0-9]+)$')::int
+		 WHERE chunk_index = 0 AND chunk_name ~ '_chunk_[0-9]+$';`,
+		`CREATE INDEX IF NOT EXISTS chunks_doc_order_idx ON chunks (doc_id, chunk_index);`,
+		// Язык чанков, загруженных до появления определения языка (та же эвристика, что utils.DetectLanguage).
+		// Чанки без букв получают 'und' (utils.LangUndetermined), поэтому полный разбор текста выполняется
+		// только один раз — для language IS NULL, а не при каждом запуске.
+		`UPDATE chunks SET language = 'und' WHERE language = '';`,
+		`UPDATE chunks c
+		 SET language = CASE
+			WHEN l.cyr = 0 AND l.lat = 0 THEN 'und'
+			WHEN l.cyr >= l.lat AND l.kk > 0 AND (l.kk * 100 >= l.cyr OR l.kk >= 3) THEN 'kk'
+			WHEN l.cyr >= l.lat THEN 'ru'
+			ELSE 'en'
+		 END
+		 FROM (
+			SELECT id,
+				length(regexp_replace(lower(text), '[^а-яёәғқңөұүһі]', '', 'g')) AS cyr,
+				length(regexp_replace(lower(text), '[^a-z]', '', 'g')) AS lat,
+				length(regexp_replace(lower(text), '[^әғқңөұүһі]', '', 'g')) AS kk
+			FROM chunks
+			WHERE language IS NULL
+		 ) l
+		 WHERE c.id = l.id;`,
+		// Однократный перенос: раньше внешние эмбеддинги с адресом Hugging Face распознавались по URL,
+		// теперь тип бэкенда задаётся только явно в embedProviderType (условие повторяет resolveEmbeddingProvider).
+		`UPDATE chat_settings
+		 SET settings = jsonb_set(settings, '{embedProviderType}', '"huggingface"')
+		 WHERE coalesce(settings->>'embedProviderType', '') = ''
+		   AND lower(coalesce(nullif(settings->>'embedExternalBaseUrl', ''), settings->>'externalBaseUrl', '')) LIKE '%huggingface%'
+		   AND (lower(coalesce(settings->>'embedProvider', '')) = 'external'
+		     OR (coalesce(settings->>'embedProvider', '') = '' AND (
+		       coalesce(settings->>'embedExternalBaseUrl', '') <> '' OR coalesce(settings->>'embedExternalApiKey', '') <> ''
+		       OR lower(coalesce(settings->>'provider', '')) = 'external'
+		       OR (coalesce(settings->>'provider', '') = ''
+		         AND (coalesce(settings->>'externalBaseUrl', '') <> '' OR coalesce(settings->>'externalApiKey', '') <> '')))));`,
 		`INSERT INTO chat_admins (chat_id, admin_id)
 		 SELECT id, admin_id
 		 FROM chats
 		 WHERE admin_id IS NOT NULL
 		 ON CONFLICT (chat_id, admin_id) DO NOTHING;`,
-
-		`DO $$
-		BEGIN
-			IF NOT EXISTS (
-				SELECT 1 FROM pg_class c
-				JOIN pg_namespace n ON n.oid=c.relnamespace
-				WHERE c.relname='chunks_embedding_ivfflat_idx'
-			) THEN
-				EXECUTE 'CREATE INDEX chunks_embedding_ivfflat_idx ON chunks USING ivfflat (embedding vector_cosine_ops) WITH (lists=100)';
-			END IF;
-		END $$;`,
+		// Векторный индекс создаётся отдельно (repository.VectorIndexRepository.EnsureIndex) по конфигурации
 	}
 
 	for _, s := range stmts {
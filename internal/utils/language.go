@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Языки корпуса, которые умеет различать DetectLanguage.
+const (
+	LangRussian = "ru"
+	LangKazakh  = "kk"
+	LangEnglish = "en"
+
+	// LangUndetermined хранится у чанков без букв (ISO 639-2 "und"), чтобы отличать их от ещё не размеченных.
+	LangUndetermined = "und"
+)
+
+// kazakhLetters — буквы казахской кириллицы, которых нет в русском алфавите.
+const kazakhLetters = "әғқңөұүһі"
+
+// DetectLanguage определяет язык текста по алфавиту: "kk", "ru", "en" или "" (не удалось определить).
+// Казахский отличается от русского по специфичным буквам, поэтому даже одна такая буква в коротком
+// запросе считается достаточным признаком.
+func DetectLanguage(text string) string {
+	latin, cyrillic, kazakh := 0, 0, 0
+	for _, r := range strings.ToLower(text) {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		switch {
+		case unicode.In(r, unicode.Latin):
+			latin++
+		case unicode.In(r, unicode.Cyrillic):
+			cyrillic++
+			if strings.ContainsRune(kazakhLetters, r) {
+				kazakh++
+			}
+		}
+	}
+
+	switch {
+	case cyrillic == 0 && latin == 0:
+		return ""
+	case cyrillic >= latin:
+		// В длинном русском тексте единичная "і" может встретиться в цитате — требуем хотя бы 1% букв.
+		if kazakh > 0 && (kazakh*100 >= cyrillic || kazakh >= 3) {
+			return LangKazakh
+		}
+		return LangRussian
+	default:
+		return LangEnglish
+	}
+}
+
+// LanguageName возвращает английское название языка для промптов LLM.
+func LanguageName(code string) string {
+	switch code {
+	case LangRussian:
+		return "Russian"
+	case LangKazakh:
+		return "Kazakh"
+	case LangEnglish:
+		return "English"
+	default:
+		return code
+	}
+}
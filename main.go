@@ -57,9 +57,17 @@ func main() {
 	chatHistoryRepo := repository.NewChatHistoryRepository(db)
 	messageRepo := repository.NewMessageRepository(db)
 	evaluationRepo := repository.NewEvaluationRepository(db)
+	vectorIndexRepo := repository.NewVectorIndexRepository(db)
+	synonymRepo := repository.NewSynonymRepository(db)
+	promptTemplateRepo := repository.NewPromptTemplateRepository(db)
 	// services
 	llm := service.NewLLMClient(cfg)
 	rag := service.NewRAGService(chunkRepo, llm)
+	synonymService := service.NewSynonymService(synonymRepo)
+	rag.SetSynonymService(synonymService) // словарь синонимов чата для расширения запросов
+	promptTemplateService := service.NewPromptTemplateService(promptTemplateRepo)
+	rag.SetPromptTemplateService(promptTemplateService) // шаблоны промптов чатов
+	rag.SetDocumentRepository(documentRepo)             // документы чата для агента и следующих вопросов
 	chunkService := service.NewChunkService(chunkRepo)
 	adminService := service.NewAdminService(adminRepo)
 
@@ -69,6 +77,19 @@ func main() {
 	chatUserService := service.NewChatUserService(chatuserRepo)
 	chatSettingsService := service.NewChatSettingsService(chatSettingsRepo)
 	evaluationService := service.NewEvaluationService(evaluationRepo)
+	vectorIndexService := service.NewVectorIndexService(vectorIndexRepo, repository.VectorIndexConfig{
+		Type:           cfg.VectorIndexType,
+		Lists:          cfg.VectorIVFLists,
+		M:              cfg.VectorHNSWM,
+		EfConstruction: cfg.VectorHNSWEfConstruction,
+	})
+	created, err := vectorIndexService.EnsureIndex()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if created {
+		log.Printf("✓ Vector index created (%s)", cfg.VectorIndexType)
+	}
 
 	// api
 	app := fiber.New(fiber.Config{
@@ -99,7 +120,7 @@ func main() {
 	}))
 
 	app.Get("/swagger/*", swagger.WrapHandler)
-	api.RegisterRoutes(app, cfg, rag, llm, chunkService, adminService, chatService, documentService, chatUserService, chatSettingsService, chatHistoryRepo, messageRepo, evaluationService)
+	api.RegisterRoutes(app, cfg, rag, llm, chunkService, adminService, chatService, documentService, chatUserService, chatSettingsService, chatHistoryRepo, messageRepo, evaluationService, vectorIndexService, synonymService, promptTemplateService)
 
 	log.Printf("🚀 Server started at %s", cfg.ServerAddr)
 	log.Fatal(app.Listen(cfg.ServerAddr))